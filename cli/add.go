@@ -11,7 +11,7 @@ import (
 func AddOperation() *cmder.Op {
 	return cmder.NewOp(
 		"add",
-		"add <'req' or 'file'>",
+		"add <'req', 'mock', 'workflow' or 'file'>",
 		"Add the requested resource",
 		cmder.NewNoopHandler("add"),
 		cmder.NewOp(
@@ -38,5 +38,27 @@ func AddOperation() *cmder.Op {
 				return handlers.AddRequest(fpath, reqName)
 			},
 		),
+		cmder.NewOp(
+			"mock",
+			"add mock <collection path> <name> <method> <path>",
+			"Add a new mock route to the given collection, served by `mock`. Use '*' as the method to match any.",
+			func(_ context.Context, args []string) error {
+				if len(args) != 4 {
+					return fmt.Errorf("expected 4 args in `add mock`, got: %d", len(args))
+				}
+				return handlers.AddMockRequest(args[0], args[1], args[2], args[3])
+			},
+		),
+		cmder.NewOp(
+			"workflow",
+			"add workflow <collection path> <name> <request names...>",
+			"Add a new workflow to the given collection, running the given requests one after another",
+			func(_ context.Context, args []string) error {
+				if len(args) < 3 {
+					return fmt.Errorf("expected at least 3 args in `add workflow`, got: %d", len(args))
+				}
+				return handlers.AddWorkflow(args[0], args[1], args[2:]...)
+			},
+		),
 	)
 }
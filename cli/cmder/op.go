@@ -130,6 +130,14 @@ func (r *Root) Handle(args []string) error {
 	if err != nil {
 		return err
 	}
+	// '--vcr <mode>' is shorthand for '-e vcr_mode=<mode>'
+	args, vcrMode, err := ExtractFlagValue(args, "--vcr")
+	if err != nil {
+		return err
+	}
+	if vcrMode != "" {
+		overrides["vcr_mode"] = vcrMode
+	}
 
 	ctx := context.WithValue(context.Background(), OverrideContextKey, overrides)
 	ctx = context.WithValue(ctx, ReadonlyContextKey, isReadonlyMode(args))
@@ -168,6 +176,38 @@ func ExtractOverrideMappings(startArgs []string, delimiter string) ([]string, ma
 	return args, mappings, nil
 }
 
+// ExtractFlagValue removes the flag and the value following it from the args, returning the last value given
+func ExtractFlagValue(startArgs []string, flag string) ([]string, string, error) {
+	args := make([]string, 0, len(startArgs))
+	value := ""
+	for i := 0; i < len(startArgs); i++ {
+		if startArgs[i] != flag {
+			args = append(args, startArgs[i])
+			continue
+		}
+		if len(startArgs) <= i+1 {
+			return nil, "", fmt.Errorf("error: expected a value following '%s'", flag)
+		}
+		value = startArgs[i+1]
+		i++
+	}
+	return args, value, nil
+}
+
+// ExtractFlag removes every occurrence of the flag from the args, reporting whether it was present
+func ExtractFlag(startArgs []string, flag string) ([]string, bool) {
+	args := make([]string, 0, len(startArgs))
+	present := false
+	for _, arg := range startArgs {
+		if arg == flag {
+			present = true
+			continue
+		}
+		args = append(args, arg)
+	}
+	return args, present
+}
+
 func isReadonlyMode(args []string) bool {
 	readonly := false
 	for _, arg := range args {
@@ -38,6 +38,40 @@ func TestMappingExtract(t *testing.T) {
 	})
 }
 
+func TestFlagExtract(t *testing.T) {
+	strToArr := func(str string) []string {
+		return strings.Split(str, " ")
+	}
+
+	t.Run("Basic", func(t *testing.T) {
+		testArr, v, err := ExtractFlagValue(strToArr("progname exec --vcr replay Coll.Req"), "--vcr")
+		assert(t, err == nil, err)
+		assert(t, v == "replay", "value comparison", v)
+		assert(t, reflect.DeepEqual(testArr, strToArr("progname exec Coll.Req")), "str comparison", testArr)
+	})
+
+	t.Run("Absent", func(t *testing.T) {
+		testArr, v, err := ExtractFlagValue(strToArr("progname exec Coll.Req"), "--vcr")
+		assert(t, err == nil, err)
+		assert(t, v == "", "value comparison", v)
+		assert(t, reflect.DeepEqual(testArr, strToArr("progname exec Coll.Req")), "str comparison", testArr)
+	})
+
+	t.Run("Missing value", func(t *testing.T) {
+		_, _, err := ExtractFlagValue(strToArr("progname exec Coll.Req --vcr"), "--vcr")
+		assert(t, err != nil, "expected error for missing value")
+	})
+}
+
+func TestBoolFlagExtract(t *testing.T) {
+	testArr, present := ExtractFlag(strings.Split("progname exec --stop-on-failure Coll.Req", " "), "--stop-on-failure")
+	assert(t, present, "expected flag to be present")
+	assert(t, reflect.DeepEqual(testArr, strings.Split("progname exec Coll.Req", " ")), "str comparison", testArr)
+
+	_, present = ExtractFlag(strings.Split("progname exec Coll.Req", " "), "--stop-on-failure")
+	assert(t, !present, "expected flag to be absent")
+}
+
 func assert(t *testing.T, value bool, args ...any) {
 	if !value {
 		t.Fatal(args...)
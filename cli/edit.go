@@ -29,6 +29,12 @@ func EditOperation() *cmder.Op {
 			"Opens selected request from given collection for editing in your $EDITOR",
 			handleEditReq,
 		),
+		cmder.NewOp(
+			"hook",
+			"edit hook <collection path> <'pre' or 'post'>",
+			"Opens the given collection's pre or post script, run around each of its requests, in your $EDITOR",
+			handleEditHook,
+		),
 	)
 }
 
@@ -47,21 +53,36 @@ func handleGlobalEdit(_ context.Context, _ []string) error {
 		}
 		options = append(options, fmt.Sprintf("%s.env", coll.Name))
 		options = append(options, fmt.Sprintf("%s.name", coll.Name))
-		for _, req := range coll.Requests {
-			options = append(options, fmt.Sprintf("%s.%s", coll.Name, req.Name))
-		}
+	}
+	// Requests follow the other options
+	reqOptions, err := requestOptions(conf)
+	if err != nil {
+		return err
 	}
 
 	idx, err := fuzzyfinder.Find(
-		options,
+		make([]struct{}, len(options)+len(reqOptions)),
 		func(i int) string {
-			return options[i]
+			if i < len(options) {
+				return options[i]
+			}
+			return reqOptions[i-len(options)].label()
 		},
+		fuzzyfinder.WithPreviewWindow(func(i, _, _ int) string {
+			if i < len(options) {
+				return ""
+			}
+			return reqOptions[i-len(options)].preview()
+		}),
 	)
 	if err != nil {
 		return err
 	}
 
+	if idx >= len(options) {
+		option := reqOptions[idx-len(options)]
+		return handlers.EditRequest(option.FilePath, option.Path)
+	}
 	option := options[idx]
 	if option == "core.current_env" {
 		return conf.EditCurrentEnv()
@@ -72,26 +93,15 @@ func handleGlobalEdit(_ context.Context, _ []string) error {
 			return err
 		}
 		return coll.EditEnv()
-	} else if strings.HasSuffix(option, ".name") {
+	} else {
 		name := strings.TrimSuffix(option, ".name")
 		coll, err := conf.CollectionByName(name)
 		if err != nil {
 			return err
 		}
 		return coll.EditName()
-	} else {
-		pieces := strings.Split(option, ".")
-		if len(pieces) != 2 {
-			return fmt.Errorf("more than a single '.' found in request identifier: '%s'", option)
-		}
-		coll, err := conf.CollectionByName(pieces[0])
-		if err != nil {
-			return err
-		}
-		return coll.EditRequest(pieces[1])
 	}
 }
-
 func handleEditCollectionEnv(_ context.Context, args []string) error {
 	if len(args) != 1 {
 		return fmt.Errorf("expected 1 arg to `edit env`, got: %d", len(args))
@@ -107,3 +117,10 @@ func handleEditReq(_ context.Context, args []string) error {
 	collectionName, requestName := args[0], args[1]
 	return handlers.EditRequest(collectionName, requestName)
 }
+
+func handleEditHook(_ context.Context, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("expected 2 args to `edit hook`, got: %d", len(args))
+	}
+	return handlers.EditCollectionHook(args[0], args[1])
+}
@@ -3,30 +3,51 @@ package cli
 import (
 	"context"
 	"fmt"
+	"strconv"
 
 	"github.com/EvWilson/sqump/cli/cmder"
 	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
 	"github.com/EvWilson/sqump/handlers"
 	"github.com/EvWilson/sqump/prnt"
-
-	"github.com/ktr0731/go-fuzzyfinder"
 )
 
 func ExecOperation() *cmder.Op {
 	return cmder.NewOp(
 		"exec",
-		"exec <collection path> <request name>, or none",
-		"Executes the given request, or fuzzy searches for one if no args provided",
+		"exec <collection path> <request name> [--param <name>=<value>...] [--data <csv or json file>] [--concurrency N] [--stop-on-failure], or --tag <tag> [collection path], or none",
+		"Executes the given request, or fuzzy searches for one if no args provided, printing what its script returns as JSON. Values for the request's declared parameters are given with '--param', once per parameter. With '--data', executes it once per row of the file, each row's values applied over the environment like '-e' mappings, N rows at a time (default 1), starting no more rows after a failure if '--stop-on-failure' is given. With '--tag', executes every request carrying the tag in the given collection, or in all registered collections",
 		handleExec,
 	)
 }
 
 func handleExec(ctx context.Context, args []string) error {
 	overrides := ctx.Value(cmder.OverrideContextKey).(map[string]string)
-	var err error
+	args, params, err := cmder.ExtractOverrideMappings(args, "--param")
+	if err != nil {
+		return err
+	}
+	args, datasetPath, err := cmder.ExtractFlagValue(args, "--data")
+	if err != nil {
+		return err
+	}
+	args, tag, err := cmder.ExtractFlagValue(args, "--tag")
+	if err != nil {
+		return err
+	}
+	if len(params) > 0 && (datasetPath != "" || tag != "") {
+		return fmt.Errorf("'--param' can't be given with '--data' or '--tag'")
+	}
+	if datasetPath != "" {
+		return handleExecDataset(args, overrides, datasetPath)
+	}
+	if tag != "" {
+		return handleExecTagged(args, overrides, tag)
+	}
+	var returned string
 	switch len(args) {
 	case 0:
-		err = handleExecFuzzy(overrides)
+		returned, err = handleExecFuzzy(overrides, params)
 	case 2:
 		filepath, requestName := args[0], args[1]
 		var env string
@@ -34,55 +55,76 @@ func handleExec(ctx context.Context, args []string) error {
 		if err != nil {
 			return err
 		}
-		err = handlers.ExecuteRequest(filepath, requestName, env, overrides)
+		returned, err = handlers.ExecuteRequest(filepath, requestName, env, overrides, params)
 	default:
 		return fmt.Errorf("expected 0 or 2 args to `exec`, got: %d", len(args))
 	}
 	if err != nil {
 		prnt.Println("error occurred during script execution:")
 		prnt.Println(err)
+		return nil
+	}
+	if returned != "" {
+		prnt.Println(returned)
 	}
 	return nil
 }
 
-func handleExecFuzzy(overrides data.EnvMapValue) error {
-	type ExecOption struct {
-		CollName string
-		ReqName  string
-		Path     string
-	}
-	options := make([]ExecOption, 0)
-
-	conf, err := handlers.GetConfig()
+func handleExecDataset(args []string, overrides data.EnvMapValue, datasetPath string) error {
+	opts := exec.DatasetOptions{}
+	args, concurrency, err := cmder.ExtractFlagValue(args, "--concurrency")
 	if err != nil {
 		return err
 	}
-
-	for _, path := range conf.Files {
-		coll, err := handlers.GetCollection(path)
-		if err != nil {
-			return err
-		}
-		for _, req := range coll.Requests {
-			options = append(options, ExecOption{
-				CollName: coll.Name,
-				ReqName:  req.Name,
-				Path:     path,
-			})
+	if concurrency != "" {
+		if opts.Concurrency, err = strconv.Atoi(concurrency); err != nil {
+			return fmt.Errorf("invalid --concurrency '%s': %v", concurrency, err)
 		}
 	}
+	args, opts.StopOnFailure = cmder.ExtractFlag(args, "--stop-on-failure")
+	if len(args) != 2 {
+		return fmt.Errorf("expected 2 args to `exec` with '--data', got: %d", len(args))
+	}
+	env, err := handlers.GetCurrentEnv()
+	if err != nil {
+		return err
+	}
+	err = handlers.ExecuteDataset(args[0], args[1], env, overrides, datasetPath, opts)
+	if err != nil {
+		prnt.Println(err)
+	}
+	return nil
+}
 
-	idx, err := fuzzyfinder.Find(
-		options,
-		func(i int) string {
-			opt := options[i]
-			return fmt.Sprintf("%s.%s", opt.CollName, opt.ReqName)
-		},
-	)
+func handleExecTagged(args []string, overrides data.EnvMapValue, tag string) error {
+	conf, err := handlers.GetConfig()
 	if err != nil {
 		return err
 	}
+	var fpaths []string
+	switch len(args) {
+	case 0:
+		fpaths = conf.Files
+	case 1:
+		fpaths = args
+	default:
+		return fmt.Errorf("expected 0 or 1 args to `exec` with '--tag', got: %d", len(args))
+	}
+	err = handlers.ExecuteTagged(fpaths, tag, conf.CurrentEnv, overrides)
+	if err != nil {
+		prnt.Println(err)
+	}
+	return nil
+}
 
-	option := options[idx]
-	return handlers.ExecuteRequest(option.Path, option.ReqName, conf.CurrentEnv, overrides)
+func handleExecFuzzy(overrides data.EnvMapValue, params map[string]string) (string, error) {
+	conf, err := handlers.GetConfig()
+	if err != nil {
+		return "", err
+	}
+	option, err := findRequest(conf)
+	if err != nil {
+		return "", err
+	}
+	return handlers.ExecuteRequest(option.FilePath, option.Path, conf.CurrentEnv, overrides, params)
 }
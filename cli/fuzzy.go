@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/handlers"
+
+	"github.com/ktr0731/go-fuzzyfinder"
+)
+
+// requestOption is a request of a registered collection, as offered by the fuzzy finders
+type requestOption struct {
+	CollName string
+	FilePath string
+	// Path is the request's dotted name within its collection
+	Path    string
+	Request *data.Request
+}
+
+// label names the request along with its tags, so that typing e.g. '#smoke' narrows the search to those tagged
+func (ro requestOption) label() string {
+	label := fmt.Sprintf("%s.%s", ro.CollName, ro.Path)
+	for _, tag := range ro.Request.Tags {
+		label += " #" + tag
+	}
+	return label
+}
+
+func (ro requestOption) preview() string {
+	req := ro.Request
+	lines := []string{fmt.Sprintf("%s.%s", ro.CollName, ro.Path), ""}
+	if req.Description != "" {
+		lines = append(lines, req.Description, "")
+	}
+	if req.Owner != "" {
+		lines = append(lines, "Owner: "+req.Owner)
+	}
+	if len(req.Tags) > 0 {
+		lines = append(lines, "Tags: "+strings.Join(req.Tags, ", "))
+	}
+	if len(req.RequiredEnv) > 0 {
+		lines = append(lines, "Requires: "+strings.Join(req.RequiredEnv, ", "))
+	}
+	if req.Mock != nil {
+		lines = append(lines, "Mock: "+req.Mock.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func requestOptions(conf *data.Config) ([]requestOption, error) {
+	options := make([]requestOption, 0)
+	for _, fpath := range conf.Files {
+		coll, err := handlers.GetCollection(fpath)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range coll.AllRequests() {
+			req := *ref.Request
+			options = append(options, requestOption{
+				CollName: coll.Name,
+				FilePath: fpath,
+				Path:     ref.Path,
+				Request:  &req,
+			})
+		}
+	}
+	return options, nil
+}
+
+// findRequest fuzzy searches the requests of the registered collections, previewing the description and other
+// details of the one under the cursor
+func findRequest(conf *data.Config) (requestOption, error) {
+	options, err := requestOptions(conf)
+	if err != nil {
+		return requestOption{}, err
+	}
+	idx, err := fuzzyfinder.Find(
+		options,
+		func(i int) string {
+			return options[i].label()
+		},
+		fuzzyfinder.WithPreviewWindow(func(i, _, _ int) string {
+			if i < 0 {
+				return ""
+			}
+			return options[i].preview()
+		}),
+	)
+	if err != nil {
+		return requestOption{}, err
+	}
+	return options[idx], nil
+}
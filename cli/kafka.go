@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/EvWilson/sqump/cli/cmder"
+	"github.com/EvWilson/sqump/handlers"
+)
+
+func KafkaOperation() *cmder.Op {
+	return cmder.NewOp(
+		"kafka",
+		"kafka <topics|create-topic|delete-topic|group|reset-offsets>",
+		"Inspect and administer a Kafka cluster. <brokers> may be comma-separated addresses, or a profile name given with '-e kafka_<profile>_brokers=...'",
+		cmder.NewNoopHandler("kafka"),
+		cmder.NewOp(
+			"topics",
+			"kafka topics <brokers>",
+			"List the topics and partitions of the cluster",
+			func(ctx context.Context, args []string) error {
+				if len(args) != 1 {
+					return fmt.Errorf("expected 1 argument to `kafka topics`, got: %d", len(args))
+				}
+				return handlers.KafkaListTopics(args[0], overridesFrom(ctx))
+			},
+		),
+		cmder.NewOp(
+			"create-topic",
+			"kafka create-topic <brokers> <topic> [partitions] [replication factor]",
+			"Create a topic, with 1 partition and a replication factor of 1 unless given",
+			func(ctx context.Context, args []string) error {
+				if len(args) < 2 || len(args) > 4 {
+					return fmt.Errorf("expected 2 to 4 arguments to `kafka create-topic`, got: %d", len(args))
+				}
+				partitions, replicationFactor := 1, 1
+				var err error
+				if len(args) > 2 {
+					if partitions, err = strconv.Atoi(args[2]); err != nil {
+						return fmt.Errorf("invalid partition count '%s': %v", args[2], err)
+					}
+				}
+				if len(args) > 3 {
+					if replicationFactor, err = strconv.Atoi(args[3]); err != nil {
+						return fmt.Errorf("invalid replication factor '%s': %v", args[3], err)
+					}
+				}
+				return handlers.KafkaCreateTopic(args[0], args[1], partitions, replicationFactor, overridesFrom(ctx))
+			},
+		),
+		cmder.NewOp(
+			"delete-topic",
+			"kafka delete-topic <brokers> <topic>",
+			"Delete a topic",
+			func(ctx context.Context, args []string) error {
+				if len(args) != 2 {
+					return fmt.Errorf("expected 2 arguments to `kafka delete-topic`, got: %d", len(args))
+				}
+				return handlers.KafkaDeleteTopic(args[0], args[1], overridesFrom(ctx))
+			},
+		),
+		cmder.NewOp(
+			"group",
+			"kafka group <brokers> <group>",
+			"Describe a consumer group, its members, and its lag per partition",
+			func(ctx context.Context, args []string) error {
+				if len(args) != 2 {
+					return fmt.Errorf("expected 2 arguments to `kafka group`, got: %d", len(args))
+				}
+				return handlers.KafkaDescribeGroup(args[0], args[1], overridesFrom(ctx))
+			},
+		),
+		cmder.NewOp(
+			"reset-offsets",
+			"kafka reset-offsets <brokers> <group> <topic> <first|last|offset>",
+			"Reset an inactive consumer group's offsets for every partition of a topic",
+			func(ctx context.Context, args []string) error {
+				if len(args) != 4 {
+					return fmt.Errorf("expected 4 arguments to `kafka reset-offsets`, got: %d", len(args))
+				}
+				return handlers.KafkaResetGroupOffsets(args[0], args[1], args[2], args[3], overridesFrom(ctx))
+			},
+		),
+	)
+}
+
+func overridesFrom(ctx context.Context) map[string]string {
+	overrides, _ := ctx.Value(cmder.OverrideContextKey).(map[string]string)
+	return overrides
+}
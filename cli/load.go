@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/EvWilson/sqump/cli/cmder"
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/handlers"
+)
+
+func LoadOperation() *cmder.Op {
+	return cmder.NewOp(
+		"load",
+		"load <collection path> <request name> [--vus N] [--duration D] [--rps R]",
+		"Run the request's script repeatedly from N virtual users (default 1) for duration D (default 10s), starting at most R runs a second across all users if given, then report throughput, error rate, and fetch latencies",
+		handleLoad,
+	)
+}
+
+func handleLoad(ctx context.Context, args []string) error {
+	opts := exec.LoadOptions{
+		VUs:      1,
+		Duration: 10 * time.Second,
+	}
+	args, vus, err := cmder.ExtractFlagValue(args, "--vus")
+	if err != nil {
+		return err
+	}
+	if vus != "" {
+		if opts.VUs, err = strconv.Atoi(vus); err != nil {
+			return fmt.Errorf("invalid --vus '%s': %v", vus, err)
+		}
+	}
+	args, duration, err := cmder.ExtractFlagValue(args, "--duration")
+	if err != nil {
+		return err
+	}
+	if duration != "" {
+		if opts.Duration, err = time.ParseDuration(duration); err != nil {
+			return fmt.Errorf("invalid --duration '%s': %v", duration, err)
+		}
+	}
+	args, rps, err := cmder.ExtractFlagValue(args, "--rps")
+	if err != nil {
+		return err
+	}
+	if rps != "" {
+		if opts.RPS, err = strconv.ParseFloat(rps, 64); err != nil {
+			return fmt.Errorf("invalid --rps '%s': %v", rps, err)
+		}
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("expected 2 args to `load`, got: %d", len(args))
+	}
+	return handlers.RunLoad(args[0], args[1], overridesFrom(ctx), opts)
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/EvWilson/sqump/cli/cmder"
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/handlers"
+)
+
+func MigrateOperation() *cmder.Op {
+	return cmder.NewOp(
+		"migrate",
+		"migrate [collection paths...] or migrate <'layout' or 'format'>",
+		"Upgrade the given collections, or the config and all registered collections, to this version of sqump, keeping backups; or convert a collection between ways of storing it",
+		func(_ context.Context, args []string) error {
+			return handlers.MigrateVersions(args)
+		},
+		cmder.NewOp(
+			"layout",
+			fmt.Sprintf("migrate layout <collection path> <'%s' or '%s'> [directory]", handlers.LayoutFiles, handlers.LayoutInline),
+			"Move each request's script out to a '.lua' file in the given directory beside the collection (default 'scripts'), or back into the collection",
+			func(_ context.Context, args []string) error {
+				if len(args) < 2 || len(args) > 3 {
+					return fmt.Errorf("expected 2 or 3 args in `migrate layout`, got: %d", len(args))
+				}
+				dir := ""
+				if len(args) == 3 {
+					dir = args[2]
+				}
+				return handlers.MigrateLayout(args[0], args[1], dir)
+			},
+		),
+		cmder.NewOp(
+			"format",
+			fmt.Sprintf("migrate format <collection path> <%s>", formatChoices()),
+			"Rewrite the collection in the given format, replacing its file with one of the format's extension beside it and updating its registration",
+			func(_ context.Context, args []string) error {
+				if len(args) != 2 {
+					return fmt.Errorf("expected 2 args in `migrate format`, got: %d", len(args))
+				}
+				return handlers.MigrateFormat(args[0], args[1])
+			},
+		),
+	)
+}
+
+func formatChoices() string {
+	quoted := make([]string, 0, len(data.Formats))
+	for _, f := range data.Formats {
+		quoted = append(quoted, fmt.Sprintf("'%s'", f))
+	}
+	return strings.Join(quoted[:len(quoted)-1], ", ") + " or " + quoted[len(quoted)-1]
+}
@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/EvWilson/sqump/cli/cmder"
+	"github.com/EvWilson/sqump/handlers"
+)
+
+const defaultMockAddr = ":5311"
+
+func MockOperation() *cmder.Op {
+	return cmder.NewOp(
+		"mock",
+		"mock <collection path> [address]",
+		fmt.Sprintf("Serve the collection's mock routes as a local HTTP server, at '%s' unless an address is given", defaultMockAddr),
+		func(ctx context.Context, args []string) error {
+			if len(args) < 1 || len(args) > 2 {
+				return fmt.Errorf("expected 1 or 2 args to `mock`, got: %d", len(args))
+			}
+			addr := defaultMockAddr
+			if len(args) == 2 {
+				addr = args[1]
+			}
+			return handlers.ServeMock(args[0], addr, overridesFrom(ctx))
+		},
+	)
+}
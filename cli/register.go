@@ -13,7 +13,7 @@ func AutoregisterOperation() *cmder.Op {
 	return cmder.NewOp(
 		"autoregister",
 		"autoregister",
-		"Recursively search for `Squmpfile.json` to register from the current working directory",
+		"Recursively search for `Squmpfile.json`, `.yaml`, or `.toml` to register from the current working directory",
 		func(_ context.Context, args []string) error {
 			if len(args) != 0 {
 				return fmt.Errorf("expected 0 arguments to `autoregister`, got: %d", len(args))
@@ -14,6 +14,7 @@ func BuildRoot() *cmder.Root {
 	root.Register(
 		EditOperation(),
 		ExecOperation(),
+		WorkflowOperation(),
 		AddOperation(),
 		RemoveOperation(),
 		AutoregisterOperation(),
@@ -30,6 +31,10 @@ func BuildRoot() *cmder.Root {
 			},
 		),
 		WebOperation(),
+		MockOperation(),
+		LoadOperation(),
+		MigrateOperation(),
+		KafkaOperation(),
 		cmder.NewOp(
 			"readonly",
 			"readonly",
@@ -41,6 +46,23 @@ Users are still able to set the current environment and temporary overrides, but
 				return nil
 			},
 		),
+		cmder.NewOp(
+			"vcr",
+			"vcr",
+			"Learn more about the '--vcr' option for recording and replaying 'fetch' calls",
+			func(_ context.Context, _ []string) error {
+				fmt.Println(`VCR mode:
+Run a command with '--vcr record' to save each 'fetch' a script makes, request and response, to a cassette at
+'cassettes/<collection>/<request>.json' beside the Squmpfile. Run with '--vcr replay' to answer each 'fetch' from
+the cassette instead of the network, failing the script on any request the cassette has no match for.
+The mode may also be set with the 'vcr_mode' environment key, e.g. '-e vcr_mode=replay'.
+Set 'vcr_match' to choose what a replayed request is matched on, as a comma-separated list of 'method', 'url',
+'body', 'headers', and 'header:<name>'. The default is 'method,url'.
+Cassettes leave out the values of the Authorization, Proxy-Authorization, Cookie, and Set-Cookie headers, along with
+those of any headers named in 'vcr_redact', as a comma-separated list.`)
+				return nil
+			},
+		),
 	)
 	return root
 }
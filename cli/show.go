@@ -3,13 +3,11 @@ package cli
 import (
 	"context"
 	"fmt"
-	"strings"
 
 	"github.com/EvWilson/sqump/cli/cmder"
 	"github.com/EvWilson/sqump/data"
 	"github.com/EvWilson/sqump/handlers"
 	"github.com/EvWilson/sqump/prnt"
-	"github.com/ktr0731/go-fuzzyfinder"
 )
 
 func ShowOperation() *cmder.Op {
@@ -55,32 +53,9 @@ func handleShow(ctx context.Context, args []string) error {
 }
 
 func handleShowFuzzy(conf *data.Config, overrides data.EnvMapValue) (string, string, error) {
-	options := make([]string, 0)
-
-	for _, path := range conf.Files {
-		coll, err := handlers.GetCollection(path)
-		if err != nil {
-			return "", "", err
-		}
-		for _, req := range coll.Requests {
-			options = append(options, fmt.Sprintf("%s.%s", coll.Name, req.Name))
-		}
-	}
-
-	idx, err := fuzzyfinder.Find(
-		options,
-		func(i int) string {
-			return options[i]
-		},
-	)
+	option, err := findRequest(conf)
 	if err != nil {
 		return "", "", err
 	}
-
-	option := options[idx]
-	pieces := strings.Split(option, ".")
-	if len(pieces) != 2 {
-		return "", "", fmt.Errorf("more than a single '.' found in request identifier: '%s'", option)
-	}
-	return pieces[0], pieces[1], nil
+	return option.FilePath, option.Path, nil
 }
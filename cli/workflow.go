@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/EvWilson/sqump/cli/cmder"
+	"github.com/EvWilson/sqump/handlers"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+func WorkflowOperation() *cmder.Op {
+	return cmder.NewOp(
+		"workflow",
+		"workflow <collection path> <workflow name>",
+		"Run the steps of the collection's workflow, passing each step's returned value to those after it, then its cleanup steps",
+		func(ctx context.Context, args []string) error {
+			if len(args) != 2 {
+				return fmt.Errorf("expected 2 args to `workflow`, got: %d", len(args))
+			}
+			env, err := handlers.GetCurrentEnv()
+			if err != nil {
+				return err
+			}
+			err = handlers.ExecuteWorkflow(args[0], args[1], env, overridesFrom(ctx))
+			if err != nil {
+				prnt.Println(err)
+			}
+			return nil
+		},
+	)
+}
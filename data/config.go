@@ -8,35 +8,10 @@ import (
 	"runtime"
 	"slices"
 	"strings"
-	"sync"
 
 	"github.com/EvWilson/sqump/prnt"
 )
 
-var (
-	configLock = make(map[string]*sync.RWMutex, 0)
-)
-
-func configRLock(path string) func() {
-	lock, ok := configLock[path]
-	if !ok {
-		lock = &sync.RWMutex{}
-		configLock[path] = lock
-	}
-	lock.RLock()
-	return lock.RUnlock
-}
-
-func configWLock(path string) func() {
-	lock, ok := configLock[path]
-	if !ok {
-		lock = &sync.RWMutex{}
-		configLock[path] = lock
-	}
-	lock.Lock()
-	return lock.Unlock
-}
-
 // DefaultConfigLocation returns the location of the sqump config file
 func DefaultConfigLocation() string {
 	switch runtime.GOOS {
@@ -57,10 +32,26 @@ type Config struct {
 	Version    SemVer   `json:"version"`
 	Files      []string `json:"files"`
 	CurrentEnv string   `json:"current_env"`
+	// Lib lists '.lua' files and directories, relative to the config's directory, whose modules any script may require
+	// by name
+	Lib []string `json:"lib,omitempty"`
+	// revision identifies the config as read or last written
+	revision string
+	// upgrade is set when the config was read from an older version, which is left on disk until it's next saved
+	upgrade *Upgrade
 }
 
+// ReadConfigFrom reads the config at path. One written by an older version of sqump is upgraded as it's read,
+// leaving the file as it is until the config is saved or `sqump migrate` is run.
 func ReadConfigFrom(path string) (*Config, error) {
-	unlock := configRLock(path)
+	return readConfig(path)
+}
+
+func readConfig(path string) (*Config, error) {
+	unlock, err := lockFile(path, false)
+	if err != nil {
+		return nil, err
+	}
 	defer unlock()
 	b, err := os.ReadFile(path)
 	if os.IsNotExist(err) {
@@ -77,17 +68,61 @@ func ReadConfigFrom(path string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = checkVersion("config", path, c.Version, CurrentConfigVersion)
+	if _, ok := err.(errOutdated); ok {
+		var migrated []byte
+		var upgrade *Upgrade
+		if migrated, upgrade, err = migrate("config", path, FormatJSON, b, CurrentConfigVersion, configMigrations); err != nil {
+			return nil, err
+		}
+		c = Config{upgrade: upgrade}
+		err = json.Unmarshal(migrated, &c)
+	}
+	if err != nil {
+		return nil, err
+	}
 	c.Path = path
+	c.revision, err = revision(path)
+	if err != nil {
+		return nil, err
+	}
 
 	return &c, nil
 }
 
+// Flush saves the config, refusing with ErrConflict if it's been changed elsewhere since it was read
 func (c *Config) Flush() error {
-	unlock := configWLock(c.Path)
-	defer unlock()
 	if err := c.validate(); err != nil {
 		return err
 	}
+	unlock, err := lockFile(c.Path, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if c.revision != "" {
+		current, err := revision(c.Path)
+		if err != nil {
+			return err
+		}
+		if current != c.revision {
+			return ErrConflict{
+				Kind: "config",
+				Path: c.Path,
+			}
+		}
+	}
+	if c.upgrade != nil {
+		if err = c.upgrade.backup("config"); err != nil {
+			return err
+		}
+		c.upgrade = nil
+	}
+	return c.flush()
+}
+
+// flush writes out the config, with its lock held
+func (c *Config) flush() error {
 	slices.SortFunc(c.Files, func(a, b string) int {
 		return strings.Compare(a, b)
 	})
@@ -95,15 +130,15 @@ func (c *Config) Flush() error {
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile(c.Path, b, defaultPerms)
-	if err != nil {
+	if err = writeFileAtomic(c.Path, b, defaultPerms); err != nil {
 		return err
 	}
-	return nil
+	c.revision, err = revision(c.Path)
+	return err
 }
 
 func (c *Config) validate() error {
-	return nil
+	return validateLib(c.Lib)
 }
 
 func (c *Config) Register(path string) error {
@@ -174,28 +209,19 @@ func DefaultConfig(path string) *Config {
 	return &Config{
 		Path:       path,
 		CurrentEnv: "staging",
-		Version:    CurrentVersion,
+		Version:    CurrentConfigVersion,
 		Files:      []string{},
 	}
 }
 
 func (c *Config) EditCurrentEnv() error {
-	path := c.Path
 	cb := func(b []byte) error {
-		conf, err := ReadConfigFrom(path)
-		if err != nil {
-			return err
-		}
-		conf.CurrentEnv = strings.TrimSpace(string(b))
-		return conf.Flush()
+		c.CurrentEnv = strings.TrimSpace(string(b))
+		return c.Flush()
 	}
 
-	b, err := EditBuffer([]byte(c.CurrentEnv), "core-config-current-env-*.json", cb)
-	if err != nil {
-		return err
-	}
-
-	return cb(b)
+	_, err := EditBuffer([]byte(c.CurrentEnv), "core-config-current-env-*.json", cb)
+	return err
 }
 
 func (c *Config) CollectionByName(name string) (*Collection, error) {
@@ -221,6 +247,9 @@ func (c *Config) PrintInfo() {
 
 	prnt.Println("Current Env:", strOrNone(c.CurrentEnv))
 	prnt.Println("Version:", strOrNone(c.Version.String()))
+	if len(c.Lib) > 0 {
+		prnt.Println("Lib:", strings.Join(c.Lib, ", "))
+	}
 	prnt.Println("Files:")
 	if len(c.Files) == 0 {
 		prnt.Println("  <none>")
@@ -13,6 +13,9 @@ import (
 	"github.com/fsnotify/fsnotify"
 )
 
+// EditBuffer opens data in the user's EDITOR, passing the contents to saveCallback each time they're saved and once
+// more when the editor exits. Saves that fail are reported without closing the editor, and if the last one fails, the
+// edits are kept in the temporary file for the user to recover.
 func EditBuffer(
 	data []byte,
 	tmpFilepattern string,
@@ -34,7 +37,11 @@ func EditBuffer(
 			return
 		}
 	}(f)
+	keep := false
 	defer func(filename string) {
+		if keep {
+			return
+		}
 		err = os.Remove(filename)
 		if err != nil {
 			prnt.Printf("error removing tmpfile '%s': %v\n", filename, err)
@@ -84,6 +91,10 @@ func EditBuffer(
 	if err != nil {
 		return nil, err
 	}
+	if err = saveCallback(b); err != nil {
+		keep = true
+		return nil, fmt.Errorf("%w, your edits are kept at '%s'", err, f.Name())
+	}
 
 	return b, nil
 }
@@ -122,9 +133,9 @@ func watcher(
 					if err != nil {
 						return nil, err
 					}
-					err = saveCallback(b)
-					if err != nil {
-						return nil, err
+					// Leave the editor open so the edits aren't lost, the final save reports the error again
+					if err = saveCallback(b); err != nil {
+						prnt.Printf("not saved: %v\n", err)
 					}
 				}
 			}
@@ -2,13 +2,15 @@ package data
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
 	"slices"
 	"strings"
-	"sync"
 
 	"github.com/EvWilson/sqump/prnt"
 )
@@ -16,41 +18,239 @@ import (
 const defaultPerms = 0644
 
 var (
-	CurrentVersion = NewSemVer(0, 1, 0)
-	collLock       = make(map[string]*sync.RWMutex, 0)
+	// CurrentVersion is that of collections written by this version of sqump, see collectionMigrations
+	CurrentVersion = NewSemVer(0, 2, 0)
 )
 
-func collRLock(path string) func() {
-	lock, ok := collLock[path]
-	if !ok {
-		lock = &sync.RWMutex{}
-		collLock[path] = lock
+type Collection struct {
+	Path     string    `json:"-" yaml:"-" toml:"-"`
+	Version  SemVer    `json:"version" yaml:"version" toml:"version"`
+	Name     string    `json:"name" yaml:"name" toml:"name"`
+	Requests []Request `json:"requests" yaml:"requests" toml:"requests"`
+	// Folders group further requests, named by their dotted path from the collection, e.g. "Folder.Request"
+	Folders []Folder `json:"folders,omitempty" yaml:"folders,omitempty" toml:"folders,omitempty"`
+	// ScriptDir, if set, has each request's script kept in a '.lua' file in this directory beside the collection
+	ScriptDir string `json:"script_dir,omitempty" yaml:"script_dir,omitempty" toml:"script_dir,omitempty"`
+	// Workflows chain the collection's requests into flows run with `sqump workflow`
+	Workflows []Workflow `json:"workflows,omitempty" yaml:"workflows,omitempty" toml:"workflows,omitempty"`
+	// Pre runs before each of the collection's requests, ahead of those of its folders, and Post after each that
+	// succeeds, once those of its folders are done, in the same state
+	Pre  Script `json:"pre,omitempty" yaml:"pre,omitempty" toml:"pre,omitempty"`
+	Post Script `json:"post,omitempty" yaml:"post,omitempty" toml:"post,omitempty"`
+	// Lib lists '.lua' files and directories, relative to the collection's directory, whose modules its scripts may
+	// require by name, ahead of those of the config
+	Lib         []string `json:"lib,omitempty" yaml:"lib,omitempty" toml:"lib,omitempty"`
+	Environment EnvMap   `json:"environment" yaml:"environment" toml:"environment"`
+	// scriptFiles are those read or last written, so that files no longer referenced can be cleaned up
+	scriptFiles map[string]bool
+	// revision identifies the collection and its script files as read or last written, see Revision
+	revision string
+	// upgrade is set when the collection was read from an older version, which is left on disk until it's next saved
+	upgrade *Upgrade
+}
+
+type Request struct {
+	Name string `json:"name" yaml:"name" toml:"name"`
+	// Description says what the request is for, in markdown
+	Description string `json:"description,omitempty" yaml:"description,omitempty" toml:"description,omitempty"`
+	// Tags group requests across folders and collections, e.g. to run together with `sqump exec --tag`
+	Tags  []string `json:"tags,omitempty" yaml:"tags,omitempty" toml:"tags,omitempty"`
+	Owner string   `json:"owner,omitempty" yaml:"owner,omitempty" toml:"owner,omitempty"`
+	// RequiredEnv lists the environment keys the request can't run without
+	RequiredEnv []string `json:"required_env,omitempty" yaml:"required_env,omitempty" toml:"required_env,omitempty"`
+	// Params are the values the script takes from whoever runs it
+	Params []Param `json:"params,omitempty" yaml:"params,omitempty" toml:"params,omitempty"`
+	Script Script  `json:"script,omitempty" yaml:"script,omitempty" toml:"script,omitempty"`
+	// File, if set, holds the script in place of the collection, at a path relative to the collection's directory
+	File string `json:"file,omitempty" yaml:"file,omitempty" toml:"file,omitempty"`
+	// Mock makes the request a route served by `sqump mock`, rather than a script to execute
+	Mock *MockRoute `json:"mock,omitempty" yaml:"mock,omitempty" toml:"mock,omitempty"`
+}
+
+// MockRoute matches incoming requests by method ("*" for any) and chi path pattern (e.g. "/users/{id}")
+type MockRoute struct {
+	Method string `json:"method" yaml:"method" toml:"method"`
+	Path   string `json:"path" yaml:"path" toml:"path"`
+}
+
+func (r *Request) HasTag(tag string) bool {
+	return slices.Contains(r.Tags, tag)
+}
+
+// MissingEnv gives the required environment keys that env lacks
+func (r *Request) MissingEnv(env EnvMapValue) []string {
+	var missing []string
+	for _, key := range r.RequiredEnv {
+		if _, ok := env[key]; !ok {
+			missing = append(missing, key)
+		}
 	}
-	lock.RLock()
-	return lock.RUnlock
+	return missing
 }
 
-func collWLock(path string) func() {
-	lock, ok := collLock[path]
-	if !ok {
-		lock = &sync.RWMutex{}
-		collLock[path] = lock
+func (mr MockRoute) String() string {
+	return fmt.Sprintf("%s %s", mr.Method, mr.Path)
+}
+
+// mockMethods are the methods the mock router can match, besides "*" for any
+var mockMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace,
+}
+
+func (mr MockRoute) validate() error {
+	if mr.Method == "" {
+		return errors.New("has no method")
+	}
+	if mr.Method != "*" && !slices.Contains(mockMethods, strings.ToUpper(mr.Method)) {
+		return fmt.Errorf("has method '%s', which must be '*' or one of %s", mr.Method, strings.Join(mockMethods, ", "))
 	}
-	lock.Lock()
-	return lock.Unlock
+	if !strings.HasPrefix(mr.Path, "/") {
+		return fmt.Errorf("has path '%s', which must begin with '/'", mr.Path)
+	}
+	if i := strings.Index(mr.Path, "*"); i >= 0 && i != len(mr.Path)-1 {
+		return fmt.Errorf("has path '%s', where a wildcard '*' may only come last", mr.Path)
+	}
+	return nil
 }
 
-type Collection struct {
-	Path        string    `json:"-"`
-	Version     SemVer    `json:"version"`
-	Name        string    `json:"name"`
-	Requests    []Request `json:"requests"`
-	Environment EnvMap    `json:"environment"`
+type Workflow struct {
+	Name  string         `json:"name" yaml:"name" toml:"name"`
+	Steps []WorkflowStep `json:"steps" yaml:"steps" toml:"steps"`
+	// Cleanup steps run in order once the others are done, whatever their outcome
+	Cleanup []WorkflowStep `json:"cleanup,omitempty" yaml:"cleanup,omitempty" toml:"cleanup,omitempty"`
 }
 
-type Request struct {
-	Name   string `json:"name"`
-	Script Script `json:"script"`
+// WorkflowStep runs a request of the collection. If no step of a workflow lists dependencies, each depends on the
+// step before it. Otherwise, each depends on only those it lists. A step whose dependencies did not all pass is
+// skipped.
+type WorkflowStep struct {
+	// Name identifies the step's output to later steps, defaulting to the request's name
+	Name      string   `json:"name,omitempty" yaml:"name,omitempty" toml:"name,omitempty"`
+	Request   string   `json:"request" yaml:"request" toml:"request"`
+	DependsOn []string `json:"depends_on,omitempty" yaml:"depends_on,omitempty" toml:"depends_on,omitempty"`
+	// Params are the values given for the request's parameters, as `require` would pass them
+	Params map[string]any `json:"params,omitempty" yaml:"params,omitempty" toml:"params,omitempty"`
+}
+
+func (ws WorkflowStep) StepName() string {
+	if ws.Name != "" {
+		return ws.Name
+	}
+	return ws.Request
+}
+
+func NewWorkflow(name string, requests ...string) *Workflow {
+	w := &Workflow{
+		Name:  name,
+		Steps: make([]WorkflowStep, 0, len(requests)),
+	}
+	for _, req := range requests {
+		w.Steps = append(w.Steps, WorkflowStep{Request: req})
+	}
+	return w
+}
+
+// IsChain reports whether the steps run one after another, rather than by their listed dependencies
+func (w *Workflow) IsChain() bool {
+	for _, step := range w.Steps {
+		if len(step.DependsOn) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Order gives the steps in an order that runs each after its dependencies, keeping to the listed order where it can
+func (w *Workflow) Order() ([]WorkflowStep, error) {
+	if w.IsChain() {
+		return w.Steps, nil
+	}
+	done := make(map[string]bool, len(w.Steps))
+	ordered := make([]WorkflowStep, 0, len(w.Steps))
+	for len(ordered) < len(w.Steps) {
+		progressed := false
+		for _, step := range w.Steps {
+			if done[step.StepName()] {
+				continue
+			}
+			ready := true
+			for _, dep := range step.DependsOn {
+				if !done[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				done[step.StepName()] = true
+				ordered = append(ordered, step)
+				progressed = true
+				break
+			}
+		}
+		if !progressed {
+			return nil, fmt.Errorf("workflow '%s' has a dependency cycle", w.Name)
+		}
+	}
+	return ordered, nil
+}
+
+func (c *Collection) GetWorkflow(name string) (*Workflow, bool) {
+	for _, w := range c.Workflows {
+		if w.Name == name {
+			return &w, true
+		}
+	}
+	return nil, false
+}
+
+func (c *Collection) validateWorkflows() error {
+	workflowNames := make(map[string]bool, len(c.Workflows))
+	for _, w := range c.Workflows {
+		if strings.Contains(w.Name, ".") {
+			return fmt.Errorf("Illegal character '.' detected in workflow name '%s'", w.Name)
+		}
+		if workflowNames[w.Name] {
+			return fmt.Errorf("duplicate workflow name '%s'", w.Name)
+		}
+		workflowNames[w.Name] = true
+		if len(w.Steps) == 0 {
+			return fmt.Errorf("workflow '%s' has no steps", w.Name)
+		}
+		stepNames := make(map[string]bool, len(w.Steps)+len(w.Cleanup))
+		for _, step := range append(slices.Clone(w.Steps), w.Cleanup...) {
+			req, ok := c.GetRequest(step.Request)
+			if !ok {
+				return fmt.Errorf("workflow '%s' step '%s' names unknown request '%s'", w.Name, step.StepName(), step.Request)
+			}
+			if req.Mock != nil {
+				return fmt.Errorf("workflow '%s' step '%s' names mock route '%s', which can't be run as a step", w.Name, step.StepName(), step.Request)
+			}
+			if _, err := req.ResolveParams(step.Params); err != nil {
+				return fmt.Errorf("workflow '%s' step '%s': %v", w.Name, step.StepName(), err)
+			}
+			if stepNames[step.StepName()] {
+				return fmt.Errorf("workflow '%s' has duplicate step name '%s', set a distinct 'name' for each", w.Name, step.StepName())
+			}
+			stepNames[step.StepName()] = true
+		}
+		for _, step := range w.Steps {
+			for _, dep := range step.DependsOn {
+				if !stepNames[dep] {
+					return fmt.Errorf("workflow '%s' step '%s' depends on unknown step '%s'", w.Name, step.StepName(), dep)
+				}
+			}
+		}
+		for _, step := range w.Cleanup {
+			if len(step.DependsOn) > 0 {
+				return fmt.Errorf("workflow '%s' cleanup step '%s' can't have dependencies, as cleanup always runs", w.Name, step.StepName())
+			}
+		}
+		if _, err := w.Order(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type Script []string
@@ -81,6 +281,24 @@ func NewRequest(name string) *Request {
 	}
 }
 
+func NewMockRequest(name, method, path string) *Request {
+	return &Request{
+		Name: name,
+		Script: Script{
+			"-- 'request' holds the method, path, params, query, headers, and body of the incoming request",
+			"return {",
+			"\tstatus = 200,",
+			"\theaders = { ['Content-Type'] = 'application/json' },",
+			"\tbody = { path = request.path },",
+			"}",
+		},
+		Mock: &MockRoute{
+			Method: strings.ToUpper(method),
+			Path:   path,
+		},
+	}
+}
+
 type EnvMap map[string]EnvMapValue
 
 func (em EnvMap) validate() error {
@@ -133,9 +351,9 @@ func (e EnvMap) PrintInfo() {
 }
 
 type SemVer struct {
-	Major uint `json:"major"`
-	Minor uint `json:"minor"`
-	Patch uint `json:"patch"`
+	Major uint `json:"major" yaml:"major" toml:"major"`
+	Minor uint `json:"minor" yaml:"minor" toml:"minor"`
+	Patch uint `json:"patch" yaml:"patch" toml:"patch"`
 }
 
 func NewSemVer(major, minor, patch uint) SemVer {
@@ -150,8 +368,21 @@ func (s SemVer) String() string {
 	return fmt.Sprintf("%d.%d.%d", s.Major, s.Minor, s.Patch)
 }
 
+// Compare gives -1, 0, or 1 as s is older than, the same as, or newer than other
+func (s SemVer) Compare(other SemVer) int {
+	for _, pair := range [][2]uint{{s.Major, other.Major}, {s.Minor, other.Minor}, {s.Patch, other.Patch}} {
+		switch {
+		case pair[0] > pair[1]:
+			return 1
+		case pair[0] < pair[1]:
+			return -1
+		}
+	}
+	return 0
+}
+
 func (s SemVer) GreaterThan(other SemVer) bool {
-	return s.Major > other.Major || s.Minor > other.Minor || s.Patch > other.Patch
+	return s.Compare(other) > 0
 }
 
 func DefaultCollection() Collection {
@@ -180,102 +411,235 @@ func (e ErrNotFound) Is(target error) bool {
 	return reflect.TypeOf(target) == reflect.TypeOf(ErrNotFound{})
 }
 
+// Flush saves the collection, refusing with ErrConflict if it's been changed elsewhere since it was read
 func (c *Collection) Flush() error {
-	unlock := collWLock(c.Path)
-	defer unlock()
 	err := c.validate()
 	if err != nil {
 		return err
 	}
-	slices.SortFunc(c.Requests, func(a, b Request) int {
-		return strings.Compare(a.Name, b.Name)
-	})
-	b, err := json.MarshalIndent(c, "", "  ")
+	unlock, err := lockFile(c.Path, true)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(c.Path, b, defaultPerms)
+	defer unlock()
+	if err = c.checkRevision(); err != nil {
+		return err
+	}
+	if c.upgrade != nil {
+		if err = c.upgrade.backup("collection"); err != nil {
+			return err
+		}
+		c.upgrade = nil
+	}
+	return c.flush()
 }
 
-func ReadCollection(path string) (*Collection, error) {
-	unlock := collRLock(path)
-	defer unlock()
-	b, err := os.ReadFile(path)
-	if os.IsNotExist(err) {
-		return nil, ErrNotFound{
-			MissingItem: "collection",
-			Location:    path,
+// checkRevision refuses with ErrConflict if the collection has changed on disk since it was read, with its lock held
+func (c *Collection) checkRevision() error {
+	if c.revision == "" {
+		return nil
+	}
+	current, err := revision(c.revisionPaths()...)
+	if err != nil {
+		return err
+	}
+	if current != c.revision {
+		return ErrConflict{
+			Kind: "collection",
+			Path: c.Path,
 		}
-	} else if err != nil {
-		return nil, err
 	}
+	return nil
+}
 
-	var s Collection
-	err = json.Unmarshal(b, &s)
+// flush writes out the collection, with its lock held
+func (c *Collection) flush() error {
+	sortContainer(c.root())
+	err := c.writeScriptFiles()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	s.Path = path
+	// Scripts kept in files are left out of the collection itself
+	out := *c
+	out.Requests, out.Folders = withoutFileScripts(c.Requests, c.Folders)
+	b, err := FormatOf(c.Path).marshal(out)
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(c.Path, b, defaultPerms); err != nil {
+		return err
+	}
+	c.revision, err = revision(c.revisionPaths()...)
+	return err
+}
 
-	return &s, nil
+// Revision identifies the collection as it was read or last saved, changing whenever it or its script files do
+func (c *Collection) Revision() string {
+	return c.revision
 }
 
-func WriteDefaultCollection() error {
-	sf := DefaultCollection()
-	return sf.Flush()
+func (c *Collection) revisionPaths() []string {
+	paths := []string{c.Path}
+	files := make([]string, 0, len(c.scriptFiles))
+	for file := range c.scriptFiles {
+		files = append(files, file)
+	}
+	slices.Sort(files)
+	for _, file := range files {
+		paths = append(paths, c.scriptFilePath(file))
+	}
+	return paths
 }
 
-func (c *Collection) GetRequest(req string) (*Request, bool) {
-	for _, r := range c.Requests {
-		if r.Name == req {
-			return &r, true
-		}
+// defaultScriptFile is where a request's script is kept under the collection's ScriptDir, in a directory per folder
+func (c *Collection) defaultScriptFile(requestPath string) string {
+	sanitize := strings.NewReplacer("/", "_", "\\", "_")
+	folders, name := SplitRequestPath(requestPath)
+	elems := []string{filepath.ToSlash(c.ScriptDir)}
+	for _, f := range folders {
+		elems = append(elems, sanitize.Replace(f))
 	}
-	return nil, false
+	elems = append(elems, sanitize.Replace(name)+".lua")
+	return path.Join(elems...)
+}
+
+// checkScriptFile refuses a script file outside the collection's directory
+func checkScriptFile(requestName, file string) error {
+	if !filepath.IsLocal(filepath.FromSlash(file)) {
+		return fmt.Errorf("request '%s' has script file '%s', which must be a relative path within the collection's directory", requestName, file)
+	}
+	return nil
 }
 
-func (c *Collection) RemoveRequest(name string) error {
-	for i, r := range c.Requests {
-		if r.Name == name {
-			c.Requests = append(c.Requests[:i], c.Requests[i+1:]...)
-			return c.Flush()
+func (c *Collection) scriptFilePath(file string) string {
+	return filepath.Join(filepath.Dir(c.Path), filepath.FromSlash(file))
+}
+
+func (c *Collection) readScriptFiles() error {
+	c.scriptFiles = make(map[string]bool)
+	for _, ref := range c.AllRequests() {
+		req := ref.Request
+		if req.File == "" {
+			continue
+		}
+		// Checked here as well as on write, so that a hand edit can't have us read any file on the system
+		if err := checkScriptFile(ref.Path, req.File); err != nil {
+			return err
 		}
+		b, err := os.ReadFile(c.scriptFilePath(req.File))
+		if err != nil {
+			return fmt.Errorf("reading script of request '%s': %w", ref.Path, err)
+		}
+		req.Script = ScriptFromString(strings.TrimSuffix(string(b), "\n"))
+		c.scriptFiles[req.File] = true
 	}
-	return fmt.Errorf("no request named '%s' found in collection '%s'", name, c.Name)
+	return nil
 }
 
-func (c *Collection) UpsertRequest(req *Request) *Collection {
-	found := false
-	for i, r := range c.Requests {
-		if r.Name == req.Name {
-			c.Requests[i] = *req
-			found = true
+// writeScriptFiles writes out the scripts kept in files, giving a file to any request without one while the collection
+// has a ScriptDir, and removes the files of scripts since moved or deleted
+func (c *Collection) writeScriptFiles() error {
+	written := make(map[string]bool)
+	for _, ref := range c.AllRequests() {
+		req := ref.Request
+		if req.File == "" && c.ScriptDir != "" {
+			req.File = c.defaultScriptFile(ref.Path)
+		}
+		file := req.File
+		if file == "" {
+			continue
+		}
+		fpath := c.scriptFilePath(file)
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(fpath, []byte(req.Script.String()+"\n"), defaultPerms); err != nil {
+			return err
 		}
+		written[file] = true
 	}
-	if !found {
-		c.Requests = append(c.Requests, *req)
+	for file := range c.scriptFiles {
+		if written[file] {
+			continue
+		}
+		if err := os.Remove(c.scriptFilePath(file)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
-	return c
+	c.scriptFiles = written
+	return nil
 }
 
-func (c *Collection) EditRequest(reqName string) error {
-	path := c.Path
-	cb := func(b []byte) error {
-		coll, err := ReadCollection(path)
-		if err != nil {
-			return err
+// ReadCollection reads the collection at path. One written by an older version of sqump is upgraded as it's read,
+// leaving the file as it is until the collection is saved or `sqump migrate` is run.
+func ReadCollection(path string) (*Collection, error) {
+	return readCollection(path)
+}
+
+func readCollection(path string) (*Collection, error) {
+	unlock, err := lockFile(path, false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound{
+			MissingItem: "collection",
+			Location:    path,
 		}
-		req, ok := coll.GetRequest(reqName)
-		if !ok {
-			return ErrNotFound{
-				MissingItem: reqName,
-				Location:    coll.Name,
+	} else if err != nil {
+		return nil, err
+	}
+
+	var s Collection
+	format := FormatOf(path)
+	err = format.unmarshal(b, &s)
+	if err != nil {
+		return nil, err
+	}
+	err = checkVersion("collection", path, s.Version, CurrentVersion)
+	if _, ok := err.(errOutdated); ok {
+		var migrated []byte
+		var upgrade *Upgrade
+		if migrated, upgrade, err = migrate("collection", path, format, b, CurrentVersion, collectionMigrations); err != nil {
+			return nil, err
+		}
+		s = Collection{upgrade: upgrade}
+		err = format.unmarshal(migrated, &s)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.Path = path
+	// A malformed mock route would panic in the router, so hand edits are caught before anything serves them
+	for _, ref := range s.AllRequests() {
+		if mock := ref.Request.Mock; mock != nil {
+			if err = mock.validate(); err != nil {
+				return nil, fmt.Errorf("mock route '%s' %v", ref.Path, err)
 			}
 		}
-		req.Script = ScriptFromString(strings.TrimSpace(string(b)))
-		return coll.UpsertRequest(req).Flush()
 	}
+	err = s.readScriptFiles()
+	if err != nil {
+		return nil, err
+	}
+	s.revision, err = revision(s.revisionPaths()...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func WriteDefaultCollection() error {
+	sf := DefaultCollection()
+	return sf.Flush()
+}
 
+// EditRequest opens the request's script for editing. Saves apply to the collection as it was when editing began, so
+// that changes made to it elsewhere meanwhile are reported as conflicts rather than overwritten.
+func (c *Collection) EditRequest(reqName string) error {
 	req, ok := c.GetRequest(reqName)
 	if !ok {
 		return ErrNotFound{
@@ -283,29 +647,25 @@ func (c *Collection) EditRequest(reqName string) error {
 			Location:    c.Name,
 		}
 	}
-	b, err := EditBuffer([]byte(req.Script.String()), fmt.Sprintf("%s-%s-*.lua", c.Name, reqName), cb)
-	if err != nil {
-		return err
+	cb := func(b []byte) error {
+		req.Script = ScriptFromString(strings.TrimSpace(string(b)))
+		return c.UpsertRequest(reqName, req).Flush()
 	}
 
-	return cb(b)
+	_, err := EditBuffer([]byte(req.Script.String()), fmt.Sprintf("%s-%s-*.lua", c.Name, reqName), cb)
+	return err
 }
 
 func (c *Collection) EditEnv() error {
-	path := c.Path
 	cb := func(b []byte) error {
-		coll, err := ReadCollection(path)
-		if err != nil {
-			return err
-		}
 		var e EnvMap
-		err = json.Unmarshal(b, &e)
+		err := json.Unmarshal(b, &e)
 		if err != nil {
 			return err
 		}
 
-		coll.Environment = e
-		return coll.Flush()
+		c.Environment = e
+		return c.Flush()
 	}
 
 	envBytes, err := json.MarshalIndent(c.Environment, "", "  ")
@@ -314,32 +674,47 @@ func (c *Collection) EditEnv() error {
 	}
 
 	basename := filepath.Base(c.Name)
-	b, err := EditBuffer(envBytes, fmt.Sprintf("%s-config-*.json", basename), cb)
-	if err != nil {
-		return err
-	}
-
-	return cb(b)
+	_, err = EditBuffer(envBytes, fmt.Sprintf("%s-config-*.json", basename), cb)
+	return err
 }
 
 func (c *Collection) EditName() error {
-	path := c.Path
 	cb := func(b []byte) error {
-		coll, err := ReadCollection(path)
-		if err != nil {
-			return err
-		}
-		coll.Name = strings.TrimSpace(string(b))
-		return coll.Flush()
+		c.Name = strings.TrimSpace(string(b))
+		return c.Flush()
 	}
 
 	basename := filepath.Base(c.Name)
-	b, err := EditBuffer([]byte(c.Name), fmt.Sprintf("%s-name-*.json", basename), cb)
-	if err != nil {
-		return err
+	_, err := EditBuffer([]byte(c.Name), fmt.Sprintf("%s-name-*.json", basename), cb)
+	return err
+}
+
+const (
+	HookPre  = "pre"
+	HookPost = "post"
+)
+
+// EditHook opens the collection's pre or post script for editing
+func (c *Collection) EditHook(hook string) error {
+	var script *Script
+	switch hook {
+	case HookPre:
+		script = &c.Pre
+	case HookPost:
+		script = &c.Post
+	default:
+		return fmt.Errorf("unrecognized hook '%s', expected '%s' or '%s'", hook, HookPre, HookPost)
+	}
+	cb := func(b []byte) error {
+		*script = nil
+		if trimmed := strings.TrimSpace(string(b)); trimmed != "" {
+			*script = ScriptFromString(trimmed)
+		}
+		return c.Flush()
 	}
 
-	return cb(b)
+	_, err := EditBuffer([]byte(script.String()), fmt.Sprintf("%s-%s-*.lua", c.Name, hook), cb)
+	return err
 }
 
 func (c *Collection) validate() error {
@@ -349,18 +724,16 @@ func (c *Collection) validate() error {
 	if strings.Contains(c.Name, ".") {
 		return fmt.Errorf("Illegal character '.' detected in collection name '%s'", c.Name)
 	}
-	reqNames := make(map[string]bool, len(c.Requests))
-	for _, req := range c.Requests {
-		if strings.Contains(req.Name, ".") {
-			return fmt.Errorf("Illegal character '.' detected in request name '%s'", req.Name)
-		}
-		if _, ok := reqNames[req.Name]; ok {
-			return fmt.Errorf("duplicate request name '%s'", req.Name)
-		} else {
-			reqNames[req.Name] = true
-		}
+	if c.ScriptDir != "" && !filepath.IsLocal(filepath.FromSlash(c.ScriptDir)) {
+		return fmt.Errorf("script directory '%s' must be a relative path within the collection's directory", c.ScriptDir)
 	}
-	return nil
+	if err := validateLib(c.Lib); err != nil {
+		return err
+	}
+	if err := c.validateRequests(); err != nil {
+		return err
+	}
+	return c.validateWorkflows()
 }
 
 func (c *Collection) SetEnvVar(env, key, val string) {
@@ -384,8 +757,38 @@ func (c *Collection) PrintInfo() {
 	prnt.Println("Name:", strOrNone(c.Name))
 	prnt.Println("Version:", strOrNone(c.Version.String()))
 	prnt.Println("Requests:")
-	for _, req := range c.Requests {
-		prnt.Printf("  %s\n", strOrNone(req.Name))
+	for _, ref := range c.AllRequests() {
+		line := strOrNone(ref.Path)
+		if ref.Request.Mock != nil {
+			line += fmt.Sprintf(" (mock: %s)", ref.Request.Mock)
+		}
+		if len(ref.Request.Tags) > 0 {
+			line += " #" + strings.Join(ref.Request.Tags, " #")
+		}
+		prnt.Printf("  %s\n", line)
+	}
+	if len(c.Pre) > 0 || len(c.Post) > 0 {
+		hooks := make([]string, 0, 2)
+		if len(c.Pre) > 0 {
+			hooks = append(hooks, HookPre)
+		}
+		if len(c.Post) > 0 {
+			hooks = append(hooks, HookPost)
+		}
+		prnt.Println("Hooks:", strings.Join(hooks, ", "))
+	}
+	if len(c.Lib) > 0 {
+		prnt.Println("Lib:", strings.Join(c.Lib, ", "))
+	}
+	if len(c.Workflows) > 0 {
+		prnt.Println("Workflows:")
+		for _, w := range c.Workflows {
+			steps := make([]string, 0, len(w.Steps))
+			for _, step := range w.Steps {
+				steps = append(steps, step.StepName())
+			}
+			prnt.Printf("  %s: %s\n", w.Name, strings.Join(steps, ", "))
+		}
 	}
 	c.Environment.PrintInfo()
 }
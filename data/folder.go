@@ -0,0 +1,320 @@
+package data
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Folder groups requests within a collection, and may nest. A request in a folder is named by its dotted path from
+// the collection root, e.g. "Users.Admin.CreateUser".
+type Folder struct {
+	Name     string    `json:"name" yaml:"name" toml:"name"`
+	Requests []Request `json:"requests,omitempty" yaml:"requests,omitempty" toml:"requests,omitempty"`
+	Folders  []Folder  `json:"folders,omitempty" yaml:"folders,omitempty" toml:"folders,omitempty"`
+	// Environment holds defaults for the folder's requests, over the collection's and those of outer folders
+	Environment EnvMap `json:"environment,omitempty" yaml:"environment,omitempty" toml:"environment,omitempty"`
+	// Pre runs before each of the folder's requests, and Post after each that succeeds, in the same state
+	Pre  Script `json:"pre,omitempty" yaml:"pre,omitempty" toml:"pre,omitempty"`
+	Post Script `json:"post,omitempty" yaml:"post,omitempty" toml:"post,omitempty"`
+}
+
+// RequestRef locates a request within the collection's folders
+type RequestRef struct {
+	// Path is the request's dotted name from the collection root
+	Path string
+	// Request points into the collection, so is only good until the collection is next changed
+	Request *Request
+	// Folders hold the request, outermost first
+	Folders []*Folder
+}
+
+func JoinRequestPath(segments ...string) string {
+	return strings.Join(segments, ".")
+}
+
+// SplitRequestPath separates a request path into its folders and the request's own name
+func SplitRequestPath(path string) ([]string, string) {
+	segments := strings.Split(path, ".")
+	return segments[:len(segments)-1], segments[len(segments)-1]
+}
+
+// container is the collection's top level, or one of its folders
+type container struct {
+	requests *[]Request
+	folders  *[]Folder
+}
+
+func (c *Collection) root() container {
+	return container{requests: &c.Requests, folders: &c.Folders}
+}
+
+func (f *Folder) container() container {
+	return container{requests: &f.Requests, folders: &f.Folders}
+}
+
+// containerAt finds the named folders, creating those missing if asked
+func (c *Collection) containerAt(folders []string, create bool) (container, []*Folder, bool) {
+	ct := c.root()
+	chain := make([]*Folder, 0, len(folders))
+	for _, name := range folders {
+		idx := slices.IndexFunc(*ct.folders, func(f Folder) bool { return f.Name == name })
+		if idx < 0 {
+			if !create {
+				return container{}, nil, false
+			}
+			*ct.folders = append(*ct.folders, Folder{Name: name})
+			idx = len(*ct.folders) - 1
+		}
+		f := &(*ct.folders)[idx]
+		chain = append(chain, f)
+		ct = f.container()
+	}
+	return ct, chain, true
+}
+
+// AllRequests lists every request in the collection, those at the top level first, then those of each folder in turn
+func (c *Collection) AllRequests() []RequestRef {
+	refs := make([]RequestRef, 0, len(c.Requests))
+	var walk func(ct container, prefix []string, chain []*Folder)
+	walk = func(ct container, prefix []string, chain []*Folder) {
+		for i := range *ct.requests {
+			req := &(*ct.requests)[i]
+			refs = append(refs, RequestRef{
+				Path:    JoinRequestPath(append(slices.Clone(prefix), req.Name)...),
+				Request: req,
+				Folders: chain,
+			})
+		}
+		for i := range *ct.folders {
+			f := &(*ct.folders)[i]
+			walk(f.container(), append(slices.Clone(prefix), f.Name), append(slices.Clone(chain), f))
+		}
+	}
+	walk(c.root(), nil, nil)
+	return refs
+}
+
+// RequestsTagged lists the requests carrying the tag, in the order of AllRequests
+func (c *Collection) RequestsTagged(tag string) []RequestRef {
+	var refs []RequestRef
+	for _, ref := range c.AllRequests() {
+		if ref.Request.HasTag(tag) {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+func (c *Collection) GetRequestRef(path string) (RequestRef, bool) {
+	folders, name := SplitRequestPath(path)
+	ct, chain, ok := c.containerAt(folders, false)
+	if !ok {
+		return RequestRef{}, false
+	}
+	idx := slices.IndexFunc(*ct.requests, func(r Request) bool { return r.Name == name })
+	if idx < 0 {
+		return RequestRef{}, false
+	}
+	return RequestRef{Path: path, Request: &(*ct.requests)[idx], Folders: chain}, true
+}
+
+// GetRequest gives a copy of the request at the dotted path
+func (c *Collection) GetRequest(path string) (*Request, bool) {
+	ref, ok := c.GetRequestRef(path)
+	if !ok {
+		return nil, false
+	}
+	req := *ref.Request
+	return &req, true
+}
+
+// AddRequest puts the request at the dotted path, creating any folders it names
+func (c *Collection) AddRequest(path string, req *Request) error {
+	if _, ok := c.GetRequest(path); ok {
+		return fmt.Errorf("request '%s' already exists in collection '%s'", path, c.Name)
+	}
+	c.UpsertRequest(path, req)
+	return nil
+}
+
+// UpsertRequest puts the request at the dotted path, replacing any already there
+func (c *Collection) UpsertRequest(path string, req *Request) *Collection {
+	folders, name := SplitRequestPath(path)
+	ct, _, _ := c.containerAt(folders, true)
+	req.Name = name
+	idx := slices.IndexFunc(*ct.requests, func(r Request) bool { return r.Name == name })
+	if idx < 0 {
+		*ct.requests = append(*ct.requests, *req)
+	} else {
+		(*ct.requests)[idx] = *req
+	}
+	return c
+}
+
+func (c *Collection) RemoveRequest(path string) error {
+	folders, name := SplitRequestPath(path)
+	ct, _, ok := c.containerAt(folders, false)
+	if ok {
+		idx := slices.IndexFunc(*ct.requests, func(r Request) bool { return r.Name == name })
+		if idx >= 0 {
+			*ct.requests = slices.Delete(*ct.requests, idx, idx+1)
+			return c.Flush()
+		}
+	}
+	return fmt.Errorf("no request named '%s' found in collection '%s'", path, c.Name)
+}
+
+// RenameRequest gives the request at the dotted path a new name within its folder, updating the workflow steps that
+// run it
+func (c *Collection) RenameRequest(oldPath, newName string) {
+	ref, ok := c.GetRequestRef(oldPath)
+	if !ok {
+		return
+	}
+	folders, _ := SplitRequestPath(oldPath)
+	newPath := JoinRequestPath(append(folders, newName)...)
+	ref.Request.Name = newName
+	// Scripts kept at their default spot move along with the name
+	if c.ScriptDir != "" && ref.Request.File == c.defaultScriptFile(oldPath) {
+		ref.Request.File = c.defaultScriptFile(newPath)
+	}
+	for _, w := range c.Workflows {
+		for _, steps := range [][]WorkflowStep{w.Steps, w.Cleanup} {
+			for i := range steps {
+				if steps[i].Request == oldPath {
+					steps[i].Request = newPath
+				}
+			}
+		}
+	}
+}
+
+// EnvironmentFor merges the environments of the folders holding the request over the collection's
+func (c *Collection) EnvironmentFor(path string) EnvMap {
+	env := c.Environment.DeepCopy()
+	ref, ok := c.GetRequestRef(path)
+	if !ok {
+		return env
+	}
+	for _, f := range ref.Folders {
+		for name, vals := range f.Environment {
+			if env[name] == nil {
+				env[name] = make(EnvMapValue, len(vals))
+			}
+			for k, v := range vals {
+				env[name][k] = v
+			}
+		}
+	}
+	return env
+}
+
+func sortContainer(ct container) {
+	slices.SortFunc(*ct.requests, func(a, b Request) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	slices.SortFunc(*ct.folders, func(a, b Folder) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	for i := range *ct.folders {
+		sortContainer((*ct.folders)[i].container())
+	}
+}
+
+// withoutFileScripts copies the requests and folders, leaving out the scripts kept in files
+func withoutFileScripts(requests []Request, folders []Folder) ([]Request, []Folder) {
+	outRequests := make([]Request, len(requests))
+	for i, req := range requests {
+		if req.File != "" {
+			req.Script = nil
+		}
+		outRequests[i] = req
+	}
+	var outFolders []Folder
+	for _, f := range folders {
+		f.Requests, f.Folders = withoutFileScripts(f.Requests, f.Folders)
+		outFolders = append(outFolders, f)
+	}
+	return outRequests, outFolders
+}
+
+func (c *Collection) validateRequests() error {
+	mockRoutes := make(map[string]bool)
+	scriptFiles := make(map[string]string)
+	var check func(ct container, where string) error
+	check = func(ct container, where string) error {
+		reqNames := make(map[string]bool, len(*ct.requests))
+		for _, req := range *ct.requests {
+			if req.Mock != nil {
+				if err := req.Mock.validate(); err != nil {
+					return fmt.Errorf("mock route '%s' %v", req.Name, err)
+				}
+				if mockRoutes[req.Mock.String()] {
+					return fmt.Errorf("duplicate mock route '%s'", req.Mock.String())
+				}
+				mockRoutes[req.Mock.String()] = true
+			}
+			if strings.Contains(req.Name, ".") {
+				return fmt.Errorf("Illegal character '.' detected in request name '%s'", req.Name)
+			}
+			if req.File != "" {
+				if err := checkScriptFile(req.Name, req.File); err != nil {
+					return err
+				}
+			}
+			if other, ok := scriptFiles[req.File]; ok && req.File != "" {
+				return fmt.Errorf("requests '%s' and '%s' share script file '%s'", other, req.Name, req.File)
+			}
+			scriptFiles[req.File] = req.Name
+			for _, tag := range req.Tags {
+				if tag == "" || strings.ContainsFunc(tag, unicode.IsSpace) {
+					return fmt.Errorf("request '%s' has tag '%s', which must be non-empty and without spaces", req.Name, tag)
+				}
+			}
+			for _, key := range req.RequiredEnv {
+				if key == "" {
+					return fmt.Errorf("request '%s' lists an empty required environment key", req.Name)
+				}
+			}
+			paramNames := make(map[string]bool, len(req.Params))
+			for _, param := range req.Params {
+				if err := param.validate(); err != nil {
+					return fmt.Errorf("request '%s': %v", req.Name, err)
+				}
+				if paramNames[param.Name] {
+					return fmt.Errorf("request '%s' has duplicate parameter '%s'", req.Name, param.Name)
+				}
+				paramNames[param.Name] = true
+			}
+			if _, ok := reqNames[req.Name]; ok {
+				return fmt.Errorf("duplicate request name '%s'%s", req.Name, where)
+			} else {
+				reqNames[req.Name] = true
+			}
+		}
+		folderNames := make(map[string]bool, len(*ct.folders))
+		for i := range *ct.folders {
+			f := &(*ct.folders)[i]
+			if f.Name == "" {
+				return fmt.Errorf("folder with no name%s", where)
+			}
+			if strings.Contains(f.Name, ".") {
+				return fmt.Errorf("Illegal character '.' detected in folder name '%s'", f.Name)
+			}
+			if folderNames[f.Name] {
+				return fmt.Errorf("duplicate folder name '%s'%s", f.Name, where)
+			}
+			folderNames[f.Name] = true
+			if err := f.Environment.validate(); err != nil {
+				return err
+			}
+			if err := check(f.container(), fmt.Sprintf(" in folder '%s'", f.Name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return check(c.root(), "")
+}
@@ -0,0 +1,232 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is how a collection is written, as given by its file's extension
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+)
+
+var Formats = []Format{FormatJSON, FormatYAML, FormatTOML}
+
+// FormatOf gives the format of the collection at path, defaulting to JSON for unrecognized extensions
+func FormatOf(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatJSON
+	}
+}
+
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == strings.ToLower(s) {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unrecognized format '%s', expected one of: %s", s, strings.Join(formatNames(), ", "))
+}
+
+func formatNames() []string {
+	names := make([]string, 0, len(Formats))
+	for _, f := range Formats {
+		names = append(names, string(f))
+	}
+	return names
+}
+
+// IsSqumpfile reports whether name is that of a collection for `autoregister` to find, e.g. "Squmpfile.yaml"
+func IsSqumpfile(name string) bool {
+	ext := filepath.Ext(name)
+	if strings.TrimSuffix(name, ext) != "Squmpfile" {
+		return false
+	}
+	switch strings.ToLower(ext) {
+	case ".json", ".yaml", ".yml", ".toml":
+		return true
+	default:
+		return false
+	}
+}
+
+// Ext is the file extension collections in the format are given
+func (f Format) Ext() string {
+	return "." + string(f)
+}
+
+// Convert rewrites the collection in the given format beside the file it was read from, which is then removed, giving
+// the new file's path
+func (c *Collection) Convert(format Format) (string, error) {
+	if FormatOf(c.Path) == format {
+		return "", fmt.Errorf("collection at '%s' is already in %s", c.Path, format)
+	}
+	newPath := strings.TrimSuffix(c.Path, filepath.Ext(c.Path)) + format.Ext()
+	if err := c.validate(); err != nil {
+		return "", err
+	}
+	unlock, err := lockFile(c.Path, true)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	if err = c.checkRevision(); err != nil {
+		return "", err
+	}
+	unlockNew, err := lockFile(newPath, true)
+	if err != nil {
+		return "", err
+	}
+	defer unlockNew()
+	if _, err = os.Stat(newPath); err == nil {
+		return "", fmt.Errorf("file already exists at '%s'", newPath)
+	}
+
+	oldPath := c.Path
+	c.Path = newPath
+	if err = c.flush(); err != nil {
+		c.Path = oldPath
+		return "", err
+	}
+	return newPath, os.Remove(oldPath)
+}
+
+func (f Format) marshal(v any) ([]byte, error) {
+	switch f {
+	case FormatYAML:
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case FormatTOML:
+		var buf bytes.Buffer
+		enc := toml.NewEncoder(&buf)
+		// Scripts can't be indented, so nothing else is for the sake of consistency
+		enc.Indent = ""
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return json.MarshalIndent(v, "", "  ")
+	}
+}
+
+func (f Format) unmarshal(b []byte, v any) error {
+	switch f {
+	case FormatYAML:
+		return yaml.Unmarshal(b, v)
+	case FormatTOML:
+		_, err := toml.Decode(string(b), v)
+		return err
+	default:
+		return json.Unmarshal(b, v)
+	}
+}
+
+// Scripts are kept as arrays of lines in JSON, which has no multi-line strings, but as block strings in YAML and TOML.
+// These end in a newline, as scripts kept in files do, which is trimmed again on reading.
+
+func (s Script) MarshalYAML() (any, error) {
+	return &yaml.Node{
+		Kind:  yaml.ScalarNode,
+		Style: yaml.LiteralStyle,
+		Value: s.String() + "\n",
+	}, nil
+}
+
+func (s *Script) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		var lines []string
+		if err := node.Decode(&lines); err != nil {
+			return err
+		}
+		*s = lines
+		return nil
+	}
+	var str string
+	if err := node.Decode(&str); err != nil {
+		return err
+	}
+	*s = ScriptFromString(strings.TrimSuffix(str, "\n"))
+	return nil
+}
+
+func (s Script) MarshalTOML() ([]byte, error) {
+	str := s.String() + "\n"
+	// Literal strings need no escaping, so long as they don't hold their delimiter
+	if !strings.Contains(str, "'''") && !hasControlChars(str) {
+		return []byte("'''\n" + str + "'''"), nil
+	}
+	return []byte(`"""` + "\n" + escapeTOML(str) + `"""`), nil
+}
+
+func (s *Script) UnmarshalTOML(v any) error {
+	switch val := v.(type) {
+	case string:
+		*s = ScriptFromString(strings.TrimSuffix(val, "\n"))
+		return nil
+	case []any:
+		lines := make([]string, 0, len(val))
+		for _, line := range val {
+			str, ok := line.(string)
+			if !ok {
+				return fmt.Errorf("expected script lines to be strings, got: %T", line)
+			}
+			lines = append(lines, str)
+		}
+		*s = lines
+		return nil
+	default:
+		return fmt.Errorf("expected script to be a string or array of lines, got: %T", v)
+	}
+}
+
+// hasControlChars reports whether s holds characters besides tabs and newlines that TOML requires be escaped
+func hasControlChars(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool {
+		return (r < 0x20 && r != '\t' && r != '\n') || r == 0x7f
+	}) >= 0
+}
+
+// escapeTOML escapes s for a multi-line basic string
+func escapeTOML(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '\n' || r == '\t':
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u%04X`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
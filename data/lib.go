@@ -0,0 +1,75 @@
+package data
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LibPaths gives the collection's library files and directories, resolved against the collection's directory
+func (c *Collection) LibPaths() []string {
+	return resolveLibPaths(filepath.Dir(c.Path), c.Lib)
+}
+
+// LibPaths gives the config's library files and directories, resolved against the config's directory
+func (c *Config) LibPaths() []string {
+	return resolveLibPaths(filepath.Dir(c.Path), c.Lib)
+}
+
+func resolveLibPaths(base string, lib []string) []string {
+	paths := make([]string, 0, len(lib))
+	for _, p := range lib {
+		p = filepath.FromSlash(p)
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(base, p)
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+func validateLib(lib []string) error {
+	for _, p := range lib {
+		if strings.TrimSpace(p) == "" {
+			return fmt.Errorf("library paths must not be empty")
+		}
+	}
+	return nil
+}
+
+// FindLibModule looks for the module of the given name, e.g. "auth.token", under each of paths in turn, giving the file
+// it's kept in. A '.lua' file provides the module named for it, and a directory those of the '.lua' files beneath it,
+// named by their dotted path within it, e.g. "auth/token.lua", with "auth/init.lua" providing "auth".
+func FindLibModule(paths []string, name string) (string, bool) {
+	segments := strings.Split(name, ".")
+	for _, s := range segments {
+		if s == "" || strings.ContainsAny(s, `/\`) {
+			return "", false
+		}
+	}
+	isFile := func(p string) bool {
+		info, err := os.Stat(p)
+		return err == nil && !info.IsDir()
+	}
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			if strings.TrimSuffix(filepath.Base(p), ".lua") == name && filepath.Ext(p) == ".lua" {
+				return p, true
+			}
+			continue
+		}
+		nested := filepath.Join(append([]string{p}, segments...)...)
+		if candidate := nested + ".lua"; isFile(candidate) {
+			return candidate, true
+		}
+		if candidate := filepath.Join(nested, "init.lua"); isFile(candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
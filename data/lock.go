@@ -0,0 +1,137 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+)
+
+var (
+	fileLocksMu sync.Mutex
+	fileLocks   = make(map[string]*sync.RWMutex)
+)
+
+// lockFile locks the file at path against other goroutines and, through an advisory lock on a file in the user's
+// cache directory, other sqump processes, returning the function to release it. Readers share the lock and writers
+// hold it alone.
+func lockFile(path string, exclusive bool) (func(), error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	fileLocksMu.Lock()
+	mu, ok := fileLocks[abs]
+	if !ok {
+		mu = &sync.RWMutex{}
+		fileLocks[abs] = mu
+	}
+	fileLocksMu.Unlock()
+
+	unlockMu := mu.RUnlock
+	if exclusive {
+		mu.Lock()
+		unlockMu = mu.Unlock
+	} else {
+		mu.RLock()
+	}
+	lockPath, err := lockFilePath(abs)
+	if err != nil {
+		unlockMu()
+		return nil, err
+	}
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		unlockMu()
+		return nil, fmt.Errorf("opening lock for '%s': %v", path, err)
+	}
+	if err = lockFD(f, exclusive); err != nil {
+		_ = f.Close()
+		unlockMu()
+		return nil, fmt.Errorf("locking '%s': %v", path, err)
+	}
+	return func() {
+		_ = unlockFD(f)
+		_ = f.Close()
+		unlockMu()
+	}, nil
+}
+
+// lockFilePath is where the advisory lock for the file at abs is taken. It's kept out of the file's own directory so
+// as not to clutter the user's repositories, and apart from the file itself as that's replaced on every write.
+func lockFilePath(abs string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	dir = filepath.Join(dir, "sqump", "locks")
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("creating lock directory: %v", err)
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".lock"), nil
+}
+
+// writeFileAtomic writes b to a temporary file beside path before renaming it into place, so readers see either the
+// old contents or the new and never a partial write
+func writeFileAtomic(path string, b []byte, perm os.FileMode) error {
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// Removing the temp file fails harmlessly once it's been renamed
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// revision identifies the contents of the files at paths, so that writers can tell whether they've changed since they
+// were read
+func revision(paths ...string) (string, error) {
+	h := sha256.New()
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if os.IsNotExist(err) {
+			fmt.Fprintf(h, "%s missing\n", p)
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %d\n", p, len(b))
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8]), nil
+}
+
+// ErrConflict is returned when saving a file that something else has changed since it was read, which saving would
+// otherwise clobber
+type ErrConflict struct {
+	Kind string
+	Path string
+}
+
+func (e ErrConflict) Error() string {
+	return fmt.Sprintf("%s at '%s' was changed elsewhere since it was read, reload it and try again", e.Kind, e.Path)
+}
+func (e ErrConflict) Is(target error) bool {
+	return reflect.TypeOf(target) == reflect.TypeOf(ErrConflict{})
+}
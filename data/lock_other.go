@@ -0,0 +1,15 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package data
+
+import "os"
+
+// Platforms without advisory file locking are only protected against writers within the same process
+
+func lockFD(*os.File, bool) error {
+	return nil
+}
+
+func unlockFD(*os.File) error {
+	return nil
+}
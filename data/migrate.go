@@ -0,0 +1,160 @@
+package data
+
+import (
+	"fmt"
+)
+
+// CurrentConfigVersion is that of configs written by this version of sqump, see configMigrations
+var CurrentConfigVersion = NewSemVer(0, 1, 0)
+
+// migration upgrades a file's contents, as read from the version before it, to the version `to`
+type migration struct {
+	to          SemVer
+	description string
+	// apply is given the file decoded into generic maps and slices, from whichever format it was written in
+	apply func(raw map[string]any) error
+}
+
+// collectionMigrations are applied in order to collections older than each step's version
+var collectionMigrations = []migration{
+	{
+		to:          NewSemVer(0, 2, 0),
+		description: "folders, hooks, workflows, script files, and request metadata, which older versions drop on saving",
+		apply:       func(map[string]any) error { return nil },
+	},
+}
+
+// configMigrations are applied in order to configs older than each step's version
+var configMigrations = []migration{}
+
+// ErrNewerVersion is returned for files written by a newer version of sqump, which this one can't safely read
+type ErrNewerVersion struct {
+	Kind      string
+	Path      string
+	Version   SemVer
+	Supported SemVer
+}
+
+func (e ErrNewerVersion) Error() string {
+	return fmt.Sprintf("%s at '%s' has version %s, newer than the %s this version of sqump supports, please upgrade sqump to use it", e.Kind, e.Path, e.Version, e.Supported)
+}
+
+// errOutdated is returned when reading a file that needs upgrading first
+type errOutdated struct {
+	version SemVer
+}
+
+func (e errOutdated) Error() string {
+	return fmt.Sprintf("file is at outdated version %s", e.version)
+}
+
+// Upgrade records a file brought up to the current version
+type Upgrade struct {
+	Path string
+	From SemVer
+	To   SemVer
+	// Backup holds the file as it was before upgrading
+	Backup string
+	// Steps describe what changed along the way
+	Steps []string
+	// original is the file as read, written to Backup when the upgraded file is first saved over it
+	original []byte
+}
+
+// backup writes out the file as it was before upgrading
+func (u *Upgrade) backup(kind string) error {
+	if err := writeFileAtomic(u.Backup, u.original, defaultPerms); err != nil {
+		return fmt.Errorf("backing up %s before upgrading: %v", kind, err)
+	}
+	return nil
+}
+
+func (u Upgrade) String() string {
+	return fmt.Sprintf("upgraded '%s' from %s to %s, backup at '%s'", u.Path, u.From, u.To, u.Backup)
+}
+
+func checkVersion(kind, path string, version, current SemVer) error {
+	switch version.Compare(current) {
+	case 1:
+		return ErrNewerVersion{
+			Kind:      kind,
+			Path:      path,
+			Version:   version,
+			Supported: current,
+		}
+	case -1:
+		return errOutdated{version: version}
+	default:
+		return nil
+	}
+}
+
+// migrate applies the steps newer than the file's version in b, giving the upgraded file in the same format, or nil
+// if the file is already current
+func migrate(kind, path string, format Format, b []byte, current SemVer, steps []migration) ([]byte, *Upgrade, error) {
+	var raw map[string]any
+	if err := format.unmarshal(b, &raw); err != nil {
+		return nil, nil, err
+	}
+	var versioned struct {
+		Version SemVer `json:"version" yaml:"version" toml:"version"`
+	}
+	if err := format.unmarshal(b, &versioned); err != nil {
+		return nil, nil, err
+	}
+	err := checkVersion(kind, path, versioned.Version, current)
+	if _, ok := err.(errOutdated); !ok {
+		return nil, nil, err
+	}
+
+	upgrade := &Upgrade{
+		Path:     path,
+		From:     versioned.Version,
+		To:       current,
+		Backup:   fmt.Sprintf("%s.%s.bak", path, versioned.Version),
+		original: b,
+	}
+	for _, step := range steps {
+		if step.to.Compare(versioned.Version) <= 0 || step.to.Compare(current) > 0 {
+			continue
+		}
+		if err := step.apply(raw); err != nil {
+			return nil, nil, fmt.Errorf("upgrading %s at '%s' to %s: %v", kind, path, step.to, err)
+		}
+		upgrade.Steps = append(upgrade.Steps, fmt.Sprintf("%s: %s", step.to, step.description))
+	}
+	raw["version"] = current
+	migrated, err := format.marshal(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return migrated, upgrade, nil
+}
+
+// UpgradeCollection brings the collection at path up to the current version, keeping a backup of the file as it was.
+// It returns nil if the collection was already current.
+func UpgradeCollection(path string) (*Upgrade, error) {
+	coll, err := readCollection(path)
+	if err != nil || coll.upgrade == nil {
+		return nil, err
+	}
+	upgrade := coll.upgrade
+	if err = coll.Flush(); err != nil {
+		return nil, fmt.Errorf("upgrading collection at '%s': %w", path, err)
+	}
+	return upgrade, nil
+}
+
+// UpgradeConfig brings the config at path up to the current version, keeping a backup of the file as it was. It
+// returns nil if the config was already current.
+func UpgradeConfig(path string) (*Upgrade, error) {
+	conf, err := readConfig(path)
+	if err != nil || conf.upgrade == nil {
+		return nil, err
+	}
+	upgrade := conf.upgrade
+	if err = conf.Flush(); err != nil {
+		return nil, fmt.Errorf("upgrading config at '%s': %w", path, err)
+	}
+	return upgrade, nil
+}
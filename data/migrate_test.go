@@ -0,0 +1,115 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// renameRequests gives a step renaming every top-level request by appending suffix, counting each time it's applied
+func renameRequests(to SemVer, suffix string, applied *int) migration {
+	return migration{
+		to:          to,
+		description: "rename requests with " + suffix,
+		apply: func(raw map[string]any) error {
+			*applied++
+			requests, ok := raw["requests"].([]any)
+			if !ok {
+				return fmt.Errorf("expected requests array, got: %T", raw["requests"])
+			}
+			for _, r := range requests {
+				req := r.(map[string]any)
+				req["name"] = req["name"].(string) + suffix
+			}
+			return nil
+		},
+	}
+}
+
+func writeTestCollection(t *testing.T, version SemVer) (string, []byte) {
+	b, err := json.MarshalIndent(Collection{
+		Version:  version,
+		Name:     "Migrating",
+		Requests: []Request{{Name: "old", Script: Script{"print('hi')"}}},
+	}, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "Squmpfile.json")
+	if err = os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, b
+}
+
+func TestMigrate(t *testing.T) {
+	t.Run("applies steps in order", func(t *testing.T) {
+		path, b := writeTestCollection(t, NewSemVer(0, 1, 0))
+		var applied int
+		steps := []migration{
+			renameRequests(NewSemVer(0, 1, 0), "_old", &applied),
+			renameRequests(NewSemVer(0, 2, 0), "_a", &applied),
+			renameRequests(NewSemVer(0, 3, 0), "_b", &applied),
+			renameRequests(NewSemVer(0, 4, 0), "_future", &applied),
+		}
+		migrated, upgrade, err := migrate("collection", path, FormatJSON, b, NewSemVer(0, 3, 0), steps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var coll Collection
+		if err = json.Unmarshal(migrated, &coll); err != nil {
+			t.Fatal(err)
+		}
+		if coll.Requests[0].Name != "old_a_b" || coll.Version != NewSemVer(0, 3, 0) {
+			t.Errorf("expected only the steps after 0.1.0 up to 0.3.0 applied in order, got: %s at %s", coll.Requests[0].Name, coll.Version)
+		}
+		want := []string{"0.2.0: rename requests with _a", "0.3.0: rename requests with _b"}
+		if applied != 2 || !reflect.DeepEqual(upgrade.Steps, want) {
+			t.Errorf("expected steps %v, got %v (%d applied)", want, upgrade.Steps, applied)
+		}
+
+		again, upgrade, err := migrate("collection", path, FormatJSON, migrated, NewSemVer(0, 3, 0), steps)
+		if err != nil || again != nil || upgrade != nil || applied != 2 {
+			t.Errorf("expected a current file left alone, got: %s, %v, %v (%d applied)", again, upgrade, err, applied)
+		}
+	})
+
+	t.Run("rewrites collection on read and backs up on save", func(t *testing.T) {
+		var applied int
+		current, steps := CurrentVersion, collectionMigrations
+		CurrentVersion = NewSemVer(0, 3, 0)
+		collectionMigrations = append(slices.Clone(collectionMigrations), renameRequests(CurrentVersion, "_new", &applied))
+		t.Cleanup(func() {
+			CurrentVersion, collectionMigrations = current, steps
+		})
+		path, original := writeTestCollection(t, current)
+
+		coll, err := readCollection(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := coll.GetRequest("old_new"); !ok || applied != 1 {
+			t.Errorf("expected request renamed once on read, got: %v (%d applied)", coll.Requests, applied)
+		}
+		upgrade := coll.upgrade
+		if err = coll.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		backup, err := os.ReadFile(upgrade.Backup)
+		if err != nil || string(backup) != string(original) {
+			t.Errorf("expected backup to hold the file before migrating, got: %s, %v", backup, err)
+		}
+
+		coll, err = readCollection(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := coll.GetRequest("old_new"); !ok || coll.upgrade != nil || applied != 1 {
+			t.Errorf("expected saved collection current and not migrated again, got: %v, %v (%d applied)", coll.Requests, coll.upgrade, applied)
+		}
+	})
+}
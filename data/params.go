@@ -0,0 +1,140 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	ParamString  = "string"
+	ParamNumber  = "number"
+	ParamBoolean = "boolean"
+)
+
+var ParamTypes = []string{ParamString, ParamNumber, ParamBoolean}
+
+// Param is a value a request's script reads as `params.<name>`, given with `sqump exec --param`, the webview's form,
+// or as the second argument to `require`
+type Param struct {
+	Name string `json:"name" yaml:"name" toml:"name"`
+	// Type is one of "string" (the default), "number" or "boolean"
+	Type string `json:"type,omitempty" yaml:"type,omitempty" toml:"type,omitempty"`
+	// Default is used when no value is given, without which one must be
+	Default any `json:"default,omitempty" yaml:"default,omitempty" toml:"default,omitempty"`
+}
+
+func (p Param) TypeName() string {
+	if p.Type == "" {
+		return ParamString
+	}
+	return p.Type
+}
+
+// Required reports whether a value must be given, the parameter having no default
+func (p Param) Required() bool {
+	return p.Default == nil
+}
+
+// Value checks that v suits the parameter's type, giving it as a string, float64 or bool. Strings, as given on the
+// command line or from a form, are parsed for the other types.
+func (p Param) Value(v any) (any, error) {
+	switch p.TypeName() {
+	case ParamString:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case ParamNumber:
+		switch n := v.(type) {
+		case float64:
+			return n, nil
+		case int:
+			return float64(n), nil
+		case int64:
+			return float64(n), nil
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+			if err != nil {
+				return nil, fmt.Errorf("parameter '%s' expects a number, got '%s'", p.Name, n)
+			}
+			return f, nil
+		}
+	case ParamBoolean:
+		switch b := v.(type) {
+		case bool:
+			return b, nil
+		case string:
+			parsed, err := strconv.ParseBool(strings.TrimSpace(b))
+			if err != nil {
+				return nil, fmt.Errorf("parameter '%s' expects a boolean, got '%s'", p.Name, b)
+			}
+			return parsed, nil
+		}
+	default:
+		return nil, fmt.Errorf("parameter '%s' has type '%s', expected one of: %s", p.Name, p.Type, strings.Join(ParamTypes, ", "))
+	}
+	return nil, fmt.Errorf("parameter '%s' expects a %s, got: %T", p.Name, p.TypeName(), v)
+}
+
+// DefaultString gives the default as it would be typed for the parameter, or "" if it has none
+func (p Param) DefaultString() string {
+	if p.Default == nil {
+		return ""
+	}
+	v, err := p.Value(p.Default)
+	if err != nil {
+		return fmt.Sprint(p.Default)
+	}
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func (p Param) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("parameter with no name")
+	}
+	if p.Required() {
+		_, err := p.Value(p.zero())
+		return err
+	}
+	_, err := p.Value(p.Default)
+	return err
+}
+
+func (p Param) zero() any {
+	switch p.TypeName() {
+	case ParamNumber:
+		return 0.0
+	case ParamBoolean:
+		return false
+	default:
+		return ""
+	}
+}
+
+// ResolveParams checks the given values against the request's parameters, filling in defaults for those not given
+func (r *Request) ResolveParams(given map[string]any) (map[string]any, error) {
+	resolved := make(map[string]any, len(r.Params))
+	for _, p := range r.Params {
+		v, ok := given[p.Name]
+		if !ok {
+			if p.Required() {
+				return nil, fmt.Errorf("request '%s' requires parameter '%s'", r.Name, p.Name)
+			}
+			v = p.Default
+		}
+		val, err := p.Value(v)
+		if err != nil {
+			return nil, fmt.Errorf("request '%s': %v", r.Name, err)
+		}
+		resolved[p.Name] = val
+	}
+	for name := range given {
+		if _, ok := resolved[name]; !ok {
+			return nil, fmt.Errorf("request '%s' has no parameter '%s'", r.Name, name)
+		}
+	}
+	return resolved, nil
+}
@@ -0,0 +1,429 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	luaAMQPConsumerTypeName = "amqpconsumer"
+	luaAMQPProducerTypeName = "amqpproducer"
+)
+
+type AMQPConsumer struct {
+	conn       *amqp.Connection
+	ch         *amqp.Channel
+	deliveries <-chan amqp.Delivery
+}
+
+func (ac *AMQPConsumer) toUserData(L *lua.LState) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = ac
+	L.SetMetatable(ud, L.GetTypeMetatable(luaAMQPConsumerTypeName))
+	return ud
+}
+
+func (ac *AMQPConsumer) Close() error {
+	return closeAMQP(ac.conn, ac.ch)
+}
+
+type AMQPProducer struct {
+	conn   *amqp.Connection
+	ch     *amqp.Channel
+	Config struct {
+		Exchange string
+		Timeout  time.Duration
+	}
+}
+
+func (ap *AMQPProducer) toUserData(L *lua.LState) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = ap
+	L.SetMetatable(ud, L.GetTypeMetatable(luaAMQPProducerTypeName))
+	return ud
+}
+
+func (ap *AMQPProducer) Close() error {
+	return closeAMQP(ap.conn, ap.ch)
+}
+
+func (s *State) registerAMQPModule(L *lua.LState) {
+	L.PreloadModule("sqump_amqp", func(l *lua.LState) int {
+		// Register consumer type
+		{
+			consumerMT := L.NewTypeMetatable(luaAMQPConsumerTypeName)
+			L.SetGlobal(luaAMQPConsumerTypeName, consumerMT)
+			L.SetField(consumerMT, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+				"read_message": s.amqpReadMessage,
+				"close":        s.amqpConsumerClose,
+			}))
+		}
+		// Register producer type
+		{
+			producerMT := L.NewTypeMetatable(luaAMQPProducerTypeName)
+			L.SetGlobal(luaAMQPProducerTypeName, producerMT)
+			L.SetField(producerMT, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+				"write": s.amqpWriteMessage,
+				"close": s.amqpProducerClose,
+			}))
+		}
+		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"declare_exchange": s.amqpDeclareExchange,
+			"declare_queue":    s.amqpDeclareQueue,
+			"bind_queue":       s.amqpBindQueue,
+			"new_consumer":     s.newAMQPConsumer,
+			"new_producer":     s.newAMQPProducer,
+		})
+		L.Push(mod)
+		return 1
+	})
+}
+
+func (s *State) amqpDeclareExchange(_ *lua.LState) int {
+	url, err := getStringParam(s.LState, "url", 1)
+	if err != nil {
+		return s.CancelErr("error: declare_exchange: %v", err)
+	}
+	name, err := getStringParam(s.LState, "name", 2)
+	if err != nil {
+		return s.CancelErr("error: declare_exchange: %v", err)
+	}
+	kind, err := getStringParam(s.LState, "kind", 3)
+	if err != nil {
+		return s.CancelErr("error: declare_exchange: %v", err)
+	}
+	options, err := getOptionsParam(s.LState, "options", 4)
+	if err != nil {
+		return s.CancelErr("error: declare_exchange: %v", err)
+	}
+	err = s.withAMQPChannel(url, func(ch *amqp.Channel) error {
+		return ch.ExchangeDeclare(
+			name,
+			kind,
+			boolOrDefault(options, "durable", false),
+			boolOrDefault(options, "auto_delete", false),
+			false,
+			false,
+			nil,
+		)
+	})
+	if err != nil {
+		return s.CancelErr("error: declare_exchange: %v", err)
+	}
+	return 0
+}
+
+func (s *State) amqpDeclareQueue(_ *lua.LState) int {
+	url, err := getStringParam(s.LState, "url", 1)
+	if err != nil {
+		return s.CancelErr("error: declare_queue: %v", err)
+	}
+	name, err := getStringParam(s.LState, "name", 2)
+	if err != nil {
+		return s.CancelErr("error: declare_queue: %v", err)
+	}
+	options, err := getOptionsParam(s.LState, "options", 3)
+	if err != nil {
+		return s.CancelErr("error: declare_queue: %v", err)
+	}
+	var declared amqp.Queue
+	err = s.withAMQPChannel(url, func(ch *amqp.Channel) error {
+		declared, err = ch.QueueDeclare(
+			name,
+			boolOrDefault(options, "durable", false),
+			boolOrDefault(options, "auto_delete", false),
+			boolOrDefault(options, "exclusive", false),
+			false,
+			nil,
+		)
+		return err
+	})
+	if err != nil {
+		return s.CancelErr("error: declare_queue: %v", err)
+	}
+	// Server-named queues are requested with an empty name, so hand back what was created
+	s.LState.Push(lua.LString(declared.Name))
+	return 1
+}
+
+func (s *State) amqpBindQueue(_ *lua.LState) int {
+	url, err := getStringParam(s.LState, "url", 1)
+	if err != nil {
+		return s.CancelErr("error: bind_queue: %v", err)
+	}
+	queue, err := getStringParam(s.LState, "queue", 2)
+	if err != nil {
+		return s.CancelErr("error: bind_queue: %v", err)
+	}
+	exchange, err := getStringParam(s.LState, "exchange", 3)
+	if err != nil {
+		return s.CancelErr("error: bind_queue: %v", err)
+	}
+	routingKey, err := getStringParam(s.LState, "routing_key", 4)
+	if err != nil {
+		return s.CancelErr("error: bind_queue: %v", err)
+	}
+	err = s.withAMQPChannel(url, func(ch *amqp.Channel) error {
+		return ch.QueueBind(queue, routingKey, exchange, false, nil)
+	})
+	if err != nil {
+		return s.CancelErr("error: bind_queue: %v", err)
+	}
+	return 0
+}
+
+func (s *State) newAMQPConsumer(_ *lua.LState) int {
+	url, err := getStringParam(s.LState, "url", 1)
+	if err != nil {
+		return s.CancelErr("error: new_consumer: %v", err)
+	}
+	queue, err := getStringParam(s.LState, "queue", 2)
+	if err != nil {
+		return s.CancelErr("error: new_consumer: %v", err)
+	}
+	conn, ch, err := s.openAMQPChannel(url)
+	if err != nil {
+		return s.CancelErr("error: new_consumer: %v", err)
+	}
+	deliveries, err := ch.Consume(queue, "", true, false, false, false, nil)
+	if err != nil {
+		_ = closeAMQP(conn, ch)
+		return s.CancelErr("error: new_consumer: %v", err)
+	}
+	consumer := &AMQPConsumer{
+		conn:       conn,
+		ch:         ch,
+		deliveries: deliveries,
+	}
+	s.track(consumer)
+	s.LState.Push(consumer.toUserData(s.LState))
+	return 1
+}
+
+func (s *State) amqpReadMessage(_ *lua.LState) int {
+	consumer, err := getAMQPConsumerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: read_message: %v", err)
+	}
+	timeout, err := getIntParam(s.LState, "timeout", 2)
+	if err != nil {
+		return s.CancelErr("error: read_message: %v", err)
+	}
+	failOnTimeout, err := getBoolParam(s.LState, "fail_on_timeout", 3)
+	if err != nil {
+		return s.CancelErr("error: read_message: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	select {
+	case msg, ok := <-consumer.deliveries:
+		if !ok {
+			return s.CancelErr("error: read_message: delivery channel closed")
+		}
+		headers := &lua.LTable{}
+		for k, v := range msg.Headers {
+			headers.RawSetString(k, lua.LString(fmt.Sprint(v)))
+		}
+		ret := &lua.LTable{}
+		ret.RawSetString("exchange", lua.LString(msg.Exchange))
+		ret.RawSetString("routing_key", lua.LString(msg.RoutingKey))
+		ret.RawSetString("content_type", lua.LString(msg.ContentType))
+		ret.RawSetString("headers", headers)
+		ret.RawSetString("data", lua.LString(string(msg.Body)))
+		s.LState.Push(ret)
+		return 1
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && !failOnTimeout {
+			// Do nothing, we mean not to fail in this case (easiest to represent this way)
+			s.LState.Push(lua.LNil)
+			return 1
+		}
+		return s.CancelErr("error: read_message: %v", ctx.Err())
+	}
+}
+
+func (s *State) amqpConsumerClose(_ *lua.LState) int {
+	consumer, err := getAMQPConsumerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: consumer:close: %v", err)
+	}
+	if err = consumer.Close(); err != nil {
+		return s.CancelErr("error: consumer:close: %v", err)
+	}
+	return 0
+}
+
+func (s *State) newAMQPProducer(_ *lua.LState) int {
+	url, err := getStringParam(s.LState, "url", 1)
+	if err != nil {
+		return s.CancelErr("error: new_producer: %v", err)
+	}
+	exchange, err := getStringParam(s.LState, "exchange", 2)
+	if err != nil {
+		return s.CancelErr("error: new_producer: %v", err)
+	}
+	timeout, err := getIntParam(s.LState, "timeout", 3)
+	if err != nil {
+		return s.CancelErr("error: new_producer: %v", err)
+	}
+	conn, ch, err := s.openAMQPChannel(url)
+	if err != nil {
+		return s.CancelErr("error: new_producer: %v", err)
+	}
+	producer := &AMQPProducer{
+		conn: conn,
+		ch:   ch,
+	}
+	producer.Config.Exchange = exchange
+	producer.Config.Timeout = time.Duration(timeout) * time.Second
+	s.track(producer)
+	s.LState.Push(producer.toUserData(s.LState))
+	return 1
+}
+
+func (s *State) amqpWriteMessage(_ *lua.LState) int {
+	producer, err := getAMQPProducerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: write: %v", err)
+	}
+	routingKey, err := getStringParam(s.LState, "routing_key", 2)
+	if err != nil {
+		return s.CancelErr("error: write: %v", err)
+	}
+	data, err := getStringParam(s.LState, "data", 3)
+	if err != nil {
+		return s.CancelErr("error: write: %v", err)
+	}
+	options, err := getOptionsParam(s.LState, "options", 4)
+	if err != nil {
+		return s.CancelErr("error: write: %v", err)
+	}
+	headerMap, err := getStringMap(options, "headers")
+	if err != nil {
+		return s.CancelErr("error: write: %v", err)
+	}
+	headers := make(amqp.Table, len(headerMap))
+	for k, v := range headerMap {
+		headers[k] = v
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, producer.Config.Timeout)
+	defer cancel()
+	err = producer.ch.PublishWithContext(ctx, producer.Config.Exchange, routingKey, false, false, amqp.Publishing{
+		Headers:     headers,
+		ContentType: stringOrDefault(options, "content_type", "text/plain"),
+		Body:        []byte(data),
+	})
+	if err != nil {
+		return s.CancelErr("error: write: %v", err)
+	}
+	return 0
+}
+
+func (s *State) amqpProducerClose(_ *lua.LState) int {
+	producer, err := getAMQPProducerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: producer:close: %v", err)
+	}
+	if err = producer.Close(); err != nil {
+		return s.CancelErr("error: producer:close: %v", err)
+	}
+	return 0
+}
+
+func (s *State) openAMQPChannel(url string) (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := s.dialAMQP(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	return conn, ch, nil
+}
+
+// dialAMQP connects as amqp.Dial does, giving up if the script is cancelled while connecting or handshaking
+func (s *State) dialAMQP(url string) (*amqp.Connection, error) {
+	var stop func() bool
+	conn, err := amqp.DialConfig(url, amqp.Config{
+		Heartbeat: 10 * time.Second,
+		Locale:    "en_US",
+		Dial: func(network, addr string) (net.Conn, error) {
+			dialer := net.Dialer{Timeout: 30 * time.Second}
+			conn, err := dialer.DialContext(s.ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			// As with amqp.DefaultDial, don't wait forever on the handshake, which closing the connection interrupts
+			if err = conn.SetDeadline(time.Now().Add(30 * time.Second)); err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+			stop = context.AfterFunc(s.ctx, func() {
+				_ = conn.Close()
+			})
+			return conn, nil
+		},
+	})
+	if stop != nil && !stop() {
+		// Cancelled after connecting, so the connection has been closed out from under us
+		if err == nil {
+			_ = conn.Close()
+		}
+		return nil, s.ctx.Err()
+	}
+	return conn, err
+}
+
+func (s *State) withAMQPChannel(url string, f func(ch *amqp.Channel) error) error {
+	conn, ch, err := s.openAMQPChannel(url)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = closeAMQP(conn, ch)
+	}()
+	return f(ch)
+}
+
+func closeAMQP(conn *amqp.Connection, ch *amqp.Channel) error {
+	if conn.IsClosed() {
+		return nil
+	}
+	if err := ch.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+		return err
+	}
+	return conn.Close()
+}
+
+func getAMQPConsumerParam(L *lua.LState, i int) (*AMQPConsumer, error) {
+	v := L.Get(i)
+	ud, ok := v.(*lua.LUserData)
+	if !ok {
+		return nil, fmt.Errorf("error: getAMQPConsumerParam: expected user data type for 'consumer', got: '%s'", v.Type().String())
+	}
+	if v, ok := ud.Value.(*AMQPConsumer); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("error: getAMQPConsumerParam: expected 'AMQPConsumer' for 'consumer', got: '%s'", reflect.TypeOf(ud.Value).String())
+}
+
+func getAMQPProducerParam(L *lua.LState, i int) (*AMQPProducer, error) {
+	v := L.Get(i)
+	ud, ok := v.(*lua.LUserData)
+	if !ok {
+		return nil, fmt.Errorf("error: getAMQPProducerParam: expected user data type for 'producer', got: '%s'", v.Type().String())
+	}
+	if v, ok := ud.Value.(*AMQPProducer); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("error: getAMQPProducerParam: expected 'AMQPProducer' for 'producer', got: '%s'", reflect.TypeOf(ud.Value).String())
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"strings"
 	"text/template"
 
 	"github.com/EvWilson/sqump/data"
@@ -26,19 +27,121 @@ func PrepareScript(
 	currentEnv string,
 	overrides data.EnvMapValue,
 ) (string, data.EnvMapValue, error) {
-	req, ok := coll.GetRequest(requestName)
+	pr, err := prepareRequest(coll, requestName, currentEnv, overrides)
+	if err != nil {
+		return "", nil, err
+	}
+	return pr.script, pr.env, nil
+}
+
+// hookScripts are the pre and post scripts of the collection or one of its folders
+type hookScripts struct {
+	kind string
+	name string
+	pre  data.Script
+	post data.Script
+}
+
+// preparedRequest holds a request's script, templated along with the pre and post scripts of the collection and the
+// folders holding it
+type preparedRequest struct {
+	ident  Identifier
+	req    *data.Request
+	env    data.EnvMapValue
+	script string
+	// overrides are those given for the run, which also apply to requests it requires from other collections
+	overrides data.EnvMapValue
+	// params are the values given for the request's parameters, checked and defaulted as it runs
+	params map[string]any
+	// pre run the collection's first, then outermost folder in, and post the other way around
+	pre  []string
+	post []string
+}
+
+func prepareRequest(
+	coll *data.Collection,
+	requestName string,
+	currentEnv string,
+	overrides data.EnvMapValue,
+) (*preparedRequest, error) {
+	ref, ok := coll.GetRequestRef(requestName)
 	if !ok {
-		return "", nil, data.ErrNotFound{
+		return nil, data.ErrNotFound{
 			MissingItem: "request",
 			Location:    requestName,
 		}
 	}
-	ident := Identifier{
-		Path:       coll.Path,
-		Collection: coll.Name,
-		Request:    requestName,
+	pr := &preparedRequest{
+		ident: Identifier{
+			Path:       coll.Path,
+			Collection: coll.Name,
+			Request:    requestName,
+		},
+		req:       ref.Request,
+		overrides: overrides,
+	}
+	collEnv := coll.EnvironmentFor(requestName)
+	env, err := getMergedEnv(currentEnv, collEnv, overrides)
+	if err != nil {
+		return nil, err
+	}
+	if missing := ref.Request.MissingEnv(env); len(missing) > 0 {
+		return nil, fmt.Errorf("request '%s' requires environment keys missing from '%s': %s", requestName, currentEnv, strings.Join(missing, ", "))
+	}
+	pr.script, pr.env, err = prepScript(currentEnv, pr.ident, ref.Request.Script.String(), collEnv, overrides)
+	if err != nil {
+		return nil, err
 	}
-	return prepScript(currentEnv, ident, req.Script.String(), coll.Environment, overrides)
+	// The collection's hooks wrap those of its folders, outermost first
+	hooks := []hookScripts{{"collection", coll.Name, coll.Pre, coll.Post}}
+	for _, f := range ref.Folders {
+		hooks = append(hooks, hookScripts{"folder", f.Name, f.Pre, f.Post})
+	}
+	for i, h := range hooks {
+		if len(h.pre) > 0 {
+			script, err := replaceEnvTemplates(pr.ident.String()+".pre", h.pre.String(), pr.env)
+			if err != nil {
+				return nil, fmt.Errorf("pre script of %s '%s': %v", h.kind, h.name, err)
+			}
+			pr.pre = append(pr.pre, script)
+		}
+		inner := hooks[len(hooks)-1-i]
+		if len(inner.post) > 0 {
+			script, err := replaceEnvTemplates(pr.ident.String()+".post", inner.post.String(), pr.env)
+			if err != nil {
+				return nil, fmt.Errorf("post script of %s '%s': %v", inner.kind, inner.name, err)
+			}
+			pr.post = append(pr.post, script)
+		}
+	}
+	return pr, nil
+}
+
+// run executes the folders' pre scripts, the request's script, then the folders' post scripts if it succeeded, leaving
+// only the values returned by the request's script on the stack
+func (pr *preparedRequest) run(state *State) error {
+	if _, err := state.setParams(pr.req, pr.params); err != nil {
+		return err
+	}
+	runDiscarding := func(script string) error {
+		top := state.GetTop()
+		defer state.SetTop(top)
+		return state.DoString(script)
+	}
+	for _, script := range pr.pre {
+		if err := runDiscarding(script); state.err != nil || err != nil {
+			return mergeErrors(state.err, err)
+		}
+	}
+	if err := state.DoString(pr.script); state.err != nil || err != nil {
+		return mergeErrors(state.err, err)
+	}
+	for _, script := range pr.post {
+		if err := runDiscarding(script); state.err != nil || err != nil {
+			return mergeErrors(state.err, err)
+		}
+	}
+	return nil
 }
 
 func prepScript(
@@ -67,35 +170,52 @@ func ExecuteRequest(
 	overrides data.EnvMapValue,
 	loopCheck LoopChecker,
 ) (*State, error) {
-	req, ok := coll.GetRequest(requestName)
-	if !ok {
-		return nil, data.ErrNotFound{
-			MissingItem: "request",
-			Location:    requestName,
-		}
-	}
-	ident := Identifier{
-		Path:       coll.Path,
-		Collection: coll.Name,
-		Request:    requestName,
-	}
+	state, _, err := executeRequest(coll, requestName, currentEnv, overrides, nil, loopCheck)
+	return state, err
+}
 
-	script, mergedEnv, err := prepScript(currentEnv, ident, req.Script.String(), coll.Environment, overrides)
+// ExecuteRequestWithParams executes the request with the given values for its parameters, giving the values returned
+// by its script as they would be encoded to JSON
+func ExecuteRequestWithParams(
+	coll *data.Collection,
+	requestName string,
+	currentEnv string,
+	overrides data.EnvMapValue,
+	params map[string]any,
+	loopCheck LoopChecker,
+) ([]any, error) {
+	_, returned, err := executeRequest(coll, requestName, currentEnv, overrides, params, loopCheck)
+	return returned, err
+}
+
+func executeRequest(
+	coll *data.Collection,
+	requestName string,
+	currentEnv string,
+	overrides data.EnvMapValue,
+	params map[string]any,
+	loopCheck LoopChecker,
+) (*State, []any, error) {
+	pr, err := prepareRequest(coll, requestName, currentEnv, overrides)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if pr.req.Mock != nil {
+		return nil, nil, fmt.Errorf("request '%s' is a mock route for '%s', serve it with `sqump mock` instead", requestName, pr.req.Mock)
 	}
+	pr.params = params
 
-	state := CreateState(ident, currentEnv, mergedEnv, loopCheck)
+	state := CreateState(pr.ident, currentEnv, pr.env, loopCheck, WithOverrides(pr.overrides))
 	CacheCancelFunc(state.Cancel)
 	defer state.Close()
 
-	err = state.DoString(script)
-	if state.err != nil || err != nil {
-		return nil, mergeErrors(state.err, err)
+	err = pr.run(state)
+	if err != nil {
+		return nil, nil, err
 	}
 	prnt.Printf("<script '%s: %s' complete>\n", coll.Name, requestName)
 
-	return state, nil
+	return state, returnedValues(state.LState), nil
 }
 
 // replaceEnvTemplates takes a script body and inserts environment
@@ -127,14 +247,11 @@ func getMergedEnv(
 	return mergeMaps(collectionEnv, overrides), nil
 }
 
-// mergeMaps will upsert later map entries into/over earlier map entries
+// mergeMaps will upsert later map entries over earlier map entries, into a new map so that the collection's
+// environment is left as read
 func mergeMaps(m ...data.EnvMapValue) data.EnvMapValue {
-	if len(m) == 0 {
-		return make(data.EnvMapValue)
-	}
-
-	res := m[0]
-	for _, other := range m[1:] {
+	res := make(data.EnvMapValue)
+	for _, other := range m {
 		for k, v := range other {
 			res[k] = v
 		}
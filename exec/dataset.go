@@ -0,0 +1,187 @@
+package exec
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EvWilson/sqump/data"
+)
+
+// ReadDataset reads the rows of a CSV file, keyed by its header row, or of a JSON file holding an array of objects
+func ReadDataset(path string) ([]data.EnvMapValue, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return readCSVDataset(b)
+	case ".json":
+		return readJSONDataset(b)
+	default:
+		return nil, fmt.Errorf("unrecognized dataset extension for '%s', expected '.csv' or '.json'", path)
+	}
+}
+
+func readCSVDataset(b []byte) ([]data.EnvMapValue, error) {
+	records, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("expected CSV dataset to start with a header row")
+	}
+	header := records[0]
+	for i, key := range header {
+		header[i] = strings.TrimSpace(key)
+		if header[i] == "" {
+			return nil, fmt.Errorf("CSV dataset header has an empty column name at position %d", i+1)
+		}
+	}
+	rows := make([]data.EnvMapValue, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(data.EnvMapValue, len(header))
+		for i, key := range header {
+			row[key] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// readJSONDataset takes string values as they are, and any others in their JSON form
+func readJSONDataset(b []byte) ([]data.EnvMapValue, error) {
+	var objects []map[string]json.RawMessage
+	if err := json.Unmarshal(b, &objects); err != nil {
+		return nil, fmt.Errorf("expected JSON dataset to be an array of objects: %v", err)
+	}
+	rows := make([]data.EnvMapValue, 0, len(objects))
+	for _, obj := range objects {
+		row := make(data.EnvMapValue, len(obj))
+		for k, raw := range obj {
+			var str string
+			switch {
+			case json.Unmarshal(raw, &str) == nil:
+				row[k] = str
+			case string(raw) == "null":
+				row[k] = ""
+			default:
+				row[k] = string(raw)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// DatasetOptions shape a run over a dataset
+type DatasetOptions struct {
+	// Concurrency is the number of rows run at once, one at a time if zero
+	Concurrency int
+	// StopOnFailure starts no further rows once one fails
+	StopOnFailure bool
+}
+
+// IterationResult is the outcome of running a request for one row of a dataset
+type IterationResult struct {
+	// Index is the row's position in the dataset, from zero
+	Index    int
+	Row      data.EnvMapValue
+	Duration time.Duration
+	Err      error
+	// Skipped is set for rows not run after an earlier failure
+	Skipped bool
+}
+
+func (ir IterationResult) String() string {
+	switch {
+	case ir.Skipped:
+		return fmt.Sprintf("row %d (%s): skipped", ir.Index+1, rowString(ir.Row))
+	case ir.Err != nil:
+		return fmt.Sprintf("row %d (%s): failed in %s: %s", ir.Index+1, rowString(ir.Row), ir.Duration.Round(time.Millisecond), strings.TrimSpace(ir.Err.Error()))
+	default:
+		return fmt.Sprintf("row %d (%s): ok in %s", ir.Index+1, rowString(ir.Row), ir.Duration.Round(time.Millisecond))
+	}
+}
+
+func rowString(row data.EnvMapValue) string {
+	keys := make([]string, 0, len(row))
+	for k := range row {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, row[k]))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// ExecuteDataset runs the request once per row, with the row's values over the given overrides. Each result is passed
+// to onResult as its row completes, one at a time, and all are returned in row order.
+func ExecuteDataset(
+	coll *data.Collection,
+	requestName string,
+	currentEnv string,
+	overrides data.EnvMapValue,
+	rows []data.EnvMapValue,
+	opts DatasetOptions,
+	onResult func(IterationResult),
+) ([]IterationResult, error) {
+	if len(rows) > 1 {
+		for _, row := range rows {
+			// Environments that can't be merged are left for each row's run to report
+			env, err := getMergedEnv(currentEnv, coll.EnvironmentFor(requestName), mergeMaps(overrides, row))
+			if err == nil && strings.EqualFold(env[VCRModeKey], vcrRecord) {
+				return nil, fmt.Errorf("%s '%s' is not supported over a dataset, as every row would overwrite the same cassette", VCRModeKey, vcrRecord)
+			}
+		}
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make([]IterationResult, len(rows))
+	var lock sync.Mutex
+	failed := false
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for i, row := range rows {
+		sem <- struct{}{}
+		lock.Lock()
+		if failed && opts.StopOnFailure {
+			results[i] = IterationResult{Index: i, Row: row, Skipped: true}
+			onResult(results[i])
+			lock.Unlock()
+			<-sem
+			continue
+		}
+		lock.Unlock()
+		wg.Add(1)
+		go func(i int, row data.EnvMapValue) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			start := time.Now()
+			_, err := ExecuteRequest(coll, requestName, currentEnv, mergeMaps(overrides, row), NewLoopChecker())
+			res := IterationResult{Index: i, Row: row, Duration: time.Since(start), Err: err}
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				failed = true
+			}
+			results[i] = res
+			onResult(res)
+		}(i, row)
+	}
+	wg.Wait()
+	return results, nil
+}
@@ -4,6 +4,7 @@ import (
 	"strconv"
 	"testing"
 
+	"github.com/segmentio/kafka-go"
 	lua "github.com/yuin/gopher-lua"
 )
 
@@ -22,4 +23,128 @@ func TestStateHelpers(t *testing.T) {
 			}
 		})
 	})
+	t.Run("Test lValueToGo cycles", func(t *testing.T) {
+		L := lua.NewState()
+		defer L.Close()
+		if err := L.DoString(`
+			shared = { 1, 2 }
+			twice = { a = shared, b = shared }
+			cyclic = { list = {} }
+			cyclic.list[1] = cyclic
+		`); err != nil {
+			t.Fatal(err)
+		}
+		v, err := lValueToGo(L.GetGlobal("twice"))
+		if err != nil {
+			t.Fatalf("table appearing twice: %v", err)
+		}
+		if m, ok := v.(map[string]any); !ok || len(m["a"].([]any)) != 2 || len(m["b"].([]any)) != 2 {
+			t.Fatalf("unexpected conversion: %v", v)
+		}
+		if _, err = lValueToGo(L.GetGlobal("cyclic")); err == nil {
+			t.Fatal("expected error converting a table that contains itself")
+		}
+		if _, err = marshalLValue(L.GetGlobal("cyclic")); err == nil {
+			t.Fatal("expected error marshalling a table that contains itself")
+		}
+	})
+	t.Run("Test kafkaConnectionFromProfile", func(t *testing.T) {
+		conn, err := kafkaConnectionFromProfile(map[string]string{
+			"kafka_prod_brokers":        "b1:9092, b2:9092,",
+			"kafka_prod_sasl_mechanism": "SCRAM-SHA-512",
+			"kafka_prod_username":       "user",
+			"kafka_prod_password":       "pass",
+			"kafka_prod_tls":            "true",
+		}, "prod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(conn.Brokers) != 2 || conn.Brokers[0] != "b1:9092" || conn.Brokers[1] != "b2:9092" {
+			t.Fatalf("unexpected brokers: %v", conn.Brokers)
+		}
+		if conn.SASL == nil || conn.SASL.Name() != "SCRAM-SHA-512" {
+			t.Fatalf("unexpected SASL mechanism: %v", conn.SASL)
+		}
+		if conn.TLS == nil {
+			t.Fatal("expected TLS to be configured")
+		}
+		if _, err = kafkaConnectionFromProfile(map[string]string{}, "missing"); err == nil {
+			t.Fatal("expected error for profile without brokers")
+		}
+	})
+	t.Run("Test kafka message options", func(t *testing.T) {
+		L := lua.NewState()
+		defer L.Close()
+		if err := L.DoString(`
+			options = { headers = { trace = 'abc' }, partition = 2 }
+			bad_headers = { headers = 'trace' }
+			bad_partitions = { { partition = '2' }, { partition = 2.5 }, { partition = true } }
+		`); err != nil {
+			t.Fatal(err)
+		}
+		kp := &KafkaProducer{Writer: &kafka.Writer{Addr: kafka.TCP("b1:9092")}}
+		kp.Config.Topic = "orders"
+		msg, explicit, err := kp.buildMessage("k", "v", L.GetGlobal("options").(*lua.LTable))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !explicit || msg.Partition != 2 || msg.Topic != "orders" || string(msg.Key) != "k" || string(msg.Value) != "v" {
+			t.Fatalf("unexpected message: %+v", msg)
+		}
+		if len(msg.Headers) != 1 || msg.Headers[0].Key != "trace" || string(msg.Headers[0].Value) != "abc" {
+			t.Fatalf("unexpected headers: %v", msg.Headers)
+		}
+		if _, explicit, err = kp.buildMessage("k", "v", &lua.LTable{}); err != nil || explicit {
+			t.Fatalf("expected message left to the balancer, got explicit=%v, err=%v", explicit, err)
+		}
+		if _, _, err = kp.buildMessage("k", "v", L.GetGlobal("bad_headers").(*lua.LTable)); err == nil {
+			t.Fatal("expected error for headers that aren't a table")
+		}
+		badPartitions := L.GetGlobal("bad_partitions").(*lua.LTable)
+		for i := 1; i <= badPartitions.Len(); i++ {
+			options := badPartitions.RawGetInt(i).(*lua.LTable)
+			if _, _, err = kp.buildMessage("k", "v", options); err == nil {
+				t.Fatalf("expected error for partition %v", options.RawGetString("partition"))
+			}
+		}
+
+		w := kp.partitionWriter()
+		if got := w.Balancer.Balance(msg, 0, 1, 2); got != 2 {
+			t.Fatalf("expected partition writer to honor the message's partition, got: %d", got)
+		}
+		if kp.partitionWriter() != w {
+			t.Fatal("expected partition writer to be reused")
+		}
+
+		headers, ok := kafkaMessageToTable(msg).RawGetString("headers").(*lua.LTable)
+		if !ok || headers.RawGetString("trace").String() != "abc" {
+			t.Fatalf("expected headers read back, got: %v", headers)
+		}
+	})
+	t.Run("Test getOffsetParam", func(t *testing.T) {
+		L := lua.NewState()
+		defer L.Close()
+		for _, tc := range []struct {
+			value lua.LValue
+			want  int64
+		}{
+			{lua.LString("first"), kafka.FirstOffset},
+			{lua.LString("LAST"), kafka.LastOffset},
+			{lua.LNumber(42), 42},
+		} {
+			L.SetTop(0)
+			L.Push(tc.value)
+			got, err := getOffsetParam(L, "offset", 1)
+			if err != nil || got != tc.want {
+				t.Fatalf("offset %v: got %d, err %v", tc.value, got, err)
+			}
+		}
+		for _, value := range []lua.LValue{lua.LString("middle"), lua.LTrue} {
+			L.SetTop(0)
+			L.Push(value)
+			if _, err := getOffsetParam(L, "offset", 1); err == nil {
+				t.Fatalf("expected error for offset %v", value)
+			}
+		}
+	})
 }
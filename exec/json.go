@@ -18,45 +18,23 @@ func marshalLValue(val lua.LValue) ([]byte, error) {
 	case lua.LTString:
 		return []byte(string(val.(lua.LString))), nil
 	case lua.LTTable:
-		t := val.(*lua.LTable)
-		var outerErr error
-		if lTableIsArray(t) {
-			arr := make([]any, 0, t.Len())
-			t.ForEach(func(_, l2 lua.LValue) {
-				l2Val, err := lValueToGo(l2)
-				if err != nil {
-					outerErr = err
-					return
-				}
-				arr = append(arr, l2Val)
-			})
-			if outerErr != nil {
-				return nil, outerErr
-			}
-			return json.Marshal(arr)
-		} else {
-			m := make(map[string]any, t.Len())
-			t.ForEach(func(l1, l2 lua.LValue) {
-				l1Bytes, err := marshalLValue(l1)
-				if err != nil {
-					outerErr = err
-					return
-				}
-				l2Val, err := lValueToGo(l2)
-				if err != nil {
-					outerErr = err
-					return
-				}
-				m[string(l1Bytes)] = l2Val
-			})
-			return json.Marshal(m)
+		v, err := lValueToGo(val)
+		if err != nil {
+			return nil, err
 		}
+		return json.Marshal(v)
 	default:
 		return nil, fmt.Errorf("unsupported value type: %s", val.Type().String())
 	}
 }
 
 func lValueToGo(val lua.LValue) (any, error) {
+	return lValueToGoFrom(val, make(map[*lua.LTable]bool))
+}
+
+// lValueToGoFrom converts val, given the tables it's nested within, so that a table holding itself is reported rather
+// than followed forever. Tables merely appearing twice are converted each time.
+func lValueToGoFrom(val lua.LValue, within map[*lua.LTable]bool) (any, error) {
 	switch val.Type() {
 	case lua.LTNil:
 		return nil, nil
@@ -68,11 +46,19 @@ func lValueToGo(val lua.LValue) (any, error) {
 		return string(val.(lua.LString)), nil
 	case lua.LTTable:
 		t := val.(*lua.LTable)
+		if within[t] {
+			return nil, fmt.Errorf("table contains itself")
+		}
+		within[t] = true
+		defer delete(within, t)
 		var outerErr error
 		if lTableIsArray(t) {
 			ret := make([]any, 0, t.Len())
 			t.ForEach(func(_, l2 lua.LValue) {
-				idxVal, err := lValueToGo(l2)
+				if outerErr != nil {
+					return
+				}
+				idxVal, err := lValueToGoFrom(l2, within)
 				if err != nil {
 					outerErr = err
 					return
@@ -83,12 +69,15 @@ func lValueToGo(val lua.LValue) (any, error) {
 		} else {
 			ret := make(map[string]any, t.Len())
 			t.ForEach(func(l1, l2 lua.LValue) {
+				if outerErr != nil {
+					return
+				}
 				l1Bytes, err := marshalLValue(l1)
 				if err != nil {
 					outerErr = err
 					return
 				}
-				l2Val, err := lValueToGo(l2)
+				l2Val, err := lValueToGoFrom(l2, within)
 				if err != nil {
 					outerErr = err
 					return
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
-	"net"
 	"os"
 	"reflect"
 	"strings"
@@ -23,6 +22,7 @@ const (
 
 type KafkaConsumer struct {
 	*kafka.Reader
+	conn *KafkaConnection
 }
 
 func (kc *KafkaConsumer) toUserData(L *lua.LState) *lua.LUserData {
@@ -34,7 +34,9 @@ func (kc *KafkaConsumer) toUserData(L *lua.LState) *lua.LUserData {
 
 type KafkaProducer struct {
 	*kafka.Writer
-	Config struct {
+	// partitioned is created on first use, to write messages that name an explicit partition
+	partitioned *kafka.Writer
+	Config      struct {
 		Topic   string
 		Timeout time.Duration
 	}
@@ -47,6 +49,59 @@ func (kp *KafkaProducer) toUserData(L *lua.LState) *lua.LUserData {
 	return ud
 }
 
+// partitionWriter returns a writer sharing this producer's settings whose balancer honors each message's partition
+func (kp *KafkaProducer) partitionWriter() *kafka.Writer {
+	if kp.partitioned == nil {
+		kp.partitioned = &kafka.Writer{
+			Addr:         kp.Writer.Addr,
+			BatchSize:    kp.Writer.BatchSize,
+			BatchTimeout: kp.Writer.BatchTimeout,
+			Compression:  kp.Writer.Compression,
+			RequiredAcks: kp.Writer.RequiredAcks,
+			Balancer: kafka.BalancerFunc(func(msg kafka.Message, _ ...int) int {
+				return msg.Partition
+			}),
+			Transport:   kp.Writer.Transport,
+			Logger:      kp.Writer.Logger,
+			ErrorLogger: kp.Writer.ErrorLogger,
+		}
+	}
+	return kp.partitioned
+}
+
+// buildMessage creates a message for the producer's topic, with headers and partition taken from the options table
+func (kp *KafkaProducer) buildMessage(key, data string, options *lua.LTable) (kafka.Message, bool, error) {
+	headers, err := getStringMap(options, "headers")
+	if err != nil {
+		return kafka.Message{}, false, err
+	}
+	msg := kafka.Message{
+		Topic: kp.Config.Topic,
+		Key:   []byte(key),
+		Value: []byte(data),
+	}
+	for k, v := range headers {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+	if options.RawGetString("partition") == lua.LNil {
+		return msg, false, nil
+	}
+	partition, err := getInt(options, "partition")
+	if err != nil {
+		return kafka.Message{}, false, fmt.Errorf("partition: %v", err)
+	}
+	msg.Partition = partition
+	return msg, true, nil
+}
+
+func (kp *KafkaProducer) Close() error {
+	err := kp.Writer.Close()
+	if kp.partitioned != nil {
+		err = errors.Join(err, kp.partitioned.Close())
+	}
+	return err
+}
+
 func (s *State) registerKafkaModule(L *lua.LState) {
 	L.PreloadModule("sqump_kafka", func(l *lua.LState) int {
 		// Register consumer type
@@ -55,6 +110,10 @@ func (s *State) registerKafkaModule(L *lua.LState) {
 			L.SetGlobal(luaConsumerTypeName, consumerMT)
 			L.SetField(consumerMT, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
 				"read_message": s.readMessage,
+				"read_until":   s.readUntil,
+				"read_all":     s.readAll,
+				"seek":         s.consumerSeek,
+				"seek_time":    s.consumerSeekTime,
 				"close":        s.consumerClose,
 			}))
 		}
@@ -63,15 +122,25 @@ func (s *State) registerKafkaModule(L *lua.LState) {
 			producerMT := L.NewTypeMetatable(luaProducerTypeName)
 			L.SetGlobal(luaProducerTypeName, producerMT)
 			L.SetField(producerMT, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
-				"write": s.writeMessage,
-				"close": s.producerClose,
+				"write":       s.writeMessage,
+				"write_batch": s.writeBatch,
+				"close":       s.producerClose,
 			}))
 		}
+		// Register schema registry type
+		s.registerSchemaRegistryType(L)
 		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
-			"new_consumer":    s.newConsumer,
-			"new_producer":    s.newProducer,
-			"random_group_id": s.randomGroupID,
-			"provision_topic": s.provisionTopic,
+			"new_consumer":           s.newConsumer,
+			"new_partition_consumer": s.newPartitionConsumer,
+			"new_producer":           s.newProducer,
+			"random_group_id":        s.randomGroupID,
+			"provision_topic":        s.provisionTopic,
+			"create_topic":           s.createTopic,
+			"delete_topic":           s.deleteTopic,
+			"list_topics":            s.listTopics,
+			"describe_group":         s.describeGroup,
+			"reset_group_offsets":    s.resetGroupOffsets,
+			"new_registry":           s.newRegistry,
 		})
 		L.Push(mod)
 		return 1
@@ -79,7 +148,7 @@ func (s *State) registerKafkaModule(L *lua.LState) {
 }
 
 func (s *State) newConsumer(_ *lua.LState) int {
-	brokers, err := getStringArrayParam(s.LState, "brokers", 1)
+	conn, err := s.getKafkaConnectionParam("brokers", 1)
 	if err != nil {
 		return s.CancelErr("error: new_consumer: %v", err)
 	}
@@ -91,18 +160,12 @@ func (s *State) newConsumer(_ *lua.LState) int {
 	if err != nil {
 		return s.CancelErr("error: new_consumer: %v", err)
 	}
-	offsetParam, err := getStringParam(s.LState, "offset", 4)
+	offset, err := getOffsetParam(s.LState, "offset", 4)
 	if err != nil {
 		return s.CancelErr("error: new_consumer: %v", err)
 	}
-	var offset int64
-	switch strings.ToLower(offsetParam) {
-	case "first":
-		offset = kafka.FirstOffset
-	case "last":
-		offset = kafka.LastOffset
-	default:
-		return s.CancelErr("error: new_consumer: unexpected offset '%s'", offsetParam)
+	if offset >= 0 {
+		return s.CancelErr("error: new_consumer: group consumers may only start from 'first' or 'last', use new_partition_consumer to read from an absolute offset")
 	}
 	p, err := NewKafkaPrinter("sqump-consumer")
 	if err != nil {
@@ -110,9 +173,9 @@ func (s *State) newConsumer(_ *lua.LState) int {
 	}
 	rc := kafka.ReaderConfig{
 		GroupID:     group,
-		Brokers:     brokers,
+		Brokers:     conn.Brokers,
 		Topic:       topic,
-		Dialer:      kafka.DefaultDialer,
+		Dialer:      conn.Dialer(10 * time.Second),
 		StartOffset: offset,
 		MaxWait:     500 * time.Millisecond,
 		Logger:      p,
@@ -121,7 +184,9 @@ func (s *State) newConsumer(_ *lua.LState) int {
 	if err = rc.Validate(); err != nil {
 		return s.CancelErr("error: new_consumer: reader consumer failed validation: %v", err)
 	}
-	s.LState.Push((&KafkaConsumer{kafka.NewReader(rc)}).toUserData(s.LState))
+	consumer := &KafkaConsumer{kafka.NewReader(rc), conn}
+	s.track(consumer)
+	s.LState.Push(consumer.toUserData(s.LState))
 	return 1
 }
 
@@ -150,13 +215,86 @@ func (s *State) readMessage(_ *lua.LState) int {
 			return s.CancelErr("error: read_message: %v", err)
 		}
 	}
-	ret := &lua.LTable{}
-	ret.RawSetString("key", lua.LString(string(msg.Key)))
-	ret.RawSetString("data", lua.LString(string(msg.Value)))
-	s.LState.Push(ret)
+	s.LState.Push(kafkaMessageToTable(msg))
 	return 1
 }
 
+func (s *State) newPartitionConsumer(_ *lua.LState) int {
+	conn, err := s.getKafkaConnectionParam("brokers", 1)
+	if err != nil {
+		return s.CancelErr("error: new_partition_consumer: %v", err)
+	}
+	topic, err := getStringParam(s.LState, "topic", 2)
+	if err != nil {
+		return s.CancelErr("error: new_partition_consumer: %v", err)
+	}
+	partition, err := getIntParam(s.LState, "partition", 3)
+	if err != nil {
+		return s.CancelErr("error: new_partition_consumer: %v", err)
+	}
+	offset, err := getOffsetParam(s.LState, "offset", 4)
+	if err != nil {
+		return s.CancelErr("error: new_partition_consumer: %v", err)
+	}
+	p, err := NewKafkaPrinter("sqump-consumer")
+	if err != nil {
+		return s.CancelErr("error: new_partition_consumer: creating logger: %v", err)
+	}
+	rc := kafka.ReaderConfig{
+		Brokers:     conn.Brokers,
+		Topic:       topic,
+		Partition:   partition,
+		Dialer:      conn.Dialer(10 * time.Second),
+		MaxWait:     500 * time.Millisecond,
+		Logger:      p,
+		ErrorLogger: p,
+	}
+	if err = rc.Validate(); err != nil {
+		return s.CancelErr("error: new_partition_consumer: reader consumer failed validation: %v", err)
+	}
+	reader := kafka.NewReader(rc)
+	if err = reader.SetOffset(offset); err != nil {
+		_ = reader.Close()
+		return s.CancelErr("error: new_partition_consumer: %v", err)
+	}
+	consumer := &KafkaConsumer{reader, conn}
+	s.track(consumer)
+	s.LState.Push(consumer.toUserData(s.LState))
+	return 1
+}
+
+func (s *State) consumerSeek(_ *lua.LState) int {
+	consumer, err := getConsumerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: consumer:seek: %v", err)
+	}
+	offset, err := getOffsetParam(s.LState, "offset", 2)
+	if err != nil {
+		return s.CancelErr("error: consumer:seek: %v", err)
+	}
+	if err = consumer.SetOffset(offset); err != nil {
+		return s.CancelErr("error: consumer:seek: %v", err)
+	}
+	return 0
+}
+
+func (s *State) consumerSeekTime(_ *lua.LState) int {
+	consumer, err := getConsumerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: consumer:seek_time: %v", err)
+	}
+	timestamp, err := getIntParam(s.LState, "timestamp", 2)
+	if err != nil {
+		return s.CancelErr("error: consumer:seek_time: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+	defer cancel()
+	if err = consumer.SetOffsetAt(ctx, time.UnixMilli(int64(timestamp))); err != nil {
+		return s.CancelErr("error: consumer:seek_time: %v", err)
+	}
+	return 0
+}
+
 func (s *State) consumerClose(_ *lua.LState) int {
 	consumer, err := getConsumerParam(s.LState, 1)
 	if err != nil {
@@ -169,7 +307,7 @@ func (s *State) consumerClose(_ *lua.LState) int {
 }
 
 func (s *State) newProducer(_ *lua.LState) int {
-	brokers, err := getStringArrayParam(s.LState, "brokers", 1)
+	conn, err := s.getKafkaConnectionParam("brokers", 1)
 	if err != nil {
 		return s.CancelErr("error: new_producer: %v", err)
 	}
@@ -181,23 +319,52 @@ func (s *State) newProducer(_ *lua.LState) int {
 	if err != nil {
 		return s.CancelErr("error: new_producer: %v", err)
 	}
+	options, err := getOptionsParam(s.LState, "options", 4)
+	if err != nil {
+		return s.CancelErr("error: new_producer: %v", err)
+	}
+	var compression kafka.Compression
+	switch c := stringOrDefault(options, "compression", "none"); strings.ToLower(c) {
+	case "none":
+	case "gzip":
+		compression = kafka.Gzip
+	case "snappy":
+		compression = kafka.Snappy
+	case "lz4":
+		compression = kafka.Lz4
+	case "zstd":
+		compression = kafka.Zstd
+	default:
+		return s.CancelErr("error: new_producer: unexpected compression '%s'", c)
+	}
+	var acks kafka.RequiredAcks
+	switch a := stringOrDefault(options, "acks", "none"); strings.ToLower(a) {
+	case "none":
+		acks = kafka.RequireNone
+	case "one":
+		acks = kafka.RequireOne
+	case "all":
+		acks = kafka.RequireAll
+	default:
+		return s.CancelErr("error: new_producer: unexpected acks '%s'", a)
+	}
 	p, err := NewKafkaPrinter("sqump-producer")
 	if err != nil {
 		return s.CancelErr("error: new_producer: creating logger: %v", err)
 	}
-	s.LState.Push((&KafkaProducer{
+	producer := &KafkaProducer{
 		&kafka.Writer{
-			Addr:      kafka.TCP(brokers...),
-			BatchSize: 1,
-			Balancer:  &kafka.LeastBytes{},
-			Transport: &kafka.Transport{
-				Dial: (&net.Dialer{
-					Timeout: time.Duration(timeout) * time.Second,
-				}).DialContext,
-			},
-			Logger:      p,
-			ErrorLogger: p,
+			Addr:         kafka.TCP(conn.Brokers...),
+			BatchSize:    intOrDefault(options, "batch_size", 1),
+			BatchTimeout: time.Duration(intOrDefault(options, "batch_timeout", 50)) * time.Millisecond,
+			Compression:  compression,
+			RequiredAcks: acks,
+			Balancer:     &kafka.LeastBytes{},
+			Transport:    conn.Transport(time.Duration(timeout) * time.Second),
+			Logger:       p,
+			ErrorLogger:  p,
 		},
+		nil,
 		struct {
 			Topic   string
 			Timeout time.Duration
@@ -205,7 +372,9 @@ func (s *State) newProducer(_ *lua.LState) int {
 			Topic:   topic,
 			Timeout: time.Duration(timeout) * time.Second,
 		},
-	}).toUserData(s.LState))
+	}
+	s.track(producer)
+	s.LState.Push(producer.toUserData(s.LState))
 	return 1
 }
 
@@ -222,13 +391,21 @@ func (s *State) writeMessage(_ *lua.LState) int {
 	if err != nil {
 		return s.CancelErr("error: write: %v", err)
 	}
+	options, err := getOptionsParam(s.LState, "options", 4)
+	if err != nil {
+		return s.CancelErr("error: write: %v", err)
+	}
+	msg, explicitPartition, err := producer.buildMessage(key, data, options)
+	if err != nil {
+		return s.CancelErr("error: write: %v", err)
+	}
+	writer := producer.Writer
+	if explicitPartition {
+		writer = producer.partitionWriter()
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), producer.Config.Timeout)
 	defer cancel()
-	err = producer.WriteMessages(ctx, kafka.Message{
-		Topic: producer.Config.Topic,
-		Key:   []byte(key),
-		Value: []byte(data),
-	})
+	err = writer.WriteMessages(ctx, msg)
 	if err != nil {
 		return s.CancelErr("error: write: %v", err)
 	}
@@ -252,7 +429,7 @@ func (s *State) randomGroupID(_ *lua.LState) int {
 }
 
 func (s *State) provisionTopic(_ *lua.LState) int {
-	brokers, err := getStringArrayParam(s.LState, "brokers", 1)
+	conn, err := s.getKafkaConnectionParam("brokers", 1)
 	if err != nil {
 		return s.CancelErr("error: provision_topic: %v", err)
 	}
@@ -262,12 +439,13 @@ func (s *State) provisionTopic(_ *lua.LState) int {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	for _, broker := range brokers {
-		conn, err := kafka.DefaultDialer.DialLeader(ctx, "tcp", broker, topic, 0)
+	dialer := conn.Dialer(5 * time.Second)
+	for _, broker := range conn.Brokers {
+		leader, err := dialer.DialLeader(ctx, "tcp", broker, topic, 0)
 		if err != nil {
 			return s.CancelErr("error: provision_topic: %v", err)
 		}
-		if err = conn.Close(); err != nil {
+		if err = leader.Close(); err != nil {
 			return s.CancelErr("error: provision_topic: %v", err)
 		}
 	}
@@ -286,6 +464,42 @@ func getConsumerParam(L *lua.LState, i int) (*KafkaConsumer, error) {
 	return nil, fmt.Errorf("error: getConsumerParam: expected 'KafkaConsumer' for 'consumer', got: '%s'", reflect.TypeOf(ud.Value).String())
 }
 
+// getOffsetParam accepts either "first" or "last", or an absolute offset
+func getOffsetParam(L *lua.LState, paramName string, stackPosition int) (int64, error) {
+	stackVal := L.Get(stackPosition)
+	switch stackVal.Type() {
+	case lua.LTString:
+		switch strings.ToLower(stackVal.String()) {
+		case "first":
+			return kafka.FirstOffset, nil
+		case "last":
+			return kafka.LastOffset, nil
+		default:
+			return 0, fmt.Errorf("unexpected offset '%s'", stackVal.String())
+		}
+	case lua.LTNumber:
+		return int64(stackVal.(lua.LNumber)), nil
+	default:
+		return 0, fmt.Errorf("error: getOffsetParam: expected '%s' parameter to be string or number, instead got '%s'", paramName, stackVal.Type().String())
+	}
+}
+
+func kafkaMessageToTable(msg kafka.Message) *lua.LTable {
+	headers := &lua.LTable{}
+	for _, h := range msg.Headers {
+		headers.RawSetString(h.Key, lua.LString(string(h.Value)))
+	}
+	ret := &lua.LTable{}
+	ret.RawSetString("key", lua.LString(string(msg.Key)))
+	ret.RawSetString("data", lua.LString(string(msg.Value)))
+	ret.RawSetString("headers", headers)
+	ret.RawSetString("topic", lua.LString(msg.Topic))
+	ret.RawSetString("partition", lua.LNumber(msg.Partition))
+	ret.RawSetString("offset", lua.LNumber(msg.Offset))
+	ret.RawSetString("timestamp", lua.LNumber(msg.Time.UnixMilli()))
+	return ret
+}
+
 func getProducerParam(L *lua.LState, i int) (*KafkaProducer, error) {
 	v := L.Get(i)
 	ud, ok := v.(*lua.LUserData)
@@ -0,0 +1,508 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// KafkaAdmin performs cluster administration, shared by the Lua module and the CLI
+type KafkaAdmin struct {
+	*kafka.Client
+}
+
+func NewKafkaAdmin(conn *KafkaConnection, timeout time.Duration) *KafkaAdmin {
+	return &KafkaAdmin{
+		&kafka.Client{
+			Addr:      kafka.TCP(conn.Brokers...),
+			Timeout:   timeout,
+			Transport: conn.Transport(timeout),
+		},
+	}
+}
+
+// Close releases the admin's idle connections to the cluster
+func (ka *KafkaAdmin) Close() {
+	if t, ok := ka.Transport.(*kafka.Transport); ok {
+		t.CloseIdleConnections()
+	}
+}
+
+// KafkaConnectionFor resolves a CLI-style connection argument: either comma-separated broker addresses, or the
+// name of a profile defined in the given environment
+func KafkaConnectionFor(arg string, env map[string]string) (*KafkaConnection, error) {
+	if strings.Contains(arg, ":") {
+		conn := &KafkaConnection{}
+		for _, broker := range strings.Split(arg, ",") {
+			if broker = strings.TrimSpace(broker); broker != "" {
+				conn.Brokers = append(conn.Brokers, broker)
+			}
+		}
+		return conn, nil
+	}
+	return kafkaConnectionFromProfile(env, arg)
+}
+
+type KafkaTopicSpec struct {
+	Name              string
+	Partitions        int
+	ReplicationFactor int
+	Configs           map[string]string
+}
+
+type KafkaTopicInfo struct {
+	Name       string
+	Internal   bool
+	Partitions []KafkaPartitionInfo
+}
+
+type KafkaPartitionInfo struct {
+	ID       int
+	Leader   int
+	Replicas []int
+	ISR      []int
+}
+
+type KafkaGroupInfo struct {
+	ID       string
+	State    string
+	Members  []KafkaGroupMember
+	Offsets  []KafkaPartitionLag
+	TotalLag int64
+}
+
+type KafkaGroupMember struct {
+	ID          string
+	ClientID    string
+	Host        string
+	Assignments map[string][]int
+}
+
+// KafkaPartitionLag holds a group's position in a partition. Committed and Lag are -1 if the group has no
+// committed offset for the partition.
+type KafkaPartitionLag struct {
+	Topic     string
+	Partition int
+	Committed int64
+	End       int64
+	Lag       int64
+}
+
+func (ka *KafkaAdmin) CreateTopic(ctx context.Context, spec KafkaTopicSpec) error {
+	topic := kafka.TopicConfig{
+		Topic:             spec.Name,
+		NumPartitions:     spec.Partitions,
+		ReplicationFactor: spec.ReplicationFactor,
+	}
+	for k, v := range spec.Configs {
+		topic.ConfigEntries = append(topic.ConfigEntries, kafka.ConfigEntry{ConfigName: k, ConfigValue: v})
+	}
+	resp, err := ka.CreateTopics(ctx, &kafka.CreateTopicsRequest{
+		Topics: []kafka.TopicConfig{topic},
+	})
+	if err != nil {
+		return err
+	}
+	return resp.Errors[spec.Name]
+}
+
+func (ka *KafkaAdmin) DeleteTopic(ctx context.Context, name string) error {
+	resp, err := ka.DeleteTopics(ctx, &kafka.DeleteTopicsRequest{
+		Topics: []string{name},
+	})
+	if err != nil {
+		return err
+	}
+	return resp.Errors[name]
+}
+
+func (ka *KafkaAdmin) ListTopics(ctx context.Context) ([]KafkaTopicInfo, error) {
+	resp, err := ka.Metadata(ctx, &kafka.MetadataRequest{})
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]KafkaTopicInfo, 0, len(resp.Topics))
+	for _, t := range resp.Topics {
+		if t.Error != nil {
+			return nil, fmt.Errorf("topic '%s': %w", t.Name, t.Error)
+		}
+		info := KafkaTopicInfo{
+			Name:     t.Name,
+			Internal: t.Internal,
+		}
+		for _, p := range t.Partitions {
+			info.Partitions = append(info.Partitions, KafkaPartitionInfo{
+				ID:       p.ID,
+				Leader:   p.Leader.ID,
+				Replicas: brokerIDs(p.Replicas),
+				ISR:      brokerIDs(p.Isr),
+			})
+		}
+		sort.Slice(info.Partitions, func(i, j int) bool {
+			return info.Partitions[i].ID < info.Partitions[j].ID
+		})
+		ret = append(ret, info)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
+
+func (ka *KafkaAdmin) DescribeGroup(ctx context.Context, group string) (*KafkaGroupInfo, error) {
+	groupsResp, err := ka.DescribeGroups(ctx, &kafka.DescribeGroupsRequest{
+		GroupIDs: []string{group},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(groupsResp.Groups) != 1 {
+		return nil, fmt.Errorf("expected a description of group '%s', got %d results", group, len(groupsResp.Groups))
+	}
+	desc := groupsResp.Groups[0]
+	if desc.Error != nil {
+		return nil, desc.Error
+	}
+	info := &KafkaGroupInfo{
+		ID:    desc.GroupID,
+		State: desc.GroupState,
+	}
+	for _, m := range desc.Members {
+		member := KafkaGroupMember{
+			ID:          m.MemberID,
+			ClientID:    m.ClientID,
+			Host:        m.ClientHost,
+			Assignments: make(map[string][]int),
+		}
+		for _, t := range m.MemberAssignments.Topics {
+			member.Assignments[t.Topic] = t.Partitions
+		}
+		info.Members = append(info.Members, member)
+	}
+
+	offsetsResp, err := ka.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: group,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if offsetsResp.Error != nil {
+		return nil, offsetsResp.Error
+	}
+	ends, err := ka.endOffsets(ctx, offsetsResp.Topics)
+	if err != nil {
+		return nil, err
+	}
+	for topic, partitions := range offsetsResp.Topics {
+		for _, p := range partitions {
+			if p.Error != nil {
+				return nil, fmt.Errorf("topic '%s' partition %d: %w", topic, p.Partition, p.Error)
+			}
+			lag := KafkaPartitionLag{
+				Topic:     topic,
+				Partition: p.Partition,
+				Committed: p.CommittedOffset,
+				End:       ends[topic][p.Partition],
+				Lag:       -1,
+			}
+			if lag.Committed >= 0 {
+				lag.Lag = lag.End - lag.Committed
+				info.TotalLag += lag.Lag
+			}
+			info.Offsets = append(info.Offsets, lag)
+		}
+	}
+	sort.Slice(info.Offsets, func(i, j int) bool {
+		if info.Offsets[i].Topic != info.Offsets[j].Topic {
+			return info.Offsets[i].Topic < info.Offsets[j].Topic
+		}
+		return info.Offsets[i].Partition < info.Offsets[j].Partition
+	})
+	return info, nil
+}
+
+// ResetGroupOffsets commits the given offset (or kafka.FirstOffset/kafka.LastOffset) for every partition of the
+// topic on behalf of the group, returning the committed offset per partition. The group must have no active members.
+func (ka *KafkaAdmin) ResetGroupOffsets(ctx context.Context, group, topic string, to int64) (map[int]int64, error) {
+	metaResp, err := ka.Metadata(ctx, &kafka.MetadataRequest{
+		Topics: []string{topic},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(metaResp.Topics) != 1 {
+		return nil, fmt.Errorf("expected metadata for topic '%s', got %d results", topic, len(metaResp.Topics))
+	}
+	if metaResp.Topics[0].Error != nil {
+		return nil, metaResp.Topics[0].Error
+	}
+	requests := make([]kafka.OffsetRequest, 0, len(metaResp.Topics[0].Partitions))
+	for _, p := range metaResp.Topics[0].Partitions {
+		requests = append(requests, kafka.OffsetRequest{Partition: p.ID, Timestamp: to})
+	}
+	offsets := make(map[int]int64, len(requests))
+	if to == kafka.FirstOffset || to == kafka.LastOffset {
+		listResp, err := ka.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+			Topics: map[string][]kafka.OffsetRequest{topic: requests},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range listResp.Topics[topic] {
+			if p.Error != nil {
+				return nil, fmt.Errorf("partition %d: %w", p.Partition, p.Error)
+			}
+			if to == kafka.FirstOffset {
+				offsets[p.Partition] = p.FirstOffset
+			} else {
+				offsets[p.Partition] = p.LastOffset
+			}
+		}
+	} else {
+		for _, r := range requests {
+			offsets[r.Partition] = to
+		}
+	}
+
+	commits := make([]kafka.OffsetCommit, 0, len(offsets))
+	for partition, offset := range offsets {
+		commits = append(commits, kafka.OffsetCommit{Partition: partition, Offset: offset})
+	}
+	commitResp, err := ka.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      group,
+		GenerationID: -1,
+		Topics:       map[string][]kafka.OffsetCommit{topic: commits},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, p := range commitResp.Topics[topic] {
+		if p.Error != nil {
+			errs = append(errs, fmt.Errorf("partition %d: %w", p.Partition, p.Error))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("committing offsets (is the group still active?): %w", errors.Join(errs...))
+	}
+	return offsets, nil
+}
+
+// endOffsets looks up the high watermark of each given topic partition
+func (ka *KafkaAdmin) endOffsets(ctx context.Context, topics map[string][]kafka.OffsetFetchPartition) (map[string]map[int]int64, error) {
+	requests := make(map[string][]kafka.OffsetRequest, len(topics))
+	for topic, partitions := range topics {
+		for _, p := range partitions {
+			requests[topic] = append(requests[topic], kafka.LastOffsetOf(p.Partition))
+		}
+	}
+	ret := make(map[string]map[int]int64, len(topics))
+	if len(requests) == 0 {
+		return ret, nil
+	}
+	resp, err := ka.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: requests,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for topic, partitions := range resp.Topics {
+		ret[topic] = make(map[int]int64, len(partitions))
+		for _, p := range partitions {
+			if p.Error != nil {
+				return nil, fmt.Errorf("topic '%s' partition %d: %w", topic, p.Partition, p.Error)
+			}
+			ret[topic][p.Partition] = p.LastOffset
+		}
+	}
+	return ret, nil
+}
+
+func brokerIDs(brokers []kafka.Broker) []int {
+	ret := make([]int, 0, len(brokers))
+	for _, b := range brokers {
+		ret = append(ret, b.ID)
+	}
+	return ret
+}
+
+func (s *State) newKafkaAdmin() (*KafkaAdmin, context.Context, context.CancelFunc, error) {
+	conn, err := s.getKafkaConnectionParam("brokers", 1)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, 30*time.Second)
+	admin := NewKafkaAdmin(conn, 10*time.Second)
+	return admin, ctx, func() {
+		cancel()
+		admin.Close()
+	}, nil
+}
+
+func (s *State) createTopic(_ *lua.LState) int {
+	admin, ctx, cancel, err := s.newKafkaAdmin()
+	if err != nil {
+		return s.CancelErr("error: create_topic: %v", err)
+	}
+	defer cancel()
+	topic, err := getStringParam(s.LState, "topic", 2)
+	if err != nil {
+		return s.CancelErr("error: create_topic: %v", err)
+	}
+	options, err := getOptionsParam(s.LState, "options", 3)
+	if err != nil {
+		return s.CancelErr("error: create_topic: %v", err)
+	}
+	configs, err := getStringMap(options, "configs")
+	if err != nil {
+		return s.CancelErr("error: create_topic: %v", err)
+	}
+	err = admin.CreateTopic(ctx, KafkaTopicSpec{
+		Name:              topic,
+		Partitions:        intOrDefault(options, "partitions", 1),
+		ReplicationFactor: intOrDefault(options, "replication_factor", 1),
+		Configs:           configs,
+	})
+	if err != nil {
+		return s.CancelErr("error: create_topic: %v", err)
+	}
+	return 0
+}
+
+func (s *State) deleteTopic(_ *lua.LState) int {
+	admin, ctx, cancel, err := s.newKafkaAdmin()
+	if err != nil {
+		return s.CancelErr("error: delete_topic: %v", err)
+	}
+	defer cancel()
+	topic, err := getStringParam(s.LState, "topic", 2)
+	if err != nil {
+		return s.CancelErr("error: delete_topic: %v", err)
+	}
+	if err = admin.DeleteTopic(ctx, topic); err != nil {
+		return s.CancelErr("error: delete_topic: %v", err)
+	}
+	return 0
+}
+
+func (s *State) listTopics(_ *lua.LState) int {
+	admin, ctx, cancel, err := s.newKafkaAdmin()
+	if err != nil {
+		return s.CancelErr("error: list_topics: %v", err)
+	}
+	defer cancel()
+	topics, err := admin.ListTopics(ctx)
+	if err != nil {
+		return s.CancelErr("error: list_topics: %v", err)
+	}
+	ret := &lua.LTable{}
+	for _, t := range topics {
+		partitions := &lua.LTable{}
+		for _, p := range t.Partitions {
+			partition := &lua.LTable{}
+			partition.RawSetString("id", lua.LNumber(p.ID))
+			partition.RawSetString("leader", lua.LNumber(p.Leader))
+			partition.RawSetString("replicas", intsToLuaArray(p.Replicas))
+			partition.RawSetString("isr", intsToLuaArray(p.ISR))
+			partitions.Append(partition)
+		}
+		topic := &lua.LTable{}
+		topic.RawSetString("name", lua.LString(t.Name))
+		topic.RawSetString("internal", lua.LBool(t.Internal))
+		topic.RawSetString("partitions", partitions)
+		ret.Append(topic)
+	}
+	s.LState.Push(ret)
+	return 1
+}
+
+func (s *State) describeGroup(_ *lua.LState) int {
+	admin, ctx, cancel, err := s.newKafkaAdmin()
+	if err != nil {
+		return s.CancelErr("error: describe_group: %v", err)
+	}
+	defer cancel()
+	group, err := getStringParam(s.LState, "group", 2)
+	if err != nil {
+		return s.CancelErr("error: describe_group: %v", err)
+	}
+	info, err := admin.DescribeGroup(ctx, group)
+	if err != nil {
+		return s.CancelErr("error: describe_group: %v", err)
+	}
+	members := &lua.LTable{}
+	for _, m := range info.Members {
+		assignments := &lua.LTable{}
+		for topic, partitions := range m.Assignments {
+			assignments.RawSetString(topic, intsToLuaArray(partitions))
+		}
+		member := &lua.LTable{}
+		member.RawSetString("id", lua.LString(m.ID))
+		member.RawSetString("client_id", lua.LString(m.ClientID))
+		member.RawSetString("host", lua.LString(m.Host))
+		member.RawSetString("assignments", assignments)
+		members.Append(member)
+	}
+	offsets := &lua.LTable{}
+	for _, o := range info.Offsets {
+		offset := &lua.LTable{}
+		offset.RawSetString("topic", lua.LString(o.Topic))
+		offset.RawSetString("partition", lua.LNumber(o.Partition))
+		offset.RawSetString("committed", lua.LNumber(o.Committed))
+		offset.RawSetString("end_offset", lua.LNumber(o.End))
+		offset.RawSetString("lag", lua.LNumber(o.Lag))
+		offsets.Append(offset)
+	}
+	ret := &lua.LTable{}
+	ret.RawSetString("id", lua.LString(info.ID))
+	ret.RawSetString("state", lua.LString(info.State))
+	ret.RawSetString("members", members)
+	ret.RawSetString("offsets", offsets)
+	ret.RawSetString("lag", lua.LNumber(info.TotalLag))
+	s.LState.Push(ret)
+	return 1
+}
+
+func (s *State) resetGroupOffsets(_ *lua.LState) int {
+	admin, ctx, cancel, err := s.newKafkaAdmin()
+	if err != nil {
+		return s.CancelErr("error: reset_group_offsets: %v", err)
+	}
+	defer cancel()
+	group, err := getStringParam(s.LState, "group", 2)
+	if err != nil {
+		return s.CancelErr("error: reset_group_offsets: %v", err)
+	}
+	topic, err := getStringParam(s.LState, "topic", 3)
+	if err != nil {
+		return s.CancelErr("error: reset_group_offsets: %v", err)
+	}
+	to, err := getOffsetParam(s.LState, "to", 4)
+	if err != nil {
+		return s.CancelErr("error: reset_group_offsets: %v", err)
+	}
+	offsets, err := admin.ResetGroupOffsets(ctx, group, topic, to)
+	if err != nil {
+		return s.CancelErr("error: reset_group_offsets: %v", err)
+	}
+	ret := &lua.LTable{}
+	for partition, offset := range offsets {
+		ret.RawSetInt(partition, lua.LNumber(offset))
+	}
+	s.LState.Push(ret)
+	return 1
+}
+
+func intsToLuaArray(ints []int) *lua.LTable {
+	arr := &lua.LTable{}
+	for _, i := range ints {
+		arr.Append(lua.LNumber(i))
+	}
+	return arr
+}
@@ -0,0 +1,207 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	lua "github.com/yuin/gopher-lua"
+)
+
+func (s *State) writeBatch(_ *lua.LState) int {
+	producer, err := getProducerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: write_batch: %v", err)
+	}
+	messages, ok := s.LState.Get(2).(*lua.LTable)
+	if !ok {
+		return s.CancelErr("error: write_batch: expected 'messages' parameter to be an array, instead got '%s'", s.LState.Get(2).Type().String())
+	}
+	var balanced, partitioned []kafka.Message
+	for i := 1; i <= messages.Len(); i++ {
+		entry, ok := messages.RawGetInt(i).(*lua.LTable)
+		if !ok {
+			return s.CancelErr("error: write_batch: expected message %d to be a table, got: '%s'", i, messages.RawGetInt(i).Type().String())
+		}
+		data, err := getString(entry, "data")
+		if err != nil {
+			return s.CancelErr("error: write_batch: message %d: data: %v", i, err)
+		}
+		msg, explicitPartition, err := producer.buildMessage(stringOrDefault(entry, "key", ""), data, entry)
+		if err != nil {
+			return s.CancelErr("error: write_batch: message %d: %v", i, err)
+		}
+		if explicitPartition {
+			partitioned = append(partitioned, msg)
+		} else {
+			balanced = append(balanced, msg)
+		}
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, producer.Config.Timeout)
+	defer cancel()
+	if len(balanced) > 0 {
+		if err = producer.WriteMessages(ctx, balanced...); err != nil {
+			return s.CancelErr("error: write_batch: %v", err)
+		}
+	}
+	if len(partitioned) > 0 {
+		if err = producer.partitionWriter().WriteMessages(ctx, partitioned...); err != nil {
+			return s.CancelErr("error: write_batch: %v", err)
+		}
+	}
+	return 0
+}
+
+func (s *State) readUntil(_ *lua.LState) int {
+	consumer, err := getConsumerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: read_until: %v", err)
+	}
+	predicate, err := getFuncParam(s.LState, "predicate", 2)
+	if err != nil {
+		return s.CancelErr("error: read_until: %v", err)
+	}
+	timeout, err := getIntParam(s.LState, "timeout", 3)
+	if err != nil {
+		return s.CancelErr("error: read_until: %v", err)
+	}
+	failOnTimeout, err := getBoolParam(s.LState, "fail_on_timeout", 4)
+	if err != nil {
+		return s.CancelErr("error: read_until: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	ret := &lua.LTable{}
+	for {
+		msg, err := consumer.ReadMessage(ctx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) && !failOnTimeout {
+				s.LState.Push(ret)
+				s.LState.Push(lua.LFalse)
+				return 2
+			}
+			return s.CancelErr("error: read_until: %v", err)
+		}
+		msgTable := kafkaMessageToTable(msg)
+		ret.Append(msgTable)
+		// The predicate is run here on the script's thread, and stops the read once it returns true
+		s.LState.Push(predicate)
+		s.LState.Push(msgTable)
+		if err = s.LState.PCall(1, 1, nil); err != nil {
+			return s.CancelErr("error: read_until: predicate: %v", err)
+		}
+		matched := lua.LVAsBool(s.LState.Get(-1))
+		s.LState.Pop(1)
+		if matched {
+			s.LState.Push(ret)
+			s.LState.Push(lua.LTrue)
+			return 2
+		}
+	}
+}
+
+func (s *State) readAll(_ *lua.LState) int {
+	consumer, err := getConsumerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: read_all: %v", err)
+	}
+	timeout, err := getIntParam(s.LState, "timeout", 2)
+	if err != nil {
+		return s.CancelErr("error: read_all: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	targets, err := consumer.drainTargets(ctx)
+	if err != nil {
+		return s.CancelErr("error: read_all: finding end of topic: %v", err)
+	}
+	ret := &lua.LTable{}
+	for len(targets) > 0 {
+		msg, err := consumer.ReadMessage(ctx)
+		if errors.Is(err, context.DeadlineExceeded) {
+			break
+		} else if err != nil {
+			return s.CancelErr("error: read_all: %v", err)
+		}
+		ret.Append(kafkaMessageToTable(msg))
+		if end, ok := targets[msg.Partition]; ok && msg.Offset+1 >= end {
+			delete(targets, msg.Partition)
+		}
+	}
+	s.LState.Push(ret)
+	return 1
+}
+
+// drainTargets snapshots the end offset of each partition the consumer has yet to read to the end of
+func (kc *KafkaConsumer) drainTargets(ctx context.Context) (map[int]int64, error) {
+	cfg := kc.Config()
+	admin := NewKafkaAdmin(kc.conn, 10*time.Second)
+	defer admin.Close()
+
+	partitions := []int{cfg.Partition}
+	if cfg.GroupID != "" {
+		meta, err := admin.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{cfg.Topic}})
+		if err != nil {
+			return nil, err
+		}
+		if len(meta.Topics) != 1 || meta.Topics[0].Error != nil {
+			return nil, fmt.Errorf("looking up partitions of topic '%s': %v", cfg.Topic, meta.Topics)
+		}
+		partitions = partitions[:0]
+		for _, p := range meta.Topics[0].Partitions {
+			partitions = append(partitions, p.ID)
+		}
+	}
+
+	requests := make([]kafka.OffsetRequest, 0, 2*len(partitions))
+	for _, p := range partitions {
+		requests = append(requests, kafka.FirstOffsetOf(p), kafka.LastOffsetOf(p))
+	}
+	offsets, err := admin.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{cfg.Topic: requests},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Work out where reading will resume in each partition, to skip those already at their end
+	positions := make(map[int]int64, len(partitions))
+	if cfg.GroupID == "" {
+		positions[cfg.Partition] = kc.Offset()
+	} else {
+		committed, err := admin.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+			GroupID: cfg.GroupID,
+			Topics:  map[string][]int{cfg.Topic: partitions},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range committed.Topics[cfg.Topic] {
+			positions[p.Partition] = p.CommittedOffset
+		}
+	}
+
+	targets := make(map[int]int64, len(partitions))
+	for _, p := range offsets.Topics[cfg.Topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("partition %d: %w", p.Partition, p.Error)
+		}
+		position, ok := positions[p.Partition]
+		if cfg.GroupID != "" && (!ok || position < 0) {
+			// Nothing committed yet, so the group will start from its configured initial offset
+			position = cfg.StartOffset
+		}
+		switch position {
+		case kafka.FirstOffset:
+			position = p.FirstOffset
+		case kafka.LastOffset:
+			position = p.LastOffset
+		}
+		if position < p.LastOffset {
+			targets[p.Partition] = p.LastOffset
+		}
+	}
+	return targets, nil
+}
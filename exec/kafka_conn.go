@@ -0,0 +1,154 @@
+package exec
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// KafkaConnection describes how to reach a cluster: its brokers, plus any SASL and TLS settings
+type KafkaConnection struct {
+	Brokers []string
+	SASL    sasl.Mechanism
+	TLS     *tls.Config
+}
+
+// Dialer returns a dialer for readers and admin connections using this connection's settings
+func (kc *KafkaConnection) Dialer(timeout time.Duration) *kafka.Dialer {
+	return &kafka.Dialer{
+		Timeout:       timeout,
+		DualStack:     true,
+		SASLMechanism: kc.SASL,
+		TLS:           kc.TLS,
+	}
+}
+
+// Transport returns a transport for writers and clients using this connection's settings
+func (kc *KafkaConnection) Transport(timeout time.Duration) *kafka.Transport {
+	return &kafka.Transport{
+		Dial: (&net.Dialer{
+			Timeout: timeout,
+		}).DialContext,
+		SASL: kc.SASL,
+		TLS:  kc.TLS,
+	}
+}
+
+// getKafkaConnectionParam accepts an array of broker addresses, the name of a profile defined in the
+// environment, or a connection table shaped as:
+// { brokers = string[], sasl = { mechanism = string, username = string, password = string }, tls = boolean | table }
+func (s *State) getKafkaConnectionParam(paramName string, stackPosition int) (*KafkaConnection, error) {
+	stackVal := s.LState.Get(stackPosition)
+	switch stackVal.Type() {
+	case lua.LTString:
+		return kafkaConnectionFromProfile(s.environment, stackVal.String())
+	case lua.LTTable:
+		table := stackVal.(*lua.LTable)
+		if table.RawGetString("brokers") == lua.LNil {
+			brokers, err := luaArrayToSlice(table)
+			if err != nil {
+				return nil, err
+			}
+			return &KafkaConnection{Brokers: brokers}, nil
+		}
+		return kafkaConnectionFromTable(table)
+	default:
+		return nil, fmt.Errorf("error: getKafkaConnectionParam: expected '%s' parameter to be a broker array, profile name, or connection table, instead got '%s'", paramName, stackVal.Type().String())
+	}
+}
+
+func kafkaConnectionFromTable(table *lua.LTable) (*KafkaConnection, error) {
+	brokers, err := luaArrayToSlice(table.RawGetString("brokers"))
+	if err != nil {
+		return nil, fmt.Errorf("brokers: %v", err)
+	}
+	conn := &KafkaConnection{Brokers: brokers}
+	if saslTable, ok := table.RawGetString("sasl").(*lua.LTable); ok {
+		conn.SASL, err = newSASLMechanism(
+			stringOrDefault(saslTable, "mechanism", "plain"),
+			stringOrDefault(saslTable, "username", ""),
+			stringOrDefault(saslTable, "password", ""),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch tlsVal := table.RawGetString("tls").(type) {
+	case lua.LBool:
+		if tlsVal {
+			conn.TLS = &tls.Config{}
+		}
+	case *lua.LTable:
+		conn.TLS, err = tlsConfigFromTable(tlsVal)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// kafkaConnectionFromProfile reads a connection from environment keys prefixed with "kafka_<profile>_":
+// brokers (comma-separated), sasl_mechanism, username, password, tls, ca_file, cert_file, key_file,
+// server_name, insecure_skip_verify
+func kafkaConnectionFromProfile(env map[string]string, profile string) (*KafkaConnection, error) {
+	prefix := fmt.Sprintf("kafka_%s_", profile)
+	get := func(key string) string {
+		return strings.TrimSpace(env[prefix+key])
+	}
+	brokerList := get("brokers")
+	if brokerList == "" {
+		return nil, fmt.Errorf("no brokers found for kafka profile '%s', expected environment key '%sbrokers'", profile, prefix)
+	}
+	conn := &KafkaConnection{}
+	for _, broker := range strings.Split(brokerList, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			conn.Brokers = append(conn.Brokers, broker)
+		}
+	}
+	if mechanism := get("sasl_mechanism"); mechanism != "" {
+		var err error
+		conn.SASL, err = newSASLMechanism(mechanism, get("username"), get("password"))
+		if err != nil {
+			return nil, fmt.Errorf("kafka profile '%s': %v", profile, err)
+		}
+	}
+	useTLS, _ := strconv.ParseBool(get("tls"))
+	if useTLS || get("ca_file") != "" || get("cert_file") != "" {
+		tlsTable := &lua.LTable{}
+		for _, key := range []string{"ca_file", "cert_file", "key_file", "server_name"} {
+			if val := get(key); val != "" {
+				tlsTable.RawSetString(key, lua.LString(val))
+			}
+		}
+		skipVerify, _ := strconv.ParseBool(get("insecure_skip_verify"))
+		tlsTable.RawSetString("insecure_skip_verify", lua.LBool(skipVerify))
+		var err error
+		conn.TLS, err = tlsConfigFromTable(tlsTable)
+		if err != nil {
+			return nil, fmt.Errorf("kafka profile '%s': %v", profile, err)
+		}
+	}
+	return conn, nil
+}
+
+func newSASLMechanism(mechanism, username, password string) (sasl.Mechanism, error) {
+	switch strings.ToLower(mechanism) {
+	case "plain":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	default:
+		return nil, fmt.Errorf("unexpected SASL mechanism '%s', expected one of 'plain', 'scram-sha-256', 'scram-sha-512'", mechanism)
+	}
+}
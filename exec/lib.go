@@ -0,0 +1,141 @@
+package exec
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/EvWilson/sqump/data"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// readConfig gives the core config, or nil if there isn't one
+func readConfig() (*data.Config, error) {
+	conf, err := data.ReadConfigFrom(data.DefaultConfigLocation())
+	if errors.Is(err, data.ErrNotFound{}) {
+		return nil, nil
+	}
+	return conf, err
+}
+
+// readConfig gives the core config as readConfig does, reading it only the first time it's asked for
+func (s *State) readConfig() (*data.Config, error) {
+	if s.confRead {
+		return s.conf, nil
+	}
+	conf, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	s.conf, s.confRead = conf, true
+	return conf, nil
+}
+
+// readCollection gives the collection at path, reading it only the first time it's asked for
+func (s *State) readCollection(path string) (*data.Collection, error) {
+	if coll, ok := s.collections[path]; ok {
+		return coll, nil
+	}
+	coll, err := data.ReadCollection(path)
+	if err != nil {
+		return nil, err
+	}
+	s.collections[path] = coll
+	return coll, nil
+}
+
+// libPaths gives the library paths available to the collection's scripts, its own ahead of the config's
+func libPaths(coll *data.Collection, conf *data.Config) []string {
+	paths := coll.LibPaths()
+	if conf != nil {
+		paths = append(paths, conf.LibPaths()...)
+	}
+	return paths
+}
+
+// requireLib loads the library module kept at path, once per state as with Lua's own require, passing it its name
+func (s *State) requireLib(name, path string) int {
+	if mod, ok := s.libs[name]; ok {
+		s.LState.Push(mod)
+		return 1
+	}
+	ident := Identifier{
+		Path:       path,
+		Collection: "lib",
+		Request:    name,
+	}
+	if !s.loopCheck.AddIdent(ident) {
+		return s.CancelErr("error: require: cyclical loop detected: library '%s' required again while loading. Loop checker state: %v", name, s.loopCheck)
+	}
+	defer s.loopCheck.ClearIdent(ident)
+
+	fn, err := s.LState.LoadFile(path)
+	if err != nil {
+		return s.CancelErr("error: require: loading library '%s': %v", name, err)
+	}
+	s.LState.Push(fn)
+	s.LState.Push(lua.LString(name))
+	if err = s.LState.PCall(1, 1, nil); err != nil || s.err != nil {
+		return s.CancelErr("error: require: library '%s': state error: %v, error: %v", name, s.err, err)
+	}
+	mod := s.LState.Get(-1)
+	s.LState.Pop(1)
+	if mod == lua.LNil {
+		mod = lua.LTrue
+	}
+	s.libs[name] = mod
+	s.LState.Push(mod)
+	return 1
+}
+
+// findCollectionRequest finds the request named by moduleName in a registered collection, where the module is
+// named by the collection's name followed by the request's path, e.g. "Coll.Folder.Request"
+func (s *State) findCollectionRequest(conf *data.Config, moduleName string) (*data.Collection, string, bool) {
+	if conf == nil {
+		return nil, "", false
+	}
+	collName, requestName, ok := strings.Cut(moduleName, ".")
+	if !ok {
+		return nil, "", false
+	}
+	for _, fpath := range conf.Files {
+		// Collections that can't be read are left for running them directly to report
+		coll, err := s.readCollection(fpath)
+		if err != nil || coll.Name != collName {
+			continue
+		}
+		if _, ok := coll.GetRequest(requestName); ok {
+			return coll, requestName, true
+		}
+	}
+	return nil, "", false
+}
+
+// requireFromCollection runs the request's script, templated with its own collection's environment and the run's
+// overrides. Requires made by the script resolve within its collection.
+func (s *State) requireFromCollection(coll *data.Collection, requestName string, params map[string]any) int {
+	pr, err := prepareRequest(coll, requestName, s.currentEnv, s.overrides)
+	if err != nil {
+		return s.CancelErr("error: require: %v", err)
+	}
+	if !s.loopCheck.AddIdent(pr.ident) {
+		return s.CancelErr("error: require: cyclical loop detected: '%s' calling '%s', which has already been executed. Loop checker state: %v", s.currentIdent.String(), pr.ident.String(), s.loopCheck)
+	}
+	defer s.loopCheck.ClearIdent(pr.ident)
+	restore, err := s.setParams(pr.req, params)
+	if err != nil {
+		return s.CancelErr("error: require: %v", err)
+	}
+	defer restore()
+
+	ident, env := s.currentIdent, s.environment
+	s.currentIdent, s.environment = pr.ident, pr.env
+	defer func() {
+		s.currentIdent, s.environment = ident, env
+	}()
+	if err = s.DoString(pr.script); err != nil || s.err != nil {
+		return s.CancelErr("error: require: state error: %v, error: %v", s.err, err)
+	}
+	// Everything above the module name argument was returned by the script
+	return s.LState.GetTop() - 1
+}
@@ -0,0 +1,291 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EvWilson/sqump/data"
+)
+
+// LoadOptions shape a load test
+type LoadOptions struct {
+	// VUs is the number of virtual users, each running the script over and over in fresh states
+	VUs      int
+	Duration time.Duration
+	// RPS caps the script runs started each second across all VUs, unlimited if zero
+	RPS float64
+}
+
+func (lo LoadOptions) validate() error {
+	if lo.VUs < 1 {
+		return fmt.Errorf("expected at least 1 VU, got: %d", lo.VUs)
+	}
+	if lo.Duration <= 0 {
+		return fmt.Errorf("expected a positive duration, got: %s", lo.Duration)
+	}
+	if lo.RPS < 0 || math.IsNaN(lo.RPS) {
+		return fmt.Errorf("expected a non-negative RPS, got: %v", lo.RPS)
+	}
+	if lo.RPS > 0 {
+		// The ticker spacing out runs needs an interval of at least a nanosecond, that fits in a time.Duration
+		interval := float64(time.Second) / lo.RPS
+		if interval < 1 || interval >= math.MaxInt64 {
+			return fmt.Errorf("expected an RPS between %v and %v, got: %v", float64(time.Second)/math.MaxInt64, float64(time.Second), lo.RPS)
+		}
+	}
+	return nil
+}
+
+// interval gives the time between runs started under the RPS cap
+func (lo LoadOptions) interval() time.Duration {
+	return time.Duration(float64(time.Second) / lo.RPS)
+}
+
+// LoadStats summarizes a load test, either in progress or complete
+type LoadStats struct {
+	Elapsed          time.Duration
+	Iterations       int
+	FailedIterations int
+	Fetches          int
+	// FailedFetches counts fetches without a response, or with a status of 400 or above
+	FailedFetches int
+	StatusCounts  map[int]int
+	// Throughput is fetches per second
+	Throughput float64
+	// ErrorRate is the fraction of fetches that failed
+	ErrorRate float64
+	P50       time.Duration
+	P90       time.Duration
+	P99       time.Duration
+	Max       time.Duration
+	// LastError is the most recent script or fetch error
+	LastError string
+	Done      bool
+}
+
+func (ls LoadStats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "elapsed:     %s\n", ls.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(&b, "iterations:  %d (%d failed)\n", ls.Iterations, ls.FailedIterations)
+	fmt.Fprintf(&b, "fetches:     %d (%.2f/s)\n", ls.Fetches, ls.Throughput)
+	fmt.Fprintf(&b, "error rate:  %.2f%% (%d failed)\n", ls.ErrorRate*100, ls.FailedFetches)
+	fmt.Fprintf(&b, "latency:     p50=%s p90=%s p99=%s max=%s\n",
+		ls.P50.Round(time.Microsecond), ls.P90.Round(time.Microsecond), ls.P99.Round(time.Microsecond), ls.Max.Round(time.Microsecond))
+	statuses := make([]int, 0, len(ls.StatusCounts))
+	for status := range ls.StatusCounts {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	counts := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		label := fmt.Sprint(status)
+		if status == 0 {
+			label = "no response"
+		}
+		counts = append(counts, fmt.Sprintf("%s=%d", label, ls.StatusCounts[status]))
+	}
+	fmt.Fprintf(&b, "statuses:    %s\n", strings.Join(counts, " "))
+	if ls.LastError != "" {
+		fmt.Fprintf(&b, "last error:  %s\n", ls.LastError)
+	}
+	return b.String()
+}
+
+// LoadRun is a load test in progress
+type LoadRun struct {
+	Options LoadOptions
+	Started time.Time
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	lock       sync.Mutex
+	finished   time.Time
+	iterations int
+	failedIter int
+	latencies  []time.Duration
+	failed     int
+	statuses   map[int]int
+	lastError  string
+}
+
+// StartLoad runs the request's script from opts.VUs goroutines until opts.Duration passes or ctx is cancelled. Each run
+// has a state of its own, with printing silenced.
+func StartLoad(
+	ctx context.Context,
+	coll *data.Collection,
+	requestName string,
+	currentEnv string,
+	overrides data.EnvMapValue,
+	opts LoadOptions,
+) (*LoadRun, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	pr, err := prepareRequest(coll, requestName, currentEnv, overrides)
+	if err != nil {
+		return nil, err
+	}
+	if pr.req.Mock != nil {
+		return nil, fmt.Errorf("request '%s' is a mock route for '%s', and can't be load tested", requestName, pr.req.Mock)
+	}
+	if strings.EqualFold(pr.env[VCRModeKey], vcrRecord) {
+		return nil, fmt.Errorf("%s '%s' is not supported under load, as every run would overwrite the same cassette", VCRModeKey, vcrRecord)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	lr := &LoadRun{
+		Options:  opts,
+		Started:  time.Now(),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		statuses: make(map[int]int),
+	}
+
+	var starts <-chan time.Time
+	if opts.RPS > 0 {
+		ticker := time.NewTicker(opts.interval())
+		context.AfterFunc(ctx, ticker.Stop)
+		starts = ticker.C
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.VUs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if starts != nil {
+					select {
+					case <-ctx.Done():
+						return
+					case <-starts:
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				lr.iterate(ctx, pr, currentEnv)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+		lr.lock.Lock()
+		lr.finished = time.Now()
+		lr.lock.Unlock()
+		close(lr.done)
+	}()
+	return lr, nil
+}
+
+func (lr *LoadRun) iterate(ctx context.Context, pr *preparedRequest, currentEnv string) {
+	// Fetches are held until the run ends, so that they're counted along with it or not at all
+	var fetchLock sync.Mutex
+	var fetches []FetchResult
+	observe := func(res FetchResult) {
+		fetchLock.Lock()
+		defer fetchLock.Unlock()
+		fetches = append(fetches, res)
+	}
+	state := CreateState(pr.ident, currentEnv, pr.env, NewLoopChecker(), WithOverrides(pr.overrides), WithFetchObserver(observe), WithSilentPrint())
+	defer state.Close()
+	stop := context.AfterFunc(ctx, state.Cancel)
+	defer stop()
+
+	err := pr.run(state)
+	// A run cut off by the end of the test isn't a failure of the script, and its fetches would count those it cut off
+	if ctx.Err() != nil {
+		return
+	}
+	fetchLock.Lock()
+	defer fetchLock.Unlock()
+	lr.lock.Lock()
+	defer lr.lock.Unlock()
+	for _, res := range fetches {
+		lr.record(res)
+	}
+	lr.iterations++
+	if err != nil {
+		lr.failedIter++
+		lr.lastError = strings.TrimSpace(err.Error())
+	}
+}
+
+// record adds a fetch to the stats, with lr.lock held
+func (lr *LoadRun) record(res FetchResult) {
+	lr.latencies = append(lr.latencies, res.Duration)
+	lr.statuses[res.Status]++
+	if res.Err != nil || res.Status >= 400 {
+		lr.failed++
+	}
+	if res.Err != nil {
+		lr.lastError = res.Err.Error()
+	}
+}
+
+// Stop ends the test early
+func (lr *LoadRun) Stop() {
+	lr.cancel()
+}
+
+// Done is closed once every VU has finished
+func (lr *LoadRun) Done() <-chan struct{} {
+	return lr.done
+}
+
+// Wait blocks until the test completes, returning the final stats
+func (lr *LoadRun) Wait() LoadStats {
+	<-lr.done
+	return lr.Stats()
+}
+
+// Stats summarizes the test so far
+func (lr *LoadRun) Stats() LoadStats {
+	lr.lock.Lock()
+	defer lr.lock.Unlock()
+	ls := LoadStats{
+		Iterations:       lr.iterations,
+		FailedIterations: lr.failedIter,
+		Fetches:          len(lr.latencies),
+		FailedFetches:    lr.failed,
+		StatusCounts:     make(map[int]int, len(lr.statuses)),
+		LastError:        lr.lastError,
+		Done:             !lr.finished.IsZero(),
+	}
+	if ls.Done {
+		ls.Elapsed = lr.finished.Sub(lr.Started)
+	} else {
+		ls.Elapsed = time.Since(lr.Started)
+	}
+	for status, count := range lr.statuses {
+		ls.StatusCounts[status] = count
+	}
+	if ls.Fetches == 0 {
+		return ls
+	}
+	ls.Throughput = float64(ls.Fetches) / ls.Elapsed.Seconds()
+	ls.ErrorRate = float64(ls.FailedFetches) / float64(ls.Fetches)
+	sorted := make([]time.Duration, len(lr.latencies))
+	copy(sorted, lr.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	ls.P50 = percentile(sorted, 50)
+	ls.P90 = percentile(sorted, 90)
+	ls.P99 = percentile(sorted, 99)
+	ls.Max = sorted[len(sorted)-1]
+	return ls
+}
+
+// percentile picks by nearest rank from sorted, which must not be empty
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
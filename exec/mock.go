@@ -0,0 +1,143 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/EvWilson/sqump/data"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// MockRequest is the incoming HTTP request, handed to a mock route's script as the `request` global
+type MockRequest struct {
+	Method string
+	Path   string
+	// Params holds the values of the route pattern's placeholders
+	Params map[string]string
+	// Query holds the first value of each query parameter
+	Query map[string]string
+	// Headers holds the first value of each header, keyed by lowercase name
+	Headers map[string]string
+	Body    string
+}
+
+// MockResponse is built from the table a mock route's script returns
+type MockResponse struct {
+	Status  int
+	Headers map[string]string
+	Body    []byte
+}
+
+func NewMockRequest(r *http.Request, params map[string]string, body []byte) MockRequest {
+	mr := MockRequest{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Params:  params,
+		Query:   make(map[string]string, len(r.URL.Query())),
+		Headers: make(map[string]string, len(r.Header)),
+		Body:    string(body),
+	}
+	for k, v := range r.URL.Query() {
+		mr.Query[k] = v[0]
+	}
+	for k, v := range r.Header {
+		mr.Headers[strings.ToLower(k)] = v[0]
+	}
+	return mr
+}
+
+func (mr MockRequest) toTable() *lua.LTable {
+	stringMap := func(m map[string]string) *lua.LTable {
+		t := &lua.LTable{}
+		for k, v := range m {
+			t.RawSetString(k, lua.LString(v))
+		}
+		return t
+	}
+	ret := &lua.LTable{}
+	ret.RawSetString("method", lua.LString(mr.Method))
+	ret.RawSetString("path", lua.LString(mr.Path))
+	ret.RawSetString("params", stringMap(mr.Params))
+	ret.RawSetString("query", stringMap(mr.Query))
+	ret.RawSetString("headers", stringMap(mr.Headers))
+	ret.RawSetString("body", lua.LString(mr.Body))
+	return ret
+}
+
+// ExecuteMock runs a mock route's script against the incoming request, which is cancelled along with ctx
+func ExecuteMock(
+	ctx context.Context,
+	coll *data.Collection,
+	requestName string,
+	currentEnv string,
+	overrides data.EnvMapValue,
+	mreq MockRequest,
+) (*MockResponse, error) {
+	pr, err := prepareRequest(coll, requestName, currentEnv, overrides)
+	if err != nil {
+		return nil, err
+	}
+	if pr.req.Mock == nil {
+		return nil, fmt.Errorf("request '%s' is not a mock route", requestName)
+	}
+
+	state := CreateState(pr.ident, currentEnv, pr.env, NewLoopChecker(), WithOverrides(pr.overrides))
+	defer state.Close()
+	stop := context.AfterFunc(ctx, state.Cancel)
+	defer stop()
+	state.SetGlobal("request", mreq.toTable())
+
+	top := state.GetTop()
+	err = pr.run(state)
+	if err != nil {
+		return nil, err
+	}
+	if state.GetTop() == top {
+		return &MockResponse{Status: http.StatusOK}, nil
+	}
+	return mockResponseFrom(state.Get(top + 1))
+}
+
+// mockResponseFrom reads `{ status, headers, body }`, where a table body is sent as JSON
+func mockResponseFrom(val lua.LValue) (*MockResponse, error) {
+	t, ok := val.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("expected mock script to return a table, instead got: %s", val.Type().String())
+	}
+	headers, err := getStringMap(t, "headers")
+	if err != nil {
+		return nil, err
+	}
+	resp := &MockResponse{
+		Status:  intOrDefault(t, "status", http.StatusOK),
+		Headers: headers,
+	}
+	switch body := t.RawGetString("body").(type) {
+	case lua.LString:
+		resp.Body = []byte(body)
+	case *lua.LTable:
+		resp.Body, err = marshalLValue(body)
+		if err != nil {
+			return nil, fmt.Errorf("body: %v", err)
+		}
+		if !hasHeader(resp.Headers, "Content-Type") {
+			resp.Headers["Content-Type"] = "application/json"
+		}
+	case *lua.LNilType:
+	default:
+		return nil, fmt.Errorf("expected mock response body to be a string or table, instead got: %s", body.Type().String())
+	}
+	return resp, nil
+}
+
+func hasHeader(headers map[string]string, name string) bool {
+	for k := range headers {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -8,7 +8,9 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/EvWilson/sqump/data"
@@ -28,6 +30,26 @@ type State struct {
 	err          error
 	oldReq       *lua.LFunction
 	pauseChan    chan struct{}
+	callbacks    chan func()
+	inCallback   bool
+	resumed      bool
+	tracked      []io.Closer
+	trackedLock  sync.Mutex
+	vcr          *vcr
+	onFetch      func(FetchResult)
+	silent       bool
+	// defaultHeaders are sent with each fetch that doesn't set them itself
+	defaultHeaders map[string]string
+	// responses are those of each fetch made so far, for hooks to inspect
+	responses []*lua.LTable
+	// overrides apply over the environments of requests required from other collections
+	overrides data.EnvMapValue
+	// libs holds the library modules required so far, each loaded once
+	libs map[string]lua.LValue
+	// collections and conf are read once by the first require that needs them
+	collections map[string]*data.Collection
+	conf        *data.Config
+	confRead    bool
 }
 
 type LoopChecker map[string]bool
@@ -37,15 +59,59 @@ func NewLoopChecker() LoopChecker {
 }
 
 func (lc LoopChecker) AddIdent(ident Identifier) bool {
-	if _, ok := lc[ident.String()]; ok {
+	key := loopKey(ident)
+	if _, ok := lc[key]; ok {
 		return false
 	}
-	lc[ident.String()] = true
+	lc[key] = true
 	return true
 }
 
 func (lc LoopChecker) ClearIdent(ident Identifier) {
-	delete(lc, ident.String())
+	delete(lc, loopKey(ident))
+}
+
+// loopKey identifies the request by its collection's absolute path, as collections required from others are found by
+// the paths registered in the config, which may differ from how the path was given to run the first
+func loopKey(ident Identifier) string {
+	if abs, err := filepath.Abs(ident.Path); err == nil {
+		ident.Path = abs
+	}
+	return ident.String()
+}
+
+// StateOption adjusts a State as it is created
+type StateOption func(*State)
+
+// FetchResult describes a completed `fetch` call, reported to an observer set with WithFetchObserver
+type FetchResult struct {
+	Method   string
+	URL      string
+	Status   int
+	Duration time.Duration
+	// Err is set if no response was received
+	Err error
+}
+
+// WithFetchObserver reports each `fetch` made by the script, including those of required scripts
+func WithFetchObserver(f func(FetchResult)) StateOption {
+	return func(s *State) {
+		s.onFetch = f
+	}
+}
+
+// WithOverrides gives the run's overrides, which apply to requests required from other collections as well
+func WithOverrides(overrides data.EnvMapValue) StateOption {
+	return func(s *State) {
+		s.overrides = overrides
+	}
+}
+
+// WithSilentPrint discards output from `print` and `print_response`
+func WithSilentPrint() StateOption {
+	return func(s *State) {
+		s.silent = true
+	}
 }
 
 func CreateState(
@@ -53,6 +119,7 @@ func CreateState(
 	currentEnv string,
 	env data.EnvMapValue,
 	loopCheck LoopChecker,
+	opts ...StateOption,
 ) *State {
 	L := lua.NewState()
 	ctx, cancel := context.WithCancel(context.Background())
@@ -69,28 +136,49 @@ func CreateState(
 		err:          nil,
 		oldReq:       L.GetGlobal("require").(*lua.LFunction),
 		pauseChan:    make(chan struct{}),
+		callbacks:    make(chan func(), 256),
+		libs:         make(map[string]lua.LValue),
+		collections:  make(map[string]*data.Collection),
+	}
+	for _, opt := range opts {
+		opt(&state)
 	}
 	state.loopCheck.AddIdent(state.currentIdent)
+	// Connections opened by the script are closed when it completes or is cancelled
+	context.AfterFunc(ctx, state.closeTracked)
 
 	L.SetGlobal("pause", L.NewFunction(state.Pause))
 	L.SetGlobal("play", L.NewFunction(state.Play))
 
-	L.SetGlobal("print", L.NewFunction(printViaCore))
+	if state.silent {
+		L.SetGlobal("print", L.NewFunction(func(_ *lua.LState) int { return 0 }))
+	} else {
+		L.SetGlobal("print", L.NewFunction(printViaCore))
+	}
 	L.SetGlobal("require", L.NewFunction(state.require))
 	L.PreloadModule("sqump", func(_ *lua.LState) int {
 		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
-			"fetch":           state.fetch,
-			"print_response":  state.printResponse,
-			"to_json":         state.toJSON,
-			"to_json_pretty":  state.toJSONPretty,
-			"from_json":       state.fromJSON,
-			"to_query_string": state.toQueryString,
+			"fetch":               state.fetch,
+			"print_response":      state.printResponse,
+			"to_json":             state.toJSON,
+			"to_json_pretty":      state.toJSONPretty,
+			"from_json":           state.fromJSON,
+			"to_query_string":     state.toQueryString,
+			"set_default_headers": state.setDefaultHeaders,
+			"responses":           state.getResponses,
 		})
 		L.Push(mod)
 		return 1
 	})
 	state.registerKafkaModule(L)
 	state.registerWebsocketModule(L)
+	state.registerSocketIOModule(L)
+	state.registerSTOMPModule(L)
+	state.registerRedisModule(L)
+	state.registerAMQPModule(L)
+	state.registerNATSModule(L)
+	state.registerMQTTModule(L)
+	state.registerNetModule(L)
 
 	return &state
 }
@@ -149,13 +237,14 @@ func (s *State) fetch(_ *lua.LState) int {
 	method := stringOrDefault(options, "method", "GET")
 	timeout := intOrDefault(options, "timeout", 10)
 
-	req, err := http.NewRequest(method, resource, buf)
+	req, err := http.NewRequestWithContext(s.ctx, method, resource, buf)
 	if err != nil {
 		return s.CancelErr("error: fetch: while creating request: %v", err)
 	}
 
 	// Add headers
 	req.Header.Add("User-Agent", "sqump")
+	given := make(map[string]bool)
 	reqHeaderTable := options.RawGetString("headers")
 	switch reqHeaderTable.Type() {
 	case lua.LTTable:
@@ -171,48 +260,100 @@ func (s *State) fetch(_ *lua.LState) int {
 				return
 			}
 			req.Header.Add(keyString, valString)
+			given[http.CanonicalHeaderKey(keyString)] = true
 		})
 	case lua.LTNil:
 		// this is fine, default to doing nothing
 	default:
 		return s.CancelErr("error: fetch: unexpected value found for header table slot. value: %v", reqHeaderTable.Type())
 	}
+	for k, v := range s.defaultHeaders {
+		if !given[k] {
+			req.Header.Set(k, v)
+		}
+	}
 
-	// Perform request
-	resp, err := (&http.Client{
-		Timeout: time.Second * time.Duration(timeout),
-	}).Do(req)
+	// Perform request, or replay it from a cassette
+	start := time.Now()
+	resp, err := s.roundTrip(req, buf.String(), time.Second*time.Duration(timeout))
+	// A fetch cut off by the script's cancellation has nothing to report
+	if s.onFetch != nil && s.ctx.Err() == nil {
+		result := FetchResult{
+			Method:   req.Method,
+			URL:      resource,
+			Duration: time.Since(start),
+			Err:      err,
+		}
+		if resp != nil {
+			result.Status = resp.Status
+		}
+		s.onFetch(result)
+	}
 	if err != nil {
-		return s.CancelErr("error: fetch: while performing request: %v", err)
+		return s.CancelErr("error: fetch: %v", err)
 	}
-	defer resp.Body.Close()
 
 	// Gather headers into a lua table
 	respHeaderTable := &lua.LTable{}
-	for k, v := range resp.Header {
+	for k, v := range resp.Headers {
 		respHeaderTable.RawSetString(k, sliceToLuaArray(v))
 	}
 
-	// Read response
-	b, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return s.CancelErr("error: fetch: while reading response body: %v", err)
-	}
-
 	respTable := &lua.LTable{}
-	respTable.RawSetString("status", lua.LNumber(resp.StatusCode))
+	respTable.RawSetString("status", lua.LNumber(resp.Status))
 	respTable.RawSetString("headers", respHeaderTable)
-	respTable.RawSetString("body", lua.LString(string(b)))
+	respTable.RawSetString("body", lua.LString(resp.Body))
+
+	recorded := &lua.LTable{}
+	recorded.RawSetString("method", lua.LString(req.Method))
+	recorded.RawSetString("url", lua.LString(resource))
+	respTable.ForEach(func(k, v lua.LValue) {
+		recorded.RawSet(k, v)
+	})
+	s.responses = append(s.responses, recorded)
+
 	s.LState.Push(respTable)
 	return 1
 }
 
+func (s *State) setDefaultHeaders(_ *lua.LState) int {
+	headers, err := getTableParam(s.LState, "headers", 1)
+	if err != nil {
+		return s.CancelErr("error: set_default_headers: %v", err)
+	}
+	if s.defaultHeaders == nil {
+		s.defaultHeaders = make(map[string]string)
+	}
+	// Kept by canonical name, so that a header is replaced or removed however its name is cased
+	for k, v := range headers {
+		k = http.CanonicalHeaderKey(k)
+		if v == "" {
+			delete(s.defaultHeaders, k)
+		} else {
+			s.defaultHeaders[k] = v
+		}
+	}
+	return 0
+}
+
+func (s *State) getResponses(_ *lua.LState) int {
+	ret := s.LState.NewTable()
+	for _, resp := range s.responses {
+		ret.Append(resp)
+	}
+	s.LState.Push(ret)
+	return 1
+}
+
 func (s *State) printResponse(_ *lua.LState) int {
 	respVal := s.LState.Get(1)
 	if respVal.Type() != lua.LTTable {
 		return s.CancelErr("error: print_response: expected response parameter to be table, instead got: %s", respVal.Type().String())
 	}
 	resp := respVal.(*lua.LTable)
+	if s.silent {
+		return 0
+	}
 
 	code, err := getInt(resp, "status")
 	if err != nil {
@@ -313,33 +454,31 @@ func (s *State) require(_ *lua.LState) int {
 	if err != nil {
 		return s.CancelErr("error: require: %v", err)
 	}
-	coll, err := data.ReadCollection(s.currentIdent.Path)
+	params, err := getParamsArg(s.LState, 2)
 	if err != nil {
 		return s.CancelErr("error: require: %v", err)
 	}
-	for _, req := range coll.Requests {
-		if moduleName == req.Name {
-			ident := s.currentIdent
-			ident.Request = req.Name
-			if !s.loopCheck.AddIdent(ident) {
-				return s.CancelErr("error: require: cyclical loop detected: '%s' calling '%s', which has already been executed. Loop checker state: %v", s.currentIdent.String(), ident.String(), s.loopCheck)
-			}
-			defer s.loopCheck.ClearIdent(ident)
-
-			script, err := s.prepScript(req.Script.String())
-			if err != nil {
-				return s.CancelErr("error: require: %v", err)
-			}
-			err = s.DoString(script)
-			if err != nil {
-				return s.CancelErr("error: require: state error: %v, error: %v", s.err, err)
-			}
-			returned := s.LState.GetTop()
-			for i := 1; i < returned; i++ {
-				s.LState.Push(s.LState.Get(i + 1))
-			}
-			return returned - 1
-		}
+	// Leave only the module name below what the required script returns
+	s.LState.SetTop(1)
+	coll, err := s.readCollection(s.currentIdent.Path)
+	if err != nil {
+		return s.CancelErr("error: require: %v", err)
+	}
+	// Requests in folders are required by their dotted path, e.g. require('Folder.Request'), templated with the
+	// environment of their folders just as when run directly
+	if _, ok := coll.GetRequest(moduleName); ok {
+		return s.requireFromCollection(coll, moduleName, params)
+	}
+	conf, err := s.readConfig()
+	if err != nil {
+		return s.CancelErr("error: require: %v", err)
+	}
+	if path, ok := data.FindLibModule(libPaths(coll, conf), moduleName); ok {
+		return s.requireLib(moduleName, path)
+	}
+	// Requests of other registered collections are required by the collection's name, e.g. require('Coll.Request')
+	if other, requestName, ok := s.findCollectionRequest(conf, moduleName); ok {
+		return s.requireFromCollection(other, requestName, params)
 	}
 	// Fall back to old require if needed
 	s.LState.Push(s.oldReq)
@@ -348,21 +487,45 @@ func (s *State) require(_ *lua.LState) int {
 	return 1
 }
 
+// Pause suspends the script, running any dispatched callbacks on the script's thread while it waits
 func (s *State) Pause(L *lua.LState) int {
-	select {
-	case <-s.pauseChan:
-		break
-	case <-s.ctx.Done():
-		break
+	for {
+		select {
+		case <-s.pauseChan:
+			return 0
+		case <-s.ctx.Done():
+			return 0
+		case cb := <-s.callbacks:
+			s.inCallback = true
+			cb()
+			s.inCallback = false
+			if s.resumed {
+				s.resumed = false
+				return 0
+			}
+		}
 	}
-	return 0
 }
 
 func (s *State) Play(L *lua.LState) int {
+	// Called from a dispatched callback, so Pause is waiting on us to return
+	if s.inCallback {
+		s.resumed = true
+		return 0
+	}
 	s.pauseChan <- struct{}{}
 	return 0
 }
 
+// dispatch queues a function to be run on the script's thread the next time it pauses,
+// which is how callbacks from other goroutines are allowed to touch the Lua state
+func (s *State) dispatch(f func()) {
+	select {
+	case s.callbacks <- f:
+	case <-s.ctx.Done():
+	}
+}
+
 func printViaCore(L *lua.LState) int {
 	top := L.GetTop()
 	args := make([]interface{}, 0, top)
@@ -373,12 +536,32 @@ func printViaCore(L *lua.LState) int {
 	return 0
 }
 
+// Close releases any connections tracked by the script, then the underlying Lua state
+func (s *State) Close() {
+	s.Cancel()
+	s.closeTracked()
+	s.LState.Close()
+}
+
+// track registers a connection to be closed along with the state
+func (s *State) track(c io.Closer) {
+	s.trackedLock.Lock()
+	defer s.trackedLock.Unlock()
+	s.tracked = append(s.tracked, c)
+}
+
+func (s *State) closeTracked() {
+	s.trackedLock.Lock()
+	defer s.trackedLock.Unlock()
+	for _, c := range s.tracked {
+		// Scripts may have already closed these themselves, so errors are expected here
+		_ = c.Close()
+	}
+	s.tracked = nil
+}
+
 func (s *State) CancelErr(format string, args ...any) int {
 	s.err = fmt.Errorf(format, args...)
 	s.Cancel()
 	return 0
 }
-
-func (s *State) prepScript(script string) (string, error) {
-	return replaceEnvTemplates(s.currentIdent.String(), script, s.environment)
-}
@@ -0,0 +1,267 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	luaMQTTClientTypeName = "mqttclient"
+)
+
+type MQTTClient struct {
+	mqtt.Client
+	// messages holds deliveries for subscriptions made without a callback, to be taken by read_message
+	messages chan mqtt.Message
+	Timeout  time.Duration
+}
+
+func (mc *MQTTClient) toUserData(L *lua.LState) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = mc
+	L.SetMetatable(ud, L.GetTypeMetatable(luaMQTTClientTypeName))
+	return ud
+}
+
+func (mc *MQTTClient) Close() error {
+	if mc.IsConnected() {
+		mc.Disconnect(250)
+	}
+	return nil
+}
+
+func (s *State) registerMQTTModule(L *lua.LState) {
+	L.PreloadModule("sqump_mqtt", func(l *lua.LState) int {
+		// Register client type
+		{
+			clientMT := L.NewTypeMetatable(luaMQTTClientTypeName)
+			L.SetGlobal(luaMQTTClientTypeName, clientMT)
+			L.SetField(clientMT, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+				"publish":      s.mqttPublish,
+				"subscribe":    s.mqttSubscribe,
+				"unsubscribe":  s.mqttUnsubscribe,
+				"read_message": s.mqttReadMessage,
+				"close":        s.mqttClose,
+			}))
+		}
+		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"connect": s.mqttConnect,
+		})
+		L.Push(mod)
+		return 1
+	})
+}
+
+func (s *State) mqttConnect(_ *lua.LState) int {
+	broker, err := getStringParam(s.LState, "broker", 1)
+	if err != nil {
+		return s.CancelErr("error: connect: %v", err)
+	}
+	options, err := getOptionsParam(s.LState, "options", 2)
+	if err != nil {
+		return s.CancelErr("error: connect: %v", err)
+	}
+	timeout := time.Duration(intOrDefault(options, "timeout", 5)) * time.Second
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(stringOrDefault(options, "client_id", fmt.Sprintf("sqump-%d", time.Now().UnixNano()))).
+		SetUsername(stringOrDefault(options, "username", "")).
+		SetPassword(stringOrDefault(options, "password", "")).
+		SetCleanSession(boolOrDefault(options, "clean_session", true)).
+		SetConnectTimeout(timeout).
+		SetAutoReconnect(false)
+	if tlsTable, ok := options.RawGetString("tls").(*lua.LTable); ok {
+		tlsConfig, err := tlsConfigFromTable(tlsTable)
+		if err != nil {
+			return s.CancelErr("error: connect: %v", err)
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	if will, ok := options.RawGetString("will").(*lua.LTable); ok {
+		topic, err := getString(will, "topic")
+		if err != nil {
+			return s.CancelErr("error: connect: will topic: %v", err)
+		}
+		opts.SetWill(
+			topic,
+			stringOrDefault(will, "payload", ""),
+			byte(intOrDefault(will, "qos", 0)),
+			boolOrDefault(will, "retained", false),
+		)
+	}
+	client := &MQTTClient{
+		Client:   mqtt.NewClient(opts),
+		messages: make(chan mqtt.Message, 256),
+		Timeout:  timeout,
+	}
+	if err = client.wait(s.ctx, client.Connect()); err != nil {
+		return s.CancelErr("error: connect: %v", err)
+	}
+	s.track(client)
+	s.LState.Push(client.toUserData(s.LState))
+	return 1
+}
+
+func (s *State) mqttPublish(_ *lua.LState) int {
+	client, err := getMQTTClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: publish: %v", err)
+	}
+	topic, err := getStringParam(s.LState, "topic", 2)
+	if err != nil {
+		return s.CancelErr("error: publish: %v", err)
+	}
+	payload, err := getStringParam(s.LState, "payload", 3)
+	if err != nil {
+		return s.CancelErr("error: publish: %v", err)
+	}
+	options, err := getOptionsParam(s.LState, "options", 4)
+	if err != nil {
+		return s.CancelErr("error: publish: %v", err)
+	}
+	token := client.Publish(
+		topic,
+		byte(intOrDefault(options, "qos", 0)),
+		boolOrDefault(options, "retained", false),
+		payload,
+	)
+	if err = client.wait(s.ctx, token); err != nil {
+		return s.CancelErr("error: publish: %v", err)
+	}
+	return 0
+}
+
+func (s *State) mqttSubscribe(_ *lua.LState) int {
+	client, err := getMQTTClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: subscribe: %v", err)
+	}
+	topic, err := getStringParam(s.LState, "topic", 2)
+	if err != nil {
+		return s.CancelErr("error: subscribe: %v", err)
+	}
+	qos, err := getIntParam(s.LState, "qos", 3)
+	if err != nil {
+		return s.CancelErr("error: subscribe: %v", err)
+	}
+	var handler mqtt.MessageHandler
+	switch cbVal := s.LState.Get(4).(type) {
+	case *lua.LFunction:
+		handler = func(_ mqtt.Client, msg mqtt.Message) {
+			s.dispatch(func() {
+				s.LState.Push(cbVal)
+				s.LState.Push(mqttMessageToTable(msg))
+				if err := s.LState.PCall(1, 0, nil); err != nil {
+					_ = s.CancelErr("error: subscribe: callback: %v", err)
+				}
+			})
+		}
+	case *lua.LNilType:
+		handler = func(_ mqtt.Client, msg mqtt.Message) {
+			select {
+			case client.messages <- msg:
+			case <-s.ctx.Done():
+			}
+		}
+	default:
+		return s.CancelErr("error: subscribe: expected 'cb' parameter to be func or nil, instead got: %s", cbVal.Type().String())
+	}
+	if err = client.wait(s.ctx, client.Subscribe(topic, byte(qos), handler)); err != nil {
+		return s.CancelErr("error: subscribe: %v", err)
+	}
+	return 0
+}
+
+func (s *State) mqttUnsubscribe(_ *lua.LState) int {
+	client, err := getMQTTClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: unsubscribe: %v", err)
+	}
+	topic, err := getStringParam(s.LState, "topic", 2)
+	if err != nil {
+		return s.CancelErr("error: unsubscribe: %v", err)
+	}
+	if err = client.wait(s.ctx, client.Unsubscribe(topic)); err != nil {
+		return s.CancelErr("error: unsubscribe: %v", err)
+	}
+	return 0
+}
+
+func (s *State) mqttReadMessage(_ *lua.LState) int {
+	client, err := getMQTTClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: read_message: %v", err)
+	}
+	timeout, err := getIntParam(s.LState, "timeout", 2)
+	if err != nil {
+		return s.CancelErr("error: read_message: %v", err)
+	}
+	failOnTimeout, err := getBoolParam(s.LState, "fail_on_timeout", 3)
+	if err != nil {
+		return s.CancelErr("error: read_message: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	select {
+	case msg := <-client.messages:
+		s.LState.Push(mqttMessageToTable(msg))
+		return 1
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && !failOnTimeout {
+			// Do nothing, we mean not to fail in this case (easiest to represent this way)
+			s.LState.Push(lua.LNil)
+			return 1
+		}
+		return s.CancelErr("error: read_message: %v", ctx.Err())
+	}
+}
+
+func (s *State) mqttClose(_ *lua.LState) int {
+	client, err := getMQTTClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: close: %v", err)
+	}
+	if err = client.Close(); err != nil {
+		return s.CancelErr("error: close: %v", err)
+	}
+	return 0
+}
+
+// wait blocks on the token until it completes, the client's timeout elapses, or the script is cancelled
+func (mc *MQTTClient) wait(ctx context.Context, token mqtt.Token) error {
+	ctx, cancel := context.WithTimeout(ctx, mc.Timeout)
+	defer cancel()
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func mqttMessageToTable(msg mqtt.Message) *lua.LTable {
+	ret := &lua.LTable{}
+	ret.RawSetString("topic", lua.LString(msg.Topic()))
+	ret.RawSetString("data", lua.LString(string(msg.Payload())))
+	ret.RawSetString("qos", lua.LNumber(msg.Qos()))
+	ret.RawSetString("retained", lua.LBool(msg.Retained()))
+	return ret
+}
+
+func getMQTTClientParam(L *lua.LState, i int) (*MQTTClient, error) {
+	v := L.Get(i)
+	ud, ok := v.(*lua.LUserData)
+	if !ok {
+		return nil, fmt.Errorf("error: getMQTTClientParam: expected user data type for 'mqttclient', got: '%s'", v.Type().String())
+	}
+	if v, ok := ud.Value.(*MQTTClient); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("error: getMQTTClientParam: expected 'MQTTClient' for 'mqttclient', got: '%s'", reflect.TypeOf(ud.Value).String())
+}
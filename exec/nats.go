@@ -0,0 +1,430 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	luaNATSConsumerTypeName = "natsconsumer"
+	luaNATSProducerTypeName = "natsproducer"
+)
+
+type NATSConsumer struct {
+	conn *nats.Conn
+	sub  *nats.Subscription
+	// msgs is only used for core NATS subscriptions, JetStream consumers pull on each read
+	msgs      chan *nats.Msg
+	jetstream bool
+}
+
+func (nc *NATSConsumer) toUserData(L *lua.LState) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = nc
+	L.SetMetatable(ud, L.GetTypeMetatable(luaNATSConsumerTypeName))
+	return ud
+}
+
+func (nc *NATSConsumer) Close() error {
+	if nc.conn.IsClosed() {
+		return nil
+	}
+	if err := nc.sub.Unsubscribe(); err != nil && !errors.Is(err, nats.ErrBadSubscription) {
+		return err
+	}
+	nc.conn.Close()
+	return nil
+}
+
+type NATSProducer struct {
+	conn   *nats.Conn
+	Config struct {
+		Timeout time.Duration
+	}
+}
+
+func (np *NATSProducer) toUserData(L *lua.LState) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = np
+	L.SetMetatable(ud, L.GetTypeMetatable(luaNATSProducerTypeName))
+	return ud
+}
+
+func (np *NATSProducer) Close() error {
+	if np.conn.IsClosed() {
+		return nil
+	}
+	if err := np.conn.Flush(); err != nil {
+		return err
+	}
+	np.conn.Close()
+	return nil
+}
+
+func (s *State) registerNATSModule(L *lua.LState) {
+	L.PreloadModule("sqump_nats", func(l *lua.LState) int {
+		// Register consumer type
+		{
+			consumerMT := L.NewTypeMetatable(luaNATSConsumerTypeName)
+			L.SetGlobal(luaNATSConsumerTypeName, consumerMT)
+			L.SetField(consumerMT, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+				"read_message": s.natsReadMessage,
+				"respond":      s.natsRespond,
+				"close":        s.natsConsumerClose,
+			}))
+		}
+		// Register producer type
+		{
+			producerMT := L.NewTypeMetatable(luaNATSProducerTypeName)
+			L.SetGlobal(luaNATSProducerTypeName, producerMT)
+			L.SetField(producerMT, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+				"write":   s.natsWriteMessage,
+				"request": s.natsRequest,
+				"close":   s.natsProducerClose,
+			}))
+		}
+		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"new_consumer":           s.newNATSConsumer,
+			"new_jetstream_consumer": s.newNATSJetStreamConsumer,
+			"new_producer":           s.newNATSProducer,
+		})
+		L.Push(mod)
+		return 1
+	})
+}
+
+func (s *State) newNATSConsumer(_ *lua.LState) int {
+	url, err := getStringParam(s.LState, "url", 1)
+	if err != nil {
+		return s.CancelErr("error: new_consumer: %v", err)
+	}
+	subject, err := getStringParam(s.LState, "subject", 2)
+	if err != nil {
+		return s.CancelErr("error: new_consumer: %v", err)
+	}
+	options, err := getOptionsParam(s.LState, "options", 3)
+	if err != nil {
+		return s.CancelErr("error: new_consumer: %v", err)
+	}
+	conn, err := natsConnect(url, options)
+	if err != nil {
+		return s.CancelErr("error: new_consumer: %v", err)
+	}
+	msgs := make(chan *nats.Msg, 64)
+	var sub *nats.Subscription
+	if queue := stringOrDefault(options, "queue", ""); queue != "" {
+		sub, err = conn.ChanQueueSubscribe(subject, queue, msgs)
+	} else {
+		sub, err = conn.ChanSubscribe(subject, msgs)
+	}
+	if err != nil {
+		conn.Close()
+		return s.CancelErr("error: new_consumer: %v", err)
+	}
+	// Make sure the server has registered interest before any writes the script goes on to make
+	if err = conn.Flush(); err != nil {
+		conn.Close()
+		return s.CancelErr("error: new_consumer: %v", err)
+	}
+	consumer := &NATSConsumer{
+		conn: conn,
+		sub:  sub,
+		msgs: msgs,
+	}
+	s.track(consumer)
+	s.LState.Push(consumer.toUserData(s.LState))
+	return 1
+}
+
+func (s *State) newNATSJetStreamConsumer(_ *lua.LState) int {
+	url, err := getStringParam(s.LState, "url", 1)
+	if err != nil {
+		return s.CancelErr("error: new_jetstream_consumer: %v", err)
+	}
+	stream, err := getStringParam(s.LState, "stream", 2)
+	if err != nil {
+		return s.CancelErr("error: new_jetstream_consumer: %v", err)
+	}
+	subject, err := getStringParam(s.LState, "subject", 3)
+	if err != nil {
+		return s.CancelErr("error: new_jetstream_consumer: %v", err)
+	}
+	options, err := getOptionsParam(s.LState, "options", 4)
+	if err != nil {
+		return s.CancelErr("error: new_jetstream_consumer: %v", err)
+	}
+	conn, err := natsConnect(url, options)
+	if err != nil {
+		return s.CancelErr("error: new_jetstream_consumer: %v", err)
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return s.CancelErr("error: new_jetstream_consumer: %v", err)
+	}
+	subOpts := []nats.SubOpt{nats.BindStream(stream)}
+	switch stringOrDefault(options, "deliver", "all") {
+	case "all":
+		subOpts = append(subOpts, nats.DeliverAll())
+	case "new":
+		subOpts = append(subOpts, nats.DeliverNew())
+	case "last":
+		subOpts = append(subOpts, nats.DeliverLast())
+	default:
+		conn.Close()
+		return s.CancelErr("error: new_jetstream_consumer: unexpected deliver policy '%s'", stringOrDefault(options, "deliver", ""))
+	}
+	// An empty durable name creates an ephemeral consumer that is cleaned up on close
+	sub, err := js.PullSubscribe(subject, stringOrDefault(options, "durable", ""), subOpts...)
+	if err != nil {
+		conn.Close()
+		return s.CancelErr("error: new_jetstream_consumer: %v", err)
+	}
+	consumer := &NATSConsumer{
+		conn:      conn,
+		sub:       sub,
+		jetstream: true,
+	}
+	s.track(consumer)
+	s.LState.Push(consumer.toUserData(s.LState))
+	return 1
+}
+
+func (s *State) natsReadMessage(_ *lua.LState) int {
+	consumer, err := getNATSConsumerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: read_message: %v", err)
+	}
+	timeout, err := getIntParam(s.LState, "timeout", 2)
+	if err != nil {
+		return s.CancelErr("error: read_message: %v", err)
+	}
+	failOnTimeout, err := getBoolParam(s.LState, "fail_on_timeout", 3)
+	if err != nil {
+		return s.CancelErr("error: read_message: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	var msg *nats.Msg
+	if consumer.jetstream {
+		var msgs []*nats.Msg
+		msgs, err = consumer.sub.Fetch(1, nats.Context(ctx))
+		if err == nil && len(msgs) > 0 {
+			msg = msgs[0]
+			err = msg.Ack()
+		}
+	} else {
+		select {
+		case msg = <-consumer.msgs:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	if err != nil {
+		if (errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout)) && !failOnTimeout {
+			// Do nothing, we mean not to fail in this case (easiest to represent this way)
+			s.LState.Push(lua.LNil)
+			return 1
+		}
+		return s.CancelErr("error: read_message: %v", err)
+	}
+	s.LState.Push(natsMessageToTable(msg))
+	return 1
+}
+
+func (s *State) natsRespond(_ *lua.LState) int {
+	consumer, err := getNATSConsumerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: respond: %v", err)
+	}
+	msgVal := s.LState.Get(2)
+	msgTable, ok := msgVal.(*lua.LTable)
+	if !ok {
+		return s.CancelErr("error: respond: expected 'message' parameter to be table, instead got: %s", msgVal.Type().String())
+	}
+	reply, err := getString(msgTable, "reply")
+	if err != nil || reply == "" {
+		return s.CancelErr("error: respond: message has no reply subject to respond to")
+	}
+	data, err := getStringParam(s.LState, "data", 3)
+	if err != nil {
+		return s.CancelErr("error: respond: %v", err)
+	}
+	if err = consumer.conn.Publish(reply, []byte(data)); err != nil {
+		return s.CancelErr("error: respond: %v", err)
+	}
+	return 0
+}
+
+func (s *State) natsConsumerClose(_ *lua.LState) int {
+	consumer, err := getNATSConsumerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: consumer:close: %v", err)
+	}
+	if err = consumer.Close(); err != nil {
+		return s.CancelErr("error: consumer:close: %v", err)
+	}
+	return 0
+}
+
+func (s *State) newNATSProducer(_ *lua.LState) int {
+	url, err := getStringParam(s.LState, "url", 1)
+	if err != nil {
+		return s.CancelErr("error: new_producer: %v", err)
+	}
+	timeout, err := getIntParam(s.LState, "timeout", 2)
+	if err != nil {
+		return s.CancelErr("error: new_producer: %v", err)
+	}
+	options, err := getOptionsParam(s.LState, "options", 3)
+	if err != nil {
+		return s.CancelErr("error: new_producer: %v", err)
+	}
+	conn, err := natsConnect(url, options)
+	if err != nil {
+		return s.CancelErr("error: new_producer: %v", err)
+	}
+	producer := &NATSProducer{
+		conn: conn,
+	}
+	producer.Config.Timeout = time.Duration(timeout) * time.Second
+	s.track(producer)
+	s.LState.Push(producer.toUserData(s.LState))
+	return 1
+}
+
+func (s *State) natsWriteMessage(_ *lua.LState) int {
+	producer, err := getNATSProducerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: write: %v", err)
+	}
+	msg, err := natsMessageFromParams(s.LState, 2)
+	if err != nil {
+		return s.CancelErr("error: write: %v", err)
+	}
+	if err = producer.conn.PublishMsg(msg); err != nil {
+		return s.CancelErr("error: write: %v", err)
+	}
+	if err = producer.conn.FlushTimeout(producer.Config.Timeout); err != nil {
+		return s.CancelErr("error: write: %v", err)
+	}
+	return 0
+}
+
+func (s *State) natsRequest(_ *lua.LState) int {
+	producer, err := getNATSProducerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: request: %v", err)
+	}
+	msg, err := natsMessageFromParams(s.LState, 2)
+	if err != nil {
+		return s.CancelErr("error: request: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, producer.Config.Timeout)
+	defer cancel()
+	resp, err := producer.conn.RequestMsgWithContext(ctx, msg)
+	if err != nil {
+		return s.CancelErr("error: request: %v", err)
+	}
+	s.LState.Push(natsMessageToTable(resp))
+	return 1
+}
+
+func (s *State) natsProducerClose(_ *lua.LState) int {
+	producer, err := getNATSProducerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: producer:close: %v", err)
+	}
+	if err = producer.Close(); err != nil {
+		return s.CancelErr("error: producer:close: %v", err)
+	}
+	return 0
+}
+
+func natsConnect(url string, options *lua.LTable) (*nats.Conn, error) {
+	opts := []nats.Option{
+		nats.Name(stringOrDefault(options, "name", "sqump")),
+		nats.Timeout(time.Duration(intOrDefault(options, "connect_timeout", 5)) * time.Second),
+	}
+	if token := stringOrDefault(options, "token", ""); token != "" {
+		opts = append(opts, nats.Token(token))
+	}
+	if user := stringOrDefault(options, "user", ""); user != "" {
+		opts = append(opts, nats.UserInfo(user, stringOrDefault(options, "password", "")))
+	}
+	if creds := stringOrDefault(options, "credentials", ""); creds != "" {
+		opts = append(opts, nats.UserCredentials(creds))
+	}
+	return nats.Connect(url, opts...)
+}
+
+// natsMessageFromParams reads the (subject, data, options) parameters shared by writes and requests
+func natsMessageFromParams(L *lua.LState, stackPosition int) (*nats.Msg, error) {
+	subject, err := getStringParam(L, "subject", stackPosition)
+	if err != nil {
+		return nil, err
+	}
+	data, err := getStringParam(L, "data", stackPosition+1)
+	if err != nil {
+		return nil, err
+	}
+	options, err := getOptionsParam(L, "options", stackPosition+2)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := getStringMap(options, "headers")
+	if err != nil {
+		return nil, err
+	}
+	msg := nats.NewMsg(subject)
+	msg.Data = []byte(data)
+	msg.Reply = stringOrDefault(options, "reply", "")
+	for k, v := range headers {
+		msg.Header.Set(k, v)
+	}
+	return msg, nil
+}
+
+func natsMessageToTable(msg *nats.Msg) *lua.LTable {
+	headers := &lua.LTable{}
+	for k := range msg.Header {
+		headers.RawSetString(k, lua.LString(msg.Header.Get(k)))
+	}
+	ret := &lua.LTable{}
+	ret.RawSetString("subject", lua.LString(msg.Subject))
+	ret.RawSetString("reply", lua.LString(msg.Reply))
+	ret.RawSetString("headers", headers)
+	ret.RawSetString("data", lua.LString(string(msg.Data)))
+	return ret
+}
+
+func getNATSConsumerParam(L *lua.LState, i int) (*NATSConsumer, error) {
+	v := L.Get(i)
+	ud, ok := v.(*lua.LUserData)
+	if !ok {
+		return nil, fmt.Errorf("error: getNATSConsumerParam: expected user data type for 'consumer', got: '%s'", v.Type().String())
+	}
+	if v, ok := ud.Value.(*NATSConsumer); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("error: getNATSConsumerParam: expected 'NATSConsumer' for 'consumer', got: '%s'", reflect.TypeOf(ud.Value).String())
+}
+
+func getNATSProducerParam(L *lua.LState, i int) (*NATSProducer, error) {
+	v := L.Get(i)
+	ud, ok := v.(*lua.LUserData)
+	if !ok {
+		return nil, fmt.Errorf("error: getNATSProducerParam: expected user data type for 'producer', got: '%s'", v.Type().String())
+	}
+	if v, ok := ud.Value.(*NATSProducer); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("error: getNATSProducerParam: expected 'NATSProducer' for 'producer', got: '%s'", reflect.TypeOf(ud.Value).String())
+}
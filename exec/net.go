@@ -0,0 +1,507 @@
+package exec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	luaNetConnTypeName     = "netconn"
+	luaNetListenerTypeName = "netlistener"
+
+	maxDatagramSize = 64 * 1024
+)
+
+type NetConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newNetConn(conn net.Conn) *NetConn {
+	return &NetConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+}
+
+func (nc *NetConn) toUserData(L *lua.LState) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = nc
+	L.SetMetatable(ud, L.GetTypeMetatable(luaNetConnTypeName))
+	return ud
+}
+
+func (nc *NetConn) Close() error {
+	return nc.conn.Close()
+}
+
+// NetListener holds either a TCP listener or a UDP packet connection, depending on the network it was opened with
+type NetListener struct {
+	tcp net.Listener
+	udp net.PacketConn
+}
+
+func (nl *NetListener) toUserData(L *lua.LState) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = nl
+	L.SetMetatable(ud, L.GetTypeMetatable(luaNetListenerTypeName))
+	return ud
+}
+
+func (nl *NetListener) Close() error {
+	if nl.tcp != nil {
+		return nl.tcp.Close()
+	}
+	return nl.udp.Close()
+}
+
+func (nl *NetListener) Addr() net.Addr {
+	if nl.tcp != nil {
+		return nl.tcp.Addr()
+	}
+	return nl.udp.LocalAddr()
+}
+
+func (s *State) registerNetModule(L *lua.LState) {
+	L.PreloadModule("sqump_net", func(l *lua.LState) int {
+		// Register connection type
+		{
+			connMT := L.NewTypeMetatable(luaNetConnTypeName)
+			L.SetGlobal(luaNetConnTypeName, connMT)
+			L.SetField(connMT, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+				"read":        s.netRead,
+				"write":       s.netWrite,
+				"remote_addr": s.netRemoteAddr,
+				"close":       s.netConnClose,
+			}))
+		}
+		// Register listener type
+		{
+			listenerMT := L.NewTypeMetatable(luaNetListenerTypeName)
+			L.SetGlobal(luaNetListenerTypeName, listenerMT)
+			L.SetField(listenerMT, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+				"accept":  s.netAccept,
+				"receive": s.netReceive,
+				"send_to": s.netSendTo,
+				"serve":   s.netServe,
+				"addr":    s.netListenerAddr,
+				"close":   s.netListenerClose,
+			}))
+		}
+		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"tcp_connect": s.netTCPConnect,
+			"udp_send":    s.netUDPSend,
+			"listen":      s.netListen,
+		})
+		L.Push(mod)
+		return 1
+	})
+}
+
+func (s *State) netTCPConnect(_ *lua.LState) int {
+	addr, err := getStringParam(s.LState, "addr", 1)
+	if err != nil {
+		return s.CancelErr("error: tcp_connect: %v", err)
+	}
+	options, err := getOptionsParam(s.LState, "options", 2)
+	if err != nil {
+		return s.CancelErr("error: tcp_connect: %v", err)
+	}
+	dialer := net.Dialer{
+		Timeout: time.Duration(intOrDefault(options, "timeout", 10)) * time.Second,
+	}
+	conn, err := dialer.DialContext(s.ctx, "tcp", addr)
+	if err != nil {
+		return s.CancelErr("error: tcp_connect: %v", err)
+	}
+	c := newNetConn(conn)
+	s.track(c)
+	s.LState.Push(c.toUserData(s.LState))
+	return 1
+}
+
+func (s *State) netUDPSend(_ *lua.LState) int {
+	addr, err := getStringParam(s.LState, "addr", 1)
+	if err != nil {
+		return s.CancelErr("error: udp_send: %v", err)
+	}
+	payload, err := getStringParam(s.LState, "data", 2)
+	if err != nil {
+		return s.CancelErr("error: udp_send: %v", err)
+	}
+	options, err := getOptionsParam(s.LState, "options", 3)
+	if err != nil {
+		return s.CancelErr("error: udp_send: %v", err)
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(s.ctx, "udp", addr)
+	if err != nil {
+		return s.CancelErr("error: udp_send: %v", err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(payload)); err != nil {
+		return s.CancelErr("error: udp_send: %v", err)
+	}
+	responseTimeout := intOrDefault(options, "response_timeout", 0)
+	if responseTimeout <= 0 {
+		return 0
+	}
+	if err = conn.SetReadDeadline(time.Now().Add(time.Duration(responseTimeout) * time.Second)); err != nil {
+		return s.CancelErr("error: udp_send: %v", err)
+	}
+	buf := make([]byte, maxDatagramSize)
+	n, err := conn.Read(buf)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		s.LState.Push(lua.LNil)
+		return 1
+	} else if err != nil {
+		return s.CancelErr("error: udp_send: %v", err)
+	}
+	s.LState.Push(lua.LString(string(buf[:n])))
+	return 1
+}
+
+func (s *State) netListen(_ *lua.LState) int {
+	network, err := getStringParam(s.LState, "network", 1)
+	if err != nil {
+		return s.CancelErr("error: listen: %v", err)
+	}
+	addr, err := getStringParam(s.LState, "addr", 2)
+	if err != nil {
+		return s.CancelErr("error: listen: %v", err)
+	}
+	var lc net.ListenConfig
+	listener := &NetListener{}
+	switch network {
+	case "tcp":
+		listener.tcp, err = lc.Listen(s.ctx, "tcp", addr)
+	case "udp":
+		listener.udp, err = lc.ListenPacket(s.ctx, "udp", addr)
+	default:
+		return s.CancelErr("error: listen: unexpected network '%s', expected 'tcp' or 'udp'", network)
+	}
+	if err != nil {
+		return s.CancelErr("error: listen: %v", err)
+	}
+	s.track(listener)
+	s.LState.Push(listener.toUserData(s.LState))
+	return 1
+}
+
+func (s *State) netRead(_ *lua.LState) int {
+	conn, err := getNetConnParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: read: %v", err)
+	}
+	options, err := getOptionsParam(s.LState, "options", 2)
+	if err != nil {
+		return s.CancelErr("error: read: %v", err)
+	}
+	timeout := intOrDefault(options, "timeout", 10)
+	if err = conn.conn.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Second)); err != nil {
+		return s.CancelErr("error: read: %v", err)
+	}
+
+	var b []byte
+	delimiter := stringOrDefault(options, "delimiter", "")
+	length := intOrDefault(options, "length", 0)
+	switch {
+	case delimiter != "":
+		b, err = conn.readUntil([]byte(delimiter))
+	case length > 0:
+		b = make([]byte, length)
+		_, err = io.ReadFull(conn.reader, b)
+	default:
+		b = make([]byte, maxDatagramSize)
+		var n int
+		n, err = conn.reader.Read(b)
+		b = b[:n]
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) && !boolOrDefault(options, "fail_on_timeout", false) {
+			// Do nothing, we mean not to fail in this case (easiest to represent this way)
+			s.LState.Push(lua.LNil)
+			return 1
+		}
+		return s.CancelErr("error: read: %v", err)
+	}
+	s.LState.Push(lua.LString(string(b)))
+	return 1
+}
+
+// readUntil reads up to and including the delimiter, returning what came before it
+func (nc *NetConn) readUntil(delimiter []byte) ([]byte, error) {
+	last := delimiter[len(delimiter)-1]
+	var buf []byte
+	for {
+		chunk, err := nc.reader.ReadBytes(last)
+		buf = append(buf, chunk...)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasSuffix(buf, delimiter) {
+			return buf[:len(buf)-len(delimiter)], nil
+		}
+	}
+}
+
+func (s *State) netWrite(_ *lua.LState) int {
+	conn, err := getNetConnParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: write: %v", err)
+	}
+	payload, err := getStringParam(s.LState, "data", 2)
+	if err != nil {
+		return s.CancelErr("error: write: %v", err)
+	}
+	if _, err = conn.conn.Write([]byte(payload)); err != nil {
+		return s.CancelErr("error: write: %v", err)
+	}
+	return 0
+}
+
+func (s *State) netRemoteAddr(_ *lua.LState) int {
+	conn, err := getNetConnParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: remote_addr: %v", err)
+	}
+	s.LState.Push(lua.LString(conn.conn.RemoteAddr().String()))
+	return 1
+}
+
+func (s *State) netConnClose(_ *lua.LState) int {
+	conn, err := getNetConnParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: close: %v", err)
+	}
+	if err = conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return s.CancelErr("error: close: %v", err)
+	}
+	return 0
+}
+
+func (s *State) netAccept(_ *lua.LState) int {
+	listener, err := getNetListenerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: accept: %v", err)
+	}
+	timeout, err := getIntParam(s.LState, "timeout", 2)
+	if err != nil {
+		return s.CancelErr("error: accept: %v", err)
+	}
+	failOnTimeout, err := getBoolParam(s.LState, "fail_on_timeout", 3)
+	if err != nil {
+		return s.CancelErr("error: accept: %v", err)
+	}
+	conn, err := s.acceptWithin(listener, time.Now().Add(time.Duration(timeout)*time.Second))
+	if err != nil {
+		return s.CancelErr("error: accept: %v", err)
+	}
+	if conn == nil {
+		if failOnTimeout {
+			return s.CancelErr("error: accept: timed out after %d seconds", timeout)
+		}
+		s.LState.Push(lua.LNil)
+		return 1
+	}
+	s.LState.Push(conn.toUserData(s.LState))
+	return 1
+}
+
+func (s *State) netReceive(_ *lua.LState) int {
+	listener, err := getNetListenerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: receive: %v", err)
+	}
+	timeout, err := getIntParam(s.LState, "timeout", 2)
+	if err != nil {
+		return s.CancelErr("error: receive: %v", err)
+	}
+	failOnTimeout, err := getBoolParam(s.LState, "fail_on_timeout", 3)
+	if err != nil {
+		return s.CancelErr("error: receive: %v", err)
+	}
+	packet, err := receiveWithin(listener, time.Now().Add(time.Duration(timeout)*time.Second))
+	if err != nil {
+		return s.CancelErr("error: receive: %v", err)
+	}
+	if packet == nil {
+		if failOnTimeout {
+			return s.CancelErr("error: receive: timed out after %d seconds", timeout)
+		}
+		s.LState.Push(lua.LNil)
+		return 1
+	}
+	s.LState.Push(packet)
+	return 1
+}
+
+func (s *State) netSendTo(_ *lua.LState) int {
+	listener, err := getNetListenerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: send_to: %v", err)
+	}
+	if listener.udp == nil {
+		return s.CancelErr("error: send_to: only available on 'udp' listeners")
+	}
+	addrStr, err := getStringParam(s.LState, "addr", 2)
+	if err != nil {
+		return s.CancelErr("error: send_to: %v", err)
+	}
+	payload, err := getStringParam(s.LState, "data", 3)
+	if err != nil {
+		return s.CancelErr("error: send_to: %v", err)
+	}
+	addr, err := net.ResolveUDPAddr("udp", addrStr)
+	if err != nil {
+		return s.CancelErr("error: send_to: %v", err)
+	}
+	if _, err = listener.udp.WriteTo([]byte(payload), addr); err != nil {
+		return s.CancelErr("error: send_to: %v", err)
+	}
+	return 0
+}
+
+func (s *State) netServe(_ *lua.LState) int {
+	listener, err := getNetListenerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: serve: %v", err)
+	}
+	cb, err := getFuncParam(s.LState, "cb", 2)
+	if err != nil {
+		return s.CancelErr("error: serve: %v", err)
+	}
+	timeout, err := getIntParam(s.LState, "timeout", 3)
+	if err != nil {
+		return s.CancelErr("error: serve: %v", err)
+	}
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	for {
+		var arg lua.LValue
+		if listener.tcp != nil {
+			conn, err := s.acceptWithin(listener, deadline)
+			if err != nil {
+				return s.CancelErr("error: serve: %v", err)
+			}
+			if conn != nil {
+				arg = conn.toUserData(s.LState)
+			}
+		} else {
+			packet, err := receiveWithin(listener, deadline)
+			if err != nil {
+				return s.CancelErr("error: serve: %v", err)
+			}
+			if packet != nil {
+				arg = packet
+			}
+		}
+		if arg == nil {
+			return 0
+		}
+		// Callbacks are run here, on the script's own thread, and may return false to stop serving
+		s.LState.Push(cb)
+		s.LState.Push(arg)
+		if err := s.LState.PCall(1, 1, nil); err != nil {
+			return s.CancelErr("error: serve: %v", err)
+		}
+		ret := s.LState.Get(-1)
+		s.LState.Pop(1)
+		if ret == lua.LFalse {
+			return 0
+		}
+	}
+}
+
+func (s *State) netListenerAddr(_ *lua.LState) int {
+	listener, err := getNetListenerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: addr: %v", err)
+	}
+	s.LState.Push(lua.LString(listener.Addr().String()))
+	return 1
+}
+
+func (s *State) netListenerClose(_ *lua.LState) int {
+	listener, err := getNetListenerParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: close: %v", err)
+	}
+	if err = listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return s.CancelErr("error: close: %v", err)
+	}
+	return 0
+}
+
+// acceptWithin accepts the next TCP connection before the deadline, returning nil on timeout
+func (s *State) acceptWithin(listener *NetListener, deadline time.Time) (*NetConn, error) {
+	tcp, ok := listener.tcp.(*net.TCPListener)
+	if !ok {
+		return nil, errors.New("only available on 'tcp' listeners")
+	}
+	if err := tcp.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	conn, err := tcp.Accept()
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	c := newNetConn(conn)
+	s.track(c)
+	return c, nil
+}
+
+// receiveWithin reads the next UDP datagram before the deadline, returning nil on timeout
+func receiveWithin(listener *NetListener, deadline time.Time) (*lua.LTable, error) {
+	if listener.udp == nil {
+		return nil, errors.New("only available on 'udp' listeners")
+	}
+	if err := listener.udp.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxDatagramSize)
+	n, from, err := listener.udp.ReadFrom(buf)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	ret := &lua.LTable{}
+	ret.RawSetString("data", lua.LString(string(buf[:n])))
+	ret.RawSetString("from", lua.LString(from.String()))
+	return ret, nil
+}
+
+func getNetConnParam(L *lua.LState, i int) (*NetConn, error) {
+	v := L.Get(i)
+	ud, ok := v.(*lua.LUserData)
+	if !ok {
+		return nil, fmt.Errorf("error: getNetConnParam: expected user data type for 'netconn', got: '%s'", v.Type().String())
+	}
+	if v, ok := ud.Value.(*NetConn); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("error: getNetConnParam: expected 'NetConn' for 'netconn', got: '%s'", reflect.TypeOf(ud.Value).String())
+}
+
+func getNetListenerParam(L *lua.LState, i int) (*NetListener, error) {
+	v := L.Get(i)
+	ud, ok := v.(*lua.LUserData)
+	if !ok {
+		return nil, fmt.Errorf("error: getNetListenerParam: expected user data type for 'netlistener', got: '%s'", v.Type().String())
+	}
+	if v, ok := ud.Value.(*NetListener); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("error: getNetListenerParam: expected 'NetListener' for 'netlistener', got: '%s'", reflect.TypeOf(ud.Value).String())
+}
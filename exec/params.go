@@ -0,0 +1,77 @@
+package exec
+
+import (
+	"fmt"
+
+	"github.com/EvWilson/sqump/data"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// setParams sets the `params` global to the request's parameters, resolved from those given, giving a func that
+// restores the caller's
+func (s *State) setParams(req *data.Request, given map[string]any) (func(), error) {
+	params, err := req.ResolveParams(given)
+	if err != nil {
+		return nil, err
+	}
+	tbl := s.LState.NewTable()
+	for k, v := range params {
+		switch val := v.(type) {
+		case string:
+			tbl.RawSetString(k, lua.LString(val))
+		case float64:
+			tbl.RawSetString(k, lua.LNumber(val))
+		case bool:
+			tbl.RawSetString(k, lua.LBool(val))
+		}
+	}
+	prev := s.LState.GetGlobal("params")
+	s.LState.SetGlobal("params", tbl)
+	return func() {
+		s.LState.SetGlobal("params", prev)
+	}, nil
+}
+
+// getParamsArg reads the optional table of parameters at the given position, e.g. that passed to `require`
+func getParamsArg(L *lua.LState, stackPosition int) (map[string]any, error) {
+	stackVal := L.Get(stackPosition)
+	if stackVal == lua.LNil {
+		return nil, nil
+	}
+	tbl, ok := stackVal.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("expected 'params' parameter to be table, instead got '%s'", stackVal.Type().String())
+	}
+	params := make(map[string]any)
+	var outerErr error
+	tbl.ForEach(func(k, v lua.LValue) {
+		key, ok := k.(lua.LString)
+		if !ok {
+			outerErr = fmt.Errorf("expected parameter names to be strings, got '%s'", k.Type().String())
+			return
+		}
+		val, err := lValueToGo(v)
+		if err != nil {
+			outerErr = fmt.Errorf("parameter '%s': %v", key, err)
+			return
+		}
+		params[string(key)] = val
+	})
+	return params, outerErr
+}
+
+// returnedValues converts the values left on the stack by a script to those that encode to JSON, giving those that
+// can't be, such as functions, as they print
+func returnedValues(L *lua.LState) []any {
+	values := make([]any, 0, L.GetTop())
+	for i := 1; i <= L.GetTop(); i++ {
+		lv := L.Get(i)
+		v, err := lValueToGo(lv)
+		if err != nil {
+			v = lv.String()
+		}
+		values = append(values, v)
+	}
+	return values
+}
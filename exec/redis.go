@@ -0,0 +1,637 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	luaRedisClientTypeName       = "redisclient"
+	luaRedisSubscriptionTypeName = "redissubscription"
+)
+
+type RedisClient struct {
+	*redis.Client
+	Timeout time.Duration
+}
+
+func (rc *RedisClient) toUserData(L *lua.LState) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = rc
+	L.SetMetatable(ud, L.GetTypeMetatable(luaRedisClientTypeName))
+	return ud
+}
+
+type RedisSubscription struct {
+	*redis.PubSub
+}
+
+func (rs *RedisSubscription) toUserData(L *lua.LState) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = rs
+	L.SetMetatable(ud, L.GetTypeMetatable(luaRedisSubscriptionTypeName))
+	return ud
+}
+
+func (s *State) registerRedisModule(L *lua.LState) {
+	L.PreloadModule("sqump_redis", func(l *lua.LState) int {
+		// Register client type
+		{
+			clientMT := L.NewTypeMetatable(luaRedisClientTypeName)
+			L.SetGlobal(luaRedisClientTypeName, clientMT)
+			L.SetField(clientMT, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+				"get":       s.redisGet,
+				"set":       s.redisSet,
+				"del":       s.redisDel,
+				"exists":    s.redisExists,
+				"expire":    s.redisExpire,
+				"ttl":       s.redisTTL,
+				"keys":      s.redisKeys,
+				"hget":      s.redisHGet,
+				"hset":      s.redisHSet,
+				"hgetall":   s.redisHGetAll,
+				"hdel":      s.redisHDel,
+				"lpush":     s.redisLPush,
+				"rpush":     s.redisRPush,
+				"lpop":      s.redisLPop,
+				"rpop":      s.redisRPop,
+				"lrange":    s.redisLRange,
+				"llen":      s.redisLLen,
+				"publish":   s.redisPublish,
+				"subscribe": s.redisSubscribe,
+				"close":     s.redisClose,
+			}))
+		}
+		// Register subscription type
+		{
+			subscriptionMT := L.NewTypeMetatable(luaRedisSubscriptionTypeName)
+			L.SetGlobal(luaRedisSubscriptionTypeName, subscriptionMT)
+			L.SetField(subscriptionMT, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+				"receive": s.redisReceive,
+				"listen":  s.redisListen,
+				"close":   s.redisSubscriptionClose,
+			}))
+		}
+		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"new_client": s.newRedisClient,
+		})
+		L.Push(mod)
+		return 1
+	})
+}
+
+func (s *State) newRedisClient(_ *lua.LState) int {
+	var opts *redis.Options
+	optionVal := s.LState.Get(1)
+	switch optionVal.Type() {
+	case lua.LTString:
+		var err error
+		opts, err = redis.ParseURL(optionVal.String())
+		if err != nil {
+			return s.CancelErr("error: redis.new_client: %v", err)
+		}
+	case lua.LTTable:
+		options := optionVal.(*lua.LTable)
+		opts = &redis.Options{
+			Addr:     stringOrDefault(options, "addr", "localhost:6379"),
+			Username: stringOrDefault(options, "username", ""),
+			Password: stringOrDefault(options, "password", ""),
+			DB:       intOrDefault(options, "db", 0),
+		}
+	default:
+		return s.CancelErr("error: redis.new_client: expected 'options' parameter to be string or table, instead got: %s", optionVal.Type().String())
+	}
+	timeout := 5
+	if optionVal.Type() == lua.LTTable {
+		timeout = intOrDefault(optionVal.(*lua.LTable), "timeout", timeout)
+	}
+	client := &RedisClient{
+		Client:  redis.NewClient(opts),
+		Timeout: time.Duration(timeout) * time.Second,
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return s.CancelErr("error: redis.new_client: %v", err)
+	}
+	s.track(client)
+	s.LState.Push(client.toUserData(s.LState))
+	return 1
+}
+
+func (rc *RedisClient) context(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, rc.Timeout)
+}
+
+func (s *State) redisGet(_ *lua.LState) int {
+	client, key, err := getRedisKeyParams(s.LState)
+	if err != nil {
+		return s.CancelErr("error: redis:get: %v", err)
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	val, err := client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		s.LState.Push(lua.LNil)
+		return 1
+	} else if err != nil {
+		return s.CancelErr("error: redis:get: %v", err)
+	}
+	s.LState.Push(lua.LString(val))
+	return 1
+}
+
+func (s *State) redisSet(_ *lua.LState) int {
+	client, key, err := getRedisKeyParams(s.LState)
+	if err != nil {
+		return s.CancelErr("error: redis:set: %v", err)
+	}
+	val, err := getStringParam(s.LState, "value", 3)
+	if err != nil {
+		return s.CancelErr("error: redis:set: %v", err)
+	}
+	ttl := 0
+	if s.LState.Get(4) != lua.LNil {
+		ttl, err = getIntParam(s.LState, "ttl", 4)
+		if err != nil {
+			return s.CancelErr("error: redis:set: %v", err)
+		}
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	if err = client.Set(ctx, key, val, time.Duration(ttl)*time.Second).Err(); err != nil {
+		return s.CancelErr("error: redis:set: %v", err)
+	}
+	return 0
+}
+
+func (s *State) redisDel(_ *lua.LState) int {
+	client, err := getRedisClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: redis:del: %v", err)
+	}
+	keys, err := getVariadicStringParams(s.LState, "keys", 2)
+	if err != nil {
+		return s.CancelErr("error: redis:del: %v", err)
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	n, err := client.Del(ctx, keys...).Result()
+	if err != nil {
+		return s.CancelErr("error: redis:del: %v", err)
+	}
+	s.LState.Push(lua.LNumber(n))
+	return 1
+}
+
+func (s *State) redisExists(_ *lua.LState) int {
+	client, key, err := getRedisKeyParams(s.LState)
+	if err != nil {
+		return s.CancelErr("error: redis:exists: %v", err)
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	n, err := client.Exists(ctx, key).Result()
+	if err != nil {
+		return s.CancelErr("error: redis:exists: %v", err)
+	}
+	s.LState.Push(lua.LBool(n > 0))
+	return 1
+}
+
+func (s *State) redisExpire(_ *lua.LState) int {
+	client, key, err := getRedisKeyParams(s.LState)
+	if err != nil {
+		return s.CancelErr("error: redis:expire: %v", err)
+	}
+	seconds, err := getIntParam(s.LState, "seconds", 3)
+	if err != nil {
+		return s.CancelErr("error: redis:expire: %v", err)
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	ok, err := client.Expire(ctx, key, time.Duration(seconds)*time.Second).Result()
+	if err != nil {
+		return s.CancelErr("error: redis:expire: %v", err)
+	}
+	s.LState.Push(lua.LBool(ok))
+	return 1
+}
+
+func (s *State) redisTTL(_ *lua.LState) int {
+	client, key, err := getRedisKeyParams(s.LState)
+	if err != nil {
+		return s.CancelErr("error: redis:ttl: %v", err)
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	ttl, err := client.TTL(ctx, key).Result()
+	if err != nil {
+		return s.CancelErr("error: redis:ttl: %v", err)
+	}
+	// Negative durations are Redis' sentinels for "no expiry" (-1) and "no key" (-2)
+	if ttl < 0 {
+		s.LState.Push(lua.LNumber(ttl))
+	} else {
+		s.LState.Push(lua.LNumber(ttl.Seconds()))
+	}
+	return 1
+}
+
+func (s *State) redisKeys(_ *lua.LState) int {
+	client, pattern, err := getRedisKeyParams(s.LState)
+	if err != nil {
+		return s.CancelErr("error: redis:keys: %v", err)
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	keys, err := client.Keys(ctx, pattern).Result()
+	if err != nil {
+		return s.CancelErr("error: redis:keys: %v", err)
+	}
+	s.LState.Push(sliceToLuaArray(keys))
+	return 1
+}
+
+func (s *State) redisHGet(_ *lua.LState) int {
+	client, key, err := getRedisKeyParams(s.LState)
+	if err != nil {
+		return s.CancelErr("error: redis:hget: %v", err)
+	}
+	field, err := getStringParam(s.LState, "field", 3)
+	if err != nil {
+		return s.CancelErr("error: redis:hget: %v", err)
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	val, err := client.HGet(ctx, key, field).Result()
+	if errors.Is(err, redis.Nil) {
+		s.LState.Push(lua.LNil)
+		return 1
+	} else if err != nil {
+		return s.CancelErr("error: redis:hget: %v", err)
+	}
+	s.LState.Push(lua.LString(val))
+	return 1
+}
+
+func (s *State) redisHSet(_ *lua.LState) int {
+	client, key, err := getRedisKeyParams(s.LState)
+	if err != nil {
+		return s.CancelErr("error: redis:hset: %v", err)
+	}
+	fields, err := getTableParam(s.LState, "fields", 3)
+	if err != nil {
+		return s.CancelErr("error: redis:hset: %v", err)
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	n, err := client.HSet(ctx, key, fields).Result()
+	if err != nil {
+		return s.CancelErr("error: redis:hset: %v", err)
+	}
+	s.LState.Push(lua.LNumber(n))
+	return 1
+}
+
+func (s *State) redisHGetAll(_ *lua.LState) int {
+	client, key, err := getRedisKeyParams(s.LState)
+	if err != nil {
+		return s.CancelErr("error: redis:hgetall: %v", err)
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	vals, err := client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return s.CancelErr("error: redis:hgetall: %v", err)
+	}
+	ret := &lua.LTable{}
+	for k, v := range vals {
+		ret.RawSetString(k, lua.LString(v))
+	}
+	s.LState.Push(ret)
+	return 1
+}
+
+func (s *State) redisHDel(_ *lua.LState) int {
+	client, key, err := getRedisKeyParams(s.LState)
+	if err != nil {
+		return s.CancelErr("error: redis:hdel: %v", err)
+	}
+	fields, err := getVariadicStringParams(s.LState, "fields", 3)
+	if err != nil {
+		return s.CancelErr("error: redis:hdel: %v", err)
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	n, err := client.HDel(ctx, key, fields...).Result()
+	if err != nil {
+		return s.CancelErr("error: redis:hdel: %v", err)
+	}
+	s.LState.Push(lua.LNumber(n))
+	return 1
+}
+
+func (s *State) redisLPush(_ *lua.LState) int {
+	return s.redisPush("lpush", func(ctx context.Context, client *RedisClient, key string, vals ...any) (int64, error) {
+		return client.LPush(ctx, key, vals...).Result()
+	})
+}
+
+func (s *State) redisRPush(_ *lua.LState) int {
+	return s.redisPush("rpush", func(ctx context.Context, client *RedisClient, key string, vals ...any) (int64, error) {
+		return client.RPush(ctx, key, vals...).Result()
+	})
+}
+
+func (s *State) redisPush(name string, push func(context.Context, *RedisClient, string, ...any) (int64, error)) int {
+	client, key, err := getRedisKeyParams(s.LState)
+	if err != nil {
+		return s.CancelErr("error: redis:%s: %v", name, err)
+	}
+	vals, err := getVariadicStringParams(s.LState, "values", 3)
+	if err != nil {
+		return s.CancelErr("error: redis:%s: %v", name, err)
+	}
+	args := make([]any, 0, len(vals))
+	for _, v := range vals {
+		args = append(args, v)
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	n, err := push(ctx, client, key, args...)
+	if err != nil {
+		return s.CancelErr("error: redis:%s: %v", name, err)
+	}
+	s.LState.Push(lua.LNumber(n))
+	return 1
+}
+
+func (s *State) redisLPop(_ *lua.LState) int {
+	return s.redisPop("lpop", func(ctx context.Context, client *RedisClient, key string) (string, error) {
+		return client.LPop(ctx, key).Result()
+	})
+}
+
+func (s *State) redisRPop(_ *lua.LState) int {
+	return s.redisPop("rpop", func(ctx context.Context, client *RedisClient, key string) (string, error) {
+		return client.RPop(ctx, key).Result()
+	})
+}
+
+func (s *State) redisPop(name string, pop func(context.Context, *RedisClient, string) (string, error)) int {
+	client, key, err := getRedisKeyParams(s.LState)
+	if err != nil {
+		return s.CancelErr("error: redis:%s: %v", name, err)
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	val, err := pop(ctx, client, key)
+	if errors.Is(err, redis.Nil) {
+		s.LState.Push(lua.LNil)
+		return 1
+	} else if err != nil {
+		return s.CancelErr("error: redis:%s: %v", name, err)
+	}
+	s.LState.Push(lua.LString(val))
+	return 1
+}
+
+func (s *State) redisLRange(_ *lua.LState) int {
+	client, key, err := getRedisKeyParams(s.LState)
+	if err != nil {
+		return s.CancelErr("error: redis:lrange: %v", err)
+	}
+	start, err := getIntParam(s.LState, "start", 3)
+	if err != nil {
+		return s.CancelErr("error: redis:lrange: %v", err)
+	}
+	stop, err := getIntParam(s.LState, "stop", 4)
+	if err != nil {
+		return s.CancelErr("error: redis:lrange: %v", err)
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	vals, err := client.LRange(ctx, key, int64(start), int64(stop)).Result()
+	if err != nil {
+		return s.CancelErr("error: redis:lrange: %v", err)
+	}
+	s.LState.Push(sliceToLuaArray(vals))
+	return 1
+}
+
+func (s *State) redisLLen(_ *lua.LState) int {
+	client, key, err := getRedisKeyParams(s.LState)
+	if err != nil {
+		return s.CancelErr("error: redis:llen: %v", err)
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	n, err := client.LLen(ctx, key).Result()
+	if err != nil {
+		return s.CancelErr("error: redis:llen: %v", err)
+	}
+	s.LState.Push(lua.LNumber(n))
+	return 1
+}
+
+func (s *State) redisPublish(_ *lua.LState) int {
+	client, channel, err := getRedisKeyParams(s.LState)
+	if err != nil {
+		return s.CancelErr("error: redis:publish: %v", err)
+	}
+	msg, err := getStringParam(s.LState, "msg", 3)
+	if err != nil {
+		return s.CancelErr("error: redis:publish: %v", err)
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	n, err := client.Publish(ctx, channel, msg).Result()
+	if err != nil {
+		return s.CancelErr("error: redis:publish: %v", err)
+	}
+	s.LState.Push(lua.LNumber(n))
+	return 1
+}
+
+func (s *State) redisSubscribe(_ *lua.LState) int {
+	client, err := getRedisClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: redis:subscribe: %v", err)
+	}
+	channels, err := getVariadicStringParams(s.LState, "channels", 2)
+	if err != nil {
+		return s.CancelErr("error: redis:subscribe: %v", err)
+	}
+	ctx, cancel := client.context(s.ctx)
+	defer cancel()
+	pubsub := client.Subscribe(ctx, channels...)
+	// Wait for the subscription to be confirmed, so that publishes directly after this call are not missed
+	if _, err = pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return s.CancelErr("error: redis:subscribe: %v", err)
+	}
+	sub := &RedisSubscription{pubsub}
+	s.track(sub)
+	s.LState.Push(sub.toUserData(s.LState))
+	return 1
+}
+
+func (s *State) redisReceive(_ *lua.LState) int {
+	sub, err := getRedisSubscriptionParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: subscription:receive: %v", err)
+	}
+	timeout, err := getIntParam(s.LState, "timeout", 2)
+	if err != nil {
+		return s.CancelErr("error: subscription:receive: %v", err)
+	}
+	failOnTimeout, err := getBoolParam(s.LState, "fail_on_timeout", 3)
+	if err != nil {
+		return s.CancelErr("error: subscription:receive: %v", err)
+	}
+	msg, err := sub.receiveMessage(s.ctx, time.Now().Add(time.Duration(timeout)*time.Second))
+	if err != nil {
+		return s.CancelErr("error: subscription:receive: %v", err)
+	}
+	if msg == nil {
+		if failOnTimeout {
+			return s.CancelErr("error: subscription:receive: timed out after %d seconds", timeout)
+		}
+		s.LState.Push(lua.LNil)
+		return 1
+	}
+	s.LState.Push(redisMessageToTable(msg))
+	return 1
+}
+
+func (s *State) redisListen(_ *lua.LState) int {
+	sub, err := getRedisSubscriptionParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: subscription:listen: %v", err)
+	}
+	cb, err := getFuncParam(s.LState, "cb", 2)
+	if err != nil {
+		return s.CancelErr("error: subscription:listen: %v", err)
+	}
+	timeout, err := getIntParam(s.LState, "timeout", 3)
+	if err != nil {
+		return s.CancelErr("error: subscription:listen: %v", err)
+	}
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	for {
+		msg, err := sub.receiveMessage(s.ctx, deadline)
+		if err != nil {
+			return s.CancelErr("error: subscription:listen: %v", err)
+		}
+		if msg == nil {
+			return 0
+		}
+		// Callbacks are run here, on the script's own thread, and may return false to stop listening
+		s.LState.Push(cb)
+		s.LState.Push(redisMessageToTable(msg))
+		if err := s.LState.PCall(1, 1, nil); err != nil {
+			return s.CancelErr("error: subscription:listen: %v", err)
+		}
+		ret := s.LState.Get(-1)
+		s.LState.Pop(1)
+		if ret == lua.LFalse {
+			return 0
+		}
+	}
+}
+
+func (s *State) redisSubscriptionClose(_ *lua.LState) int {
+	sub, err := getRedisSubscriptionParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: subscription:close: %v", err)
+	}
+	if err = sub.Close(); err != nil {
+		return s.CancelErr("error: subscription:close: %v", err)
+	}
+	return 0
+}
+
+func (s *State) redisClose(_ *lua.LState) int {
+	client, err := getRedisClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: redis:close: %v", err)
+	}
+	if err = client.Close(); err != nil && !errors.Is(err, redis.ErrClosed) {
+		return s.CancelErr("error: redis:close: %v", err)
+	}
+	return 0
+}
+
+// receiveMessage waits until the deadline for the next published message, returning nil on timeout
+func (rs *RedisSubscription) receiveMessage(ctx context.Context, deadline time.Time) (*redis.Message, error) {
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, nil
+		}
+		received, err := rs.ReceiveTimeout(ctx, remaining)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil, nil
+			}
+			return nil, err
+		}
+		// Subscription confirmations and pongs are skipped over
+		if msg, ok := received.(*redis.Message); ok {
+			return msg, nil
+		}
+	}
+}
+
+func redisMessageToTable(msg *redis.Message) *lua.LTable {
+	ret := &lua.LTable{}
+	ret.RawSetString("channel", lua.LString(msg.Channel))
+	ret.RawSetString("data", lua.LString(msg.Payload))
+	return ret
+}
+
+func getRedisKeyParams(L *lua.LState) (*RedisClient, string, error) {
+	client, err := getRedisClientParam(L, 1)
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := getStringParam(L, "key", 2)
+	if err != nil {
+		return nil, "", err
+	}
+	return client, key, nil
+}
+
+func getRedisClientParam(L *lua.LState, i int) (*RedisClient, error) {
+	v := L.Get(i)
+	ud, ok := v.(*lua.LUserData)
+	if !ok {
+		return nil, fmt.Errorf("error: getRedisClientParam: expected user data type for 'redisclient', got: '%s'", v.Type().String())
+	}
+	if v, ok := ud.Value.(*RedisClient); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("error: getRedisClientParam: expected 'RedisClient' for 'redisclient', got: '%s'", reflect.TypeOf(ud.Value).String())
+}
+
+func getRedisSubscriptionParam(L *lua.LState, i int) (*RedisSubscription, error) {
+	v := L.Get(i)
+	ud, ok := v.(*lua.LUserData)
+	if !ok {
+		return nil, fmt.Errorf("error: getRedisSubscriptionParam: expected user data type for 'redissubscription', got: '%s'", v.Type().String())
+	}
+	if v, ok := ud.Value.(*RedisSubscription); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("error: getRedisSubscriptionParam: expected 'RedisSubscription' for 'redissubscription', got: '%s'", reflect.TypeOf(ud.Value).String())
+}
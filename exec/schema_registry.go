@@ -0,0 +1,540 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/linkedin/goavro/v2"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	lua "github.com/yuin/gopher-lua"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	luaRegistryTypeName = "registry"
+
+	schemaTypeAvro     = "AVRO"
+	schemaTypeProtobuf = "PROTOBUF"
+	schemaTypeJSON     = "JSON"
+
+	// rootProtoFile is the name given to the schema being compiled, so that its references may be imported by name
+	rootProtoFile = "sqump_root.proto"
+	// jsonSchemaBase is the URL JSON schemas are compiled under, so that references by relative name resolve to the
+	// schemas they name
+	jsonSchemaBase = "sqump://registry/"
+)
+
+// SchemaRegistry is a client for a Confluent-compatible schema registry, caching the schemas it fetches
+type SchemaRegistry struct {
+	URL      string
+	Username string
+	Password string
+	client   *http.Client
+	byID     map[int]*registrySchema
+}
+
+type registrySchema struct {
+	ID         int               `json:"id"`
+	Schema     string            `json:"schema"`
+	SchemaType string            `json:"schemaType"`
+	References []schemaReference `json:"references"`
+	avro       *goavro.Codec
+	proto      protoreflect.FileDescriptor
+	jsonSchema *jsonschema.Schema
+}
+
+type schemaReference struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+}
+
+func (sr *SchemaRegistry) toUserData(L *lua.LState) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = sr
+	L.SetMetatable(ud, L.GetTypeMetatable(luaRegistryTypeName))
+	return ud
+}
+
+func (s *State) registerSchemaRegistryType(L *lua.LState) {
+	registryMT := L.NewTypeMetatable(luaRegistryTypeName)
+	L.SetGlobal(luaRegistryTypeName, registryMT)
+	L.SetField(registryMT, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"register": s.registryRegister,
+		"decode":   s.registryDecode,
+		"encode":   s.registryEncode,
+	}))
+}
+
+func (s *State) newRegistry(_ *lua.LState) int {
+	var registryURL string
+	switch urlVal := s.LState.Get(1).(type) {
+	case lua.LString:
+		registryURL = string(urlVal)
+	case *lua.LNilType:
+		registryURL = s.environment["schema_registry_url"]
+		if registryURL == "" {
+			return s.CancelErr("error: new_registry: no URL given, and no 'schema_registry_url' found in the environment")
+		}
+	default:
+		return s.CancelErr("error: new_registry: expected 'url' parameter to be string or nil, instead got: %s", urlVal.Type().String())
+	}
+	options, err := getOptionsParam(s.LState, "options", 2)
+	if err != nil {
+		return s.CancelErr("error: new_registry: %v", err)
+	}
+	s.LState.Push((&SchemaRegistry{
+		URL:      strings.TrimSuffix(registryURL, "/"),
+		Username: stringOrDefault(options, "username", ""),
+		Password: stringOrDefault(options, "password", ""),
+		client: &http.Client{
+			Timeout: time.Duration(intOrDefault(options, "timeout", 10)) * time.Second,
+		},
+		byID: make(map[int]*registrySchema),
+	}).toUserData(s.LState))
+	return 1
+}
+
+func (s *State) registryRegister(_ *lua.LState) int {
+	registry, err := getRegistryParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: registry:register: %v", err)
+	}
+	subject, err := getStringParam(s.LState, "subject", 2)
+	if err != nil {
+		return s.CancelErr("error: registry:register: %v", err)
+	}
+	schema, err := getStringParam(s.LState, "schema", 3)
+	if err != nil {
+		return s.CancelErr("error: registry:register: %v", err)
+	}
+	schemaType := schemaTypeAvro
+	if s.LState.Get(4) != lua.LNil {
+		schemaType, err = getStringParam(s.LState, "schema_type", 4)
+		if err != nil {
+			return s.CancelErr("error: registry:register: %v", err)
+		}
+	}
+	body, err := json.Marshal(registrySchema{
+		Schema:     schema,
+		SchemaType: strings.ToUpper(schemaType),
+	})
+	if err != nil {
+		return s.CancelErr("error: registry:register: %v", err)
+	}
+	var resp struct {
+		ID int `json:"id"`
+	}
+	path := fmt.Sprintf("/subjects/%s/versions", url.PathEscape(subject))
+	if err = registry.do(s.ctx, http.MethodPost, path, body, &resp); err != nil {
+		return s.CancelErr("error: registry:register: %v", err)
+	}
+	s.LState.Push(lua.LNumber(resp.ID))
+	return 1
+}
+
+func (s *State) registryDecode(_ *lua.LState) int {
+	registry, err := getRegistryParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: registry:decode: %v", err)
+	}
+	payload, err := getStringParam(s.LState, "data", 2)
+	if err != nil {
+		return s.CancelErr("error: registry:decode: %v", err)
+	}
+	id, body, err := unframeMessage([]byte(payload))
+	if err != nil {
+		return s.CancelErr("error: registry:decode: %v", err)
+	}
+	schema, err := registry.schemaByID(s.ctx, id)
+	if err != nil {
+		return s.CancelErr("error: registry:decode: %v", err)
+	}
+	jsonVal, err := schema.decode(body)
+	if err != nil {
+		return s.CancelErr("error: registry:decode: schema %d: %v", id, err)
+	}
+	val, err := parseJSONString(jsonVal)
+	if err != nil {
+		return s.CancelErr("error: registry:decode: %v", err)
+	}
+	s.LState.Push(val)
+	s.LState.Push(lua.LNumber(id))
+	return 2
+}
+
+func (s *State) registryEncode(_ *lua.LState) int {
+	registry, err := getRegistryParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: registry:encode: %v", err)
+	}
+	subject, err := getStringParam(s.LState, "subject", 2)
+	if err != nil {
+		return s.CancelErr("error: registry:encode: %v", err)
+	}
+	goVal, err := lValueToGo(s.LState.Get(3))
+	if err != nil {
+		return s.CancelErr("error: registry:encode: %v", err)
+	}
+	options, err := getOptionsParam(s.LState, "options", 4)
+	if err != nil {
+		return s.CancelErr("error: registry:encode: %v", err)
+	}
+	version := "latest"
+	if v, err := getInt(options, "version"); err == nil {
+		version = fmt.Sprint(v)
+	}
+	schema, err := registry.schemaBySubject(s.ctx, subject, version)
+	if err != nil {
+		return s.CancelErr("error: registry:encode: %v", err)
+	}
+	jsonVal, err := json.Marshal(goVal)
+	if err != nil {
+		return s.CancelErr("error: registry:encode: %v", err)
+	}
+	framed, err := schema.encode(jsonVal, stringOrDefault(options, "message", ""))
+	if err != nil {
+		return s.CancelErr("error: registry:encode: schema %d: %v", schema.ID, err)
+	}
+	s.LState.Push(lua.LString(string(framed)))
+	return 1
+}
+
+func (sr *SchemaRegistry) schemaByID(ctx context.Context, id int) (*registrySchema, error) {
+	if schema, ok := sr.byID[id]; ok {
+		return schema, nil
+	}
+	schema := &registrySchema{}
+	if err := sr.do(ctx, http.MethodGet, fmt.Sprintf("/schemas/ids/%d", id), nil, schema); err != nil {
+		return nil, err
+	}
+	schema.ID = id
+	if err := sr.compile(ctx, schema); err != nil {
+		return nil, err
+	}
+	sr.byID[id] = schema
+	return schema, nil
+}
+
+func (sr *SchemaRegistry) schemaBySubject(ctx context.Context, subject, version string) (*registrySchema, error) {
+	schema := &registrySchema{}
+	path := fmt.Sprintf("/subjects/%s/versions/%s", url.PathEscape(subject), version)
+	if err := sr.do(ctx, http.MethodGet, path, nil, schema); err != nil {
+		return nil, err
+	}
+	if cached, ok := sr.byID[schema.ID]; ok {
+		return cached, nil
+	}
+	if err := sr.compile(ctx, schema); err != nil {
+		return nil, err
+	}
+	sr.byID[schema.ID] = schema
+	return schema, nil
+}
+
+// compile prepares the codec for the schema, resolving any references it imports
+func (sr *SchemaRegistry) compile(ctx context.Context, schema *registrySchema) error {
+	if schema.SchemaType == "" {
+		schema.SchemaType = schemaTypeAvro
+	}
+	switch schema.SchemaType {
+	case schemaTypeAvro:
+		if len(schema.References) > 0 {
+			return errors.New("schema references are only supported for Protobuf schemas")
+		}
+		codec, err := goavro.NewCodec(schema.Schema)
+		if err != nil {
+			return fmt.Errorf("parsing Avro schema %d: %v", schema.ID, err)
+		}
+		schema.avro = codec
+	case schemaTypeProtobuf:
+		sources := map[string]string{rootProtoFile: schema.Schema}
+		if err := sr.collectReferences(ctx, schema.References, sources); err != nil {
+			return err
+		}
+		compiler := protocompile.Compiler{
+			Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+				Accessor: protocompile.SourceAccessorFromMap(sources),
+			}),
+		}
+		files, err := compiler.Compile(ctx, rootProtoFile)
+		if err != nil {
+			return fmt.Errorf("parsing Protobuf schema %d: %v", schema.ID, err)
+		}
+		schema.proto = files[0]
+	case schemaTypeJSON:
+		sources := map[string]string{"": schema.Schema}
+		if err := sr.collectReferences(ctx, schema.References, sources); err != nil {
+			return err
+		}
+		compiler := jsonschema.NewCompiler()
+		for name, source := range sources {
+			if err := compiler.AddResource(jsonSchemaURL(name), strings.NewReader(source)); err != nil {
+				return fmt.Errorf("parsing JSON schema %d: %v", schema.ID, err)
+			}
+		}
+		compiled, err := compiler.Compile(jsonSchemaURL(""))
+		if err != nil {
+			return fmt.Errorf("parsing JSON schema %d: %v", schema.ID, err)
+		}
+		schema.jsonSchema = compiled
+	default:
+		return fmt.Errorf("unsupported schema type '%s'", schema.SchemaType)
+	}
+	return nil
+}
+
+// jsonSchemaURL gives the URL a JSON schema is compiled under, named by its reference or "" for the root schema
+func jsonSchemaURL(name string) string {
+	if u, err := url.Parse(name); err == nil && u.IsAbs() {
+		return name
+	}
+	if name == "" {
+		name = "root.json"
+	}
+	return jsonSchemaBase + name
+}
+
+// validateJSON checks a plain JSON payload against the schema
+func (rs *registrySchema) validateJSON(body []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	if err := rs.jsonSchema.Validate(v); err != nil {
+		return fmt.Errorf("payload doesn't match JSON schema %d: %v", rs.ID, err)
+	}
+	return nil
+}
+
+func (sr *SchemaRegistry) collectReferences(ctx context.Context, refs []schemaReference, sources map[string]string) error {
+	for _, ref := range refs {
+		if _, ok := sources[ref.Name]; ok {
+			continue
+		}
+		referenced := &registrySchema{}
+		path := fmt.Sprintf("/subjects/%s/versions/%d", url.PathEscape(ref.Subject), ref.Version)
+		if err := sr.do(ctx, http.MethodGet, path, nil, referenced); err != nil {
+			return fmt.Errorf("fetching reference '%s': %v", ref.Name, err)
+		}
+		sources[ref.Name] = referenced.Schema
+		if err := sr.collectReferences(ctx, referenced.References, sources); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sr *SchemaRegistry) do(ctx context.Context, method, path string, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, sr.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	}
+	if sr.Username != "" {
+		req.SetBasicAuth(sr.Username, sr.Password)
+	}
+	resp, err := sr.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var regErr struct {
+			Code    int    `json:"error_code"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(respBody, &regErr) == nil && regErr.Message != "" {
+			return fmt.Errorf("%s %s: registry error %d: %s", method, path, regErr.Code, regErr.Message)
+		}
+		return fmt.Errorf("%s %s: unexpected status %d", method, path, resp.StatusCode)
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// decode converts a payload, stripped of its framing, into JSON
+func (rs *registrySchema) decode(body []byte) (json.RawMessage, error) {
+	switch rs.SchemaType {
+	case schemaTypeAvro:
+		native, _, err := rs.avro.NativeFromBinary(body)
+		if err != nil {
+			return nil, err
+		}
+		return rs.avro.TextualFromNative(nil, native)
+	case schemaTypeProtobuf:
+		indexes, n, err := readMessageIndexes(body)
+		if err != nil {
+			return nil, err
+		}
+		md, err := messageByIndexes(rs.proto, indexes)
+		if err != nil {
+			return nil, err
+		}
+		msg := dynamicpb.NewMessage(md)
+		if err = proto.Unmarshal(body[n:], msg); err != nil {
+			return nil, err
+		}
+		return protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	default:
+		if err := rs.validateJSON(body); err != nil {
+			return nil, err
+		}
+		return body, nil
+	}
+}
+
+// encode converts a JSON value into a framed payload. For Protobuf schemas, message optionally names the message
+// type to encode, defaulting to the first in the schema.
+func (rs *registrySchema) encode(jsonVal []byte, message string) ([]byte, error) {
+	framed := make([]byte, 5, 5+len(jsonVal))
+	binary.BigEndian.PutUint32(framed[1:], uint32(rs.ID))
+	switch rs.SchemaType {
+	case schemaTypeAvro:
+		native, _, err := rs.avro.NativeFromTextual(jsonVal)
+		if err != nil {
+			return nil, err
+		}
+		return rs.avro.BinaryFromNative(framed, native)
+	case schemaTypeProtobuf:
+		indexes, md, err := messageByName(rs.proto, message)
+		if err != nil {
+			return nil, err
+		}
+		msg := dynamicpb.NewMessage(md)
+		if err = protojson.Unmarshal(jsonVal, msg); err != nil {
+			return nil, err
+		}
+		body, err := proto.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		framed = appendMessageIndexes(framed, indexes)
+		return append(framed, body...), nil
+	default:
+		if err := rs.validateJSON(jsonVal); err != nil {
+			return nil, err
+		}
+		return append(framed, jsonVal...), nil
+	}
+}
+
+// unframeMessage splits a payload in the registry wire format: a zero magic byte, then a 4-byte schema ID
+func unframeMessage(payload []byte) (int, []byte, error) {
+	if len(payload) < 5 || payload[0] != 0 {
+		return 0, nil, errors.New("payload is not in the schema registry wire format")
+	}
+	return int(binary.BigEndian.Uint32(payload[1:5])), payload[5:], nil
+}
+
+// readMessageIndexes reads the path to the Protobuf message type that follows the schema ID, returning it along
+// with the number of bytes read. A lone zero is shorthand for the first message.
+func readMessageIndexes(body []byte) ([]int, int, error) {
+	r := bytes.NewReader(body)
+	count, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading message indexes: %v", err)
+	}
+	if count == 0 {
+		return []int{0}, len(body) - r.Len(), nil
+	}
+	indexes := make([]int, 0, count)
+	for i := int64(0); i < count; i++ {
+		idx, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, 0, fmt.Errorf("reading message indexes: %v", err)
+		}
+		indexes = append(indexes, int(idx))
+	}
+	return indexes, len(body) - r.Len(), nil
+}
+
+func appendMessageIndexes(buf []byte, indexes []int) []byte {
+	if len(indexes) == 1 && indexes[0] == 0 {
+		return binary.AppendVarint(buf, 0)
+	}
+	buf = binary.AppendVarint(buf, int64(len(indexes)))
+	for _, idx := range indexes {
+		buf = binary.AppendVarint(buf, int64(idx))
+	}
+	return buf
+}
+
+func messageByIndexes(fd protoreflect.FileDescriptor, indexes []int) (protoreflect.MessageDescriptor, error) {
+	messages := fd.Messages()
+	var md protoreflect.MessageDescriptor
+	for _, idx := range indexes {
+		if idx < 0 || idx >= messages.Len() {
+			return nil, fmt.Errorf("message index %v not found in schema", indexes)
+		}
+		md = messages.Get(idx)
+		messages = md.Messages()
+	}
+	if md == nil {
+		return nil, errors.New("schema holds no messages")
+	}
+	return md, nil
+}
+
+// messageByName finds a message by its name, fully-qualified or relative to the schema's package, returning its
+// index path. An empty name selects the first message.
+func messageByName(fd protoreflect.FileDescriptor, name string) ([]int, protoreflect.MessageDescriptor, error) {
+	if name == "" {
+		md, err := messageByIndexes(fd, []int{0})
+		return []int{0}, md, err
+	}
+	var search func(messages protoreflect.MessageDescriptors, path []int) ([]int, protoreflect.MessageDescriptor)
+	search = func(messages protoreflect.MessageDescriptors, path []int) ([]int, protoreflect.MessageDescriptor) {
+		for i := 0; i < messages.Len(); i++ {
+			md := messages.Get(i)
+			current := append(append([]int{}, path...), i)
+			fullName := string(md.FullName())
+			if fullName == name || strings.TrimPrefix(fullName, string(fd.Package())+".") == name {
+				return current, md
+			}
+			if found, foundMD := search(md.Messages(), current); found != nil {
+				return found, foundMD
+			}
+		}
+		return nil, nil
+	}
+	indexes, md := search(fd.Messages(), nil)
+	if md == nil {
+		return nil, nil, fmt.Errorf("message '%s' not found in schema", name)
+	}
+	return indexes, md, nil
+}
+
+func getRegistryParam(L *lua.LState, i int) (*SchemaRegistry, error) {
+	v := L.Get(i)
+	ud, ok := v.(*lua.LUserData)
+	if !ok {
+		return nil, fmt.Errorf("error: getRegistryParam: expected user data type for 'registry', got: '%s'", v.Type().String())
+	}
+	if v, ok := ud.Value.(*SchemaRegistry); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("error: getRegistryParam: expected 'SchemaRegistry' for 'registry', got: '%s'", reflect.TypeOf(ud.Value).String())
+}
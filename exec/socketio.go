@@ -0,0 +1,607 @@
+package exec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobwas/ws"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	luaSocketIOClientTypeName = "socketioclient"
+)
+
+// Engine.IO packet types, the first character of each WebSocket message
+const (
+	eioOpen    = '0'
+	eioClose   = '1'
+	eioPing    = '2'
+	eioPong    = '3'
+	eioMessage = '4'
+)
+
+// Socket.IO packet types, carried in Engine.IO messages
+const (
+	sioConnect byte = iota
+	sioDisconnect
+	sioEvent
+	sioAck
+	sioConnectError
+	sioBinaryEvent
+	sioBinaryAck
+)
+
+// SocketIOClient speaks Socket.IO v5 (Engine.IO v4) over the WebSocket transport, joined to a single namespace
+type SocketIOClient struct {
+	ws        *WSClient
+	namespace string
+	auth      json.RawMessage
+	timeout   time.Duration
+	connected chan error
+	// events holds events without a registered handler, to be taken by receive
+	events   chan sioEventMessage
+	lock     sync.Mutex
+	handlers map[string]*lua.LFunction
+	acks     map[int]chan []json.RawMessage
+	nextAck  int
+}
+
+type sioEventMessage struct {
+	name  string
+	args  []json.RawMessage
+	ackID int
+}
+
+type sioPacket struct {
+	Type      byte
+	Namespace string
+	// AckID is -1 where the packet carries no acknowledgement ID
+	AckID int
+	Data  json.RawMessage
+}
+
+func (sc *SocketIOClient) toUserData(L *lua.LState) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = sc
+	L.SetMetatable(ud, L.GetTypeMetatable(luaSocketIOClientTypeName))
+	return ud
+}
+
+func (s *State) registerSocketIOModule(L *lua.LState) {
+	L.PreloadModule("sqump_socketio", func(l *lua.LState) int {
+		// Register client type
+		{
+			clientMT := L.NewTypeMetatable(luaSocketIOClientTypeName)
+			L.SetGlobal(luaSocketIOClientTypeName, clientMT)
+			L.SetField(clientMT, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+				"emit":          s.sioEmit,
+				"emit_with_ack": s.sioEmitWithAck,
+				"on":            s.sioOn,
+				"receive":       s.sioReceive,
+				"ack":           s.sioAck,
+				"close":         s.sioClose,
+			}))
+		}
+
+		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"connect": s.sioConnect,
+		})
+		L.Push(mod)
+		return 1
+	})
+}
+
+func (s *State) sioConnect(_ *lua.LState) int {
+	urlStr, err := getStringParam(s.LState, "url", 1)
+	if err != nil {
+		return s.CancelErr("error: connect: %v", err)
+	}
+	options, err := getOptionsParam(s.LState, "options", 2)
+	if err != nil {
+		return s.CancelErr("error: connect: %v", err)
+	}
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return s.CancelErr("error: connect: %v", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	u.Path = stringOrDefault(options, "path", "/socket.io/")
+	query, err := getStringMap(options, "query")
+	if err != nil {
+		return s.CancelErr("error: connect: %v", err)
+	}
+	q := u.Query()
+	for k, v := range query {
+		q.Set(k, v)
+	}
+	q.Set("EIO", "4")
+	q.Set("transport", "websocket")
+	u.RawQuery = q.Encode()
+
+	client := &SocketIOClient{
+		namespace: stringOrDefault(options, "namespace", "/"),
+		timeout:   time.Duration(intOrDefault(options, "timeout", 10)) * time.Second,
+		connected: make(chan error, 1),
+		events:    make(chan sioEventMessage, 256),
+		handlers:  make(map[string]*lua.LFunction),
+		acks:      make(map[int]chan []json.RawMessage),
+	}
+	if !strings.HasPrefix(client.namespace, "/") {
+		client.namespace = "/" + client.namespace
+	}
+	if auth := options.RawGetString("auth"); auth != lua.LNil {
+		authVal, err := lValueToGo(auth)
+		if err != nil {
+			return s.CancelErr("error: connect: auth: %v", err)
+		}
+		if client.auth, err = json.Marshal(authVal); err != nil {
+			return s.CancelErr("error: connect: auth: %v", err)
+		}
+	}
+	client.ws, err = s.dialWSClient(u.String(), options)
+	if err != nil {
+		return s.CancelErr("error: connect: %v", err)
+	}
+	client.ws.start(s, func(msg wsMessage) {
+		client.handle(s, msg)
+	})
+
+	ctx, cancel := context.WithTimeout(s.ctx, client.timeout)
+	defer cancel()
+	select {
+	case err = <-client.connected:
+	case <-client.ws.done:
+		err = fmt.Errorf("connection closed: %v", client.ws.closeErr)
+	case <-ctx.Done():
+		err = fmt.Errorf("joining namespace '%s': %v", client.namespace, ctx.Err())
+	}
+	if err != nil {
+		_ = client.ws.Close()
+		return s.CancelErr("error: connect: %v", err)
+	}
+	s.LState.Push(client.toUserData(s.LState))
+	return 1
+}
+
+// handle runs on the WebSocket read loop, answering Engine.IO pings and routing packets for the client's namespace
+func (sc *SocketIOClient) handle(s *State, msg wsMessage) {
+	if msg.binary || len(msg.data) == 0 {
+		// Binary attachments are not supported
+		return
+	}
+	payload := string(msg.data[1:])
+	switch msg.data[0] {
+	case eioOpen:
+		// The handshake is done, so join the namespace
+		err := sc.send(sioPacket{Type: sioConnect, Namespace: sc.namespace, AckID: -1, Data: sc.auth})
+		if err != nil {
+			sc.signalConnected(err)
+		}
+	case eioPing:
+		_ = sc.ws.write(ws.OpText, []byte(string(eioPong)+payload))
+	case eioClose:
+		_ = sc.ws.Close()
+	case eioMessage:
+		p, err := parseSIOPacket(payload)
+		if err != nil || p.Namespace != sc.namespace {
+			return
+		}
+		sc.handlePacket(s, p)
+	}
+}
+
+func (sc *SocketIOClient) handlePacket(s *State, p sioPacket) {
+	switch p.Type {
+	case sioConnect:
+		sc.signalConnected(nil)
+	case sioConnectError:
+		var body struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(p.Data, &body); err != nil || body.Message == "" {
+			body.Message = string(p.Data)
+		}
+		sc.signalConnected(fmt.Errorf("server refused connection to namespace '%s': %s", sc.namespace, body.Message))
+	case sioDisconnect:
+		_ = sc.ws.Close()
+	case sioEvent:
+		var data []json.RawMessage
+		if err := json.Unmarshal(p.Data, &data); err != nil || len(data) == 0 {
+			return
+		}
+		ev := sioEventMessage{args: data[1:], ackID: p.AckID}
+		if err := json.Unmarshal(data[0], &ev.name); err != nil {
+			return
+		}
+		sc.lock.Lock()
+		cb := sc.handlers[ev.name]
+		sc.lock.Unlock()
+		if cb != nil {
+			s.dispatch(func() {
+				sc.callHandler(s, cb, ev)
+			})
+			return
+		}
+		select {
+		case sc.events <- ev:
+		case <-s.ctx.Done():
+		}
+	case sioAck:
+		var args []json.RawMessage
+		_ = json.Unmarshal(p.Data, &args)
+		sc.lock.Lock()
+		ch, ok := sc.acks[p.AckID]
+		delete(sc.acks, p.AckID)
+		sc.lock.Unlock()
+		if ok {
+			ch <- args
+		}
+	}
+}
+
+func (sc *SocketIOClient) signalConnected(err error) {
+	select {
+	case sc.connected <- err:
+	default:
+	}
+}
+
+// callHandler runs an event handler on the script's thread, acknowledging the event with its return values if the
+// server asked for it
+func (sc *SocketIOClient) callHandler(s *State, cb *lua.LFunction, ev sioEventMessage) {
+	top := s.LState.GetTop()
+	s.LState.Push(cb)
+	for _, arg := range ev.args {
+		lv, err := parseJSONString(arg)
+		if err != nil {
+			s.LState.SetTop(top)
+			_ = s.CancelErr("error: on: '%s' handler: %v", ev.name, err)
+			return
+		}
+		s.LState.Push(lv)
+	}
+	if err := s.LState.PCall(len(ev.args), lua.MultRet, nil); err != nil {
+		_ = s.CancelErr("error: on: '%s' handler: %v", ev.name, err)
+		return
+	}
+	returned := make([]lua.LValue, 0, s.LState.GetTop()-top)
+	for i := top + 1; i <= s.LState.GetTop(); i++ {
+		returned = append(returned, s.LState.Get(i))
+	}
+	s.LState.SetTop(top)
+	if ev.ackID < 0 {
+		return
+	}
+	if err := sc.sendAck(ev.ackID, returned); err != nil {
+		_ = s.CancelErr("error: on: '%s' handler: acknowledging: %v", ev.name, err)
+	}
+}
+
+func (sc *SocketIOClient) send(p sioPacket) error {
+	return sc.ws.write(ws.OpText, []byte(string(eioMessage)+p.encode()))
+}
+
+func (sc *SocketIOClient) sendEvent(name string, args []lua.LValue, ackID int) error {
+	data, err := encodeSIOArgs(append([]lua.LValue{lua.LString(name)}, args...))
+	if err != nil {
+		return err
+	}
+	return sc.send(sioPacket{Type: sioEvent, Namespace: sc.namespace, AckID: ackID, Data: data})
+}
+
+func (sc *SocketIOClient) sendAck(ackID int, args []lua.LValue) error {
+	data, err := encodeSIOArgs(args)
+	if err != nil {
+		return err
+	}
+	return sc.send(sioPacket{Type: sioAck, Namespace: sc.namespace, AckID: ackID, Data: data})
+}
+
+func (s *State) sioEmit(_ *lua.LState) int {
+	client, err := getSocketIOClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: emit: %v", err)
+	}
+	event, err := getStringParam(s.LState, "event", 2)
+	if err != nil {
+		return s.CancelErr("error: emit: %v", err)
+	}
+	args := make([]lua.LValue, 0, s.LState.GetTop())
+	for i := 3; i <= s.LState.GetTop(); i++ {
+		args = append(args, s.LState.Get(i))
+	}
+	if err = client.sendEvent(event, args, -1); err != nil {
+		return s.CancelErr("error: emit: %v", err)
+	}
+	return 0
+}
+
+func (s *State) sioEmitWithAck(_ *lua.LState) int {
+	client, err := getSocketIOClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: emit_with_ack: %v", err)
+	}
+	event, err := getStringParam(s.LState, "event", 2)
+	if err != nil {
+		return s.CancelErr("error: emit_with_ack: %v", err)
+	}
+	var args []lua.LValue
+	switch argsVal := s.LState.Get(3).(type) {
+	case *lua.LTable:
+		for i := 1; i <= argsVal.Len(); i++ {
+			args = append(args, argsVal.RawGetInt(i))
+		}
+	case *lua.LNilType:
+	default:
+		return s.CancelErr("error: emit_with_ack: expected 'args' parameter to be an array or nil, instead got: %s", argsVal.Type().String())
+	}
+	timeout, err := getIntParam(s.LState, "timeout", 4)
+	if err != nil {
+		return s.CancelErr("error: emit_with_ack: %v", err)
+	}
+	failOnTimeout, err := getBoolParam(s.LState, "fail_on_timeout", 5)
+	if err != nil {
+		return s.CancelErr("error: emit_with_ack: %v", err)
+	}
+
+	reply := make(chan []json.RawMessage, 1)
+	client.lock.Lock()
+	ackID := client.nextAck
+	client.nextAck++
+	client.acks[ackID] = reply
+	client.lock.Unlock()
+	defer func() {
+		client.lock.Lock()
+		delete(client.acks, ackID)
+		client.lock.Unlock()
+	}()
+	if err = client.sendEvent(event, args, ackID); err != nil {
+		return s.CancelErr("error: emit_with_ack: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	select {
+	case ackArgs := <-reply:
+		ret := &lua.LTable{}
+		for _, arg := range ackArgs {
+			lv, err := parseJSONString(arg)
+			if err != nil {
+				return s.CancelErr("error: emit_with_ack: %v", err)
+			}
+			ret.Append(lv)
+		}
+		s.LState.Push(ret)
+		return 1
+	case <-client.ws.done:
+		return s.CancelErr("error: emit_with_ack: connection closed: %v", client.ws.closeErr)
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && !failOnTimeout {
+			// Do nothing, we mean not to fail in this case (easiest to represent this way)
+			s.LState.Push(lua.LNil)
+			return 1
+		}
+		return s.CancelErr("error: emit_with_ack: %v", ctx.Err())
+	}
+}
+
+func (s *State) sioOn(_ *lua.LState) int {
+	client, err := getSocketIOClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: on: %v", err)
+	}
+	event, err := getStringParam(s.LState, "event", 2)
+	if err != nil {
+		return s.CancelErr("error: on: %v", err)
+	}
+	cb, err := getFuncParam(s.LState, "cb", 3)
+	if err != nil {
+		return s.CancelErr("error: on: %v", err)
+	}
+	client.lock.Lock()
+	client.handlers[event] = cb
+	client.lock.Unlock()
+	// Queued events of this name are handed to the new handler, in order, and the rest put back
+	var pending []sioEventMessage
+drain:
+	for {
+		select {
+		case ev := <-client.events:
+			pending = append(pending, ev)
+		default:
+			break drain
+		}
+	}
+	for _, ev := range pending {
+		if ev.name == event {
+			ev := ev
+			s.dispatch(func() {
+				client.callHandler(s, cb, ev)
+			})
+			continue
+		}
+		select {
+		case client.events <- ev:
+		default:
+		}
+	}
+	return 0
+}
+
+func (s *State) sioReceive(_ *lua.LState) int {
+	client, err := getSocketIOClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: receive: %v", err)
+	}
+	timeout, err := getIntParam(s.LState, "timeout", 2)
+	if err != nil {
+		return s.CancelErr("error: receive: %v", err)
+	}
+	failOnTimeout, err := getBoolParam(s.LState, "fail_on_timeout", 3)
+	if err != nil {
+		return s.CancelErr("error: receive: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	var ev sioEventMessage
+	select {
+	case ev = <-client.events:
+	case <-client.ws.done:
+		// Hand over anything that arrived before the connection closed
+		select {
+		case ev = <-client.events:
+		default:
+			if failOnTimeout {
+				return s.CancelErr("error: receive: connection closed: %v", client.ws.closeErr)
+			}
+			s.LState.Push(lua.LNil)
+			return 1
+		}
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && !failOnTimeout {
+			// Do nothing, we mean not to fail in this case (easiest to represent this way)
+			s.LState.Push(lua.LNil)
+			return 1
+		}
+		return s.CancelErr("error: receive: %v", ctx.Err())
+	}
+	ret, err := sioEventToTable(ev)
+	if err != nil {
+		return s.CancelErr("error: receive: %v", err)
+	}
+	s.LState.Push(ret)
+	return 1
+}
+
+func (s *State) sioAck(_ *lua.LState) int {
+	client, err := getSocketIOClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: ack: %v", err)
+	}
+	event, ok := s.LState.Get(2).(*lua.LTable)
+	if !ok {
+		return s.CancelErr("error: ack: expected 'event' parameter to be a table, instead got: %s", s.LState.Get(2).Type().String())
+	}
+	ackID, ok := event.RawGetString("ack_id").(lua.LNumber)
+	if !ok {
+		return s.CancelErr("error: ack: the server did not ask for event '%s' to be acknowledged", event.RawGetString("event").String())
+	}
+	args := make([]lua.LValue, 0, s.LState.GetTop())
+	for i := 3; i <= s.LState.GetTop(); i++ {
+		args = append(args, s.LState.Get(i))
+	}
+	if err = client.sendAck(int(ackID), args); err != nil {
+		return s.CancelErr("error: ack: %v", err)
+	}
+	return 0
+}
+
+func (s *State) sioClose(_ *lua.LState) int {
+	client, err := getSocketIOClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: close: %v", err)
+	}
+	// Leave the namespace politely before closing the transport, ignoring failures on an already closed connection
+	_ = client.send(sioPacket{Type: sioDisconnect, Namespace: client.namespace, AckID: -1})
+	if err = client.ws.shutdown(ws.StatusNormalClosure, ""); err != nil {
+		return s.CancelErr("error: close: %v", err)
+	}
+	return 0
+}
+
+func (p sioPacket) encode() string {
+	var b strings.Builder
+	b.WriteByte('0' + p.Type)
+	if p.Namespace != "/" && p.Namespace != "" {
+		b.WriteString(p.Namespace)
+		b.WriteByte(',')
+	}
+	if p.AckID >= 0 {
+		b.WriteString(strconv.Itoa(p.AckID))
+	}
+	b.Write(p.Data)
+	return b.String()
+}
+
+// parseSIOPacket reads `<type>[<namespace>,][<ack id>][<JSON data>]`, the encoding of all non-binary packets
+func parseSIOPacket(raw string) (sioPacket, error) {
+	if raw == "" {
+		return sioPacket{}, errors.New("empty packet")
+	}
+	p := sioPacket{Type: raw[0] - '0', Namespace: "/", AckID: -1}
+	switch {
+	case p.Type > sioBinaryAck:
+		return sioPacket{}, fmt.Errorf("unknown packet type '%c'", raw[0])
+	case p.Type == sioBinaryEvent || p.Type == sioBinaryAck:
+		return sioPacket{}, errors.New("binary packets are not supported")
+	}
+	rest := raw[1:]
+	if strings.HasPrefix(rest, "/") {
+		p.Namespace, rest, _ = strings.Cut(rest, ",")
+	}
+	i := 0
+	for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+		i++
+	}
+	if i > 0 {
+		p.AckID, _ = strconv.Atoi(rest[:i])
+	}
+	p.Data = json.RawMessage(rest[i:])
+	return p, nil
+}
+
+func encodeSIOArgs(args []lua.LValue) (json.RawMessage, error) {
+	goArgs := make([]any, 0, len(args))
+	for _, arg := range args {
+		v, err := lValueToGo(arg)
+		if err != nil {
+			return nil, err
+		}
+		goArgs = append(goArgs, v)
+	}
+	return json.Marshal(goArgs)
+}
+
+func sioEventToTable(ev sioEventMessage) (*lua.LTable, error) {
+	ret := &lua.LTable{}
+	ret.RawSetString("event", lua.LString(ev.name))
+	args := &lua.LTable{}
+	for _, arg := range ev.args {
+		lv, err := parseJSONString(arg)
+		if err != nil {
+			return nil, err
+		}
+		args.Append(lv)
+	}
+	ret.RawSetString("args", args)
+	if ev.ackID >= 0 {
+		ret.RawSetString("ack_id", lua.LNumber(ev.ackID))
+	}
+	return ret, nil
+}
+
+func getSocketIOClientParam(L *lua.LState, i int) (*SocketIOClient, error) {
+	v := L.Get(i)
+	ud, ok := v.(*lua.LUserData)
+	if !ok {
+		return nil, fmt.Errorf("error: getSocketIOClientParam: expected user data type for 'socketioclient', got: '%s'", v.Type().String())
+	}
+	if v, ok := ud.Value.(*SocketIOClient); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("error: getSocketIOClientParam: expected 'SocketIOClient' for 'socketioclient', got: '%s'", reflect.TypeOf(ud.Value).String())
+}
@@ -0,0 +1,556 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobwas/ws"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	luaSTOMPClientTypeName = "stompclient"
+)
+
+// STOMPClient speaks STOMP 1.2 over a WebSocket, with one frame per message
+type STOMPClient struct {
+	ws        *WSClient
+	timeout   time.Duration
+	connected chan error
+	// messages holds MESSAGE frames for subscriptions made without a callback, to be taken by read_message
+	messages  chan stompFrame
+	lock      sync.Mutex
+	subs      map[string]*lua.LFunction
+	receipts  map[string]chan struct{}
+	nextID    int
+	serverErr error
+}
+
+type stompFrame struct {
+	Command string
+	Headers map[string]string
+	Body    string
+}
+
+func (sc *STOMPClient) toUserData(L *lua.LState) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = sc
+	L.SetMetatable(ud, L.GetTypeMetatable(luaSTOMPClientTypeName))
+	return ud
+}
+
+func (s *State) registerSTOMPModule(L *lua.LState) {
+	L.PreloadModule("sqump_stomp", func(l *lua.LState) int {
+		// Register client type
+		{
+			clientMT := L.NewTypeMetatable(luaSTOMPClientTypeName)
+			L.SetGlobal(luaSTOMPClientTypeName, clientMT)
+			L.SetField(clientMT, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+				"send":         s.stompSend,
+				"subscribe":    s.stompSubscribe,
+				"unsubscribe":  s.stompUnsubscribe,
+				"read_message": s.stompReadMessage,
+				"ack":          s.stompAck,
+				"nack":         s.stompNack,
+				"close":        s.stompClose,
+			}))
+		}
+
+		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"connect": s.stompConnect,
+		})
+		L.Push(mod)
+		return 1
+	})
+}
+
+func (s *State) stompConnect(_ *lua.LState) int {
+	urlStr, err := getStringParam(s.LState, "url", 1)
+	if err != nil {
+		return s.CancelErr("error: connect: %v", err)
+	}
+	options, err := getOptionsParam(s.LState, "options", 2)
+	if err != nil {
+		return s.CancelErr("error: connect: %v", err)
+	}
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return s.CancelErr("error: connect: %v", err)
+	}
+	connectHeaders, err := getStringMap(options, "connect_headers")
+	if err != nil {
+		return s.CancelErr("error: connect: %v", err)
+	}
+	if connectHeaders == nil {
+		connectHeaders = make(map[string]string)
+	}
+	connectHeaders["accept-version"] = "1.2"
+	connectHeaders["host"] = stringOrDefault(options, "host", u.Hostname())
+	// Heart-beating is left off, as nothing would keep it going while the script is busy
+	connectHeaders["heart-beat"] = "0,0"
+	if login := stringOrDefault(options, "login", ""); login != "" {
+		connectHeaders["login"] = login
+		connectHeaders["passcode"] = stringOrDefault(options, "passcode", "")
+	}
+
+	wsOptions := &lua.LTable{}
+	options.ForEach(func(k, v lua.LValue) {
+		wsOptions.RawSet(k, v)
+	})
+	if wsOptions.RawGetString("subprotocols") == lua.LNil {
+		wsOptions.RawSetString("subprotocols", sliceToLuaArray([]string{"v12.stomp"}))
+	}
+
+	client := &STOMPClient{
+		timeout:   time.Duration(intOrDefault(options, "timeout", 10)) * time.Second,
+		connected: make(chan error, 1),
+		messages:  make(chan stompFrame, 256),
+		subs:      make(map[string]*lua.LFunction),
+		receipts:  make(map[string]chan struct{}),
+	}
+	client.ws, err = s.dialWSClient(urlStr, wsOptions)
+	if err != nil {
+		return s.CancelErr("error: connect: %v", err)
+	}
+	client.ws.start(s, func(msg wsMessage) {
+		client.handle(s, msg)
+	})
+	if err = client.send(stompFrame{Command: "CONNECT", Headers: connectHeaders}); err != nil {
+		_ = client.ws.Close()
+		return s.CancelErr("error: connect: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, client.timeout)
+	defer cancel()
+	select {
+	case err = <-client.connected:
+	case <-client.ws.done:
+		err = client.closedErr()
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = client.ws.Close()
+		return s.CancelErr("error: connect: %v", err)
+	}
+	s.LState.Push(client.toUserData(s.LState))
+	return 1
+}
+
+// handle runs on the WebSocket read loop, routing each frame from the server
+func (sc *STOMPClient) handle(s *State, msg wsMessage) {
+	frames, err := parseSTOMPFrames(msg.data)
+	if err != nil {
+		return
+	}
+	for _, f := range frames {
+		switch f.Command {
+		case "CONNECTED":
+			sc.signalConnected(nil)
+		case "ERROR":
+			err := fmt.Errorf("server error: %s", f.Headers["message"])
+			if f.Body != "" {
+				err = fmt.Errorf("%v: %s", err, f.Body)
+			}
+			sc.lock.Lock()
+			sc.serverErr = err
+			sc.lock.Unlock()
+			sc.signalConnected(err)
+		case "RECEIPT":
+			sc.lock.Lock()
+			ch, ok := sc.receipts[f.Headers["receipt-id"]]
+			delete(sc.receipts, f.Headers["receipt-id"])
+			sc.lock.Unlock()
+			if ok {
+				close(ch)
+			}
+		case "MESSAGE":
+			sc.lock.Lock()
+			cb := sc.subs[f.Headers["subscription"]]
+			sc.lock.Unlock()
+			if cb != nil {
+				f := f
+				s.dispatch(func() {
+					s.LState.Push(cb)
+					s.LState.Push(stompMessageToTable(f))
+					if err := s.LState.PCall(1, 0, nil); err != nil {
+						_ = s.CancelErr("error: subscribe: callback: %v", err)
+					}
+				})
+				continue
+			}
+			select {
+			case sc.messages <- f:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (sc *STOMPClient) signalConnected(err error) {
+	select {
+	case sc.connected <- err:
+	default:
+	}
+}
+
+// closedErr explains a closed connection, preferring any ERROR frame the server sent before hanging up
+func (sc *STOMPClient) closedErr() error {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	if sc.serverErr != nil {
+		return sc.serverErr
+	}
+	return fmt.Errorf("connection closed: %v", sc.ws.closeErr)
+}
+
+func (sc *STOMPClient) send(f stompFrame) error {
+	return sc.ws.write(ws.OpText, f.encode())
+}
+
+// sendWithReceipt sends the frame and waits for the server to confirm it was processed
+func (sc *STOMPClient) sendWithReceipt(ctx context.Context, f stompFrame) error {
+	done := make(chan struct{})
+	sc.lock.Lock()
+	id := "receipt-" + strconv.Itoa(sc.nextID)
+	sc.nextID++
+	sc.receipts[id] = done
+	sc.lock.Unlock()
+	defer func() {
+		sc.lock.Lock()
+		delete(sc.receipts, id)
+		sc.lock.Unlock()
+	}()
+	if f.Headers == nil {
+		f.Headers = make(map[string]string)
+	}
+	f.Headers["receipt"] = id
+	if err := sc.send(f); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, sc.timeout)
+	defer cancel()
+	select {
+	case <-done:
+		return nil
+	case <-sc.ws.done:
+		return sc.closedErr()
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for receipt: %v", ctx.Err())
+	}
+}
+
+func (s *State) stompSend(_ *lua.LState) int {
+	client, err := getSTOMPClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: send: %v", err)
+	}
+	destination, err := getStringParam(s.LState, "destination", 2)
+	if err != nil {
+		return s.CancelErr("error: send: %v", err)
+	}
+	body, err := getStringParam(s.LState, "body", 3)
+	if err != nil {
+		return s.CancelErr("error: send: %v", err)
+	}
+	options, err := getOptionsParam(s.LState, "options", 4)
+	if err != nil {
+		return s.CancelErr("error: send: %v", err)
+	}
+	headers, err := getStringMap(options, "headers")
+	if err != nil {
+		return s.CancelErr("error: send: %v", err)
+	}
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	headers["destination"] = destination
+	if contentType := stringOrDefault(options, "content_type", ""); contentType != "" {
+		headers["content-type"] = contentType
+	}
+	frame := stompFrame{Command: "SEND", Headers: headers, Body: body}
+	if boolOrDefault(options, "receipt", false) {
+		err = client.sendWithReceipt(s.ctx, frame)
+	} else {
+		err = client.send(frame)
+	}
+	if err != nil {
+		return s.CancelErr("error: send: %v", err)
+	}
+	return 0
+}
+
+func (s *State) stompSubscribe(_ *lua.LState) int {
+	client, err := getSTOMPClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: subscribe: %v", err)
+	}
+	destination, err := getStringParam(s.LState, "destination", 2)
+	if err != nil {
+		return s.CancelErr("error: subscribe: %v", err)
+	}
+	options, err := getOptionsParam(s.LState, "options", 3)
+	if err != nil {
+		return s.CancelErr("error: subscribe: %v", err)
+	}
+	var cb *lua.LFunction
+	switch cbVal := s.LState.Get(4).(type) {
+	case *lua.LFunction:
+		cb = cbVal
+	case *lua.LNilType:
+	default:
+		return s.CancelErr("error: subscribe: expected 'cb' parameter to be func or nil, instead got: %s", cbVal.Type().String())
+	}
+	headers, err := getStringMap(options, "headers")
+	if err != nil {
+		return s.CancelErr("error: subscribe: %v", err)
+	}
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	client.lock.Lock()
+	id := stringOrDefault(options, "id", "sub-"+strconv.Itoa(client.nextID))
+	client.nextID++
+	client.subs[id] = cb
+	client.lock.Unlock()
+	headers["id"] = id
+	headers["destination"] = destination
+	headers["ack"] = stringOrDefault(options, "ack", "auto")
+	// Wait on a receipt so that nothing sent after subscribing can beat the subscription to the server
+	if err = client.sendWithReceipt(s.ctx, stompFrame{Command: "SUBSCRIBE", Headers: headers}); err != nil {
+		return s.CancelErr("error: subscribe: %v", err)
+	}
+	s.LState.Push(lua.LString(id))
+	return 1
+}
+
+func (s *State) stompUnsubscribe(_ *lua.LState) int {
+	client, err := getSTOMPClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: unsubscribe: %v", err)
+	}
+	id, err := getStringParam(s.LState, "id", 2)
+	if err != nil {
+		return s.CancelErr("error: unsubscribe: %v", err)
+	}
+	err = client.sendWithReceipt(s.ctx, stompFrame{Command: "UNSUBSCRIBE", Headers: map[string]string{"id": id}})
+	if err != nil {
+		return s.CancelErr("error: unsubscribe: %v", err)
+	}
+	client.lock.Lock()
+	delete(client.subs, id)
+	client.lock.Unlock()
+	return 0
+}
+
+func (s *State) stompReadMessage(_ *lua.LState) int {
+	client, err := getSTOMPClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: read_message: %v", err)
+	}
+	timeout, err := getIntParam(s.LState, "timeout", 2)
+	if err != nil {
+		return s.CancelErr("error: read_message: %v", err)
+	}
+	failOnTimeout, err := getBoolParam(s.LState, "fail_on_timeout", 3)
+	if err != nil {
+		return s.CancelErr("error: read_message: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	select {
+	case f := <-client.messages:
+		s.LState.Push(stompMessageToTable(f))
+		return 1
+	case <-client.ws.done:
+		// Hand over anything that arrived before the connection closed
+		select {
+		case f := <-client.messages:
+			s.LState.Push(stompMessageToTable(f))
+			return 1
+		default:
+		}
+		if failOnTimeout {
+			return s.CancelErr("error: read_message: %v", client.closedErr())
+		}
+		s.LState.Push(lua.LNil)
+		return 1
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && !failOnTimeout {
+			// Do nothing, we mean not to fail in this case (easiest to represent this way)
+			s.LState.Push(lua.LNil)
+			return 1
+		}
+		return s.CancelErr("error: read_message: %v", ctx.Err())
+	}
+}
+
+func (s *State) stompAck(_ *lua.LState) int {
+	return s.stompAcknowledge("ack", "ACK")
+}
+
+func (s *State) stompNack(_ *lua.LState) int {
+	return s.stompAcknowledge("nack", "NACK")
+}
+
+func (s *State) stompAcknowledge(fnName, command string) int {
+	client, err := getSTOMPClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: %s: %v", fnName, err)
+	}
+	msg, ok := s.LState.Get(2).(*lua.LTable)
+	if !ok {
+		return s.CancelErr("error: %s: expected 'message' parameter to be a table, instead got: %s", fnName, s.LState.Get(2).Type().String())
+	}
+	id, ok := msg.RawGetString("ack").(lua.LString)
+	if !ok {
+		return s.CancelErr("error: %s: message has no 'ack' ID, was it received on a subscription with client acknowledgement?", fnName)
+	}
+	if err = client.send(stompFrame{Command: command, Headers: map[string]string{"id": string(id)}}); err != nil {
+		return s.CancelErr("error: %s: %v", fnName, err)
+	}
+	return 0
+}
+
+func (s *State) stompClose(_ *lua.LState) int {
+	client, err := getSTOMPClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: close: %v", err)
+	}
+	select {
+	case <-client.ws.done:
+	default:
+		// A receipt for the DISCONNECT means everything sent before it was handled, though it need not come back
+		_ = client.sendWithReceipt(s.ctx, stompFrame{Command: "DISCONNECT"})
+	}
+	if err = client.ws.shutdown(ws.StatusNormalClosure, ""); err != nil {
+		return s.CancelErr("error: close: %v", err)
+	}
+	return 0
+}
+
+var (
+	stompHeaderEscaper   = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+	stompHeaderUnescaper = strings.NewReplacer("\\\\", "\\", "\\r", "\r", "\\n", "\n", "\\c", ":")
+)
+
+// stompEscapes reports whether header escaping applies, which the spec leaves out of the connection frames
+func stompEscapes(command string) bool {
+	return command != "CONNECT" && command != "CONNECTED"
+}
+
+func (f stompFrame) encode() []byte {
+	var b bytes.Buffer
+	b.WriteString(f.Command)
+	b.WriteByte('\n')
+	keys := make([]string, 0, len(f.Headers))
+	for k := range f.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		key, value := k, f.Headers[k]
+		if stompEscapes(f.Command) {
+			key, value = stompHeaderEscaper.Replace(key), stompHeaderEscaper.Replace(value)
+		}
+		b.WriteString(key)
+		b.WriteByte(':')
+		b.WriteString(value)
+		b.WriteByte('\n')
+	}
+	if f.Body != "" {
+		b.WriteString("content-length:")
+		b.WriteString(strconv.Itoa(len(f.Body)))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	b.WriteString(f.Body)
+	b.WriteByte(0)
+	return b.Bytes()
+}
+
+// parseSTOMPFrames reads every frame in a message, skipping the bare EOLs used as heart-beats
+func parseSTOMPFrames(data []byte) ([]stompFrame, error) {
+	var frames []stompFrame
+	for {
+		data = bytes.TrimLeft(data, "\r\n")
+		if len(data) == 0 {
+			return frames, nil
+		}
+		head, rest, found := bytes.Cut(data, []byte("\n\n"))
+		if crHead, crRest, crFound := bytes.Cut(data, []byte("\r\n\r\n")); crFound && (!found || len(crHead) < len(head)) {
+			head, rest, found = crHead, crRest, true
+		}
+		if !found {
+			return nil, errors.New("frame has no end to its headers")
+		}
+		lines := strings.Split(strings.ReplaceAll(string(head), "\r\n", "\n"), "\n")
+		f := stompFrame{Command: lines[0], Headers: make(map[string]string, len(lines)-1)}
+		for _, line := range lines[1:] {
+			key, value, ok := strings.Cut(line, ":")
+			if !ok {
+				return nil, fmt.Errorf("malformed header line '%s'", line)
+			}
+			if stompEscapes(f.Command) {
+				key, value = stompHeaderUnescaper.Replace(key), stompHeaderUnescaper.Replace(value)
+			}
+			// Repeated headers keep their first value
+			if _, ok := f.Headers[key]; !ok {
+				f.Headers[key] = value
+			}
+		}
+		length := bytes.IndexByte(rest, 0)
+		if cl, ok := f.Headers["content-length"]; ok {
+			n, err := strconv.Atoi(cl)
+			if err != nil || n < 0 || n >= len(rest) || rest[n] != 0 {
+				return nil, fmt.Errorf("invalid content-length '%s'", cl)
+			}
+			length = n
+		}
+		if length < 0 {
+			return nil, errors.New("frame is missing its terminating NULL")
+		}
+		f.Body = string(rest[:length])
+		frames = append(frames, f)
+		data = rest[length+1:]
+	}
+}
+
+func stompMessageToTable(f stompFrame) *lua.LTable {
+	ret := &lua.LTable{}
+	headers := &lua.LTable{}
+	for k, v := range f.Headers {
+		headers.RawSetString(k, lua.LString(v))
+	}
+	ret.RawSetString("destination", lua.LString(f.Headers["destination"]))
+	ret.RawSetString("subscription", lua.LString(f.Headers["subscription"]))
+	ret.RawSetString("message_id", lua.LString(f.Headers["message-id"]))
+	if ack, ok := f.Headers["ack"]; ok {
+		ret.RawSetString("ack", lua.LString(ack))
+	}
+	ret.RawSetString("headers", headers)
+	ret.RawSetString("body", lua.LString(f.Body))
+	return ret
+}
+
+func getSTOMPClientParam(L *lua.LState, i int) (*STOMPClient, error) {
+	v := L.Get(i)
+	ud, ok := v.(*lua.LUserData)
+	if !ok {
+		return nil, fmt.Errorf("error: getSTOMPClientParam: expected user data type for 'stompclient', got: '%s'", v.Type().String())
+	}
+	if v, ok := ud.Value.(*STOMPClient); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("error: getSTOMPClientParam: expected 'STOMPClient' for 'stompclient', got: '%s'", reflect.TypeOf(ud.Value).String())
+}
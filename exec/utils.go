@@ -1,7 +1,10 @@
 package exec
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"os"
 	"strconv"
 
 	lua "github.com/yuin/gopher-lua"
@@ -169,3 +172,92 @@ func luaArrayToSlice(val lua.LValue) ([]string, error) {
 	})
 	return strs, nil
 }
+
+func getVariadicStringParams(L *lua.LState, paramName string, startPosition int) ([]string, error) {
+	top := L.GetTop()
+	if top < startPosition {
+		return nil, fmt.Errorf("error: getVariadicStringParams: expected at least one '%s' parameter, got none", paramName)
+	}
+	ret := make([]string, 0, top-startPosition+1)
+	for i := startPosition; i <= top; i++ {
+		val, err := getStringParam(L, paramName, i)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, val)
+	}
+	return ret, nil
+}
+
+func getOptionsParam(L *lua.LState, paramName string, stackPosition int) (*lua.LTable, error) {
+	stackVal := L.Get(stackPosition)
+	switch stackVal.Type() {
+	case lua.LTTable:
+		return stackVal.(*lua.LTable), nil
+	case lua.LTNil:
+		return &lua.LTable{}, nil
+	default:
+		return nil, fmt.Errorf("error: getOptionsParam: expected '%s' parameter to be table or nil, instead got '%s'", paramName, stackVal.Type().String())
+	}
+}
+
+func boolOrDefault(table *lua.LTable, key string, defaultVal bool) bool {
+	val := table.RawGetString(key)
+	if b, ok := val.(lua.LBool); ok {
+		return bool(b)
+	}
+	return defaultVal
+}
+
+func getStringMap(table *lua.LTable, key string) (map[string]string, error) {
+	ret := make(map[string]string)
+	innerTable := table.RawGetString(key)
+	switch innerTable.Type() {
+	case lua.LTTable:
+		var err error
+		innerTable.(*lua.LTable).ForEach(func(k, v lua.LValue) {
+			if k.Type() != lua.LTString {
+				err = fmt.Errorf("expected string keys in '%s', got: '%s'", key, k.Type().String())
+				return
+			}
+			ret[k.String()] = v.String()
+		})
+		if err != nil {
+			return nil, err
+		}
+	case lua.LTNil:
+		// this is fine, default to doing nothing
+	default:
+		return nil, fmt.Errorf("unexpected value found for '%s' table slot. value: %v", key, innerTable.Type())
+	}
+	return ret, nil
+}
+
+// tlsConfigFromTable builds a client TLS configuration from a Lua options table, shaped as:
+// { ca_file = string, cert_file = string, key_file = string, server_name = string, insecure_skip_verify = boolean }
+func tlsConfigFromTable(table *lua.LTable) (*tls.Config, error) {
+	conf := &tls.Config{
+		ServerName:         stringOrDefault(table, "server_name", ""),
+		InsecureSkipVerify: boolOrDefault(table, "insecure_skip_verify", false),
+	}
+	if caFile := stringOrDefault(table, "ca_file", ""); caFile != "" {
+		b, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in CA file '%s'", caFile)
+		}
+		conf.RootCAs = pool
+	}
+	certFile, keyFile := stringOrDefault(table, "cert_file", ""), stringOrDefault(table, "key_file", "")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %v", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
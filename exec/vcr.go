@@ -0,0 +1,277 @@
+package exec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Environment keys configuring record and replay of `fetch`
+const (
+	VCRModeKey   = "vcr_mode"
+	VCRMatchKey  = "vcr_match"
+	VCRRedactKey = "vcr_redact"
+)
+
+const (
+	vcrRecord = "record"
+	vcrReplay = "replay"
+)
+
+var defaultVCRMatch = []string{"method", "url"}
+
+// defaultVCRRedact are the headers whose values are kept out of cassettes, along with any named by VCRRedactKey
+var defaultVCRRedact = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+const redactedValue = "[redacted]"
+
+// Cassette holds the fetches made by one request's script, in the order they were made
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Request    RecordedRequest  `json:"request"`
+	Response   RecordedResponse `json:"response"`
+	RecordedAt time.Time        `json:"recorded_at"`
+}
+
+type RecordedRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+}
+
+type RecordedResponse struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+}
+
+// vcr records or replays the fetches of a single script run
+type vcr struct {
+	mode     string
+	path     string
+	match    []string
+	redact   []string
+	cassette *Cassette
+	// used marks the interactions already replayed, so repeated requests are answered in recorded order
+	used []bool
+}
+
+// CassettePath is where the fetches of the identified request are recorded, beside its collection
+func CassettePath(ident Identifier) string {
+	return filepath.Join(filepath.Dir(ident.Path), "cassettes", ident.Collection, ident.Request+".json")
+}
+
+// getVCR sets up recording or replay on the first fetch, per the environment, returning nil if neither is enabled
+func (s *State) getVCR() (*vcr, error) {
+	if s.vcr != nil {
+		return s.vcr, nil
+	}
+	mode := strings.ToLower(s.environment[VCRModeKey])
+	if mode == "" || mode == "off" {
+		return nil, nil
+	}
+	v := &vcr{
+		mode:   mode,
+		path:   CassettePath(s.currentIdent),
+		match:  defaultVCRMatch,
+		redact: defaultVCRRedact,
+	}
+	for _, name := range strings.Split(s.environment[VCRRedactKey], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			v.redact = append(v.redact, name)
+		}
+	}
+	if match := s.environment[VCRMatchKey]; match != "" {
+		v.match = strings.Split(match, ",")
+		for i, m := range v.match {
+			v.match[i] = strings.TrimSpace(m)
+			if err := validateVCRMatcher(v.match[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	switch mode {
+	case vcrRecord:
+		// Each run records afresh
+		v.cassette = &Cassette{Interactions: []Interaction{}}
+	case vcrReplay:
+		b, err := os.ReadFile(v.path)
+		if err != nil {
+			return nil, fmt.Errorf("reading cassette to replay: %w", err)
+		}
+		var c Cassette
+		if err = json.Unmarshal(b, &c); err != nil {
+			return nil, fmt.Errorf("reading cassette '%s': %w", v.path, err)
+		}
+		v.cassette = &c
+		v.used = make([]bool, len(c.Interactions))
+	default:
+		return nil, fmt.Errorf("unrecognized %s '%s', expected 'record', 'replay', or 'off'", VCRModeKey, mode)
+	}
+	s.vcr = v
+	return v, nil
+}
+
+func validateVCRMatcher(m string) error {
+	switch {
+	case m == "method", m == "url", m == "body", m == "headers":
+		return nil
+	case strings.HasPrefix(m, "header:") && len(m) > len("header:"):
+		return nil
+	default:
+		return fmt.Errorf("unrecognized %s entry '%s', expected 'method', 'url', 'body', 'headers', or 'header:<name>'", VCRMatchKey, m)
+	}
+}
+
+// roundTrip performs the request, or answers it from the cassette when replaying
+func (s *State) roundTrip(req *http.Request, body string, timeout time.Duration) (*RecordedResponse, error) {
+	v, err := s.getVCR()
+	if err != nil {
+		return nil, err
+	}
+	recReq := RecordedRequest{
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Headers: req.Header,
+		Body:    body,
+	}
+	if v != nil {
+		// Redacted alike when replaying, so that a redacted header still matches on being present
+		recReq.Headers = v.redactHeaders(recReq.Headers)
+		if v.mode == vcrReplay {
+			return v.replay(recReq)
+		}
+	}
+
+	resp, err := (&http.Client{
+		Timeout: timeout,
+	}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("while performing request: %v", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("while reading response body: %v", err)
+	}
+	recResp := &RecordedResponse{
+		Status:  resp.StatusCode,
+		Headers: resp.Header,
+		Body:    string(b),
+	}
+	if v != nil {
+		recorded := *recResp
+		recorded.Headers = v.redactHeaders(recorded.Headers)
+		if err = v.record(recReq, recorded); err != nil {
+			return nil, fmt.Errorf("while recording to cassette: %v", err)
+		}
+	}
+	return recResp, nil
+}
+
+// record appends the interaction and writes out the cassette, so that it survives a script that later fails
+func (v *vcr) record(req RecordedRequest, resp RecordedResponse) error {
+	v.cassette.Interactions = append(v.cassette.Interactions, Interaction{
+		Request:    req,
+		Response:   resp,
+		RecordedAt: time.Now().UTC(),
+	})
+	b, err := json.MarshalIndent(v.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(v.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(v.path, b, 0644)
+}
+
+// redactHeaders gives a copy of h with the values of the headers to redact replaced, leaving h as sent or received
+func (v *vcr) redactHeaders(h http.Header) http.Header {
+	ret := h.Clone()
+	for _, name := range v.redact {
+		values := ret.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		redacted := make([]string, len(values))
+		for i := range redacted {
+			redacted[i] = redactedValue
+		}
+		ret[http.CanonicalHeaderKey(name)] = redacted
+	}
+	return ret
+}
+
+func (v *vcr) replay(req RecordedRequest) (*RecordedResponse, error) {
+	for i, interaction := range v.cassette.Interactions {
+		if v.used[i] || !v.matches(interaction.Request, req) {
+			continue
+		}
+		v.used[i] = true
+		resp := interaction.Response
+		return &resp, nil
+	}
+	return nil, fmt.Errorf("no unused interaction in cassette '%s' matches %s %s on %s", v.path, req.Method, req.URL, strings.Join(v.match, ", "))
+}
+
+func (v *vcr) matches(recorded, req RecordedRequest) bool {
+	for _, m := range v.match {
+		switch {
+		case m == "method":
+			if !strings.EqualFold(recorded.Method, req.Method) {
+				return false
+			}
+		case m == "url":
+			if !sameURL(recorded.URL, req.URL) {
+				return false
+			}
+		case m == "body":
+			if recorded.Body != req.Body {
+				return false
+			}
+		case m == "headers":
+			if !reflect.DeepEqual(normalizeHeaders(recorded.Headers), normalizeHeaders(req.Headers)) {
+				return false
+			}
+		case strings.HasPrefix(m, "header:"):
+			name := strings.TrimPrefix(m, "header:")
+			if !reflect.DeepEqual(recorded.Headers.Values(name), req.Headers.Values(name)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sameURL compares URLs with their query parameters in any order
+func sameURL(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	qa, qb := ua.Query(), ub.Query()
+	ua.RawQuery, ub.RawQuery = "", ""
+	return ua.String() == ub.String() && reflect.DeepEqual(qa, qb)
+}
+
+// normalizeHeaders canonicalizes header names, as a cassette edited by hand may not have
+func normalizeHeaders(h http.Header) http.Header {
+	ret := make(http.Header, len(h))
+	for k, v := range h {
+		ret[http.CanonicalHeaderKey(k)] = v
+	}
+	return ret
+}
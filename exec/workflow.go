@@ -0,0 +1,170 @@
+package exec
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/EvWilson/sqump/data"
+)
+
+type StepStatus string
+
+const (
+	StepPassed  StepStatus = "passed"
+	StepFailed  StepStatus = "failed"
+	StepSkipped StepStatus = "skipped"
+)
+
+type StepResult struct {
+	Name    string
+	Request string
+	Status  StepStatus
+	// Cleanup is set for the workflow's cleanup steps
+	Cleanup  bool
+	Duration time.Duration
+	Err      error
+}
+
+func (sr StepResult) String() string {
+	kind := "step"
+	if sr.Cleanup {
+		kind = "cleanup step"
+	}
+	switch sr.Status {
+	case StepSkipped:
+		return fmt.Sprintf("%s '%s': skipped", kind, sr.Name)
+	case StepFailed:
+		return fmt.Sprintf("%s '%s': failed in %s: %s", kind, sr.Name, sr.Duration.Round(time.Millisecond), strings.TrimSpace(sr.Err.Error()))
+	default:
+		return fmt.Sprintf("%s '%s': passed in %s", kind, sr.Name, sr.Duration.Round(time.Millisecond))
+	}
+}
+
+type WorkflowResult struct {
+	Steps []StepResult
+}
+
+// Passed reports whether every step, cleanup included, passed
+func (wr WorkflowResult) Passed() bool {
+	for _, step := range wr.Steps {
+		if step.Status != StepPassed {
+			return false
+		}
+	}
+	return true
+}
+
+// ExecuteWorkflow runs the workflow's steps, each in a state of its own, followed by its cleanup steps. The first value
+// each passing step returns is kept in the `steps` global of the steps after it, under the step's name, as an empty
+// table if it returned nothing. Each result is passed to onStep as it completes.
+func ExecuteWorkflow(
+	coll *data.Collection,
+	workflowName string,
+	currentEnv string,
+	overrides data.EnvMapValue,
+	onStep func(StepResult),
+) (*WorkflowResult, error) {
+	w, ok := coll.GetWorkflow(workflowName)
+	if !ok {
+		return nil, data.ErrNotFound{
+			MissingItem: "workflow",
+			Location:    workflowName,
+		}
+	}
+	steps, err := w.Order()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &WorkflowResult{}
+	outputs := make(map[string]any)
+	statuses := make(map[string]StepStatus)
+	run := func(step data.WorkflowStep, deps []string, cleanup bool) {
+		res := StepResult{
+			Name:    step.StepName(),
+			Request: step.Request,
+			Cleanup: cleanup,
+		}
+		for _, dep := range deps {
+			if statuses[dep] != StepPassed {
+				res.Status = StepSkipped
+			}
+		}
+		if res.Status != StepSkipped {
+			start := time.Now()
+			output, err := executeStep(coll, step, currentEnv, overrides, outputs)
+			res.Duration = time.Since(start)
+			if err != nil {
+				res.Status = StepFailed
+				res.Err = err
+			} else {
+				res.Status = StepPassed
+				outputs[res.Name] = output
+			}
+		}
+		statuses[res.Name] = res.Status
+		result.Steps = append(result.Steps, res)
+		onStep(res)
+	}
+
+	chain := w.IsChain()
+	for i, step := range steps {
+		deps := step.DependsOn
+		if chain && i > 0 {
+			deps = []string{steps[i-1].StepName()}
+		}
+		run(step, deps, false)
+	}
+	for _, step := range w.Cleanup {
+		run(step, nil, true)
+	}
+	return result, nil
+}
+
+func executeStep(
+	coll *data.Collection,
+	step data.WorkflowStep,
+	currentEnv string,
+	overrides data.EnvMapValue,
+	outputs map[string]any,
+) (any, error) {
+	pr, err := prepareRequest(coll, step.Request, currentEnv, overrides)
+	if err != nil {
+		return nil, err
+	}
+	if pr.req.Mock != nil {
+		return nil, fmt.Errorf("request '%s' is a mock route for '%s', and can't be run as a step", step.Request, pr.req.Mock)
+	}
+	pr.params = step.Params
+
+	state := CreateState(pr.ident, currentEnv, pr.env, NewLoopChecker(), WithOverrides(pr.overrides))
+	CacheCancelFunc(state.Cancel)
+	defer state.Close()
+
+	// Outputs move between states by way of JSON
+	b, err := json.Marshal(outputs)
+	if err != nil {
+		return nil, fmt.Errorf("passing step outputs: %v", err)
+	}
+	stepsTable, err := parseJSONString(b)
+	if err != nil {
+		return nil, fmt.Errorf("passing step outputs: %v", err)
+	}
+	state.SetGlobal("steps", stepsTable)
+
+	top := state.GetTop()
+	err = pr.run(state)
+	if err != nil {
+		return nil, err
+	}
+	if state.GetTop() == top {
+		return map[string]any{}, nil
+	}
+	output, err := lValueToGo(state.Get(top + 1))
+	if err != nil {
+		return nil, fmt.Errorf("reading step output: %v", err)
+	}
+	return output, nil
+}
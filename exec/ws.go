@@ -4,9 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"net/url"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
@@ -18,8 +22,28 @@ const (
 )
 
 type WSClient struct {
-	conn net.Conn
-	url  string
+	conn      net.Conn
+	reader    io.Reader
+	url       string
+	writeLock sync.Mutex
+	// messages holds data frames for `receive`, or for the callback once one is registered
+	messages chan wsMessage
+	pongs    chan struct{}
+	// done is closed once the read loop stops, after which closeErr says why
+	done     chan struct{}
+	closeErr error
+	cbLock   sync.Mutex
+	callback *lua.LFunction
+	// onClose, if set, is dispatched once with the close code and reason after the last message is delivered
+	onClose   *lua.LFunction
+	closeOnce sync.Once
+	// handler, if set, takes every data message in place of the queue and callback, for protocols built on top
+	handler func(wsMessage)
+}
+
+type wsMessage struct {
+	data   []byte
+	binary bool
 }
 
 func (ws *WSClient) toUserData(L *lua.LState) *lua.LUserData {
@@ -29,6 +53,10 @@ func (ws *WSClient) toUserData(L *lua.LState) *lua.LUserData {
 	return ud
 }
 
+func (wc *WSClient) Close() error {
+	return wc.conn.Close()
+}
+
 func getClientParam(L *lua.LState, i int) (*WSClient, error) {
 	v := L.Get(i)
 	ud, ok := v.(*lua.LUserData)
@@ -48,9 +76,13 @@ func (s *State) registerWebsocketModule(L *lua.LState) {
 			clientMT := L.NewTypeMetatable(luaWebsocketClientTypeName)
 			L.SetGlobal(luaWebsocketClientTypeName, clientMT)
 			L.SetField(clientMT, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
-				"send":      s.sendMessage,
-				"onmessage": s.onMessage,
-				"close":     s.close,
+				"send":        s.sendMessage,
+				"send_binary": s.sendBinary,
+				"ping":        s.ping,
+				"receive":     s.receive,
+				"onmessage":   s.onMessage,
+				"onclose":     s.onCloseHandler,
+				"close":       s.close,
 			}))
 		}
 
@@ -67,22 +99,152 @@ func (s *State) newClient(_ *lua.LState) int {
 	if err != nil {
 		return s.CancelErr("error: new_client: %v", err)
 	}
-	u, err := url.Parse(urlStr)
+	options, err := getOptionsParam(s.LState, "options", 2)
 	if err != nil {
 		return s.CancelErr("error: new_client: %v", err)
 	}
-	conn, _, _, err := ws.Dial(context.Background(), u.String())
+	c, err := s.dialWSClient(urlStr, options)
 	if err != nil {
 		return s.CancelErr("error: new_client: %v", err)
 	}
-	c := WSClient{
-		conn: conn,
-		url:  urlStr,
-	}
+	c.start(s, nil)
 	s.LState.Push(c.toUserData(s.LState))
 	return 1
 }
 
+// dialWSClient connects with the options accepted by new_client: headers, subprotocols, timeout and tls. Nothing is
+// read from the connection until the client is started.
+func (s *State) dialWSClient(urlStr string, options *lua.LTable) (*WSClient, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := getStringMap(options, "headers")
+	if err != nil {
+		return nil, err
+	}
+	dialer := ws.Dialer{
+		Timeout: time.Duration(intOrDefault(options, "timeout", 10)) * time.Second,
+	}
+	if len(headers) > 0 {
+		h := make(http.Header, len(headers))
+		for k, v := range headers {
+			h.Set(k, v)
+		}
+		dialer.Header = ws.HandshakeHeaderHTTP(h)
+	}
+	if protocols := options.RawGetString("subprotocols"); protocols != lua.LNil {
+		dialer.Protocols, err = luaArrayToSlice(protocols)
+		if err != nil {
+			return nil, fmt.Errorf("subprotocols: %v", err)
+		}
+	}
+	if tlsTable, ok := options.RawGetString("tls").(*lua.LTable); ok {
+		dialer.TLSConfig, err = tlsConfigFromTable(tlsTable)
+		if err != nil {
+			return nil, err
+		}
+	}
+	conn, br, _, err := dialer.Dial(s.ctx, u.String())
+	if err != nil {
+		return nil, err
+	}
+	c := &WSClient{
+		conn:     conn,
+		reader:   conn,
+		url:      urlStr,
+		messages: make(chan wsMessage, 256),
+		pongs:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	if br != nil {
+		// The handshake may have buffered the first frames, so keep reading through it
+		c.reader = br
+	}
+	s.track(c)
+	return c, nil
+}
+
+// start begins reading from the connection. A non-nil handler is called from the read loop for every data message
+// received, in place of the queue and callback.
+func (wc *WSClient) start(s *State, handler func(wsMessage)) {
+	wc.handler = handler
+	go wc.readLoop(s)
+}
+
+// readLoop takes every frame from the server, answering control frames and queueing data frames for `receive` or the
+// registered callback
+func (wc *WSClient) readLoop(s *State) {
+	defer close(wc.done)
+	rd := &wsutil.Reader{
+		Source:    wc.reader,
+		State:     ws.StateClientSide,
+		CheckUTF8: true,
+	}
+	rd.OnIntermediate = func(hdr ws.Header, r io.Reader) error {
+		return wc.handleControl(hdr, r)
+	}
+	for {
+		hdr, err := rd.NextFrame()
+		if err != nil {
+			wc.closeErr = err
+			return
+		}
+		if hdr.OpCode.IsControl() {
+			if err = wc.handleControl(hdr, rd); err != nil {
+				wc.closeErr = err
+				return
+			}
+			continue
+		}
+		data, err := io.ReadAll(rd)
+		if err != nil {
+			wc.closeErr = err
+			return
+		}
+		msg := wsMessage{data: data, binary: hdr.OpCode == ws.OpBinary}
+		if wc.handler != nil {
+			wc.handler(msg)
+			continue
+		}
+		select {
+		case wc.messages <- msg:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (wc *WSClient) handleControl(hdr ws.Header, r io.Reader) error {
+	payload := make([]byte, hdr.Length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return err
+	}
+	switch hdr.OpCode {
+	case ws.OpPing:
+		return wc.write(ws.OpPong, payload)
+	case ws.OpPong:
+		select {
+		case wc.pongs <- struct{}{}:
+		default:
+		}
+		return nil
+	case ws.OpClose:
+		code, reason := ws.ParseCloseFrameData(payload)
+		// Echo the close as the protocol asks, though the server may already have hung up
+		_ = wc.write(ws.OpClose, ws.NewCloseFrameBody(code, ""))
+		return wsutil.ClosedError{Code: code, Reason: reason}
+	default:
+		return nil
+	}
+}
+
+func (wc *WSClient) write(op ws.OpCode, payload []byte) error {
+	wc.writeLock.Lock()
+	defer wc.writeLock.Unlock()
+	return wsutil.WriteClientMessage(wc.conn, op, payload)
+}
+
 func (s *State) sendMessage(_ *lua.LState) int {
 	client, err := getClientParam(s.LState, 1)
 	if err != nil {
@@ -92,13 +254,112 @@ func (s *State) sendMessage(_ *lua.LState) int {
 	if err != nil {
 		return s.CancelErr("error: send_message: %v", err)
 	}
-	err = wsutil.WriteClientText(client.conn, []byte(msg))
+	err = client.write(ws.OpText, []byte(msg))
 	if err != nil {
 		return s.CancelErr("error: send_message: %v", err)
 	}
 	return 0
 }
 
+func (s *State) sendBinary(_ *lua.LState) int {
+	client, err := getClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: send_binary: %v", err)
+	}
+	data, err := getStringParam(s.LState, "data", 2)
+	if err != nil {
+		return s.CancelErr("error: send_binary: %v", err)
+	}
+	if err = client.write(ws.OpBinary, []byte(data)); err != nil {
+		return s.CancelErr("error: send_binary: %v", err)
+	}
+	return 0
+}
+
+func (s *State) ping(_ *lua.LState) int {
+	client, err := getClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: ping: %v", err)
+	}
+	timeout, err := getIntParam(s.LState, "timeout", 2)
+	if err != nil {
+		return s.CancelErr("error: ping: %v", err)
+	}
+	// Drop any unsolicited pong so that only the answer to this ping counts
+	select {
+	case <-client.pongs:
+	default:
+	}
+	if err = client.write(ws.OpPing, nil); err != nil {
+		return s.CancelErr("error: ping: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	select {
+	case <-client.pongs:
+		s.LState.Push(lua.LTrue)
+	case <-client.done:
+		s.LState.Push(lua.LFalse)
+	case <-ctx.Done():
+		s.LState.Push(lua.LFalse)
+	}
+	return 1
+}
+
+func (s *State) receive(_ *lua.LState) int {
+	client, err := getClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: receive: %v", err)
+	}
+	timeout, err := getIntParam(s.LState, "timeout", 2)
+	if err != nil {
+		return s.CancelErr("error: receive: %v", err)
+	}
+	failOnTimeout, err := getBoolParam(s.LState, "fail_on_timeout", 3)
+	if err != nil {
+		return s.CancelErr("error: receive: %v", err)
+	}
+	client.cbLock.Lock()
+	delivering := client.callback != nil
+	client.cbLock.Unlock()
+	if delivering {
+		// The callback takes from the same queue, so receiving here would steal its messages
+		return s.CancelErr("error: receive: client messages are delivered to its onmessage callback")
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	select {
+	case msg := <-client.messages:
+		s.LState.Push(wsMessageToTable(msg))
+		return 1
+	case <-client.done:
+		// Hand over anything that arrived before the connection closed
+		select {
+		case msg := <-client.messages:
+			s.LState.Push(wsMessageToTable(msg))
+			return 1
+		default:
+		}
+		code, reason := client.closeInfo()
+		if failOnTimeout {
+			return s.CancelErr("error: receive: connection closed: %d %s", code, reason)
+		}
+		closed := &lua.LTable{}
+		closed.RawSetString("code", lua.LNumber(code))
+		closed.RawSetString("reason", lua.LString(reason))
+		s.LState.Push(lua.LNil)
+		s.LState.Push(closed)
+		return 2
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && !failOnTimeout {
+			// Do nothing, we mean not to fail in this case (easiest to represent this way)
+			s.LState.Push(lua.LNil)
+			return 1
+		}
+		return s.CancelErr("error: receive: %v", ctx.Err())
+	}
+}
+
 func (s *State) onMessage(_ *lua.LState) int {
 	client, err := getClientParam(s.LState, 1)
 	if err != nil {
@@ -108,36 +369,167 @@ func (s *State) onMessage(_ *lua.LState) int {
 	if err != nil {
 		return s.CancelErr("error: onmessage: %v", err)
 	}
+	client.cbLock.Lock()
+	registered := client.callback != nil
+	client.callback = cb
+	client.cbLock.Unlock()
+	if !registered {
+		go client.deliver(s)
+	}
+	return 0
+}
 
-	go func() {
-		for {
-			msg, err := wsutil.ReadServerText(client.conn)
-			if err != nil {
-				var errType *net.OpError
-				if errors.As(err, &errType) || err.Error() == "EOF" {
-					break
-				}
-				s.CancelErr("error: onmessage: %v", err)
-			}
-			s.LState.Push(cb)
-			s.LState.Push(lua.LString(string(msg)))
-			if err := s.LState.PCall(1, 0, nil); err != nil {
-				s.CancelErr("error: onmessage: %v", err)
+// deliver hands queued messages to the registered callback one at a time, in the order they arrived, starting with
+// those queued before it was registered. Callbacks are dispatched from here rather than the script's thread, which
+// only runs them while paused. Once the connection closes and the queue is drained, the close callback follows.
+func (wc *WSClient) deliver(s *State) {
+	for {
+		select {
+		case msg := <-wc.messages:
+			wc.dispatchMessage(s, msg)
+		case <-wc.done:
+			// Nothing is queued once the read loop stops, so whatever remains is the last of it
+			for len(wc.messages) > 0 {
+				wc.dispatchMessage(s, <-wc.messages)
 			}
+			wc.dispatchClose(s)
+			return
+		case <-s.ctx.Done():
+			return
 		}
-	}()
+	}
+}
 
+func (wc *WSClient) dispatchMessage(s *State, msg wsMessage) {
+	wc.cbLock.Lock()
+	cb := wc.callback
+	wc.cbLock.Unlock()
+	s.dispatch(func() {
+		s.LState.Push(cb)
+		s.LState.Push(lua.LString(string(msg.data)))
+		s.LState.Push(lua.LBool(msg.binary))
+		if err := s.LState.PCall(2, 0, nil); err != nil {
+			_ = s.CancelErr("error: onmessage: %v", err)
+		}
+	})
+}
+
+func (s *State) onCloseHandler(_ *lua.LState) int {
+	client, err := getClientParam(s.LState, 1)
+	if err != nil {
+		return s.CancelErr("error: onclose: %v", err)
+	}
+	cb, err := getFuncParam(s.LState, "cb", 2)
+	if err != nil {
+		return s.CancelErr("error: onclose: %v", err)
+	}
+	client.cbLock.Lock()
+	registered := client.onClose != nil
+	client.onClose = cb
+	client.cbLock.Unlock()
+	if !registered {
+		go client.awaitClose(s)
+	}
 	return 0
 }
 
+// awaitClose dispatches the close callback once the connection closes, unless an onmessage callback is registered,
+// in which case deliver does so after the last message so that the close is always seen last
+func (wc *WSClient) awaitClose(s *State) {
+	select {
+	case <-wc.done:
+	case <-s.ctx.Done():
+		return
+	}
+	wc.cbLock.Lock()
+	delivering := wc.callback != nil
+	wc.cbLock.Unlock()
+	if !delivering {
+		wc.dispatchClose(s)
+	}
+}
+
+func (wc *WSClient) dispatchClose(s *State) {
+	wc.cbLock.Lock()
+	cb := wc.onClose
+	wc.cbLock.Unlock()
+	if cb == nil {
+		return
+	}
+	wc.closeOnce.Do(func() {
+		code, reason := wc.closeInfo()
+		s.dispatch(func() {
+			s.LState.Push(cb)
+			s.LState.Push(lua.LNumber(code))
+			s.LState.Push(lua.LString(reason))
+			if err := s.LState.PCall(2, 0, nil); err != nil {
+				_ = s.CancelErr("error: onclose: %v", err)
+			}
+		})
+	})
+}
+
+// closeInfo gives the close code and reason sent by the server, or 1006 (abnormal closure) and the read error when the
+// connection ended without a close frame. Only valid once done is closed.
+func (wc *WSClient) closeInfo() (int, string) {
+	var closed wsutil.ClosedError
+	if errors.As(wc.closeErr, &closed) {
+		return int(closed.Code), closed.Reason
+	}
+	if wc.closeErr != nil {
+		return int(ws.StatusAbnormalClosure), wc.closeErr.Error()
+	}
+	return int(ws.StatusAbnormalClosure), ""
+}
+
 func (s *State) close(_ *lua.LState) int {
 	client, err := getClientParam(s.LState, 1)
 	if err != nil {
 		return s.CancelErr("error: close: %v", err)
 	}
-	err = client.conn.Close()
-	if err != nil {
+	code := ws.StatusNormalClosure
+	if s.LState.Get(2) != lua.LNil {
+		codeParam, err := getIntParam(s.LState, "code", 2)
+		if err != nil {
+			return s.CancelErr("error: close: %v", err)
+		}
+		code = ws.StatusCode(codeParam)
+	}
+	var reason string
+	if s.LState.Get(3) != lua.LNil {
+		reason, err = getStringParam(s.LState, "reason", 3)
+		if err != nil {
+			return s.CancelErr("error: close: %v", err)
+		}
+	}
+	if err = client.shutdown(code, reason); err != nil {
 		return s.CancelErr("error: close: %v", err)
 	}
 	return 0
 }
+
+// shutdown starts the closing handshake, giving the server a moment to answer before hanging up
+func (wc *WSClient) shutdown(code ws.StatusCode, reason string) error {
+	select {
+	case <-wc.done:
+	default:
+		if err := wc.write(ws.OpClose, ws.NewCloseFrameBody(code, reason)); err == nil {
+			select {
+			case <-wc.done:
+			case <-time.After(time.Second):
+			}
+		}
+	}
+	err := wc.conn.Close()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+func wsMessageToTable(msg wsMessage) *lua.LTable {
+	ret := &lua.LTable{}
+	ret.RawSetString("data", lua.LString(string(msg.data)))
+	ret.RawSetString("binary", lua.LBool(msg.binary))
+	return ret
+}
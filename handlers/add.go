@@ -35,6 +35,8 @@ func AddRequest(fpath, requestName string) error {
 	if err != nil {
 		return err
 	}
-	coll.Requests = append(coll.Requests, *data.NewRequest(requestName))
+	if err = coll.AddRequest(requestName, data.NewRequest(requestName)); err != nil {
+		return err
+	}
 	return coll.Flush()
 }
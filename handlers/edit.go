@@ -27,6 +27,14 @@ func EditRequest(fpath, requestName string) error {
 	return nil
 }
 
+func EditCollectionHook(fpath, hook string) error {
+	coll, err := data.ReadCollection(fpath)
+	if err != nil {
+		return err
+	}
+	return coll.EditHook(hook)
+}
+
 func UpdateCollectionName(fpath, newName string) error {
 	coll, err := data.ReadCollection(fpath)
 	if err != nil {
@@ -36,8 +44,10 @@ func UpdateCollectionName(fpath, newName string) error {
 	return coll.Flush()
 }
 
-func UpdateCollectionEnv(fpath string, env data.EnvMap) error {
-	coll, err := data.ReadCollection(fpath)
+// UpdateCollectionEnv sets the collection's environment, refusing with data.ErrConflict if revision is given and the
+// collection has changed since
+func UpdateCollectionEnv(fpath string, env data.EnvMap, revision string) error {
+	coll, err := readRevision(fpath, revision)
 	if err != nil {
 		return err
 	}
@@ -50,24 +60,17 @@ func UpdateRequestName(fpath, oldName, newName string) error {
 	if err != nil {
 		return err
 	}
-	req, ok := coll.GetRequest(oldName)
-	if !ok {
+	if _, ok := coll.GetRequest(oldName); !ok {
 		return fmt.Errorf("UpdateRequestName: no request '%s' found in collection '%s'", oldName, coll.Name)
 	}
-	newReq := data.Request{
-		Name:   newName,
-		Script: req.Script,
-	}
-	err = coll.RemoveRequest(oldName)
-	if err != nil {
-		return err
-	}
-	coll = coll.UpsertRequest(&newReq)
+	coll.RenameRequest(oldName, newName)
 	return coll.Flush()
 }
 
-func UpdateRequestScript(fpath, requestName string, newScript []string) error {
-	coll, err := data.ReadCollection(fpath)
+// UpdateRequestScript sets the request's script, refusing with data.ErrConflict if revision is given and the
+// collection has changed since
+func UpdateRequestScript(fpath, requestName string, newScript []string, revision string) error {
+	coll, err := readRevision(fpath, revision)
 	if err != nil {
 		return err
 	}
@@ -76,7 +79,7 @@ func UpdateRequestScript(fpath, requestName string, newScript []string) error {
 		return fmt.Errorf("UpdateRequestScript: no request '%s' found in collection '%s'", requestName, coll.Name)
 	}
 	req.Script = data.ScriptFromString(strings.Join(newScript, "\n"))
-	return coll.UpsertRequest(req).Flush()
+	return coll.UpsertRequest(requestName, req).Flush()
 }
 
 func GetCurrentEnv() (string, error) {
@@ -95,3 +98,18 @@ func SetCurrentEnv(newEnv string) error {
 	conf.CurrentEnv = newEnv
 	return conf.Flush()
 }
+
+// readRevision reads the collection, checking that it's still at the given revision if there is one
+func readRevision(fpath, revision string) (*data.Collection, error) {
+	coll, err := data.ReadCollection(fpath)
+	if err != nil {
+		return nil, err
+	}
+	if revision != "" && revision != coll.Revision() {
+		return nil, data.ErrConflict{
+			Kind: "collection",
+			Path: fpath,
+		}
+	}
+	return coll, nil
+}
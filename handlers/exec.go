@@ -1,18 +1,126 @@
 package handlers
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/EvWilson/sqump/data"
 	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/prnt"
 )
 
-func ExecuteRequest(fpath, requestName, currentEnv string, overrides data.EnvMapValue) error {
+// ExecuteRequest runs the request with the given values for its parameters, giving what its script returned as JSON,
+// or "" if it returned nothing
+func ExecuteRequest(fpath, requestName, currentEnv string, overrides data.EnvMapValue, params map[string]string) (string, error) {
 	var coll *data.Collection
+	coll, err := data.ReadCollection(fpath)
+	if err != nil {
+		return "", err
+	}
+	given := make(map[string]any, len(params))
+	for k, v := range params {
+		given[k] = v
+	}
+	returned, err := exec.ExecuteRequestWithParams(coll, requestName, currentEnv, overrides, given, exec.NewLoopChecker())
+	if err != nil {
+		return "", err
+	}
+	return returnedJSON(returned)
+}
+
+// returnedJSON encodes the values returned by a script, as the one value if there's only one, or as an array
+func returnedJSON(returned []any) (string, error) {
+	var v any
+	switch len(returned) {
+	case 0:
+		return "", nil
+	case 1:
+		v = returned[0]
+	default:
+		v = returned
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("encoding returned values: %v", err)
+	}
+	return string(b), nil
+}
+
+// ExecuteDataset runs the request once per row of the dataset file, printing each row's result and a summary
+func ExecuteDataset(fpath, requestName, currentEnv string, overrides data.EnvMapValue, datasetPath string, opts exec.DatasetOptions) error {
 	coll, err := data.ReadCollection(fpath)
 	if err != nil {
 		return err
 	}
-	_, err = exec.ExecuteRequest(coll, requestName, currentEnv, overrides, exec.NewLoopChecker())
-	return err
+	if _, ok := coll.GetRequest(requestName); !ok {
+		return data.ErrNotFound{
+			MissingItem: "request",
+			Location:    requestName,
+		}
+	}
+	rows, err := exec.ReadDataset(datasetPath)
+	if err != nil {
+		return err
+	}
+	results, err := exec.ExecuteDataset(coll, requestName, currentEnv, overrides, rows, opts, func(res exec.IterationResult) {
+		prnt.Println(res.String())
+	})
+	if err != nil {
+		return err
+	}
+	passed, failed, skipped := 0, 0, 0
+	for _, res := range results {
+		switch {
+		case res.Skipped:
+			skipped++
+		case res.Err != nil:
+			failed++
+		default:
+			passed++
+		}
+	}
+	prnt.Printf("%d rows: %d passed, %d failed, %d skipped\n", len(results), passed, failed, skipped)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d rows failed", failed, len(results))
+	}
+	return nil
+}
+
+// ExecuteTagged runs each request carrying the tag in the given collections, one after another, printing each one's
+// result and a summary. Mock routes are left out, as they're served rather than run.
+func ExecuteTagged(fpaths []string, tag, currentEnv string, overrides data.EnvMapValue) error {
+	total, failed := 0, 0
+	for _, fpath := range fpaths {
+		coll, err := data.ReadCollection(fpath)
+		if err != nil {
+			return err
+		}
+		for _, ref := range coll.RequestsTagged(tag) {
+			if ref.Request.Mock != nil {
+				continue
+			}
+			total++
+			start := time.Now()
+			_, err := exec.ExecuteRequest(coll, ref.Path, currentEnv, overrides, exec.NewLoopChecker())
+			elapsed := time.Since(start).Round(time.Millisecond)
+			if err != nil {
+				failed++
+				prnt.Printf("%s.%s: failed in %s: %s\n", coll.Name, ref.Path, elapsed, strings.TrimSpace(err.Error()))
+			} else {
+				prnt.Printf("%s.%s: ok in %s\n", coll.Name, ref.Path, elapsed)
+			}
+		}
+	}
+	if total == 0 {
+		return fmt.Errorf("no requests tagged '%s' found", tag)
+	}
+	prnt.Printf("%d requests tagged '%s': %d passed, %d failed\n", total, tag, total-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d requests failed", failed, total)
+	}
+	return nil
 }
 
 func CancelScripts() {
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/prnt"
+	"github.com/segmentio/kafka-go"
+)
+
+const kafkaAdminTimeout = 30 * time.Second
+
+func newKafkaAdmin(connArg string, overrides data.EnvMapValue) (*exec.KafkaAdmin, error) {
+	conn, err := exec.KafkaConnectionFor(connArg, overrides)
+	if err != nil {
+		return nil, err
+	}
+	return exec.NewKafkaAdmin(conn, 10*time.Second), nil
+}
+
+func KafkaListTopics(connArg string, overrides data.EnvMapValue) error {
+	admin, err := newKafkaAdmin(connArg, overrides)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaAdminTimeout)
+	defer cancel()
+	topics, err := admin.ListTopics(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing topics: %v", err)
+	}
+	for _, t := range topics {
+		internal := ""
+		if t.Internal {
+			internal = " (internal)"
+		}
+		prnt.Printf("%s%s: %d partitions\n", t.Name, internal, len(t.Partitions))
+		for _, p := range t.Partitions {
+			prnt.Printf("  partition %d: leader %d, replicas %v, isr %v\n", p.ID, p.Leader, p.Replicas, p.ISR)
+		}
+	}
+	return nil
+}
+
+func KafkaCreateTopic(connArg, topic string, partitions, replicationFactor int, overrides data.EnvMapValue) error {
+	admin, err := newKafkaAdmin(connArg, overrides)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaAdminTimeout)
+	defer cancel()
+	err = admin.CreateTopic(ctx, exec.KafkaTopicSpec{
+		Name:              topic,
+		Partitions:        partitions,
+		ReplicationFactor: replicationFactor,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating topic '%s': %v", topic, err)
+	}
+	prnt.Printf("created topic '%s'\n", topic)
+	return nil
+}
+
+func KafkaDeleteTopic(connArg, topic string, overrides data.EnvMapValue) error {
+	admin, err := newKafkaAdmin(connArg, overrides)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaAdminTimeout)
+	defer cancel()
+	if err = admin.DeleteTopic(ctx, topic); err != nil {
+		return fmt.Errorf("error deleting topic '%s': %v", topic, err)
+	}
+	prnt.Printf("deleted topic '%s'\n", topic)
+	return nil
+}
+
+func KafkaDescribeGroup(connArg, group string, overrides data.EnvMapValue) error {
+	admin, err := newKafkaAdmin(connArg, overrides)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaAdminTimeout)
+	defer cancel()
+	info, err := admin.DescribeGroup(ctx, group)
+	if err != nil {
+		return fmt.Errorf("error describing group '%s': %v", group, err)
+	}
+	prnt.Printf("group '%s' (%s), %d members, total lag %d\n", info.ID, info.State, len(info.Members), info.TotalLag)
+	for _, m := range info.Members {
+		topics := make([]string, 0, len(m.Assignments))
+		for topic, partitions := range m.Assignments {
+			topics = append(topics, fmt.Sprintf("%s%v", topic, partitions))
+		}
+		sort.Strings(topics)
+		prnt.Printf("  member %s (%s@%s): %s\n", m.ID, m.ClientID, m.Host, strings.Join(topics, ", "))
+	}
+	for _, o := range info.Offsets {
+		lag := strconv.FormatInt(o.Lag, 10)
+		if o.Lag < 0 {
+			lag = "-"
+		}
+		prnt.Printf("  %s/%d: committed %d, end %d, lag %s\n", o.Topic, o.Partition, o.Committed, o.End, lag)
+	}
+	return nil
+}
+
+// KafkaResetGroupOffsets accepts "first", "last", or an absolute offset to reset to
+func KafkaResetGroupOffsets(connArg, group, topic, to string, overrides data.EnvMapValue) error {
+	var offset int64
+	switch strings.ToLower(to) {
+	case "first":
+		offset = kafka.FirstOffset
+	case "last":
+		offset = kafka.LastOffset
+	default:
+		var err error
+		offset, err = strconv.ParseInt(to, 10, 64)
+		if err != nil || offset < 0 {
+			return fmt.Errorf("expected 'first', 'last', or a non-negative offset to reset to, got: '%s'", to)
+		}
+	}
+	admin, err := newKafkaAdmin(connArg, overrides)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaAdminTimeout)
+	defer cancel()
+	offsets, err := admin.ResetGroupOffsets(ctx, group, topic, offset)
+	if err != nil {
+		return fmt.Errorf("error resetting offsets for group '%s': %v", group, err)
+	}
+	partitions := make([]int, 0, len(offsets))
+	for p := range offsets {
+		partitions = append(partitions, p)
+	}
+	sort.Ints(partitions)
+	for _, p := range partitions {
+		prnt.Printf("%s/%d: reset to %d\n", topic, p, offsets[p])
+	}
+	return nil
+}
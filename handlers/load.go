@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+func StartLoad(ctx context.Context, fpath, requestName, currentEnv string, overrides data.EnvMapValue, opts exec.LoadOptions) (*exec.LoadRun, error) {
+	coll, err := data.ReadCollection(fpath)
+	if err != nil {
+		return nil, err
+	}
+	return exec.StartLoad(ctx, coll, requestName, currentEnv, overrides, opts)
+}
+
+// RunLoad runs a load test to completion, printing progress each second and the stats at the end
+func RunLoad(fpath, requestName string, overrides data.EnvMapValue, opts exec.LoadOptions) error {
+	conf, err := GetConfig()
+	if err != nil {
+		return err
+	}
+	run, err := StartLoad(context.Background(), fpath, requestName, conf.CurrentEnv, overrides, opts)
+	if err != nil {
+		return err
+	}
+	prnt.Printf("load testing '%s' with %d VUs for %s\n", requestName, opts.VUs, opts.Duration)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-run.Done():
+			prnt.Printf("\n%s", run.Stats())
+			return nil
+		case <-ticker.C:
+			stats := run.Stats()
+			prnt.Printf("%s: %d iterations, %d fetches, %.2f%% errors, p90 %s\n",
+				stats.Elapsed.Round(time.Second), stats.Iterations, stats.Fetches, stats.ErrorRate*100, stats.P90.Round(time.Microsecond))
+		}
+	}
+}
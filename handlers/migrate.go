@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+const (
+	LayoutFiles  = "files"
+	LayoutInline = "inline"
+
+	defaultScriptDir = "scripts"
+)
+
+// MigrateLayout moves the collection's scripts out to '.lua' files in dir (default 'scripts'), or back inline
+func MigrateLayout(fpath, layout, dir string) error {
+	coll, err := data.ReadCollection(fpath)
+	if err != nil {
+		return err
+	}
+	switch layout {
+	case LayoutFiles:
+		if dir == "" {
+			dir = defaultScriptDir
+		}
+		coll.ScriptDir = filepath.ToSlash(dir)
+		for _, ref := range coll.AllRequests() {
+			ref.Request.File = ""
+		}
+		return coll.Flush()
+	case LayoutInline:
+		if dir != "" {
+			return fmt.Errorf("no directory is taken when migrating to the '%s' layout", LayoutInline)
+		}
+		oldDir := coll.ScriptDir
+		coll.ScriptDir = ""
+		for _, ref := range coll.AllRequests() {
+			ref.Request.File = ""
+		}
+		if err = coll.Flush(); err != nil {
+			return err
+		}
+		if oldDir != "" {
+			removeEmptyDirs(filepath.Join(filepath.Dir(fpath), filepath.FromSlash(oldDir)))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unrecognized layout '%s', expected '%s' or '%s'", layout, LayoutFiles, LayoutInline)
+	}
+}
+
+// removeEmptyDirs removes dir and the directories beneath it, such as those of folders, where nothing else was kept
+func removeEmptyDirs(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			removeEmptyDirs(filepath.Join(dir, e.Name()))
+		}
+	}
+	// Only succeeds if nothing else was kept there
+	_ = os.Remove(dir)
+}
+
+// MigrateVersions upgrades the given collections to the current version, or the config and every registered
+// collection if none are given
+func MigrateVersions(fpaths []string) error {
+	if len(fpaths) == 0 {
+		confPath := data.DefaultConfigLocation()
+		upgrade, err := data.UpgradeConfig(confPath)
+		if err != nil {
+			return err
+		}
+		printUpgrade(confPath, upgrade)
+		conf, err := data.ReadConfigFrom(confPath)
+		if err != nil {
+			return err
+		}
+		fpaths = conf.Files
+	}
+	for _, fpath := range fpaths {
+		upgrade, err := data.UpgradeCollection(fpath)
+		if err != nil {
+			return err
+		}
+		printUpgrade(fpath, upgrade)
+	}
+	return nil
+}
+
+// MigrateFormat rewrites the collection in the named format, replacing its file and registration with those of the
+// new file
+func MigrateFormat(fpath, formatName string) error {
+	format, err := data.ParseFormat(formatName)
+	if err != nil {
+		return err
+	}
+	coll, err := data.ReadCollection(fpath)
+	if err != nil {
+		return err
+	}
+	newPath, err := coll.Convert(format)
+	if err != nil {
+		return err
+	}
+	prnt.Printf("converted '%s' to '%s'\n", fpath, newPath)
+
+	conf, err := data.ReadConfigFrom(data.DefaultConfigLocation())
+	if err != nil {
+		return err
+	}
+	oldAbs, err := filepath.Abs(fpath)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(conf.Files, oldAbs) {
+		return nil
+	}
+	if err = conf.Unregister(oldAbs); err != nil {
+		return err
+	}
+	return conf.Register(newPath)
+}
+
+func printUpgrade(path string, upgrade *data.Upgrade) {
+	if upgrade == nil {
+		prnt.Printf("'%s' is up to date\n", path)
+		return
+	}
+	prnt.Println(upgrade.String())
+	for _, step := range upgrade.Steps {
+		prnt.Printf("  %s\n", step)
+	}
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/prnt"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type MockLogEntry struct {
+	Time   time.Time
+	Method string
+	Path   string
+	// Route is the name of the mock request that served the call, empty if none matched
+	Route    string
+	Status   int
+	Duration time.Duration
+	Error    string
+}
+
+func (e MockLogEntry) String() string {
+	route := e.Route
+	if route == "" {
+		route = "<no route>"
+	}
+	line := fmt.Sprintf("%s %s %s -> %s %d (%s)", e.Time.Format(time.TimeOnly), e.Method, e.Path, route, e.Status, e.Duration.Round(time.Microsecond))
+	if e.Error != "" {
+		line += ": " + e.Error
+	}
+	return line
+}
+
+// MockLog keeps the most recent calls served by a mock server
+type MockLog struct {
+	lock    sync.Mutex
+	entries []MockLogEntry
+	limit   int
+}
+
+func NewMockLog(limit int) *MockLog {
+	return &MockLog{limit: limit}
+}
+
+func (ml *MockLog) Add(e MockLogEntry) {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	ml.entries = append(ml.entries, e)
+	if len(ml.entries) > ml.limit {
+		ml.entries = ml.entries[len(ml.entries)-ml.limit:]
+	}
+}
+
+// Entries returns the logged calls, newest first
+func (ml *MockLog) Entries() []MockLogEntry {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	ret := make([]MockLogEntry, len(ml.entries))
+	for i, e := range ml.entries {
+		ret[len(ret)-1-i] = e
+	}
+	return ret
+}
+
+// NewMockHandler routes calls to the mock requests of the collection at fpath. Routes are fixed when the handler is
+// built, but each call reads the collection afresh, so edits to scripts take effect without a restart.
+func NewMockHandler(fpath, currentEnv string, overrides data.EnvMapValue, onCall func(MockLogEntry)) (http.Handler, error) {
+	coll, err := data.ReadCollection(fpath)
+	if err != nil {
+		return nil, err
+	}
+	mux := chi.NewMux()
+	routes := 0
+	for _, ref := range coll.AllRequests() {
+		req := ref.Request
+		if req.Mock == nil {
+			continue
+		}
+		handler := mockRouteHandler(fpath, ref.Path, currentEnv, overrides, onCall)
+		if err = addMockRoute(mux, *req.Mock, handler); err != nil {
+			return nil, fmt.Errorf("mock route '%s': %v", ref.Path, err)
+		}
+		routes++
+	}
+	if routes == 0 {
+		return nil, fmt.Errorf("collection '%s' has no mock routes", coll.Name)
+	}
+	mux.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		onCall(MockLogEntry{Time: time.Now(), Method: r.Method, Path: r.URL.Path, Status: http.StatusNotFound})
+		http.NotFound(w, r)
+	})
+	mux.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		onCall(MockLogEntry{Time: time.Now(), Method: r.Method, Path: r.URL.Path, Status: http.StatusMethodNotAllowed})
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})
+	return mux, nil
+}
+
+// addMockRoute registers the route, reporting a pattern chi refuses rather than letting it panic
+func addMockRoute(mux *chi.Mux, route data.MockRoute, handler http.HandlerFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	if route.Method == "*" {
+		mux.HandleFunc(route.Path, handler)
+	} else {
+		mux.MethodFunc(route.Method, route.Path, handler)
+	}
+	return nil
+}
+
+func mockRouteHandler(fpath, requestName, currentEnv string, overrides data.EnvMapValue, onCall func(MockLogEntry)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := MockLogEntry{
+			Time:   time.Now(),
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Route:  requestName,
+		}
+		defer func() {
+			entry.Duration = time.Since(entry.Time)
+			onCall(entry)
+		}()
+		fail := func(err error) {
+			entry.Status = http.StatusInternalServerError
+			entry.Error = err.Error()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			fail(err)
+			return
+		}
+		params := make(map[string]string)
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			for i, key := range rctx.URLParams.Keys {
+				params[key] = rctx.URLParams.Values[i]
+			}
+		}
+		coll, err := data.ReadCollection(fpath)
+		if err != nil {
+			fail(err)
+			return
+		}
+		resp, err := exec.ExecuteMock(r.Context(), coll, requestName, currentEnv, overrides, exec.NewMockRequest(r, params, body))
+		if err != nil {
+			fail(err)
+			return
+		}
+		for k, v := range resp.Headers {
+			w.Header().Set(k, v)
+		}
+		entry.Status = resp.Status
+		w.WriteHeader(resp.Status)
+		_, _ = w.Write(resp.Body)
+	}
+}
+
+// ServeMock serves the collection's mock routes at addr until the server fails, printing each call
+func ServeMock(fpath, addr string, overrides data.EnvMapValue) error {
+	conf, err := GetConfig()
+	if err != nil {
+		return err
+	}
+	coll, err := data.ReadCollection(fpath)
+	if err != nil {
+		return err
+	}
+	handler, err := NewMockHandler(fpath, conf.CurrentEnv, overrides, func(e MockLogEntry) {
+		prnt.Println(e.String())
+	})
+	if err != nil {
+		return err
+	}
+	prnt.Printf("serving mock routes of '%s' at %s:\n", coll.Name, addr)
+	for _, ref := range coll.AllRequests() {
+		if ref.Request.Mock != nil {
+			prnt.Printf("  %s -> %s\n", ref.Request.Mock, ref.Path)
+		}
+	}
+	return http.ListenAndServe(addr, handler)
+}
+
+func AddMockRequest(fpath, requestName, method, path string) error {
+	coll, err := data.ReadCollection(fpath)
+	if err != nil {
+		return err
+	}
+	if err = coll.AddRequest(requestName, data.NewMockRequest(requestName, method, path)); err != nil {
+		return err
+	}
+	return coll.Flush()
+}
@@ -11,7 +11,7 @@ import (
 func Autoregister(cwd string) error {
 	found := make([]string, 0)
 	err := filepath.Walk(cwd, func(path string, info fs.FileInfo, err error) error {
-		if err == nil && info.Name() == "Squmpfile.json" {
+		if err == nil && data.IsSqumpfile(info.Name()) {
 			found = append(found, path)
 		}
 		return nil
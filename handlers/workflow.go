@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+// ExecuteWorkflow runs the collection's workflow, printing each step's result and a summary
+func ExecuteWorkflow(fpath, workflowName, currentEnv string, overrides data.EnvMapValue) error {
+	coll, err := data.ReadCollection(fpath)
+	if err != nil {
+		return err
+	}
+	result, err := exec.ExecuteWorkflow(coll, workflowName, currentEnv, overrides, func(res exec.StepResult) {
+		prnt.Printf("<%s>\n", res)
+	})
+	if err != nil {
+		return err
+	}
+	counts := make(map[exec.StepStatus]int)
+	for _, step := range result.Steps {
+		counts[step.Status]++
+	}
+	prnt.Printf("workflow '%s': %d passed, %d failed, %d skipped\n", workflowName, counts[exec.StepPassed], counts[exec.StepFailed], counts[exec.StepSkipped])
+	if !result.Passed() {
+		return fmt.Errorf("workflow '%s' did not pass", workflowName)
+	}
+	return nil
+}
+
+func AddWorkflow(fpath, workflowName string, requests ...string) error {
+	coll, err := data.ReadCollection(fpath)
+	if err != nil {
+		return err
+	}
+	coll.Workflows = append(coll.Workflows, *data.NewWorkflow(workflowName, requests...))
+	return coll.Flush()
+}
@@ -0,0 +1,57 @@
+package test
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+func TestAMQPDialCancelled(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	// Accepts connections but never answers the AMQP handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert(t, err == nil, "listen", err)
+	var conns []net.Conn
+	var lock sync.Mutex
+	t.Cleanup(func() {
+		_ = l.Close()
+		lock.Lock()
+		defer lock.Unlock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			lock.Lock()
+			conns = append(conns, conn)
+			lock.Unlock()
+		}
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runRequest(t, "testdata/test_example_amqp_squmpfile.json", "dial", data.EnvMapValue{
+			"amqp_url": "amqp://guest:guest@" + l.Addr().String() + "/",
+		})
+	}()
+	time.Sleep(200 * time.Millisecond)
+	exec.CancelScripts()
+	select {
+	case err := <-done:
+		assert(t, err != nil && strings.Contains(err.Error(), "new_producer"), "expected cancelled dial, got:", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("dial not interrupted by cancelling the script")
+	}
+}
@@ -0,0 +1,116 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+func TestDataset(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	var lock sync.Mutex
+	var hits []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/items/")
+		lock.Lock()
+		hits = append(hits, id+":"+r.URL.Query().Get("flag"))
+		lock.Unlock()
+		if id == "bad" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	tmpConf, tmpFile := setup(t, "testdata/test_example_config.json", "testdata/test_example_dataset_squmpfile.json")
+	conf, err := data.ReadConfigFrom(tmpConf.F.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll, err := data.ReadCollection(tmpFile.F.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(datasetPath string, opts exec.DatasetOptions) []exec.IterationResult {
+		lock.Lock()
+		hits = nil
+		lock.Unlock()
+		rows, err := exec.ReadDataset(datasetPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reported int
+		results, err := exec.ExecuteDataset(coll, "get_item", conf.CurrentEnv, data.EnvMapValue{"base_url": server.URL}, rows, opts, func(exec.IterationResult) {
+			reported++
+		})
+		assert(t, err == nil, err)
+		assert(t, reported == len(rows), "expected a result reported per row, got:", reported)
+		return results
+	}
+	outcomes := func(results []exec.IterationResult) []string {
+		ret := make([]string, len(results))
+		for i, res := range results {
+			switch {
+			case res.Skipped:
+				ret[i] = "skipped"
+			case res.Err != nil:
+				ret[i] = "failed"
+			default:
+				ret[i] = "ok"
+			}
+		}
+		return ret
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		results := run("testdata/test_example_dataset.csv", exec.DatasetOptions{})
+		assert(t, reflect.DeepEqual(outcomes(results), []string{"ok", "ok", "failed", "ok"}), "outcomes", outcomes(results))
+		assert(t, reflect.DeepEqual(hits, []string{"1:on", "2:off", "bad:on", "3:on"}), "hits", hits)
+		assert(t, strings.Contains(results[2].Err.Error(), "item bad status: 404"), "error", results[2].Err)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		results := run("testdata/test_example_dataset.json", exec.DatasetOptions{})
+		assert(t, reflect.DeepEqual(outcomes(results), []string{"ok", "ok", "failed", "ok"}), "outcomes", outcomes(results))
+		// Rows without a key fall back to the environment, and null is empty
+		assert(t, reflect.DeepEqual(hits, []string{"1:true", "2:", "bad:default", "3:default"}), "hits", hits)
+	})
+
+	t.Run("stop on failure", func(t *testing.T) {
+		results := run("testdata/test_example_dataset.csv", exec.DatasetOptions{StopOnFailure: true})
+		assert(t, reflect.DeepEqual(outcomes(results), []string{"ok", "ok", "failed", "skipped"}), "outcomes", outcomes(results))
+		assert(t, len(hits) == 3, "hits", hits)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		results := run("testdata/test_example_dataset.csv", exec.DatasetOptions{Concurrency: 4})
+		assert(t, reflect.DeepEqual(outcomes(results), []string{"ok", "ok", "failed", "ok"}), "outcomes", outcomes(results))
+		sort.Strings(hits)
+		assert(t, reflect.DeepEqual(hits, []string{"1:on", "2:off", "3:on", "bad:on"}), "hits", hits)
+	})
+
+	t.Run("recording refused", func(t *testing.T) {
+		rows, err := exec.ReadDataset("testdata/test_example_dataset.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows[1] = data.EnvMapValue{exec.VCRModeKey: "Record"}
+		_, err = exec.ExecuteDataset(coll, "get_item", conf.CurrentEnv, data.EnvMapValue{"base_url": server.URL}, rows, exec.DatasetOptions{}, func(exec.IterationResult) {
+			t.Fatal("expected no rows run")
+		})
+		assert(t, err != nil && strings.Contains(err.Error(), "overwrite the same cassette"), "expected recording refused, got:", err)
+	})
+
+	t.Run("bad dataset", func(t *testing.T) {
+		_, err := exec.ReadDataset("testdata/test_example_dataset_squmpfile.json")
+		assert(t, err != nil && strings.Contains(err.Error(), "array of objects"), "expected bad dataset, got:", err)
+	})
+}
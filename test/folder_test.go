@@ -0,0 +1,157 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/handlers"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+func TestFolders(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	var lock sync.Mutex
+	var traces []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		traces = append(traces, r.URL.Query().Get("steps"))
+	}))
+	t.Cleanup(server.Close)
+
+	tmpConf, tmpFile := setup(t, "testdata/test_example_config.json", "testdata/test_example_folder_squmpfile.json")
+	conf, err := data.ReadConfigFrom(tmpConf.F.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrides := data.EnvMapValue{"base_url": server.URL}
+
+	t.Run("lookup", func(t *testing.T) {
+		coll, err := data.ReadCollection(tmpFile.F.Name())
+		assert(t, err == nil, err)
+		paths := make([]string, 0)
+		for _, ref := range coll.AllRequests() {
+			paths = append(paths, ref.Path)
+		}
+		assert(t, reflect.DeepEqual(paths, []string{"UsesLib", "Users.Lib", "Users.Admin.Get"}), "request paths", paths)
+		_, ok := coll.GetRequest("Get")
+		assert(t, !ok, "expected request in folder not found by bare name")
+		_, ok = coll.GetRequest("Users.Missing.Get")
+		assert(t, !ok, "expected request in missing folder not found")
+		env := coll.EnvironmentFor("Users.Admin.Get")
+		assert(t, env["staging"]["greeting"] == "admin" && env["staging"]["top"] == "yes", "folder environment", env)
+		assert(t, coll.Environment["staging"]["greeting"] == "top", "expected collection environment untouched", coll.Environment)
+	})
+
+	t.Run("pre and post scripts", func(t *testing.T) {
+		coll, err := data.ReadCollection(tmpFile.F.Name())
+		assert(t, err == nil, err)
+		lock.Lock()
+		traces = nil
+		lock.Unlock()
+		result, err := exec.ExecuteWorkflow(coll, "Flow", conf.CurrentEnv, overrides, func(exec.StepResult) {})
+		assert(t, err == nil && result.Passed(), "workflow", result, err)
+		lock.Lock()
+		defer lock.Unlock()
+		assert(t, reflect.DeepEqual(traces, []string{"Users.pre,Admin.pre,Get,Admin.post"}), "folder scripts run outermost in", traces)
+	})
+
+	t.Run("mock route in folder", func(t *testing.T) {
+		coll, err := data.ReadCollection(tmpFile.F.Name())
+		assert(t, err == nil, err)
+		coll.UpsertRequest("Users.Admin.Echo", &data.Request{
+			Script: data.Script{"table.insert(trace, 'Echo')", "return { body = '{{.greeting}} ' .. table.concat(trace, ',') }"},
+			Mock:   &data.MockRoute{Method: "GET", Path: "/echo"},
+		})
+		resp, err := exec.ExecuteMock(context.Background(), coll, "Users.Admin.Echo", conf.CurrentEnv, overrides, exec.MockRequest{})
+		assert(t, err == nil, "execute mock", err)
+		assert(t, string(resp.Body) == "admin Users.pre,Admin.pre,Echo", "mock response", string(resp.Body))
+	})
+
+	t.Run("require by path", func(t *testing.T) {
+		coll, err := data.ReadCollection(tmpFile.F.Name())
+		assert(t, err == nil, err)
+		returned, err := exec.ExecuteRequestWithParams(coll, "UsesLib", conf.CurrentEnv, overrides, nil, exec.NewLoopChecker())
+		assert(t, err == nil, "require request in folder", err)
+		assert(t, reflect.DeepEqual(returned, []any{map[string]any{"value": "users"}}), "expected the folder's environment, got:", returned)
+	})
+
+	t.Run("rename and remove", func(t *testing.T) {
+		err := handlers.UpdateRequestName(tmpFile.F.Name(), "Users.Admin.Get", "Fetch")
+		assert(t, err == nil, err)
+		coll, err := data.ReadCollection(tmpFile.F.Name())
+		assert(t, err == nil, err)
+		_, ok := coll.GetRequest("Users.Admin.Fetch")
+		assert(t, ok, "renamed request")
+		w, _ := coll.GetWorkflow("Flow")
+		assert(t, w.Steps[0].Request == "Users.Admin.Fetch", "workflow step follows rename", w.Steps)
+
+		err = handlers.AddRequest(tmpFile.F.Name(), "Orders.Create")
+		assert(t, err == nil, "add request to new folder", err)
+		err = handlers.AddRequest(tmpFile.F.Name(), "Orders.Create")
+		assert(t, err != nil, "expected duplicate request refused")
+		err = handlers.RemoveRequest(tmpFile.F.Name(), "Orders.Create")
+		assert(t, err == nil, err)
+		coll, err = data.ReadCollection(tmpFile.F.Name())
+		assert(t, err == nil, err)
+		_, ok = coll.GetRequest("Orders.Create")
+		assert(t, !ok, "expected request removed")
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		coll, err := data.ReadCollection(tmpFile.F.Name())
+		assert(t, err == nil, err)
+		coll.Folders[0].Name = "Bad.Name"
+		err = coll.Flush()
+		assert(t, err != nil && strings.Contains(err.Error(), "folder name"), "expected dotted folder name refused, got:", err)
+
+		coll, err = data.ReadCollection(tmpFile.F.Name())
+		assert(t, err == nil, err)
+		coll.Folders = append(coll.Folders, data.Folder{Name: coll.Folders[0].Name})
+		err = coll.Flush()
+		assert(t, err != nil && strings.Contains(err.Error(), "duplicate folder name"), "expected duplicate folder refused, got:", err)
+
+		coll, err = data.ReadCollection(tmpFile.F.Name())
+		assert(t, err == nil, err)
+		coll.Folders[0].Requests = append(coll.Folders[0].Requests, data.Request{Name: "UsesLib"})
+		err = coll.Flush()
+		assert(t, err == nil, "same request name in another folder", err)
+	})
+}
+
+func TestFolderScriptLayout(t *testing.T) {
+	dir := t.TempDir()
+	fpath := filepath.Join(dir, "Squmpfile.json")
+	b, err := os.ReadFile("testdata/test_example_folder_squmpfile.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(fpath, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = handlers.MigrateLayout(fpath, handlers.LayoutFiles, "")
+	assert(t, err == nil, "migrate to files", err)
+	lua, err := os.ReadFile(filepath.Join(dir, "scripts", "Users", "Admin", "Get.lua"))
+	assert(t, err == nil, "script file of request in folder", err)
+	assert(t, strings.HasPrefix(string(lua), "table.insert(trace, 'Get')"), "script file contents", string(lua))
+	coll, err := data.ReadCollection(fpath)
+	assert(t, err == nil, err)
+	req, ok := coll.GetRequest("Users.Admin.Get")
+	assert(t, ok && req.File == "scripts/Users/Admin/Get.lua" && len(req.Script) == 2, "request read from file", req)
+
+	err = handlers.MigrateLayout(fpath, handlers.LayoutInline, "")
+	assert(t, err == nil, "migrate inline", err)
+	_, err = os.Stat(filepath.Join(dir, "scripts"))
+	assert(t, os.IsNotExist(err), "expected script directories removed", err)
+}
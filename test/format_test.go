@@ -0,0 +1,163 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/handlers"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+// copyAs copies the file at src into dir under the given name
+func copyAs(t *testing.T, src, dir, name string) string {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err = os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// contents gives what's stored of the collection, apart from where
+func contents(t *testing.T, coll *data.Collection) string {
+	b, err := json.Marshal(coll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCollectionFormats(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	t.Run("detect by extension", func(t *testing.T) {
+		assert(t, data.FormatOf("Squmpfile.json") == data.FormatJSON, "json")
+		assert(t, data.FormatOf("a/Squmpfile.yml") == data.FormatYAML, "yml")
+		assert(t, data.FormatOf("Squmpfile.YAML") == data.FormatYAML, "YAML")
+		assert(t, data.FormatOf("Squmpfile.toml") == data.FormatTOML, "toml")
+		assert(t, data.FormatOf("Squmpfile") == data.FormatJSON, "no extension")
+	})
+
+	t.Run("convert between formats", func(t *testing.T) {
+		dir := t.TempDir()
+		path := copyAs(t, "testdata/test_example_folder_squmpfile.json", dir, "Squmpfile.json")
+		original, err := data.ReadCollection(path)
+		assert(t, err == nil, err)
+		want := contents(t, original)
+
+		for _, format := range []data.Format{data.FormatYAML, data.FormatTOML, data.FormatJSON} {
+			coll, err := data.ReadCollection(path)
+			assert(t, err == nil, "read", path, err)
+			newPath, err := coll.Convert(format)
+			assert(t, err == nil, "convert to", format, err)
+			assert(t, newPath == filepath.Join(dir, "Squmpfile"+format.Ext()), "converted path", newPath)
+			_, err = os.Stat(path)
+			assert(t, os.IsNotExist(err), "expected old file removed", path)
+
+			converted, err := data.ReadCollection(newPath)
+			assert(t, err == nil, "read converted", format, err)
+			assert(t, contents(t, converted) == want, "contents changed converting to", format, contents(t, converted))
+			path = newPath
+		}
+
+		coll, err := data.ReadCollection(path)
+		assert(t, err == nil, err)
+		_, err = coll.Convert(data.FormatJSON)
+		assert(t, err != nil, "expected converting to the same format refused")
+	})
+
+	t.Run("awkward scripts round trip", func(t *testing.T) {
+		scripts := []data.Script{
+			{"local s = 'quoted'"},
+			{"print([[", "'''", "]])"},
+			{"print('trailing space')   ", "\tindented", ""},
+			{"  leading space", "print(\"\\\" \x01\")"},
+		}
+		for _, format := range []data.Format{data.FormatYAML, data.FormatTOML} {
+			dir := t.TempDir()
+			path := copyAs(t, "testdata/test_example_basic_squmpfile.json", dir, "Squmpfile.json")
+			coll, err := data.ReadCollection(path)
+			assert(t, err == nil, err)
+			path, err = coll.Convert(format)
+			assert(t, err == nil, err)
+			for i, script := range scripts {
+				coll.Requests[i%len(coll.Requests)].Script = script
+				assert(t, coll.Flush() == nil, "save", format)
+				read, err := data.ReadCollection(path)
+				assert(t, err == nil, "read", format, err)
+				got := read.Requests[i%len(read.Requests)].Script
+				assert(t, reflect.DeepEqual(got, script), "script changed in", format, got)
+			}
+		}
+	})
+
+	t.Run("execute", func(t *testing.T) {
+		var lock sync.Mutex
+		var paths []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			paths = append(paths, r.URL.RequestURI())
+		}))
+		t.Cleanup(server.Close)
+		overrides := data.EnvMapValue{"base_url": server.URL}
+
+		for _, fixture := range []string{"testdata/test_example_yaml_squmpfile.yaml", "testdata/test_example_toml_squmpfile.toml"} {
+			path := copyAs(t, fixture, t.TempDir(), "Squmpfile"+filepath.Ext(fixture))
+			coll, err := data.ReadCollection(path)
+			assert(t, err == nil, "read", fixture, err)
+			lock.Lock()
+			paths = nil
+			lock.Unlock()
+			for _, name := range []string{"Block", "Lines", "Nested.Get"} {
+				_, err = exec.ExecuteRequest(coll, name, "staging", overrides, exec.NewLoopChecker())
+				assert(t, err == nil, "execute", fixture, name, err)
+			}
+			lock.Lock()
+			got := append([]string(nil), paths...)
+			lock.Unlock()
+			want := []string{"/block?from=collection", "/lines", "/nested?from=folder"}
+			assert(t, reflect.DeepEqual(got, want), "requests from", fixture, got)
+		}
+	})
+
+	t.Run("autoregister", func(t *testing.T) {
+		home := t.TempDir()
+		t.Setenv("HOME", home)
+		_, err := data.CreateNewConfigFileAt(data.DefaultConfigLocation())
+		assert(t, err == nil, "create config", err)
+		dir := t.TempDir()
+		yamlPath := copyAs(t, "testdata/test_example_yaml_squmpfile.yaml", dir, "Squmpfile.yaml")
+		assert(t, os.Mkdir(filepath.Join(dir, "sub"), 0755) == nil, "mkdir")
+		tomlPath := copyAs(t, "testdata/test_example_toml_squmpfile.toml", filepath.Join(dir, "sub"), "Squmpfile.toml")
+		copyAs(t, "testdata/test_example_toml_squmpfile.toml", dir, "Other.toml")
+
+		err = handlers.Autoregister(dir)
+		assert(t, err == nil, "autoregister", err)
+		conf, err := data.ReadConfigFrom(data.DefaultConfigLocation())
+		assert(t, err == nil, err)
+		want := []string{yamlPath, tomlPath}
+		slices.Sort(want)
+		assert(t, reflect.DeepEqual(conf.Files, want), "registered", conf.Files)
+
+		err = handlers.MigrateFormat(yamlPath, "json")
+		assert(t, err == nil, "migrate format", err)
+		conf, err = data.ReadConfigFrom(data.DefaultConfigLocation())
+		assert(t, err == nil, err)
+		want = []string{filepath.Join(dir, "Squmpfile.json"), tomlPath}
+		slices.Sort(want)
+		assert(t, reflect.DeepEqual(conf.Files, want), "registered after converting", conf.Files)
+	})
+}
@@ -3,6 +3,9 @@ package test
 import (
 	"os"
 	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
 )
 
 type Tmpfile struct {
@@ -40,3 +43,38 @@ func assert(t *testing.T, value bool, args ...any) {
 		t.Fatal(args...)
 	}
 }
+
+// runRequests executes each of the named requests of a copy of the squmpfile as a subtest, failing on any error
+func runRequests(t *testing.T, squmpfile string, overrides data.EnvMapValue, names ...string) {
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			if err := runRequest(t, squmpfile, name, overrides); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// runRequest executes the named request of a copy of the squmpfile under the example config's current environment
+func runRequest(t *testing.T, squmpfile, name string, overrides data.EnvMapValue) error {
+	tmpConf, err := CreateTmpfile("testdata/test_example_config.json")
+	assert(t, err == nil, "create conf", err)
+	t.Cleanup(func() {
+		_ = tmpConf.Cleanup()
+	})
+	tmpFile, err := CreateTmpfile(squmpfile)
+	assert(t, err == nil, "create file", err)
+	t.Cleanup(func() {
+		_ = tmpFile.Cleanup()
+	})
+	conf, err := data.ReadConfigFrom(tmpConf.F.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll, err := data.ReadCollection(tmpFile.F.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = exec.ExecuteRequest(coll, name, conf.CurrentEnv, overrides, exec.NewLoopChecker())
+	return err
+}
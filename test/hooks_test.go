@@ -0,0 +1,106 @@
+package test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+func TestCollectionHooks(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	type call struct {
+		Path          string
+		Authorization string
+		Team          string
+		Body          string
+	}
+	var lock sync.Mutex
+	var calls []call
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lock.Lock()
+		defer lock.Unlock()
+		calls = append(calls, call{
+			Path:          r.URL.Path,
+			Authorization: r.Header.Get("Authorization"),
+			Team:          r.Header.Get("X-Team"),
+			Body:          string(body),
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	tmpConf, tmpFile := setup(t, "testdata/test_example_config.json", "testdata/test_example_hooks_squmpfile.json")
+	conf, err := data.ReadConfigFrom(tmpConf.F.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrides := data.EnvMapValue{"base_url": server.URL}
+	run := func(requestName string) []call {
+		coll, err := data.ReadCollection(tmpFile.F.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		lock.Lock()
+		calls = nil
+		lock.Unlock()
+		_, err = exec.ExecuteRequest(coll, requestName, conf.CurrentEnv, overrides, exec.NewLoopChecker())
+		assert(t, err == nil, "execute", requestName, err)
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]call(nil), calls...)
+	}
+
+	t.Run("collection hooks", func(t *testing.T) {
+		got := run("Plain")
+		want := []call{
+			{Path: "/plain", Authorization: "Bearer abc"},
+			{Path: "/coll-post", Authorization: "Bearer abc", Body: "GET /plain 200"},
+		}
+		assert(t, reflect.DeepEqual(got, want), "calls", got)
+	})
+
+	t.Run("folder hooks within collection hooks", func(t *testing.T) {
+		got := run("Team.Calls")
+		want := []call{
+			{Path: "/a", Authorization: "Bearer abc", Team: "override"},
+			{Path: "/b", Authorization: "Bearer abc", Team: "team"},
+			{Path: "/folder-post", Authorization: "Bearer abc", Team: "team"},
+			{Path: "/coll-post", Authorization: "Bearer abc", Team: "team", Body: "GET /a 200,GET /b 200,GET /folder-post 200"},
+		}
+		assert(t, reflect.DeepEqual(got, want), "calls", got)
+	})
+
+	t.Run("default headers by any case", func(t *testing.T) {
+		got := run("Team.Casing")
+		want := []call{
+			{Path: "/c", Authorization: "Bearer other"},
+			{Path: "/folder-post", Authorization: "Bearer other"},
+			{Path: "/coll-post", Authorization: "Bearer other", Body: "GET /c 200,GET /folder-post 200"},
+		}
+		assert(t, reflect.DeepEqual(got, want), "calls", got)
+	})
+
+	t.Run("failed request skips post hooks", func(t *testing.T) {
+		coll, err := data.ReadCollection(tmpFile.F.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		coll.UpsertRequest("Plain", &data.Request{Script: data.Script{"error('boom')"}})
+		lock.Lock()
+		calls = nil
+		lock.Unlock()
+		_, err = exec.ExecuteRequest(coll, "Plain", conf.CurrentEnv, overrides, exec.NewLoopChecker())
+		assert(t, err != nil, "expected failure")
+		lock.Lock()
+		defer lock.Unlock()
+		assert(t, len(calls) == 0, "expected no post hook calls", calls)
+	})
+}
@@ -0,0 +1,43 @@
+package test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/handlers"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+func TestKafkaAdminCLI(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	t.Run("connection argument", func(t *testing.T) {
+		conn, err := exec.KafkaConnectionFor("b1:9092, b2:9092,", nil)
+		assert(t, err == nil && len(conn.Brokers) == 2 && conn.Brokers[1] == "b2:9092", "brokers", conn, err)
+		conn, err = exec.KafkaConnectionFor("prod", map[string]string{"kafka_prod_brokers": "b3:9092"})
+		assert(t, err == nil && len(conn.Brokers) == 1 && conn.Brokers[0] == "b3:9092", "profile", conn, err)
+		err = handlers.KafkaListTopics("missing", data.EnvMapValue{})
+		assert(t, err != nil, "expected error for profile without brokers")
+	})
+
+	t.Run("bad reset offset", func(t *testing.T) {
+		for _, to := range []string{"middle", "-1"} {
+			err := handlers.KafkaResetGroupOffsets("localhost:9092", "group", "topic", to, data.EnvMapValue{})
+			assert(t, err != nil && strings.Contains(err.Error(), "expected 'first', 'last', or a non-negative offset"), "expected offset refused, got:", err)
+		}
+	})
+
+	t.Run("unreachable cluster", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		assert(t, err == nil, "listen", err)
+		addr := l.Addr().String()
+		_ = l.Close()
+		err = handlers.KafkaListTopics(addr, data.EnvMapValue{})
+		assert(t, err != nil && strings.Contains(err.Error(), "error listing topics"), "expected dial refused, got:", err)
+		err = handlers.KafkaDeleteTopic(addr, "topic", data.EnvMapValue{})
+		assert(t, err != nil && strings.Contains(err.Error(), "error deleting topic 'topic'"), "expected dial refused, got:", err)
+	})
+}
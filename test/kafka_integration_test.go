@@ -0,0 +1,54 @@
+//go:build integration
+
+package test
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/handlers"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+func kafkaBroker() string {
+	if broker := os.Getenv("SQUMP_KAFKA_BROKER"); broker != "" {
+		return broker
+	}
+	return "localhost:9092"
+}
+
+// TestKafka needs a broker, such as the one in test/docker/docker-compose.yml, and is run with `go test -tags integration`
+func TestKafka(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	runRequests(t, "testdata/test_example_kafka_squmpfile.json", data.EnvMapValue{"kafka_broker": kafkaBroker()}, "roundtrip")
+}
+
+// TestKafkaAdminCommands runs the `sqump kafka` subcommands against a broker, as TestKafka does
+func TestKafkaAdminCommands(t *testing.T) {
+	var out strings.Builder
+	prnt.SetPrinter(prnt.NewDualWriter(
+		func(msg string, args ...any) (int, error) { return fmt.Fprintf(&out, msg, args...) },
+		func(args ...any) (int, error) { return fmt.Fprintln(&out, args...) },
+	))
+	t.Cleanup(func() { prnt.SetPrinter(&prnt.StandardPrinter{}) })
+
+	broker, overrides := kafkaBroker(), data.EnvMapValue{}
+	topic := fmt.Sprintf("sqump-admin-%d", rand.Int63())
+	group := topic + "-group"
+
+	err := handlers.KafkaCreateTopic(broker, topic, 2, 1, overrides)
+	assert(t, err == nil, "create topic", err)
+	err = handlers.KafkaListTopics(broker, overrides)
+	assert(t, err == nil && strings.Contains(out.String(), topic+": 2 partitions"), "list topics", err, out.String())
+	err = handlers.KafkaResetGroupOffsets(broker, group, topic, "first", overrides)
+	assert(t, err == nil && strings.Contains(out.String(), topic+"/1: reset to 0"), "reset group offsets", err, out.String())
+	err = handlers.KafkaDescribeGroup(broker, group, overrides)
+	assert(t, err == nil && strings.Contains(out.String(), "group '"+group+"'"), "describe group", err, out.String())
+	err = handlers.KafkaDeleteTopic(broker, topic, overrides)
+	assert(t, err == nil && strings.Contains(out.String(), "deleted topic '"+topic+"'"), "delete topic", err)
+}
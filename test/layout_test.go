@@ -0,0 +1,104 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/handlers"
+)
+
+func TestScriptLayout(t *testing.T) {
+	dir := t.TempDir()
+	fpath := filepath.Join(dir, "Squmpfile.json")
+	b, err := os.ReadFile("testdata/test_example_basic_squmpfile.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(fpath, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	original, err := data.ReadCollection(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripts := func(coll *data.Collection) map[string]string {
+		ret := make(map[string]string)
+		for _, req := range coll.Requests {
+			ret[req.Name] = req.Script.String()
+		}
+		return ret
+	}
+
+	err = handlers.MigrateLayout(fpath, handlers.LayoutFiles, "")
+	assert(t, err == nil, "migrate to files", err)
+	raw, err := os.ReadFile(fpath)
+	assert(t, err == nil, err)
+	assert(t, !strings.Contains(string(raw), `"script":`), "expected scripts out of the collection, got:", string(raw))
+	req := original.Requests[0]
+	lua, err := os.ReadFile(filepath.Join(dir, "scripts", req.Name+".lua"))
+	assert(t, err == nil, "script file", err)
+	assert(t, string(lua) == req.Script.String()+"\n", "script file contents", string(lua))
+	coll, err := data.ReadCollection(fpath)
+	assert(t, err == nil, err)
+	assert(t, reflect.DeepEqual(scripts(coll), scripts(original)), "scripts after migrating to files", scripts(coll))
+
+	t.Run("edit and rename", func(t *testing.T) {
+		err := handlers.UpdateRequestScript(fpath, req.Name, []string{"print('edited')"}, "")
+		assert(t, err == nil, err)
+		lua, err := os.ReadFile(filepath.Join(dir, "scripts", req.Name+".lua"))
+		assert(t, err == nil && string(lua) == "print('edited')\n", "edited script file", string(lua), err)
+
+		err = handlers.UpdateRequestName(fpath, req.Name, "Renamed")
+		assert(t, err == nil, err)
+		_, err = os.Stat(filepath.Join(dir, "scripts", req.Name+".lua"))
+		assert(t, os.IsNotExist(err), "expected old script file removed", err)
+		coll, err := data.ReadCollection(fpath)
+		assert(t, err == nil, err)
+		renamed, ok := coll.GetRequest("Renamed")
+		assert(t, ok && renamed.File == "scripts/Renamed.lua" && renamed.Script.String() == "print('edited')", "renamed request", renamed)
+		err = handlers.UpdateRequestName(fpath, "Renamed", req.Name)
+		assert(t, err == nil, err)
+		err = handlers.UpdateRequestScript(fpath, req.Name, strings.Split(req.Script.String(), "\n"), "")
+		assert(t, err == nil, err)
+	})
+
+	t.Run("back inline", func(t *testing.T) {
+		err := handlers.MigrateLayout(fpath, handlers.LayoutInline, "")
+		assert(t, err == nil, "migrate inline", err)
+		_, err = os.Stat(filepath.Join(dir, "scripts"))
+		assert(t, os.IsNotExist(err), "expected script directory removed", err)
+		coll, err := data.ReadCollection(fpath)
+		assert(t, err == nil, err)
+		assert(t, reflect.DeepEqual(scripts(coll), scripts(original)), "scripts after migrating inline", scripts(coll))
+	})
+
+	t.Run("escaping file", func(t *testing.T) {
+		coll, err := data.ReadCollection(fpath)
+		assert(t, err == nil, err)
+		coll.Requests[0].File = "../outside.lua"
+		err = coll.Flush()
+		assert(t, err != nil && strings.Contains(err.Error(), "relative path within"), "expected escaping file refused, got:", err)
+
+		// Nor is it read from a collection edited by hand
+		outside := filepath.Join(t.TempDir(), "outside.lua")
+		err = os.WriteFile(outside, []byte("print('secret')\n"), 0644)
+		assert(t, err == nil, err)
+		rel, err := filepath.Rel(dir, outside)
+		assert(t, err == nil, err)
+		for _, file := range []string{filepath.ToSlash(rel), filepath.ToSlash(outside)} {
+			raw, err := os.ReadFile(fpath)
+			assert(t, err == nil, err)
+			edited := strings.Replace(string(raw), `"name": "`+req.Name+`",`, `"name": "`+req.Name+`", "file": "`+file+`",`, 1)
+			assert(t, edited != string(raw), "expected request to edit")
+			escaping := filepath.Join(dir, "Escaping.json")
+			err = os.WriteFile(escaping, []byte(edited), 0644)
+			assert(t, err == nil, err)
+			_, err = data.ReadCollection(escaping)
+			assert(t, err != nil && strings.Contains(err.Error(), "relative path within"), "expected", file, "refused on read, got:", err)
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package test
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+// copyTree copies the directory at src to dst
+func copyTree(t *testing.T, src, dst string) {
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, b, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLibraries(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	dir := t.TempDir()
+	copyTree(t, "testdata/lib", dir)
+	t.Setenv("HOME", t.TempDir())
+	conf, err := data.CreateNewConfigFileAt(data.DefaultConfigLocation())
+	assert(t, err == nil, "create config", err)
+	conf.Lib = []string{filepath.Join(dir, "conflib")}
+	mainPath := filepath.Join(dir, "main", "Squmpfile.json")
+	assert(t, conf.Register(mainPath) == nil, "register main")
+	assert(t, conf.Register(filepath.Join(dir, "shared", "Squmpfile.json")) == nil, "register shared")
+
+	// Run from the collection's directory, so it's given by a relative path as on the command line
+	wd, err := os.Getwd()
+	assert(t, err == nil, err)
+	assert(t, os.Chdir(filepath.Join(dir, "main")) == nil, "chdir")
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	coll, err := data.ReadCollection("Squmpfile.json")
+	assert(t, err == nil, "read", err)
+	overrides := data.EnvMapValue{"who": "override"}
+	run := func(name string) error {
+		_, err := exec.ExecuteRequest(coll, name, "staging", overrides, exec.NewLoopChecker())
+		return err
+	}
+
+	t.Run("library modules", func(t *testing.T) {
+		err := run("UsesLib")
+		assert(t, err == nil, "require libraries", err)
+	})
+
+	t.Run("other collections", func(t *testing.T) {
+		err := run("UsesShared")
+		assert(t, err == nil, "require from other collection", err)
+	})
+
+	t.Run("collections read once per run", func(t *testing.T) {
+		err := run("RequiresAfterMove")
+		assert(t, err == nil, "require after collection moved", err)
+	})
+
+	t.Run("loop between collections", func(t *testing.T) {
+		err := run("LoopA")
+		assert(t, err != nil && strings.Contains(err.Error(), "cyclical loop detected"), "expected loop detected, got:", err)
+	})
+
+	t.Run("loop between libraries", func(t *testing.T) {
+		err := run("LibCycle")
+		assert(t, err != nil && strings.Contains(err.Error(), "library 'cycle_a' required again"), "expected loop detected, got:", err)
+	})
+
+	t.Run("find module", func(t *testing.T) {
+		paths := []string{filepath.Join(dir, "main", "lib"), filepath.Join(dir, "conflib", "common.lua")}
+		path, ok := data.FindLibModule(paths, "auth.token")
+		assert(t, ok && path == filepath.Join(dir, "main", "lib", "auth", "token.lua"), "nested module", path)
+		path, ok = data.FindLibModule(paths, "common")
+		assert(t, ok && path == paths[1], "module file", path)
+		for _, name := range []string{"missing", "../conflib/common", "auth..token", "lib.helpers"} {
+			_, ok = data.FindLibModule(paths, name)
+			assert(t, !ok, "expected no module for", name)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package test
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+func TestLoad(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	tmpConf, tmpFile := setup(t, "testdata/test_example_config.json", "testdata/test_example_load_squmpfile.json")
+	conf, err := data.ReadConfigFrom(tmpConf.F.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll, err := data.ReadCollection(tmpFile.F.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := func(name string, opts exec.LoadOptions, overrides data.EnvMapValue) (*exec.LoadRun, error) {
+		overrides["base_url"] = server.URL
+		return exec.StartLoad(context.Background(), coll, name, conf.CurrentEnv, overrides, opts)
+	}
+
+	t.Run("stats", func(t *testing.T) {
+		run, err := start("hit", exec.LoadOptions{VUs: 2, Duration: 300 * time.Millisecond}, data.EnvMapValue{})
+		assert(t, err == nil, err)
+		stats := run.Wait()
+		assert(t, stats.Done, "expected test to be done")
+		assert(t, stats.Iterations > 0 && stats.FailedIterations == 0, "iterations", stats)
+		assert(t, stats.Fetches >= 2*stats.Iterations, "fetches", stats)
+		assert(t, stats.StatusCounts[200] > 0 && stats.StatusCounts[404] > 0, "statuses", stats.StatusCounts)
+		assert(t, stats.ErrorRate > 0.4 && stats.ErrorRate < 0.6, "error rate", stats.ErrorRate)
+		assert(t, stats.P50 > 0 && stats.P50 <= stats.P90 && stats.P90 <= stats.P99 && stats.P99 <= stats.Max, "percentiles", stats)
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		run, err := start("hit", exec.LoadOptions{VUs: 4, Duration: 500 * time.Millisecond, RPS: 20}, data.EnvMapValue{})
+		assert(t, err == nil, err)
+		stats := run.Wait()
+		assert(t, stats.Iterations > 0 && stats.Iterations <= 11, "expected around 10 iterations, got:", stats.Iterations)
+	})
+
+	t.Run("stopped", func(t *testing.T) {
+		run, err := start("hit", exec.LoadOptions{VUs: 1, Duration: time.Minute}, data.EnvMapValue{})
+		assert(t, err == nil, err)
+		time.Sleep(50 * time.Millisecond)
+		run.Stop()
+		select {
+		case <-run.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("load test did not stop")
+		}
+	})
+
+	t.Run("failing script", func(t *testing.T) {
+		run, err := start("broken", exec.LoadOptions{VUs: 1, Duration: 100 * time.Millisecond}, data.EnvMapValue{})
+		assert(t, err == nil, err)
+		stats := run.Wait()
+		assert(t, stats.Iterations > 0 && stats.FailedIterations == stats.Iterations, "iterations", stats)
+		assert(t, strings.Contains(stats.LastError, "no good"), "last error", stats.LastError)
+	})
+
+	t.Run("straddling the deadline", func(t *testing.T) {
+		run, err := start("straddle", exec.LoadOptions{VUs: 1, Duration: 200 * time.Millisecond}, data.EnvMapValue{})
+		assert(t, err == nil, err)
+		stats := run.Wait()
+		assert(t, stats.Iterations == 0 && stats.Fetches == 0, "expected the cut off run and its fetches dropped together, got:", stats)
+	})
+
+	t.Run("recording refused", func(t *testing.T) {
+		_, err := start("hit", exec.LoadOptions{VUs: 1, Duration: time.Second}, data.EnvMapValue{exec.VCRModeKey: "record"})
+		assert(t, err != nil && strings.Contains(err.Error(), "not supported under load"), "expected refusal, got:", err)
+	})
+
+	t.Run("bad options", func(t *testing.T) {
+		_, err := start("hit", exec.LoadOptions{VUs: 0, Duration: time.Second}, data.EnvMapValue{})
+		assert(t, err != nil, "expected error for no VUs")
+		for _, rps := range []float64{-1, math.NaN(), 2e9, 1e-300} {
+			_, err = start("hit", exec.LoadOptions{VUs: 1, Duration: time.Second, RPS: rps}, data.EnvMapValue{})
+			assert(t, err != nil && strings.Contains(err.Error(), "RPS"), "expected error for RPS", rps, err)
+		}
+	})
+}
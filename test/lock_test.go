@@ -0,0 +1,140 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/handlers"
+)
+
+func TestConcurrentWrites(t *testing.T) {
+	copyCollection := func(t *testing.T) string {
+		dir := t.TempDir()
+		fpath := filepath.Join(dir, "Squmpfile.json")
+		b, err := os.ReadFile("testdata/test_example_basic_squmpfile.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(fpath, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return fpath
+	}
+
+	t.Run("stale collection refused", func(t *testing.T) {
+		fpath := copyCollection(t)
+		first, err := data.ReadCollection(fpath)
+		assert(t, err == nil, err)
+		second, err := data.ReadCollection(fpath)
+		assert(t, err == nil, err)
+		assert(t, first.Revision() == second.Revision(), "revisions differ before saving")
+
+		first.Name = "First"
+		err = first.Flush()
+		assert(t, err == nil, "first save", err)
+		err = first.Flush()
+		assert(t, err == nil, "saving again after own save", err)
+
+		second.Name = "Second"
+		err = second.Flush()
+		assert(t, errors.Is(err, data.ErrConflict{}), "expected conflict, got:", err)
+		coll, err := data.ReadCollection(fpath)
+		assert(t, err == nil, err)
+		assert(t, coll.Name == "First", "name clobbered", coll.Name)
+	})
+
+	t.Run("changed script file refused", func(t *testing.T) {
+		fpath := copyCollection(t)
+		err := handlers.MigrateLayout(fpath, handlers.LayoutFiles, "")
+		assert(t, err == nil, "migrate to files", err)
+		coll, err := data.ReadCollection(fpath)
+		assert(t, err == nil, err)
+		req := coll.Requests[0]
+		err = os.WriteFile(filepath.Join(filepath.Dir(fpath), req.File), []byte("print('from an editor')\n"), 0644)
+		assert(t, err == nil, err)
+		coll.Name = "Renamed"
+		err = coll.Flush()
+		assert(t, errors.Is(err, data.ErrConflict{}), "expected conflict, got:", err)
+	})
+
+	t.Run("stale revision refused", func(t *testing.T) {
+		fpath := copyCollection(t)
+		coll, err := data.ReadCollection(fpath)
+		assert(t, err == nil, err)
+		name := coll.Requests[0].Name
+		revision := coll.Revision()
+		err = handlers.UpdateRequestScript(fpath, name, []string{"print('one')"}, revision)
+		assert(t, err == nil, "update at current revision", err)
+		err = handlers.UpdateRequestScript(fpath, name, []string{"print('two')"}, revision)
+		assert(t, errors.Is(err, data.ErrConflict{}), "expected conflict, got:", err)
+		updated, err := data.ReadCollection(fpath)
+		assert(t, err == nil, err)
+		req, _ := updated.GetRequest(name)
+		assert(t, req.Script.String() == "print('one')", "script clobbered", req.Script.String())
+	})
+
+	t.Run("stale config refused", func(t *testing.T) {
+		tmpConf, _ := setup(t, "testdata/test_example_config.json", "testdata/test_example_basic_squmpfile.json")
+		first, err := data.ReadConfigFrom(tmpConf.F.Name())
+		assert(t, err == nil, err)
+		second, err := data.ReadConfigFrom(tmpConf.F.Name())
+		assert(t, err == nil, err)
+		first.CurrentEnv = "first"
+		assert(t, first.Flush() == nil, "first save")
+		second.CurrentEnv = "second"
+		err = second.Flush()
+		assert(t, errors.Is(err, data.ErrConflict{}), "expected conflict, got:", err)
+	})
+
+	t.Run("no lost updates", func(t *testing.T) {
+		fpath := copyCollection(t)
+		const writers = 8
+		var wg sync.WaitGroup
+		errs := make(chan error, writers)
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				// Retry on conflict, as a user would after reloading
+				for {
+					coll, err := data.ReadCollection(fpath)
+					if err != nil {
+						errs <- err
+						return
+					}
+					err = coll.AddRequest(fmt.Sprintf("Writer%d", i), &data.Request{Script: data.Script{"print('hi')"}})
+					if err == nil {
+						err = coll.Flush()
+					}
+					if errors.Is(err, data.ErrConflict{}) {
+						continue
+					}
+					errs <- err
+					return
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			assert(t, err == nil, "writer", err)
+		}
+		coll, err := data.ReadCollection(fpath)
+		assert(t, err == nil, err)
+		for i := 0; i < writers; i++ {
+			_, ok := coll.GetRequest(fmt.Sprintf("Writer%d", i))
+			assert(t, ok, "lost update from writer", i)
+		}
+		entries, err := os.ReadDir(filepath.Dir(fpath))
+		assert(t, err == nil, err)
+		for _, e := range entries {
+			assert(t, !strings.Contains(e.Name(), ".tmp-"), "temp file left behind", e.Name())
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/handlers"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+func TestRequestMetadata(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	var lock sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		paths = append(paths, r.URL.Path)
+	}))
+	t.Cleanup(server.Close)
+
+	tmpConf, tmpFile := setup(t, "testdata/test_example_config.json", "testdata/test_example_metadata_squmpfile.json")
+	conf, err := data.ReadConfigFrom(tmpConf.F.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrides := data.EnvMapValue{"base_url": server.URL}
+	reset := func() {
+		lock.Lock()
+		defer lock.Unlock()
+		paths = nil
+	}
+
+	t.Run("read", func(t *testing.T) {
+		coll, err := data.ReadCollection(tmpFile.F.Name())
+		assert(t, err == nil, err)
+		req, ok := coll.GetRequest("Health")
+		assert(t, ok, "health request")
+		assert(t, req.Description == "Checks the service is **up**." && req.Owner == "platform-team", "metadata", req)
+		tagged := make([]string, 0)
+		for _, ref := range coll.RequestsTagged("smoke") {
+			tagged = append(tagged, ref.Path)
+		}
+		assert(t, reflect.DeepEqual(tagged, []string{"Health", "NeedsToken", "Orders.List"}), "tagged requests", tagged)
+	})
+
+	t.Run("required env", func(t *testing.T) {
+		coll, err := data.ReadCollection(tmpFile.F.Name())
+		assert(t, err == nil, err)
+		_, err = exec.ExecuteRequest(coll, "NeedsToken", conf.CurrentEnv, overrides, exec.NewLoopChecker())
+		assert(t, err != nil && strings.Contains(err.Error(), "requires environment keys missing from 'staging': token"), "expected missing key reported, got:", err)
+
+		withToken := data.EnvMapValue{"base_url": server.URL, "token": "abc"}
+		_, err = exec.ExecuteRequest(coll, "NeedsToken", conf.CurrentEnv, withToken, exec.NewLoopChecker())
+		assert(t, err == nil, "with required key", err)
+	})
+
+	t.Run("batch by tag", func(t *testing.T) {
+		reset()
+		withToken := data.EnvMapValue{"base_url": server.URL, "token": "abc"}
+		err := handlers.ExecuteTagged([]string{tmpFile.F.Name()}, "smoke", conf.CurrentEnv, withToken)
+		assert(t, err == nil, "run tagged", err)
+		lock.Lock()
+		got := append([]string(nil), paths...)
+		lock.Unlock()
+		sort.Strings(got)
+		assert(t, reflect.DeepEqual(got, []string{"/accounts", "/health", "/orders"}), "tagged requests run", got)
+
+		reset()
+		err = handlers.ExecuteTagged([]string{tmpFile.F.Name()}, "smoke", conf.CurrentEnv, overrides)
+		assert(t, err != nil && strings.Contains(err.Error(), "1 of 3 requests failed"), "expected failure counted, got:", err)
+
+		err = handlers.ExecuteTagged([]string{tmpFile.F.Name()}, "missing", conf.CurrentEnv, overrides)
+		assert(t, err != nil && strings.Contains(err.Error(), "no requests tagged"), "expected no tagged requests reported, got:", err)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		coll, err := data.ReadCollection(tmpFile.F.Name())
+		assert(t, err == nil, err)
+		coll.Requests[0].Tags = append(coll.Requests[0].Tags, "two words")
+		err = coll.Flush()
+		assert(t, err != nil && strings.Contains(err.Error(), "without spaces"), "expected spaced tag refused, got:", err)
+	})
+}
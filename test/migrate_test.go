@@ -0,0 +1,120 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+)
+
+// writeVersioned copies the file at src into dir with its version replaced
+func writeVersioned(t *testing.T, src, dir string, version data.SemVer) string {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]any
+	if err = json.Unmarshal(b, &raw); err != nil {
+		t.Fatal(err)
+	}
+	raw["version"] = version
+	if b, err = json.MarshalIndent(raw, "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, filepath.Base(src))
+	if err = os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVersionMigrations(t *testing.T) {
+	t.Run("compare versions", func(t *testing.T) {
+		assert(t, data.NewSemVer(1, 0, 0).GreaterThan(data.NewSemVer(0, 9, 0)), "1.0.0 > 0.9.0")
+		assert(t, !data.NewSemVer(0, 9, 0).GreaterThan(data.NewSemVer(1, 0, 0)), "0.9.0 > 1.0.0")
+		assert(t, data.NewSemVer(0, 2, 1).GreaterThan(data.NewSemVer(0, 2, 0)), "0.2.1 > 0.2.0")
+		assert(t, !data.NewSemVer(0, 2, 0).GreaterThan(data.NewSemVer(0, 2, 0)), "0.2.0 > 0.2.0")
+		assert(t, data.NewSemVer(0, 2, 0).Compare(data.NewSemVer(0, 2, 0)) == 0, "0.2.0 == 0.2.0")
+	})
+
+	t.Run("upgrades older collection in memory on read", func(t *testing.T) {
+		dir := t.TempDir()
+		path := writeVersioned(t, "testdata/test_example_folder_squmpfile.json", dir, data.NewSemVer(0, 1, 0))
+		original, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		coll, err := data.ReadCollection(path)
+		assert(t, err == nil, "read", err)
+		assert(t, coll.Version == data.CurrentVersion, "version", coll.Version)
+		_, ok := coll.GetRequest("Users.Admin.Get")
+		assert(t, ok, "nested request kept")
+		b, err := os.ReadFile(path)
+		assert(t, err == nil && string(b) == string(original), "expected file left as it was on read", err)
+		_, err = os.Stat(path + ".0.1.0.bak")
+		assert(t, os.IsNotExist(err), "expected no backup on read")
+
+		assert(t, coll.Flush() == nil, "flush")
+		backup, err := os.ReadFile(path + ".0.1.0.bak")
+		assert(t, err == nil, "backup", err)
+		assert(t, string(backup) == string(original), "backup matches original")
+
+		upgrade, err := data.UpgradeCollection(path)
+		assert(t, err == nil && upgrade == nil, "expected already current", upgrade, err)
+	})
+
+	t.Run("upgrades older config in memory on read", func(t *testing.T) {
+		dir := t.TempDir()
+		path := writeVersioned(t, "testdata/test_example_config.json", dir, data.NewSemVer(0, 0, 1))
+		original, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conf, err := data.ReadConfigFrom(path)
+		assert(t, err == nil, "read", err)
+		assert(t, conf.Version == data.CurrentConfigVersion, "version", conf.Version)
+		b, err := os.ReadFile(path)
+		assert(t, err == nil && string(b) == string(original), "expected file left as it was on read", err)
+
+		upgrade, err := data.UpgradeConfig(path)
+		assert(t, err == nil && upgrade != nil && upgrade.From == data.NewSemVer(0, 0, 1), "upgrade", upgrade, err)
+		backup, err := os.ReadFile(upgrade.Backup)
+		assert(t, err == nil && string(backup) == string(original), "backup matches original", err)
+		conf, err = data.ReadConfigFrom(path)
+		assert(t, err == nil && conf.Version == data.CurrentConfigVersion, "config saved at current version", err)
+	})
+
+	t.Run("explicit upgrade", func(t *testing.T) {
+		dir := t.TempDir()
+		path := writeVersioned(t, "testdata/test_example_basic_squmpfile.json", dir, data.NewSemVer(0, 1, 0))
+		upgrade, err := data.UpgradeCollection(path)
+		assert(t, err == nil, "upgrade", err)
+		assert(t, upgrade.From == data.NewSemVer(0, 1, 0) && upgrade.To == data.CurrentVersion, "versions", upgrade)
+		assert(t, len(upgrade.Steps) == 1 && strings.HasPrefix(upgrade.Steps[0], "0.2.0: "), "steps", upgrade.Steps)
+	})
+
+	t.Run("refuses newer collection", func(t *testing.T) {
+		dir := t.TempDir()
+		path := writeVersioned(t, "testdata/test_example_basic_squmpfile.json", dir, data.NewSemVer(9, 0, 0))
+		_, err := data.ReadCollection(path)
+		var newer data.ErrNewerVersion
+		assert(t, errors.As(err, &newer), "expected newer version refused, got:", err)
+		assert(t, newer.Version == data.NewSemVer(9, 0, 0) && newer.Supported == data.CurrentVersion, "versions", newer)
+		_, err = os.Stat(path + ".9.0.0.bak")
+		assert(t, os.IsNotExist(err), "expected no backup")
+	})
+
+	t.Run("refuses newer config", func(t *testing.T) {
+		dir := t.TempDir()
+		path := writeVersioned(t, "testdata/test_example_config.json", dir, data.NewSemVer(9, 0, 0))
+		_, err := data.ReadConfigFrom(path)
+		var newer data.ErrNewerVersion
+		assert(t, errors.As(err, &newer) && newer.Kind == "config", "expected newer config refused, got:", err)
+	})
+}
@@ -0,0 +1,131 @@
+package test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/handlers"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+func TestMockServer(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	tmpConf, tmpFile := setup(t, "testdata/test_example_config.json", "testdata/test_example_mock_squmpfile.json")
+	conf, err := data.ReadConfigFrom(tmpConf.F.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lock sync.Mutex
+	var calls []handlers.MockLogEntry
+	handler, err := handlers.NewMockHandler(tmpFile.F.Name(), conf.CurrentEnv, data.EnvMapValue{"greeting": "hiya"}, func(e handlers.MockLogEntry) {
+		lock.Lock()
+		defer lock.Unlock()
+		calls = append(calls, e)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	do := func(method, path, body string) (*http.Response, string) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Trace", "abc")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(b)
+	}
+
+	t.Run("params and templating", func(t *testing.T) {
+		resp, body := do("GET", "/users/42?verbose=yes", "")
+		assert(t, resp.StatusCode == http.StatusOK, "status", resp.StatusCode, body)
+		assert(t, resp.Header.Get("Content-Type") == "application/json", "json content type", resp.Header)
+		var user map[string]string
+		err := json.Unmarshal([]byte(body), &user)
+		assert(t, err == nil, "decode body", err)
+		assert(t, user["id"] == "42" && user["verbose"] == "yes" && user["greeting"] == "hiya", "user body", user)
+	})
+
+	t.Run("request body and headers", func(t *testing.T) {
+		resp, body := do("POST", "/echo", "ping")
+		assert(t, resp.StatusCode == http.StatusCreated, "status", resp.StatusCode, body)
+		assert(t, resp.Header.Get("X-Trace") == "abc", "header echoed", resp.Header)
+		assert(t, body == "POST ping", "body echoed", body)
+	})
+
+	t.Run("any method", func(t *testing.T) {
+		resp, _ := do("DELETE", "/anything", "")
+		assert(t, resp.StatusCode == http.StatusNoContent, "status", resp.StatusCode)
+	})
+
+	t.Run("script error", func(t *testing.T) {
+		resp, body := do("GET", "/broken", "")
+		assert(t, resp.StatusCode == http.StatusInternalServerError, "status", resp.StatusCode)
+		assert(t, strings.Contains(body, "no good"), "error in body", body)
+	})
+
+	t.Run("unmatched", func(t *testing.T) {
+		resp, _ := do("GET", "/missing", "")
+		assert(t, resp.StatusCode == http.StatusNotFound, "status", resp.StatusCode)
+		resp, _ = do("PUT", "/echo", "")
+		assert(t, resp.StatusCode == http.StatusMethodNotAllowed, "status", resp.StatusCode)
+	})
+
+	t.Run("logged", func(t *testing.T) {
+		lock.Lock()
+		defer lock.Unlock()
+		assert(t, len(calls) == 6, "calls logged", len(calls))
+		assert(t, calls[0].Route == "get_user" && calls[0].Status == http.StatusOK, "first call", calls[0])
+		assert(t, calls[3].Route == "broken" && strings.Contains(calls[3].Error, "no good"), "error logged", calls[3])
+		assert(t, calls[4].Route == "" && calls[4].Status == http.StatusNotFound, "unmatched logged", calls[4])
+	})
+
+	t.Run("exec refused", func(t *testing.T) {
+		_, err := handlers.ExecuteRequest(tmpFile.F.Name(), "get_user", conf.CurrentEnv, nil, nil)
+		assert(t, err != nil && strings.Contains(err.Error(), "mock route"), "mock route not executable", err)
+	})
+
+	t.Run("bad routes", func(t *testing.T) {
+		b, err := os.ReadFile("testdata/test_example_mock_squmpfile.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tc := range []struct{ from, to, want string }{
+			{`"method": "POST"`, `"method": "FETCH"`, "must be '*' or one of"},
+			{`"path": "/echo"`, `"path": "/echo/*/more"`, "may only come last"},
+			{`"path": "/echo"`, `"path": "echo"`, "must begin with '/'"},
+		} {
+			fpath := filepath.Join(t.TempDir(), "Squmpfile.json")
+			err := os.WriteFile(fpath, []byte(strings.Replace(string(b), tc.from, tc.to, 1)), 0644)
+			assert(t, err == nil, err)
+			_, err = data.ReadCollection(fpath)
+			assert(t, err != nil && strings.Contains(err.Error(), tc.want), "expected", tc.to, "refused on read, got:", err)
+		}
+
+		// Passes our checks, but chi refuses the unclosed parameter
+		fpath := filepath.Join(t.TempDir(), "Squmpfile.json")
+		err = os.WriteFile(fpath, []byte(strings.Replace(string(b), `"/users/{id}"`, `"/users/{id"`, 1)), 0644)
+		assert(t, err == nil, err)
+		_, err = handlers.NewMockHandler(fpath, conf.CurrentEnv, nil, func(handlers.MockLogEntry) {})
+		assert(t, err != nil && strings.Contains(err.Error(), "mock route 'get_user'"), "expected router refusal as error, got:", err)
+	})
+}
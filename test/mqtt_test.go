@@ -0,0 +1,29 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/prnt"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+func TestMQTT(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	broker := mqtt.New(nil)
+	err := broker.AddHook(new(auth.AllowHook), nil)
+	assert(t, err == nil, "add auth hook", err)
+	err = broker.AddListener(listeners.NewTCP("tcp", "localhost:5311", nil))
+	assert(t, err == nil, "add listener", err)
+	err = broker.Serve()
+	assert(t, err == nil, "serve broker", err)
+	t.Cleanup(func() {
+		_ = broker.Close()
+	})
+
+	runRequests(t, "testdata/test_example_mqtt_squmpfile.json", data.EnvMapValue{"mqtt_broker": "tcp://localhost:5311"}, "blocking_read", "callback")
+}
@@ -0,0 +1,43 @@
+package test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/prnt"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+func TestNATS(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoSigs:    true,
+	})
+	assert(t, err == nil, "create server", err)
+	go srv.Start()
+	t.Cleanup(srv.Shutdown)
+	assert(t, srv.ReadyForConnections(5*time.Second), "server ready")
+
+	nc, err := nats.Connect(srv.ClientURL())
+	assert(t, err == nil, "connect", err)
+	t.Cleanup(nc.Close)
+	_, err = nc.Subscribe("echo", func(msg *nats.Msg) {
+		_ = msg.Respond([]byte(strings.ToUpper(string(msg.Data))))
+	})
+	assert(t, err == nil, "subscribe responder", err)
+	js, err := nc.JetStream()
+	assert(t, err == nil, "jetstream", err)
+	_, err = js.AddStream(&nats.StreamConfig{Name: "ORDERS", Subjects: []string{"orders.>"}})
+	assert(t, err == nil, "add stream", err)
+
+	runRequests(t, "testdata/test_example_nats_squmpfile.json", data.EnvMapValue{"nats_url": srv.ClientURL()}, "pubsub", "request_reply", "jetstream")
+}
@@ -0,0 +1,13 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/EvWilson/sqump/prnt"
+)
+
+func TestNet(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	runRequests(t, "testdata/test_example_net_squmpfile.json", nil, "tcp", "udp", "serve")
+}
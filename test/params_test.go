@@ -0,0 +1,116 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/handlers"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+func TestRequestParams(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	tmpConf, tmpFile := setup(t, "testdata/test_example_config.json", "testdata/test_example_params_squmpfile.json")
+	conf, err := data.ReadConfigFrom(tmpConf.F.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(name string, params map[string]string) (any, error) {
+		returned, err := handlers.ExecuteRequest(tmpFile.F.Name(), name, conf.CurrentEnv, nil, params)
+		if err != nil || returned == "" {
+			return nil, err
+		}
+		var v any
+		if err = json.Unmarshal([]byte(returned), &v); err != nil {
+			t.Fatal(err)
+		}
+		return v, nil
+	}
+
+	t.Run("given and defaulted", func(t *testing.T) {
+		got, err := run("Greet", map[string]string{"name": "ada", "times": "3", "loud": "true"})
+		assert(t, err == nil, err)
+		assert(t, reflect.DeepEqual(got, map[string]any{"greeting": "HELLO ADA", "count": 3.0}), "given", got)
+
+		got, err = run("Greet", map[string]string{"name": "ada"})
+		assert(t, err == nil, err)
+		assert(t, reflect.DeepEqual(got, map[string]any{"greeting": "hello ada", "count": 2.0}), "defaulted", got)
+	})
+
+	t.Run("checked", func(t *testing.T) {
+		_, err := run("Greet", nil)
+		assert(t, err != nil && strings.Contains(err.Error(), "requires parameter 'name'"), "expected missing parameter, got:", err)
+		_, err = run("Greet", map[string]string{"name": "ada", "times": "lots"})
+		assert(t, err != nil && strings.Contains(err.Error(), "parameter 'times' expects a number, got 'lots'"), "expected bad number, got:", err)
+		_, err = run("Greet", map[string]string{"name": "ada", "loud": "very"})
+		assert(t, err != nil && strings.Contains(err.Error(), "parameter 'loud' expects a boolean"), "expected bad boolean, got:", err)
+		_, err = run("Greet", map[string]string{"name": "ada", "nope": "1"})
+		assert(t, err != nil && strings.Contains(err.Error(), "has no parameter 'nope'"), "expected unknown parameter, got:", err)
+	})
+
+	t.Run("passed to require", func(t *testing.T) {
+		got, err := run("CallsGreet", nil)
+		assert(t, err == nil, err)
+		assert(t, reflect.DeepEqual(got, []any{"hello sam!", 3.0}), "several values returned as an array", got)
+
+		_, err = run("RequiresBadly", nil)
+		assert(t, err != nil && strings.Contains(err.Error(), "parameter 'times' expects a number, got 'lots'"), "expected bad value from require, got:", err)
+	})
+
+	t.Run("nothing returned", func(t *testing.T) {
+		returned, err := handlers.ExecuteRequest(tmpFile.F.Name(), "NoReturn", conf.CurrentEnv, nil, nil)
+		assert(t, err == nil, err)
+		assert(t, returned == "", "expected no output, got:", returned)
+	})
+
+	t.Run("cyclic table returned", func(t *testing.T) {
+		got, err := run("ReturnsCycle", nil)
+		assert(t, err == nil, err)
+		values, ok := got.([]any)
+		assert(t, ok && len(values) == 2 && strings.HasPrefix(values[0].(string), "table: ") && values[1] == "after", "cyclic table given as it prints", got)
+	})
+
+	t.Run("kept across formats", func(t *testing.T) {
+		for _, format := range []data.Format{data.FormatYAML, data.FormatTOML} {
+			path := copyAs(t, tmpFile.F.Name(), t.TempDir(), "Squmpfile.json")
+			coll, err := data.ReadCollection(path)
+			assert(t, err == nil, err)
+			newPath, err := coll.Convert(format)
+			assert(t, err == nil, format, err)
+			coll, err = data.ReadCollection(newPath)
+			assert(t, err == nil, format, err)
+			req, ok := coll.GetRequest("Greet")
+			assert(t, ok && len(req.Params) == 3 && req.Params[0].Required() && req.Params[1].DefaultString() == "2", format, req.Params)
+			returned, err := exec.ExecuteRequestWithParams(coll, "Greet", conf.CurrentEnv, nil, map[string]any{"name": "bo"}, exec.NewLoopChecker())
+			assert(t, err == nil, format, err)
+			assert(t, reflect.DeepEqual(returned, []any{map[string]any{"greeting": "hello bo", "count": 2.0}}), format, returned)
+		}
+	})
+
+	t.Run("validated", func(t *testing.T) {
+		for params, msg := range map[string]string{
+			`[{"name": ""}]`:                                      "parameter with no name",
+			`[{"name": "a"}, {"name": "a"}]`:                      "duplicate parameter 'a'",
+			`[{"name": "a", "type": "date"}]`:                     "has type 'date'",
+			`[{"name": "a", "type": "number", "default": "ten"}]`: "expects a number, got 'ten'",
+			`[{"name": "a", "type": "boolean", "default": 1}]`:    "expects a boolean, got: float64",
+		} {
+			path := filepath.Join(t.TempDir(), "Squmpfile.json")
+			body := `{"version": {"major": 0, "minor": 2, "patch": 0}, "name": "Bad", "requests": [{"name": "R", "params": ` + params + `}], "environment": {"staging": {}}}`
+			if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+				t.Fatal(err)
+			}
+			coll, err := data.ReadCollection(path)
+			assert(t, err == nil, err)
+			err = coll.Flush()
+			assert(t, err != nil && strings.Contains(err.Error(), msg), "expected", msg, "got:", err)
+		}
+	})
+}
@@ -0,0 +1,17 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/prnt"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRedis(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+	mr := miniredis.RunT(t)
+
+	runRequests(t, "testdata/test_example_redis_squmpfile.json", data.EnvMapValue{"redis_addr": mr.Addr()}, "strings", "hashes_and_lists", "pubsub")
+}
@@ -0,0 +1,86 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+// fakeRegistry is a minimal in-memory stand-in for a Confluent schema registry
+type fakeRegistry struct {
+	lock     sync.Mutex
+	schemas  []map[string]any
+	subjects map[string][]int
+}
+
+func (fr *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "subjects":
+		var schema map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&schema); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id := len(fr.schemas) + 1
+		schema["id"] = id
+		fr.schemas = append(fr.schemas, schema)
+		fr.subjects[parts[1]] = append(fr.subjects[parts[1]], id)
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": id})
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[0] == "schemas":
+		var id int
+		_, _ = fmt.Sscan(parts[2], &id)
+		if id < 1 || id > len(fr.schemas) {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]any{"error_code": 40403, "message": "Schema not found"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(fr.schemas[id-1])
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[0] == "subjects":
+		versions := fr.subjects[parts[1]]
+		version := len(versions)
+		if parts[3] != "latest" {
+			_, _ = fmt.Sscan(parts[3], &version)
+		}
+		if version < 1 || version > len(versions) {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]any{"error_code": 40401, "message": "Subject not found"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(fr.schemas[versions[version-1]-1])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestSchemaRegistry(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+	// Registered up front, as register has no way to give references
+	registry := &fakeRegistry{
+		schemas: []map[string]any{
+			{"id": 1, "schemaType": "JSON", "schema": `{"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}`},
+			{"id": 2, "schemaType": "JSON", "schema": `{"type": "object", "properties": {"name": {"type": "string"}, "address": {"$ref": "address.json"}}}`,
+				"references": []map[string]any{{"name": "address.json", "subject": "address", "version": 1}}},
+		},
+		subjects: map[string][]int{"address": {1}, "customers-value": {2}},
+	}
+	server := httptest.NewServer(registry)
+	t.Cleanup(server.Close)
+	overrides := data.EnvMapValue{"schema_registry_url": server.URL}
+
+	runRequests(t, "testdata/test_example_schema_registry_squmpfile.json", overrides, "avro", "protobuf", "json", "json_reference")
+
+	t.Run("json_invalid", func(t *testing.T) {
+		err := runRequest(t, "testdata/test_example_schema_registry_squmpfile.json", "json_invalid", overrides)
+		assert(t, err != nil && strings.Contains(err.Error(), "doesn't match JSON schema"), "expected invalid payload refused, got:", err)
+	})
+}
@@ -0,0 +1,105 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/prnt"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+func TestSocketIO(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/socket.io/" || q.Get("EIO") != "4" || q.Get("transport") != "websocket" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, _, _, err := ws.UpgradeHTTP(r, w)
+		if err != nil {
+			return
+		}
+		go serveSocketIO(conn)
+	}))
+	t.Cleanup(server.Close)
+
+	overrides := data.EnvMapValue{"sio_url": server.URL}
+	runRequests(t, "testdata/test_example_socketio_squmpfile.json", overrides, "receive", "handlers")
+	t.Run("refused", func(t *testing.T) {
+		err := runRequest(t, "testdata/test_example_socketio_squmpfile.json", "refused", overrides)
+		if err == nil || !strings.Contains(err.Error(), "not authorized") {
+			t.Fatalf("expected refused connection, got: %v", err)
+		}
+	})
+}
+
+// serveSocketIO plays a server holding a '/chat' namespace, just enough of the protocol to exercise the client
+func serveSocketIO(conn net.Conn) {
+	defer conn.Close()
+	send := func(msg string) {
+		_ = wsutil.WriteServerMessage(conn, ws.OpText, []byte(msg))
+	}
+	send(`0{"sid":"s1","upgrades":[],"pingInterval":25000,"pingTimeout":20000,"maxPayload":1000000}`)
+	send("2")
+	ponged := false
+	for {
+		msg, _, err := wsutil.ReadClientData(conn)
+		if err != nil {
+			return
+		}
+		switch raw := string(msg); {
+		case raw == "3":
+			ponged = true
+		case strings.HasPrefix(raw, "40/chat,"):
+			var auth struct {
+				Token string `json:"token"`
+			}
+			_ = json.Unmarshal([]byte(strings.TrimPrefix(raw, "40/chat,")), &auth)
+			if auth.Token != "secret" {
+				send(`44/chat,{"message":"not authorized"}`)
+				continue
+			}
+			send(`40/chat,{"sid":"n1"}`)
+		case strings.HasPrefix(raw, "41/chat,"):
+			return
+		case strings.HasPrefix(raw, "42/chat,"):
+			rest := strings.TrimPrefix(raw, "42/chat,")
+			i := strings.IndexByte(rest, '[')
+			ackID := rest[:i]
+			var args []json.RawMessage
+			if err := json.Unmarshal([]byte(rest[i:]), &args); err != nil || len(args) == 0 {
+				return
+			}
+			var event string
+			_ = json.Unmarshal(args[0], &event)
+			switch event {
+			case "echo":
+				send("42/chat," + rest[i:])
+			case "add":
+				var a, b float64
+				_ = json.Unmarshal(args[1], &a)
+				_ = json.Unmarshal(args[2], &b)
+				send(fmt.Sprintf("43/chat,%s[%v]", ackID, a+b))
+			case "pinged":
+				send(fmt.Sprintf("43/chat,%s[%t]", ackID, ponged))
+			case "quiz":
+				send(`42/chat,7["question","2+2?"]`)
+			}
+		case strings.HasPrefix(raw, "43/chat,7["):
+			var answer []float64
+			_ = json.Unmarshal([]byte(strings.TrimPrefix(raw, "43/chat,7")), &answer)
+			send("42/chat," + `["result",` + strconv.FormatBool(len(answer) == 1 && answer[0] == 4) + "]")
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package test
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/prnt"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+func TestSTOMP(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := ws.HTTPUpgrader{
+			Protocol: func(p string) bool { return p == "v12.stomp" },
+		}
+		conn, _, _, err := upgrader.Upgrade(r, w)
+		if err != nil {
+			return
+		}
+		go serveSTOMP(conn)
+	}))
+	t.Cleanup(server.Close)
+
+	overrides := data.EnvMapValue{"stomp_url": "ws" + strings.TrimPrefix(server.URL, "http")}
+	runRequests(t, "testdata/test_example_stomp_squmpfile.json", overrides, "read", "callback")
+	t.Run("refused", func(t *testing.T) {
+		err := runRequest(t, "testdata/test_example_stomp_squmpfile.json", "refused", overrides)
+		if err == nil || !strings.Contains(err.Error(), "bad credentials") {
+			t.Fatalf("expected refused connection, got: %v", err)
+		}
+	})
+}
+
+type testSTOMPFrame struct {
+	command string
+	// headers are kept as sent, escaping included, so they can be passed straight back
+	headers map[string]string
+	body    string
+}
+
+func (f testSTOMPFrame) encode() []byte {
+	var b strings.Builder
+	b.WriteString(f.command + "\n")
+	for k, v := range f.headers {
+		b.WriteString(k + ":" + v + "\n")
+	}
+	b.WriteString("\n" + f.body + "\x00")
+	return []byte(b.String())
+}
+
+// serveSTOMP plays a broker that delivers each SEND to the subscriptions on its destination, and reports ACKs on
+// '/topic/acks'
+func serveSTOMP(conn net.Conn) {
+	defer conn.Close()
+	type subscription struct{ id, ack string }
+	subs := make(map[string][]subscription)
+	messageID := 0
+	send := func(f testSTOMPFrame) {
+		_ = wsutil.WriteServerMessage(conn, ws.OpText, f.encode())
+	}
+	deliver := func(destination, body string, extra map[string]string) {
+		for _, sub := range subs[destination] {
+			messageID++
+			headers := map[string]string{
+				"destination":  destination,
+				"subscription": sub.id,
+				"message-id":   fmt.Sprint(messageID),
+			}
+			if sub.ack != "auto" {
+				headers["ack"] = fmt.Sprintf("ack-%d", messageID)
+			}
+			for k, v := range extra {
+				headers[k] = v
+			}
+			send(testSTOMPFrame{command: "MESSAGE", headers: headers, body: body})
+		}
+	}
+	for {
+		msg, _, err := wsutil.ReadClientData(conn)
+		if err != nil {
+			return
+		}
+		head, rest, _ := strings.Cut(string(msg), "\n\n")
+		lines := strings.Split(head, "\n")
+		f := testSTOMPFrame{command: lines[0], headers: make(map[string]string), body: strings.TrimSuffix(rest, "\x00")}
+		for _, line := range lines[1:] {
+			k, v, _ := strings.Cut(line, ":")
+			f.headers[k] = v
+		}
+		switch f.command {
+		case "CONNECT":
+			if f.headers["login"] != "guest" || f.headers["passcode"] != "guest" {
+				send(testSTOMPFrame{command: "ERROR", headers: map[string]string{"message": "bad credentials"}})
+				return
+			}
+			send(testSTOMPFrame{command: "CONNECTED", headers: map[string]string{"version": "1.2"}})
+		case "SUBSCRIBE":
+			dest := f.headers["destination"]
+			subs[dest] = append(subs[dest], subscription{id: f.headers["id"], ack: f.headers["ack"]})
+		case "UNSUBSCRIBE":
+			for dest, list := range subs {
+				kept := list[:0]
+				for _, sub := range list {
+					if sub.id != f.headers["id"] {
+						kept = append(kept, sub)
+					}
+				}
+				subs[dest] = kept
+			}
+		case "SEND":
+			extra := make(map[string]string)
+			for k, v := range f.headers {
+				if k != "destination" && k != "receipt" {
+					extra[k] = v
+				}
+			}
+			deliver(f.headers["destination"], f.body, extra)
+		case "ACK":
+			deliver("/topic/acks", f.headers["id"], nil)
+		}
+		if receipt, ok := f.headers["receipt"]; ok {
+			send(testSTOMPFrame{command: "RECEIPT", headers: map[string]string{"receipt-id": receipt}})
+		}
+		if f.command == "DISCONNECT" {
+			return
+		}
+	}
+}
@@ -0,0 +1 @@
+return { value = 'from config' }
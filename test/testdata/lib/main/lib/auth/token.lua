@@ -0,0 +1,2 @@
+local name = ...
+return { name = 'token', module = name }
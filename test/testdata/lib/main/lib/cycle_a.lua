@@ -0,0 +1 @@
+return { b = require('cycle_b') }
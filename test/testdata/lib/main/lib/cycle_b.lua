@@ -0,0 +1 @@
+return { a = require('cycle_a') }
@@ -0,0 +1,103 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+func TestVCR(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	var hits atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/greet", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %d", hits.Add(1))
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cret"})
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(b)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	tmpConf, tmpFile := setup(t, "testdata/test_example_config.json", "testdata/test_example_vcr_squmpfile.json")
+	conf, err := data.ReadConfigFrom(tmpConf.F.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Move the collection to a directory of its own, to keep cassettes out of the shared temp directory
+	fpath := filepath.Join(t.TempDir(), "Squmpfile.json")
+	if err = os.Rename(tmpFile.F.Name(), fpath); err != nil {
+		t.Fatal(err)
+	}
+	coll, err := data.ReadCollection(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(name string, overrides data.EnvMapValue) error {
+		overrides["base_url"] = server.URL
+		_, err := exec.ExecuteRequest(coll, name, conf.CurrentEnv, overrides, exec.NewLoopChecker())
+		return err
+	}
+
+	err = run("calls", data.EnvMapValue{exec.VCRModeKey: "record"})
+	assert(t, err == nil, "record", err)
+	assert(t, hits.Load() == 3, "expected recording to reach the server, got hits:", hits.Load())
+	cassette := exec.CassettePath(exec.Identifier{Path: coll.Path, Collection: coll.Name, Request: "calls"})
+	b, err := os.ReadFile(cassette)
+	assert(t, err == nil, "cassette written", err)
+	assert(t, !strings.Contains(string(b), "Bearer abc") && !strings.Contains(string(b), "s3cret"), "expected credentials redacted", string(b))
+	var recorded exec.Cassette
+	assert(t, json.Unmarshal(b, &recorded) == nil && len(recorded.Interactions) == 3, "cassette", string(b))
+	echo := recorded.Interactions[2]
+	assert(t, echo.Request.Headers.Get("Authorization") == "[redacted]" && echo.Response.Headers.Get("Set-Cookie") == "[redacted]", "redacted headers", echo)
+	assert(t, echo.Request.Headers.Get("X-Token") == "abc", "expected other headers kept", echo.Request.Headers)
+
+	t.Run("replay", func(t *testing.T) {
+		err := run("calls", data.EnvMapValue{exec.VCRModeKey: "replay", exec.VCRMatchKey: "method,url,body,header:X-Token,header:Authorization"})
+		assert(t, err == nil, "replay", err)
+		assert(t, hits.Load() == 3, "expected replay not to reach the server, got hits:", hits.Load())
+	})
+
+	t.Run("mismatched header", func(t *testing.T) {
+		err := run("calls", data.EnvMapValue{exec.VCRModeKey: "replay", exec.VCRMatchKey: "method,url,header:X-Token", "token": "xyz"})
+		assert(t, err != nil && strings.Contains(err.Error(), "no unused interaction"), "expected unmatched replay, got:", err)
+	})
+
+	t.Run("unrecorded", func(t *testing.T) {
+		err := run("unrecorded", data.EnvMapValue{exec.VCRModeKey: "replay"})
+		assert(t, err != nil && strings.Contains(err.Error(), "reading cassette to replay"), "expected missing cassette, got:", err)
+	})
+
+	t.Run("bad match", func(t *testing.T) {
+		err := run("calls", data.EnvMapValue{exec.VCRModeKey: "replay", exec.VCRMatchKey: "method,colour"})
+		assert(t, err != nil && strings.Contains(err.Error(), "unrecognized vcr_match entry 'colour'"), "expected bad match, got:", err)
+	})
+
+	t.Run("redact more", func(t *testing.T) {
+		// The script expects a fresh server
+		hits.Store(0)
+		err := run("calls", data.EnvMapValue{exec.VCRModeKey: "record", exec.VCRRedactKey: "x-token, "})
+		assert(t, err == nil, "record", err)
+		b, err := os.ReadFile(cassette)
+		assert(t, err == nil && !strings.Contains(string(b), "abc"), "expected X-Token redacted", err, string(b))
+		err = run("calls", data.EnvMapValue{exec.VCRModeKey: "replay", exec.VCRMatchKey: "method,url,headers", exec.VCRRedactKey: "X-Token", "token": "xyz"})
+		assert(t, err == nil, "expected redacted headers matched on being present", err)
+	})
+}
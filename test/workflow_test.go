@@ -0,0 +1,136 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/prnt"
+)
+
+func TestWorkflow(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	var lock sync.Mutex
+	var calls []string
+	orders := make(map[string]map[string]any)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/users":
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"id": "u1"}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/orders":
+			var order map[string]any
+			_ = json.NewDecoder(r.Body).Decode(&order)
+			id := fmt.Sprintf("o%d", len(orders)+1)
+			orders[id] = order
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(map[string]string{"id": id})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/orders/"):
+			_ = json.NewEncoder(w).Encode(orders[strings.TrimPrefix(r.URL.Path, "/orders/")])
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	tmpConf, tmpFile := setup(t, "testdata/test_example_config.json", "testdata/test_example_workflow_squmpfile.json")
+	conf, err := data.ReadConfigFrom(tmpConf.F.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(name string) []string {
+		coll, err := data.ReadCollection(tmpFile.F.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		lock.Lock()
+		calls = nil
+		lock.Unlock()
+		result, err := exec.ExecuteWorkflow(coll, name, conf.CurrentEnv, data.EnvMapValue{"base_url": server.URL}, func(exec.StepResult) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := make([]string, 0, len(result.Steps))
+		for _, step := range result.Steps {
+			ret = append(ret, fmt.Sprintf("%s:%s", step.Name, step.Status))
+		}
+		return ret
+	}
+
+	t.Run("chain", func(t *testing.T) {
+		statuses := run("flow")
+		assert(t, reflect.DeepEqual(statuses, []string{"create_user:passed", "order:passed", "verify:passed", "delete_user:passed"}), "statuses", statuses)
+		assert(t, calls[len(calls)-1] == "DELETE /users/u1", "expected cleanup to use step output, got calls:", calls)
+	})
+
+	t.Run("skip on failure", func(t *testing.T) {
+		statuses := run("broken")
+		assert(t, reflect.DeepEqual(statuses, []string{"create_user:passed", "fail:failed", "order:skipped", "delete_user:passed"}), "statuses", statuses)
+		assert(t, reflect.DeepEqual(calls, []string{"POST /users", "DELETE /users/u1"}), "calls", calls)
+	})
+
+	t.Run("dependencies", func(t *testing.T) {
+		statuses := run("dag")
+		assert(t, reflect.DeepEqual(statuses, []string{"fail:failed", "create_user:passed", "order:passed", "verify:passed", "after_fail:skipped"}), "statuses", statuses)
+	})
+
+	t.Run("step params", func(t *testing.T) {
+		statuses := run("flow")
+		assert(t, reflect.DeepEqual(statuses[:2], []string{"create_user:passed", "order:passed"}), "statuses", statuses)
+		lock.Lock()
+		quantity := orders[fmt.Sprintf("o%d", len(orders))]["quantity"]
+		lock.Unlock()
+		assert(t, quantity == 1.0, "expected the default quantity, got:", quantity)
+
+		statuses = run("bulk")
+		assert(t, reflect.DeepEqual(statuses, []string{"create_user:passed", "order:passed"}), "statuses", statuses)
+		lock.Lock()
+		quantity = orders[fmt.Sprintf("o%d", len(orders))]["quantity"]
+		lock.Unlock()
+		assert(t, quantity == 3.0, "expected the step's quantity, got:", quantity)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		coll, err := data.ReadCollection(tmpFile.F.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		coll.Workflows = append(coll.Workflows, data.Workflow{Name: "cycle", Steps: []data.WorkflowStep{
+			{Name: "a", Request: "create_user", DependsOn: []string{"b"}},
+			{Name: "b", Request: "create_order", DependsOn: []string{"a"}},
+		}})
+		err = coll.Flush()
+		assert(t, err != nil && strings.Contains(err.Error(), "dependency cycle"), "expected cycle, got:", err)
+
+		coll.Workflows[len(coll.Workflows)-1] = *data.NewWorkflow("unknown", "create_user", "nope")
+		err = coll.Flush()
+		assert(t, err != nil && strings.Contains(err.Error(), "unknown request 'nope'"), "expected unknown request, got:", err)
+
+		coll.Workflows[len(coll.Workflows)-1] = data.Workflow{Name: "params", Steps: []data.WorkflowStep{
+			{Request: "create_order", Params: map[string]any{"colour": "red"}},
+		}}
+		err = coll.Flush()
+		assert(t, err != nil && strings.Contains(err.Error(), "has no parameter 'colour'"), "expected unknown parameter, got:", err)
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		coll, err := data.ReadCollection(tmpFile.F.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		coll.RenameRequest("create_order", "place_order")
+		err = coll.Flush()
+		assert(t, err == nil, err)
+		w, _ := coll.GetWorkflow("flow")
+		assert(t, w.Steps[1].Request == "place_order", "expected step to follow the rename, got:", w.Steps[1])
+	})
+}
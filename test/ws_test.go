@@ -0,0 +1,68 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/prnt"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+func TestWebsocket(t *testing.T) {
+	prnt.SetPrinter(&prnt.StandardPrinter{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		upgrader := ws.HTTPUpgrader{
+			Protocol: func(p string) bool { return p == "echo.v1" },
+		}
+		conn, _, _, err := upgrader.Upgrade(r, w)
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				msg, op, err := wsutil.ReadClientData(conn)
+				if err != nil {
+					return
+				}
+				switch {
+				case op == ws.OpText && string(msg) == "bye":
+					body := ws.NewCloseFrameBody(ws.StatusGoingAway, "see ya")
+					_ = ws.WriteFrame(conn, ws.NewCloseFrame(body))
+					return
+				case op == ws.OpText && strings.HasPrefix(string(msg), "flood:"):
+					n, _ := strconv.Atoi(strings.TrimPrefix(string(msg), "flood:"))
+					for i := 1; i <= n; i++ {
+						_ = wsutil.WriteServerMessage(conn, op, []byte(strconv.Itoa(i)))
+					}
+				case op == ws.OpText && strings.HasPrefix(string(msg), "twice:"):
+					reply := []byte(strings.TrimPrefix(string(msg), "twice:"))
+					_ = wsutil.WriteServerMessage(conn, op, reply)
+					_ = wsutil.WriteServerMessage(conn, op, reply)
+				default:
+					_ = wsutil.WriteServerMessage(conn, op, msg)
+				}
+			}
+		}()
+	}))
+	t.Cleanup(server.Close)
+
+	overrides := data.EnvMapValue{"ws_url": "ws" + strings.TrimPrefix(server.URL, "http")}
+	runRequests(t, "testdata/test_example_ws_squmpfile.json", overrides, "receive", "callback", "backlog", "close_callback")
+
+	t.Run("receive_with_callback", func(t *testing.T) {
+		err := runRequest(t, "testdata/test_example_ws_squmpfile.json", "receive_with_callback", overrides)
+		assert(t, err != nil && strings.Contains(err.Error(), "delivered to its onmessage callback"), "expected receive refused, got:", err)
+	})
+}
@@ -8,6 +8,8 @@ ws.onmessage = (event) => {
 	switch (data.command) {
 		case "clear":
 			document.getElementById("result").innerHTML = ""
+			document.getElementById("returned").textContent = ""
+			document.getElementById("returned-div").hidden = true
 			break
 		case "replaced":
 			document.getElementById("result").innerHTML = data.payload.script
@@ -15,6 +17,10 @@ ws.onmessage = (event) => {
 		case "exec":
 			document.getElementById("result").innerHTML += data.payload.fragment
 			break
+		case "result":
+			document.getElementById("returned").textContent = data.payload.returned
+			document.getElementById("returned-div").hidden = data.payload.returned === ""
+			break
 		default:
 			console.log(`[message] unrecognized command: ${data.command}`)
 	}
@@ -44,7 +50,7 @@ const viewRequest = (path, name, scope, environment) => {
 	}))
 }
 
-const execRequest = (path, name, scope, environment) => {
+const execRequest = (path, name, scope, environment, params) => {
 	ws.send(JSON.stringify({
 		command: "exec",
 		payload: {
@@ -52,6 +58,7 @@ const execRequest = (path, name, scope, environment) => {
 			name: name,
 			scope: scope,
 			environment: environment,
+			params: params,
 		}
 	}))
 }
@@ -6,8 +6,11 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/EvWilson/sqump/exec"
 	"github.com/EvWilson/sqump/handlers"
 	"github.com/EvWilson/sqump/web/middleware"
 	"github.com/EvWilson/sqump/web/stores"
@@ -75,7 +78,7 @@ func (r *Router) handleCollectionConfig(isReadonly bool, tcs stores.TempConfigSe
 				r.ServerError(w, err)
 				return
 			}
-			err = handlers.UpdateCollectionEnv(fmt.Sprintf("/%s", path), envMap)
+			err = handlers.UpdateCollectionEnv(fmt.Sprintf("/%s", path), envMap, req.Form.Get("revision"))
 			if err != nil {
 				r.ServerError(w, err)
 				return
@@ -190,6 +193,73 @@ func (r *Router) createRequest(w http.ResponseWriter, req *http.Request) {
 	http.Redirect(w, req, fmt.Sprintf("/collection/%s/request/%s", url.PathEscape(path), name), http.StatusFound)
 }
 
+func (r *Router) createMockRequest(w http.ResponseWriter, req *http.Request) {
+	path, ok := getParamEscaped(r, w, req, "path")
+	if !ok {
+		return
+	}
+	err := req.ParseForm()
+	if err != nil {
+		r.ServerError(w, err)
+		return
+	}
+	fields := make(map[string]string, 3)
+	for _, field := range []string{"name", "method", "route"} {
+		value, ok := req.Form[field]
+		if !ok {
+			r.RequestError(w, fmt.Errorf("create mock route form does not contain field '%s'", field))
+			return
+		}
+		fields[field] = strings.Join(value, "\n")
+	}
+	err = handlers.AddMockRequest(fmt.Sprintf("/%s", path), fields["name"], fields["method"], fields["route"])
+	if err != nil {
+		r.ServerError(w, err)
+		return
+	}
+	http.Redirect(w, req, fmt.Sprintf("/collection/%s/request/%s", url.PathEscape(path), fields["name"]), http.StatusFound)
+}
+
+func (r *Router) startMockServer(mss stores.MockServerService) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		path, ok := getParamEscaped(r, w, req, "path")
+		if !ok {
+			return
+		}
+		err := req.ParseForm()
+		if err != nil {
+			r.ServerError(w, err)
+			return
+		}
+		addr, ok := req.Form["address"]
+		if !ok {
+			r.RequestError(w, errors.New("start mock server form does not contain field 'address'"))
+			return
+		}
+		err = mss.Start(fmt.Sprintf("/%s", path), strings.Join(addr, "\n"), req)
+		if err != nil {
+			r.ServerError(w, err)
+			return
+		}
+		http.Redirect(w, req, fmt.Sprintf("/collection/%s/mock", url.PathEscape(path)), http.StatusFound)
+	}
+}
+
+func (r *Router) stopMockServer(mss stores.MockServerService) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		path, ok := getParamEscaped(r, w, req, "path")
+		if !ok {
+			return
+		}
+		err := mss.Stop(fmt.Sprintf("/%s", path))
+		if err != nil {
+			r.ServerError(w, err)
+			return
+		}
+		http.Redirect(w, req, fmt.Sprintf("/collection/%s/mock", url.PathEscape(path)), http.StatusFound)
+	}
+}
+
 func (r *Router) handleRenameRequest(w http.ResponseWriter, req *http.Request) {
 	path, ok := getParamEscaped(r, w, req, "path")
 	if !ok {
@@ -239,7 +309,7 @@ func (r *Router) updateRequestScript(w http.ResponseWriter, req *http.Request) {
 		r.RequestError(w, errors.New("update request form does not contain field 'edit'"))
 		return
 	}
-	err = handlers.UpdateRequestScript(fmt.Sprintf("/%s", path), name, script)
+	err = handlers.UpdateRequestScript(fmt.Sprintf("/%s", path), name, script, req.Form.Get("revision"))
 	if err != nil {
 		r.ServerError(w, err)
 		return
@@ -277,3 +347,70 @@ func (r *Router) performAutoregister(w http.ResponseWriter, req *http.Request) {
 	}
 	http.Redirect(w, req, "/", http.StatusFound)
 }
+
+func (r *Router) startLoadTest(lts stores.LoadTestService) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		path, ok := getParamEscaped(r, w, req, "path")
+		if !ok {
+			return
+		}
+		name, ok := getParamEscaped(r, w, req, "name")
+		if !ok {
+			return
+		}
+		err := req.ParseForm()
+		if err != nil {
+			r.ServerError(w, err)
+			return
+		}
+		fields := make(map[string]string)
+		for _, field := range []string{"vus", "duration", "rps"} {
+			val, ok := req.Form[field]
+			if !ok {
+				r.RequestError(w, fmt.Errorf("start load test form does not contain field '%s'", field))
+				return
+			}
+			fields[field] = strings.TrimSpace(strings.Join(val, "\n"))
+		}
+		opts := exec.LoadOptions{}
+		if opts.VUs, err = strconv.Atoi(fields["vus"]); err != nil {
+			r.RequestError(w, fmt.Errorf("invalid VUs '%s': %v", fields["vus"], err))
+			return
+		}
+		if opts.Duration, err = time.ParseDuration(fields["duration"]); err != nil {
+			r.RequestError(w, fmt.Errorf("invalid duration '%s': %v", fields["duration"], err))
+			return
+		}
+		if fields["rps"] != "" {
+			if opts.RPS, err = strconv.ParseFloat(fields["rps"], 64); err != nil {
+				r.RequestError(w, fmt.Errorf("invalid RPS '%s': %v", fields["rps"], err))
+				return
+			}
+		}
+		err = lts.Start(fmt.Sprintf("/%s", path), name, opts, req)
+		if err != nil {
+			r.ServerError(w, err)
+			return
+		}
+		http.Redirect(w, req, fmt.Sprintf("/collection/%s/request/%s/load", url.PathEscape(path), name), http.StatusFound)
+	}
+}
+
+func (r *Router) stopLoadTest(lts stores.LoadTestService) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		path, ok := getParamEscaped(r, w, req, "path")
+		if !ok {
+			return
+		}
+		name, ok := getParamEscaped(r, w, req, "name")
+		if !ok {
+			return
+		}
+		err := lts.Stop(fmt.Sprintf("/%s", path), name)
+		if err != nil {
+			r.ServerError(w, err)
+			return
+		}
+		http.Redirect(w, req, fmt.Sprintf("/collection/%s/request/%s/load", url.PathEscape(path), name), http.StatusFound)
+	}
+}
@@ -0,0 +1,128 @@
+package web
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	mdCodeSpan = regexp.MustCompile("`([^`]+)`")
+	mdLink     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdBold     = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	mdItalic   = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+	mdOrdered  = regexp.MustCompile(`^\d+\.\s+`)
+	mdHeading  = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+)
+
+// renderMarkdown renders the common parts of markdown used in request descriptions: headings, paragraphs, lists,
+// quotes, fenced code, and inline code, emphasis, and links. Everything else is escaped, so the result is safe to
+// place in a page as is.
+func renderMarkdown(src string) string {
+	var out strings.Builder
+	var para []string
+	list := ""
+	flushPara := func() {
+		if len(para) > 0 {
+			fmt.Fprintf(&out, "<p>%s</p>\n", renderInline(strings.Join(para, " ")))
+			para = nil
+		}
+	}
+	closeList := func() {
+		if list != "" {
+			fmt.Fprintf(&out, "</%s>\n", list)
+			list = ""
+		}
+	}
+	openList := func(tag string) {
+		flushPara()
+		if list != tag {
+			closeList()
+			fmt.Fprintf(&out, "<%s>\n", tag)
+			list = tag
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			flushPara()
+			closeList()
+			code := make([]string, 0)
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, html.EscapeString(lines[i]))
+			}
+			fmt.Fprintf(&out, "<pre><code>%s</code></pre>\n", strings.Join(code, "\n"))
+		case trimmed == "":
+			flushPara()
+			closeList()
+		case mdHeading.MatchString(trimmed):
+			flushPara()
+			closeList()
+			m := mdHeading.FindStringSubmatch(trimmed)
+			fmt.Fprintf(&out, "<h%d>%s</h%d>\n", len(m[1]), renderInline(m[2]), len(m[1]))
+		case trimmed == "---" || trimmed == "***":
+			flushPara()
+			closeList()
+			out.WriteString("<hr />\n")
+		case strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* "):
+			openList("ul")
+			fmt.Fprintf(&out, "<li>%s</li>\n", renderInline(trimmed[2:]))
+		case mdOrdered.MatchString(trimmed):
+			openList("ol")
+			fmt.Fprintf(&out, "<li>%s</li>\n", renderInline(mdOrdered.ReplaceAllString(trimmed, "")))
+		case strings.HasPrefix(trimmed, ">"):
+			flushPara()
+			closeList()
+			fmt.Fprintf(&out, "<blockquote>%s</blockquote>\n", renderInline(strings.TrimSpace(strings.TrimPrefix(trimmed, ">"))))
+		default:
+			closeList()
+			para = append(para, trimmed)
+		}
+	}
+	flushPara()
+	closeList()
+	return out.String()
+}
+
+func renderInline(text string) string {
+	text = html.EscapeString(text)
+	// Code spans are set aside so that nothing within them is taken as markup
+	codes := make([]string, 0)
+	text = mdCodeSpan.ReplaceAllStringFunc(text, func(m string) string {
+		codes = append(codes, "<code>"+mdCodeSpan.FindStringSubmatch(m)[1]+"</code>")
+		return fmt.Sprintf("\x00%d\x00", len(codes)-1)
+	})
+	text = mdLink.ReplaceAllStringFunc(text, func(m string) string {
+		parts := mdLink.FindStringSubmatch(m)
+		if !safeLink(html.UnescapeString(parts[2])) {
+			return parts[1]
+		}
+		return fmt.Sprintf(`<a href="%s">%s</a>`, parts[2], parts[1])
+	})
+	text = mdBold.ReplaceAllString(text, "<strong>$1$2</strong>")
+	text = mdItalic.ReplaceAllString(text, "<em>$1$2</em>")
+	for i, code := range codes {
+		text = strings.Replace(text, fmt.Sprintf("\x00%d\x00", i), code, 1)
+	}
+	return text
+}
+
+// safeLink allows relative links and those to web pages and mail addresses, leaving out schemes like 'javascript:'
+func safeLink(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return true
+	default:
+		return false
+	}
+}
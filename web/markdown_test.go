@@ -0,0 +1,35 @@
+package web
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"paragraph", "Fetches a *user*\nby **id**.", "<p>Fetches a <em>user</em> by <strong>id</strong>.</p>\n"},
+		{"heading", "## Usage", "<h2>Usage</h2>\n"},
+		{"list", "- one\n- `two`", "<ul>\n<li>one</li>\n<li><code>two</code></li>\n</ul>\n"},
+		{"ordered list", "1. first\n2. second", "<ol>\n<li>first</li>\n<li>second</li>\n</ol>\n"},
+		{"code block", "```\nlocal x = '<a>'\n```", "<pre><code>local x = &#39;&lt;a&gt;&#39;</code></pre>\n"},
+		{"link", "See [docs](https://example.com/a?b=c&d=e).", `<p>See <a href="https://example.com/a?b=c&amp;d=e">docs</a>.</p>` + "\n"},
+		{"unsafe link", "[click](javascript:alert(1))", "<p>click)</p>\n"},
+		{"html escaped", "<script>alert('x')</script>", "<p>&lt;script&gt;alert(&#39;x&#39;)&lt;/script&gt;</p>\n"},
+		{"markup in code", "`**not bold**`", "<p><code>**not bold**</code></p>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := renderMarkdown(tt.src)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if strings.Contains(got, "<script") {
+				t.Errorf("unescaped script in %q", got)
+			}
+		})
+	}
+}
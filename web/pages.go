@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/EvWilson/sqump/data"
+	"github.com/EvWilson/sqump/exec"
 	"github.com/EvWilson/sqump/handlers"
 	"github.com/EvWilson/sqump/prnt"
 	"github.com/EvWilson/sqump/web/stores"
@@ -77,20 +79,58 @@ func (r *Router) showCollection(ces stores.CurrentEnvService) http.HandlerFunc {
 			Name               string
 			Path               string
 			EnvironmentText    string
+			Revision           string
 			CurrentEnvironment string
-			Requests           []data.Request
+			Tree               requestTree
 			Error              string
 		}{
 			Name:               coll.Name,
 			Path:               path,
 			EnvironmentText:    string(envBytes),
+			Revision:           coll.Revision(),
 			CurrentEnvironment: currentEnv,
-			Requests:           coll.Requests,
+			Tree:               newRequestTree(url.PathEscape(path), "", coll.Requests, coll.Folders),
 			Error:              util.GetErrorOnRequest(w, req),
 		})
 	}
 }
 
+// requestTree is the top level of a collection, or one of its folders, as listed on the collection page
+type requestTree struct {
+	Name string
+	// EscapedPath is that of the collection, for building links
+	EscapedPath string
+	Requests    []requestLink
+	Folders     []requestTree
+}
+
+type requestLink struct {
+	Name string
+	// Path is the request's dotted name from the collection root
+	Path string
+	Mock *data.MockRoute
+	Tags []string
+}
+
+func newRequestTree(escapedPath, prefix string, requests []data.Request, folders []data.Folder) requestTree {
+	join := func(name string) string {
+		if prefix == "" {
+			return name
+		}
+		return data.JoinRequestPath(prefix, name)
+	}
+	tree := requestTree{EscapedPath: escapedPath}
+	for _, req := range requests {
+		tree.Requests = append(tree.Requests, requestLink{Name: req.Name, Path: join(req.Name), Mock: req.Mock, Tags: req.Tags})
+	}
+	for _, f := range folders {
+		sub := newRequestTree(escapedPath, join(f.Name), f.Requests, f.Folders)
+		sub.Name = f.Name
+		tree.Folders = append(tree.Folders, sub)
+	}
+	return tree
+}
+
 func (r *Router) showRequest(ces stores.CurrentEnvService, tcs stores.TempConfigService) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		path, ok := getParamEscaped(r, w, req, "path")
@@ -143,10 +183,12 @@ func (r *Router) showRequest(ces stores.CurrentEnvService, tcs stores.TempConfig
 		r.Render(w, 200, "request.tmpl.html", struct {
 			CollectionName     string
 			CollectionPath     string
-			Requests           []data.Request
+			Requests           []data.RequestRef
 			Name               string
+			Request            *data.Request
 			EditText           string
 			EnvironmentText    string
+			Revision           string
 			CurrentEnvironment string
 			ExecText           string
 			EnvScope           string
@@ -154,10 +196,12 @@ func (r *Router) showRequest(ces stores.CurrentEnvService, tcs stores.TempConfig
 		}{
 			CollectionName:     coll.Name,
 			CollectionPath:     coll.Path,
-			Requests:           coll.Requests,
+			Requests:           coll.AllRequests(),
 			Name:               name,
+			Request:            request,
 			EditText:           request.Script.String(),
 			EnvironmentText:    string(envBytes),
+			Revision:           coll.Revision(),
 			CurrentEnvironment: currentEnv,
 			ExecText:           "",
 			EnvScope:           scope,
@@ -284,6 +328,109 @@ func (r *Router) showDeleteRequest(w http.ResponseWriter, req *http.Request) {
 	})
 }
 
+func (r *Router) showMock(ces stores.CurrentEnvService, mss stores.MockServerService) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		path, ok := getParamEscaped(r, w, req, "path")
+		if !ok {
+			return
+		}
+		fpath := fmt.Sprintf("/%s", path)
+		coll, err := handlers.GetCollection(fpath)
+		if err != nil {
+			r.ServerError(w, err)
+			return
+		}
+		currentEnv, err := ces.GetCurrentEnv(req)
+		if err != nil {
+			r.ServerError(w, err)
+			return
+		}
+		routes := make([]data.RequestRef, 0)
+		for _, ref := range coll.AllRequests() {
+			if ref.Request.Mock != nil {
+				routes = append(routes, ref)
+			}
+		}
+		status, running := mss.Status(fpath)
+		r.Render(w, 200, "mock.tmpl.html", struct {
+			EscapedPath        string
+			CollectionName     string
+			CurrentEnvironment string
+			Routes             []data.RequestRef
+			Running            bool
+			Addr               string
+			Started            string
+			DefaultAddr        string
+			Calls              []handlers.MockLogEntry
+			Error              string
+		}{
+			EscapedPath:        url.PathEscape(path),
+			CollectionName:     coll.Name,
+			CurrentEnvironment: currentEnv,
+			Routes:             routes,
+			Running:            running,
+			Addr:               status.Addr,
+			Started:            status.Started.Format(time.TimeOnly),
+			DefaultAddr:        "localhost:5311",
+			Calls:              status.Calls,
+			Error:              util.GetErrorOnRequest(w, req),
+		})
+	}
+}
+
+func (r *Router) showLoad(lts stores.LoadTestService) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		path, ok := getParamEscaped(r, w, req, "path")
+		if !ok {
+			return
+		}
+		name, ok := getParamEscaped(r, w, req, "name")
+		if !ok {
+			return
+		}
+		fpath := fmt.Sprintf("/%s", path)
+		coll, err := handlers.GetCollection(fpath)
+		if err != nil {
+			r.ServerError(w, err)
+			return
+		}
+		opts := exec.LoadOptions{VUs: 1, Duration: 10 * time.Second}
+		var stats *exec.LoadStats
+		var started string
+		if run, ok := lts.Latest(fpath, name); ok {
+			opts = run.Options
+			s := run.Stats()
+			stats = &s
+			started = run.Started.Format(time.TimeOnly)
+		}
+		rps := ""
+		if opts.RPS > 0 {
+			rps = fmt.Sprint(opts.RPS)
+		}
+		r.Render(w, 200, "load.tmpl.html", struct {
+			EscapedPath    string
+			CollectionName string
+			RequestName    string
+			VUs            int
+			Duration       string
+			RPS            string
+			Started        string
+			Stats          *exec.LoadStats
+			Error          string
+		}{
+			EscapedPath:    url.PathEscape(path),
+			CollectionName: coll.Name,
+			RequestName:    name,
+			VUs:            opts.VUs,
+			Duration:       opts.Duration.String(),
+			RPS:            rps,
+			Started:        started,
+			Stats:          stats,
+			Error:          util.GetErrorOnRequest(w, req),
+		})
+	}
+}
+
 func getParamEscaped(r *Router, w http.ResponseWriter, req *http.Request, key string) (string, bool) {
 	param := chi.URLParam(req, key)
 	if param == "" {
@@ -2,6 +2,7 @@ package web
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log/slog"
@@ -12,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/EvWilson/sqump/data"
 	"github.com/EvWilson/sqump/prnt"
 	"github.com/EvWilson/sqump/web/log"
 	"github.com/EvWilson/sqump/web/middleware"
@@ -61,6 +63,8 @@ func NewRouter(isReadonly bool) (*Router, error) {
 	ces := stores.NewCurrentEnvService(isReadonly)
 	tcs := stores.NewTempConfigService(ces)
 	eps := stores.NewExecProxyService(ces, tcs)
+	mss := stores.NewMockServerService(ces, tcs)
+	lts := stores.NewLoadTestService(ces, tcs)
 
 	// These routes have a special case with the readonly mode
 	mux.Group(func(plainMux chi.Router) {
@@ -83,14 +87,21 @@ func NewRouter(isReadonly bool) (*Router, error) {
 			roMux.Post("/unregister", r.handleUnregisterCollection)
 			roMux.Get("/delete", r.showDeleteCollection)
 			roMux.Post("/delete", r.handleDeleteCollection)
+			roMux.Get("/mock", r.showMock(ces, mss))
+			roMux.Post("/mock/start", r.startMockServer(mss))
+			roMux.Post("/mock/stop", r.stopMockServer(mss))
 			roMux.Route("/request", func(roMux chi.Router) {
 				roMux.Post("/create/new", r.createRequest)
+				roMux.Post("/create/mock", r.createMockRequest)
 				roMux.Get("/{name}", r.showRequest(ces, tcs))
 				roMux.Post("/{name}/edit-script", r.updateRequestScript)
 				roMux.Get("/{name}/rename", r.showRenameRequest)
 				roMux.Post("/{name}/rename", r.handleRenameRequest)
 				roMux.Get("/{name}/delete", r.showDeleteRequest)
 				roMux.Post("/{name}/delete", r.performDeleteRequest)
+				roMux.Get("/{name}/load", r.showLoad(lts))
+				roMux.Post("/{name}/load/start", r.startLoadTest(lts))
+				roMux.Post("/{name}/load/stop", r.stopLoadTest(lts))
 			})
 		})
 		roMux.Get("/*", http.FileServer(http.FS(subAssets)).ServeHTTP)
@@ -112,6 +123,10 @@ func (r *Router) Render(w http.ResponseWriter, status int, page string, data any
 }
 
 func (r *Router) ServerError(w http.ResponseWriter, err error) {
+	if errors.Is(err, data.ErrConflict{}) {
+		r.ConflictError(w, err)
+		return
+	}
 	var pcs [1]uintptr
 	_ = runtime.Callers(2, pcs[:])
 	rec := slog.NewRecord(time.Now(), slog.LevelError, err.Error(), pcs[0])
@@ -124,6 +139,13 @@ func (r *Router) ServerError(w http.ResponseWriter, err error) {
 	Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
+// ConflictError reports a save refused because the file was changed elsewhere, e.g. by the CLI editor
+func (r *Router) ConflictError(w http.ResponseWriter, err error) {
+	r.l.Info(err.Error())
+	util.SetErrorCookie(w, fmt.Sprintf("Not saved: %v", err))
+	Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+}
+
 func (r *Router) RequestError(w http.ResponseWriter, err error) {
 	r.l.Error(err.Error(), "stack", string(debug.Stack()))
 	util.SetErrorCookie(w, fmt.Sprintf("Request error: %v", err))
@@ -7,7 +7,7 @@ import (
 )
 
 type ExecProxyService interface {
-	ExecuteRequest(fpath, requestName string, r *http.Request) error
+	ExecuteRequest(fpath, requestName string, params map[string]string, r *http.Request) (string, error)
 	GetPreparedScript(fpath, requestName string, r *http.Request) (string, error)
 	CancelScripts()
 }
@@ -24,16 +24,16 @@ type execProxyService struct {
 	tcs TempConfigService
 }
 
-func (e *execProxyService) ExecuteRequest(fpath, requestName string, r *http.Request) error {
+func (e *execProxyService) ExecuteRequest(fpath, requestName string, params map[string]string, r *http.Request) (string, error) {
 	currentEnv, err := e.ces.GetCurrentEnv(r)
 	if err != nil {
-		return err
+		return "", err
 	}
 	env, err := e.tcs.GetTempEnvValue(r)
 	if err != nil {
-		return err
+		return "", err
 	}
-	return handlers.ExecuteRequest(fpath, requestName, currentEnv, env)
+	return handlers.ExecuteRequest(fpath, requestName, currentEnv, env, params)
 }
 
 func (e *execProxyService) GetPreparedScript(fpath, requestName string, r *http.Request) (string, error) {
@@ -0,0 +1,80 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/EvWilson/sqump/exec"
+	"github.com/EvWilson/sqump/handlers"
+)
+
+type LoadTestService interface {
+	Start(fpath, requestName string, opts exec.LoadOptions, r *http.Request) error
+	Stop(fpath, requestName string) error
+	// Latest gives the request's running load test, or the last to complete
+	Latest(fpath, requestName string) (*exec.LoadRun, bool)
+}
+
+func NewLoadTestService(ces CurrentEnvService, tcs TempConfigService) LoadTestService {
+	return &loadTests{
+		ces:  ces,
+		tcs:  tcs,
+		runs: make(map[string]*exec.LoadRun),
+	}
+}
+
+type loadTests struct {
+	ces  CurrentEnvService
+	tcs  TempConfigService
+	runs map[string]*exec.LoadRun
+	sync.Mutex
+}
+
+func loadKey(fpath, requestName string) string {
+	return fmt.Sprintf("%s.%s", fpath, requestName)
+}
+
+func (l *loadTests) Start(fpath, requestName string, opts exec.LoadOptions, r *http.Request) error {
+	currentEnv, err := l.ces.GetCurrentEnv(r)
+	if err != nil {
+		return err
+	}
+	overrides, err := l.tcs.GetTempEnvValue(r)
+	if err != nil {
+		return err
+	}
+	l.Lock()
+	defer l.Unlock()
+	key := loadKey(fpath, requestName)
+	if run, ok := l.runs[key]; ok && !run.Stats().Done {
+		return fmt.Errorf("load test of '%s' already running", requestName)
+	}
+	// Not tied to the request's context, as the test outlives the call that starts it
+	run, err := handlers.StartLoad(context.Background(), fpath, requestName, currentEnv, overrides, opts)
+	if err != nil {
+		return err
+	}
+	l.runs[key] = run
+	return nil
+}
+
+func (l *loadTests) Stop(fpath, requestName string) error {
+	l.Lock()
+	run, ok := l.runs[loadKey(fpath, requestName)]
+	l.Unlock()
+	if !ok {
+		return fmt.Errorf("no load test of '%s' to stop", requestName)
+	}
+	run.Stop()
+	<-run.Done()
+	return nil
+}
+
+func (l *loadTests) Latest(fpath, requestName string) (*exec.LoadRun, bool) {
+	l.Lock()
+	defer l.Unlock()
+	run, ok := l.runs[loadKey(fpath, requestName)]
+	return run, ok
+}
@@ -0,0 +1,113 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/EvWilson/sqump/handlers"
+)
+
+const mockLogLimit = 200
+
+type MockServerService interface {
+	Start(fpath, addr string, r *http.Request) error
+	Stop(fpath string) error
+	// Status reports on the collection's mock server, if one is running
+	Status(fpath string) (MockServerStatus, bool)
+}
+
+type MockServerStatus struct {
+	Addr    string
+	Started time.Time
+	Calls   []handlers.MockLogEntry
+}
+
+func NewMockServerService(ces CurrentEnvService, tcs TempConfigService) MockServerService {
+	return &mockServers{
+		ces:     ces,
+		tcs:     tcs,
+		servers: make(map[string]*mockServer),
+	}
+}
+
+type mockServers struct {
+	ces     CurrentEnvService
+	tcs     TempConfigService
+	servers map[string]*mockServer
+	sync.Mutex
+}
+
+type mockServer struct {
+	*http.Server
+	addr    string
+	started time.Time
+	log     *handlers.MockLog
+}
+
+func (m *mockServers) Start(fpath, addr string, r *http.Request) error {
+	currentEnv, err := m.ces.GetCurrentEnv(r)
+	if err != nil {
+		return err
+	}
+	overrides, err := m.tcs.GetTempEnvValue(r)
+	if err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	if running, ok := m.servers[fpath]; ok {
+		return fmt.Errorf("mock server already running at '%s'", running.addr)
+	}
+	log := handlers.NewMockLog(mockLogLimit)
+	handler, err := handlers.NewMockHandler(fpath, currentEnv, overrides, log.Add)
+	if err != nil {
+		return err
+	}
+	// Listen up front, so that a taken address is reported rather than lost in the background
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := &mockServer{
+		Server:  &http.Server{Handler: handler},
+		addr:    ln.Addr().String(),
+		started: time.Now(),
+		log:     log,
+	}
+	m.servers[fpath] = server
+	go func() {
+		_ = server.Serve(ln)
+	}()
+	return nil
+}
+
+func (m *mockServers) Stop(fpath string) error {
+	m.Lock()
+	server, ok := m.servers[fpath]
+	delete(m.servers, fpath)
+	m.Unlock()
+	if !ok {
+		return fmt.Errorf("no mock server running for '%s'", fpath)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+func (m *mockServers) Status(fpath string) (MockServerStatus, bool) {
+	m.Lock()
+	defer m.Unlock()
+	server, ok := m.servers[fpath]
+	if !ok {
+		return MockServerStatus{}, false
+	}
+	return MockServerStatus{
+		Addr:    server.addr,
+		Started: server.started,
+		Calls:   server.log.Entries(),
+	}, true
+}
@@ -18,6 +18,9 @@ func NewTemplateCache() (TemplateCache, error) {
 	templFuncs := template.FuncMap{
 		"pathescape": url.PathEscape,
 		"trim":       trimSlashes,
+		"percent":    percent,
+		"markdown":   renderMarkdown,
+		"join":       strings.Join,
 	}
 	for _, page := range pages {
 		files := []string{
@@ -36,3 +39,7 @@ func NewTemplateCache() (TemplateCache, error) {
 func trimSlashes(path string) string {
 	return strings.Trim(path, "/")
 }
+
+func percent(fraction float64) string {
+	return fmt.Sprintf("%.2f%%", fraction*100)
+}
@@ -0,0 +1,56 @@
+package web
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/EvWilson/sqump/data"
+)
+
+func TestRequestPageEscaping(t *testing.T) {
+	cache, err := NewTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const evil = `<script>alert("x")</script>`
+	request := &data.Request{
+		Name:        "Evil",
+		Owner:       evil,
+		Tags:        []string{evil},
+		RequiredEnv: []string{evil},
+		Params:      []data.Param{{Name: evil}, {Name: "flag", Type: data.ParamBoolean, Default: true}},
+	}
+	var b strings.Builder
+	err = cache["request.tmpl.html"].ExecuteTemplate(&b, "base", struct {
+		CollectionName     string
+		CollectionPath     string
+		Requests           []data.RequestRef
+		Name               string
+		Request            *data.Request
+		EditText           string
+		EnvironmentText    string
+		Revision           string
+		CurrentEnvironment string
+		ExecText           string
+		EnvScope           string
+		Error              string
+	}{
+		CollectionName: "Coll",
+		CollectionPath: "/tmp/Squmpfile.json",
+		Name:           request.Name,
+		Request:        request,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := b.String()
+	if strings.Contains(page, "<script>alert") {
+		t.Errorf("unescaped metadata or parameter name in page:\n%s", page)
+	}
+	escaped := `&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;`
+	for _, want := range []string{"Owner: " + escaped, `#` + escaped, `data-name="` + escaped + `"`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("expected %q in page", want)
+		}
+	}
+}
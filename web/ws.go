@@ -37,16 +37,21 @@ type ViewResponsePayload struct {
 }
 
 type ExecRequestPayload struct {
-	EscapedPath string `json:"path"`
-	Name        string `json:"name"`
-	Scope       string `json:"scope"`
-	Environment string `json:"environment"`
+	EscapedPath string            `json:"path"`
+	Name        string            `json:"name"`
+	Scope       string            `json:"scope"`
+	Environment string            `json:"environment"`
+	Params      map[string]string `json:"params"`
 }
 
 type ExecResponsePayload struct {
 	OutputFragment string `json:"fragment"`
 }
 
+type ResultResponsePayload struct {
+	Returned string `json:"returned"`
+}
+
 func (r *Router) handleSocketConnection(eps stores.ExecProxyService) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		conn, _, _, err := ws.UpgradeHTTP(req, w)
@@ -196,11 +201,20 @@ func handleExecCommand(
 	if err != nil {
 		return err
 	}
-	err = eps.ExecuteRequest(fmt.Sprintf("/%s", path), erp.Name, r)
+	returned, err := eps.ExecuteRequest(fmt.Sprintf("/%s", path), erp.Name, erp.Params, r)
 	if err != nil {
 		return err
 	}
-	return nil
+	b, err := json.Marshal(Command{
+		Name: "result",
+		Payload: ResultResponsePayload{
+			Returned: returned,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return wsutil.WriteServerMessage(conn, ws.OpText, b)
 }
 
 func handleCancelCommand(eps stores.ExecProxyService) {